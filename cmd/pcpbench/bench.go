@@ -0,0 +1,182 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/* fetcher fetches the metrics being measured once, returning how many values it got */
+type fetcher func() (int, error)
+
+type Options struct {
+	/* Number of fetchers run at once, each with its own context */
+	Concurrency int
+	/* Fetches made by each fetcher, before and while measuring */
+	Warmup int
+	Fetches int
+}
+
+/* Report is what measuring a layer found. Allocations are those of the Go heap, so memory
+   libpcp allocates in C is not counted, but the Go side of each value PmFetch copies is */
+type Report struct {
+	Layer string
+	Fetches int
+	Errors int
+	Values int
+	Elapsed time.Duration
+	/* Latencies of the successful fetches, sorted */
+	Latencies []time.Duration
+	Mallocs uint64
+	Bytes uint64
+	GCs uint32
+	GCPause time.Duration
+}
+
+/* measure opens a fetcher per worker, warms them up, then times each fetch while they run
+   together */
+func measure(layer string, open func() (fetcher, error), options Options) (Report, error) {
+	fetchers := make([]fetcher, options.Concurrency)
+	for i := range fetchers {
+		var err error
+		fetchers[i], err = open()
+		if(err != nil) {
+			return Report{}, err
+		}
+		for j := 0; j < options.Warmup; j++ {
+			fetchers[i]()
+		}
+	}
+
+	results := make([]Report, len(fetchers))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var workers sync.WaitGroup
+	for i, fetch := range fetchers {
+		workers.Add(1)
+		go func(result *Report, fetch fetcher) {
+			defer workers.Done()
+			/* Keeps libpcp's per-thread current context the one this worker uses */
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			result.Latencies = make([]time.Duration, 0, options.Fetches)
+			for j := 0; j < options.Fetches; j++ {
+				fetch_start := time.Now()
+				values, err := fetch()
+				latency := time.Since(fetch_start)
+				if(err != nil) {
+					result.Errors++
+					continue
+				}
+				result.Values += values
+				result.Latencies = append(result.Latencies, latency)
+			}
+		}(&results[i], fetch)
+	}
+	workers.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	report := Report{
+		Layer:layer,
+		Fetches:options.Fetches * options.Concurrency,
+		Elapsed:elapsed,
+		Mallocs:after.Mallocs - before.Mallocs,
+		Bytes:after.TotalAlloc - before.TotalAlloc,
+		GCs:after.NumGC - before.NumGC,
+		GCPause:time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+	}
+	for _, result := range results {
+		report.Errors += result.Errors
+		report.Values += result.Values
+		report.Latencies = append(report.Latencies, result.Latencies...)
+	}
+	sort.Slice(report.Latencies, func(i, j int) bool {
+		return report.Latencies[i] < report.Latencies[j]
+	})
+	return report, nil
+}
+
+/* Percentile gives the latency p percent of fetches took at most, by the nearest rank */
+func (r Report) Percentile(p float64) time.Duration {
+	if(len(r.Latencies) == 0) {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(r.Latencies))))
+	if(rank < 1) {
+		rank = 1
+	}
+	if(rank > len(r.Latencies)) {
+		rank = len(r.Latencies)
+	}
+	return r.Latencies[rank - 1]
+}
+
+func (r Report) Mean() time.Duration {
+	if(len(r.Latencies) == 0) {
+		return 0
+	}
+	total := time.Duration(0)
+	for _, latency := range r.Latencies {
+		total += latency
+	}
+	return total / time.Duration(len(r.Latencies))
+}
+
+func (r Report) perSecond(count int) float64 {
+	if(r.Elapsed <= 0) {
+		return 0
+	}
+	return float64(count) / r.Elapsed.Seconds()
+}
+
+func (r Report) perFetch(count uint64) float64 {
+	if(r.Fetches == 0) {
+		return 0
+	}
+	return float64(count) / float64(r.Fetches)
+}
+
+/* writeReports prints a table with a row per layer */
+func writeReports(out io.Writer, reports []Report) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "layer\tfetches\terrors\tfetch/s\tvalues/s\tmin\tmean\tp50\tp90\tp99\tmax\tallocs/fetch\tbytes/fetch\tGCs\tGC pause\t")
+	for _, r := range reports {
+		max := time.Duration(0)
+		if(len(r.Latencies) > 0) {
+			max = r.Latencies[len(r.Latencies) - 1]
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\t%.1f\t%.1f\t%v\t%v\t%v\t%v\t%v\t%v\t%.1f\t%.0f\t%v\t%v\t\n",
+			r.Layer, r.Fetches, r.Errors, r.perSecond(r.Fetches - r.Errors), r.perSecond(r.Values),
+			r.Percentile(0), r.Mean(), r.Percentile(50), r.Percentile(90), r.Percentile(99), max,
+			r.perFetch(r.Mallocs), r.perFetch(r.Bytes), r.GCs, r.GCPause)
+	}
+	table.Flush()
+}
@@ -0,0 +1,138 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmcdtest"
+	"fmt"
+	"sort"
+)
+
+/* pmapiFetcher fetches through pmapi alone, extracting every value as a client of it would */
+func pmapiFetcher(context pmapi.PMAPI, names []string) (fetcher, error) {
+	pmids, err := context.PmLookupName(names...)
+	if(err != nil) {
+		return nil, err
+	}
+	types := make(map[pmapi.PmID]int)
+	for _, pmid := range pmids {
+		desc, err := context.PmLookupDesc(pmid)
+		if(err != nil) {
+			return nil, err
+		}
+		types[pmid] = desc.Type
+	}
+	return func() (int, error) {
+		result, err := context.PmFetch(pmids...)
+		if(err != nil) {
+			return 0, err
+		}
+		values := 0
+		for _, vset := range result.VSet {
+			for _, value := range vset.VList {
+				_, err = context.PmExtractValue(vset.ValFmt, types[vset.PmID], value)
+				if(err != nil) {
+					return values, err
+				}
+				values++
+			}
+		}
+		return values, nil
+	}, nil
+}
+
+/* pcpeasyFetcher fetches through a pcpeasy agent on the context */
+func pcpeasyFetcher(context pmapi.PMAPI, names []string) fetcher {
+	agent := pcpeasy.NewAgentWithPMAPI(context)
+	return func() (int, error) {
+		metrics, err := agent.Metrics(names...)
+		values := 0
+		for _, metric := range metrics {
+			values += len(metric.Values)
+		}
+		return values, err
+	}
+}
+
+/* limitInstances restricts fetches of each metric with an instance domain to the instances with
+   the lowest count identifiers */
+func limitInstances(context pmapi.PMAPI, names []string, count int) error {
+	pmids, err := context.PmLookupName(names...)
+	if(err != nil) {
+		return err
+	}
+	limited := make(map[pmapi.PmInDom]bool)
+	for _, pmid := range pmids {
+		desc, err := context.PmLookupDesc(pmid)
+		if(err != nil) {
+			return err
+		}
+		if(desc.InDom == pmapi.PmInDomNull || limited[desc.InDom]) {
+			continue
+		}
+		instances, err := context.PmGetInDom(desc.InDom)
+		if(err != nil) {
+			return err
+		}
+		ids := make([]int, 0, len(instances))
+		for id := range instances {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		if(len(ids) > count) {
+			ids = ids[:count]
+		}
+		err = context.PmDelProfile(desc.InDom)
+		if(err == nil) {
+			err = context.PmAddProfile(desc.InDom, ids...)
+		}
+		if(err != nil) {
+			return err
+		}
+		limited[desc.InDom] = true
+	}
+	return nil
+}
+
+/* mockServer builds a pmcd with metrics bench.m0 and on, each an unsigned 64 bit counter over an
+   instance domain of instances instances */
+func mockServer(metrics int, instances int) (*pmcdtest.Server, []string) {
+	server := pmcdtest.New()
+	indom := uint32(pmapi.PmInDomFromParts(250, 0))
+	names := make(map[int]string)
+	values := make(map[int]interface{})
+	for i := 0; i < instances; i++ {
+		names[i] = fmt.Sprintf("instance-%v", i)
+		values[i] = uint64(i) * 1000
+	}
+	server.AddInDom(indom, names)
+	metric_names := make([]string, metrics)
+	for i := range metric_names {
+		metric_names[i] = fmt.Sprintf("bench.m%v", i)
+		desc := pdu.Desc{PmID:uint32(pmapi.PmIDFromParts(250, 0, i)), Type:pdu.TypeU64, InDom:indom, Sem:pdu.SemCounter,
+			Units:pdu.Units{DimSpace:1}}
+		server.AddMetric(metric_names[i], desc, values)
+	}
+	return server, metric_names
+}
@@ -0,0 +1,132 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcpbench measures the latency and throughput of fetches through the pmapi and pcpeasy
+layers, with percentiles of fetch latency and the Go allocations and garbage collection each
+fetch costs.
+
+	pcpbench [-h host] [-m metric,...] [-l pmapi,pcpeasy] [-i instances] [-c concurrency]
+	         [-n fetches] [-w warmup]
+	pcpbench -mock METRICSxINSTANCES [options]
+
+Each of -c workers opens its own context and makes -n fetches of the -m metrics after -w to warm
+up. -i limits metrics with an instance domain to that many instances. -mock serves METRICS
+counters, each with INSTANCES instances, from a scripted pmcd in the process instead of using a
+host, so the cost of fetching many values can be measured anywhere.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pcpbench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	host := flags.String("h", "localhost", "`host` to fetch from")
+	metrics := flags.String("m", "kernel.all.load,kernel.percpu.cpu.user,mem.util.free,network.interface.in.bytes", "comma separated `metrics` to fetch")
+	layers := flags.String("l", "pmapi,pcpeasy", "comma separated `layers` to measure")
+	instances := flags.Int("i", 0, "fetch at most this many `instances` of each metric, 0 for all")
+	concurrency := flags.Int("c", 1, "`workers` fetching at once")
+	fetches := flags.Int("n", 1000, "`fetches` each worker makes")
+	warmup := flags.Int("w", 10, "`fetches` each worker makes before measuring")
+	mock := flags.String("mock", "", "serve `METRICSxINSTANCES` counters from a scripted pmcd")
+	if(flags.Parse(args) != nil) {
+		return 2
+	}
+	if(*concurrency < 1 || *fetches < 1 || *warmup < 0 || *instances < 0) {
+		fmt.Fprintln(stderr, "pcpbench: -c and -n must be positive, -w and -i not negative")
+		return 2
+	}
+
+	names := strings.Split(*metrics, ",")
+	hostspec := *host
+	if(*mock != "") {
+		var mock_metrics, mock_instances int
+		_, err := fmt.Sscanf(*mock, "%dx%d", &mock_metrics, &mock_instances)
+		if(err != nil || mock_metrics < 1 || mock_instances < 1) {
+			fmt.Fprintf(stderr, "pcpbench: invalid -mock \"%v\", want METRICSxINSTANCES\n", *mock)
+			return 2
+		}
+		server, mock_names := mockServer(mock_metrics, mock_instances)
+		hostspec, err = server.Start("localhost:0")
+		if(err != nil) {
+			fmt.Fprintf(stderr, "pcpbench: %v\n", err)
+			return 1
+		}
+		defer server.Close()
+		names = mock_names
+	}
+
+	connect := func() (pmapi.PMAPI, error) {
+		return pmapi.PmNewContext(pmapi.PmContextHost, hostspec)
+	}
+	options := Options{Concurrency:*concurrency, Warmup:*warmup, Fetches:*fetches}
+	reports, err := benchmark(connect, names, strings.Split(*layers, ","), *instances, options)
+	if(err != nil) {
+		fmt.Fprintf(stderr, "pcpbench: %v\n", err)
+		return 1
+	}
+	writeReports(stdout, reports)
+	return 0
+}
+
+/* benchmark measures each layer in turn, every worker with a context of its own */
+func benchmark(connect func() (pmapi.PMAPI, error), names []string, layers []string, instances int, options Options) ([]Report, error) {
+	reports := []Report{}
+	for _, layer := range layers {
+		if(layer != "pmapi" && layer != "pcpeasy") {
+			return nil, errors.New(fmt.Sprintf("unknown layer \"%v\", want pmapi or pcpeasy", layer))
+		}
+		open := func() (fetcher, error) {
+			context, err := connect()
+			if(err != nil) {
+				return nil, err
+			}
+			if(instances > 0) {
+				err = limitInstances(context, names, instances)
+				if(err != nil) {
+					return nil, err
+				}
+			}
+			if(layer == "pmapi") {
+				return pmapiFetcher(context, names)
+			}
+			return pcpeasyFetcher(context, names), nil
+		}
+		report, err := measure(layer, open, options)
+		if(err != nil) {
+			return nil, errors.New(fmt.Sprintf("%v: %v", layer, err))
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
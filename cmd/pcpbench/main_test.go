@@ -0,0 +1,147 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+)
+
+var loadInDom = pmapi.PmInDomFromParts(60, 2)
+
+func fakeContext() (pmapi.PMAPI, error) {
+	fake := pmapitest.New()
+	fake.AddInDom(loadInDom, map[int]string{1:"1 minute", 5:"5 minute", 15:"15 minute"})
+	fake.AddMetric("kernel.all.load",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 2, 0), Type:pmapi.PmTypeFloat, InDom:loadInDom, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{1:{Float:0.5}, 5:{Float:0.25}, 15:{Float:0.1}})
+	fake.AddMetric("mem.util.free",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(58, 0, 2), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:1024}})
+	return fake, nil
+}
+
+func TestReport_Percentile_usesTheNearestRank(t *testing.T) {
+	report := Report{}
+	for i := 1; i <= 10; i++ {
+		report.Latencies = append(report.Latencies, time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Equal(t, time.Millisecond, report.Percentile(0))
+	assert.Equal(t, 5 * time.Millisecond, report.Percentile(50))
+	assert.Equal(t, 9 * time.Millisecond, report.Percentile(90))
+	assert.Equal(t, 10 * time.Millisecond, report.Percentile(99))
+	assert.Equal(t, 5500 * time.Microsecond, report.Mean())
+	assert.Equal(t, time.Duration(0), Report{}.Percentile(50))
+}
+
+func TestMeasure_countsFetchesValuesAndErrors(t *testing.T) {
+	calls := 0
+	open := func() (fetcher, error) {
+		return func() (int, error) {
+			calls++
+			if(calls % 5 == 0) {
+				return 0, errors.New("failed")
+			}
+			return 3, nil
+		}, nil
+	}
+
+	report, err := measure("fake", open, Options{Concurrency:1, Warmup:5, Fetches:20})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 25, calls)
+	assert.Equal(t, 20, report.Fetches)
+	assert.Equal(t, 4, report.Errors)
+	assert.Equal(t, 48, report.Values)
+	assert.Len(t, report.Latencies, 16)
+}
+
+func TestBenchmark_measuresBothLayersWithAContextPerWorker(t *testing.T) {
+	contexts := 0
+	connect := func() (pmapi.PMAPI, error) {
+		contexts++
+		return fakeContext()
+	}
+
+	reports, err := benchmark(connect, []string{"kernel.all.load", "mem.util.free"}, []string{"pmapi", "pcpeasy"}, 0,
+		Options{Concurrency:2, Fetches:10})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, contexts)
+	assert.Equal(t, "pmapi", reports[0].Layer)
+	assert.Equal(t, 80, reports[0].Values)
+	assert.Equal(t, "pcpeasy", reports[1].Layer)
+	assert.Equal(t, 80, reports[1].Values)
+	assert.Equal(t, 0, reports[1].Errors)
+}
+
+func TestBenchmark_limitsInstances(t *testing.T) {
+	reports, err := benchmark(fakeContext, []string{"kernel.all.load"}, []string{"pmapi"}, 2, Options{Concurrency:1, Fetches:1})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reports[0].Values)
+}
+
+func TestBenchmark_reportsUnknownLayersAndMetrics(t *testing.T) {
+	_, err := benchmark(fakeContext, []string{"mem.util.free"}, []string{"libpcp"}, 0, Options{Concurrency:1, Fetches:1})
+	assert.EqualError(t, err, "unknown layer \"libpcp\", want pmapi or pcpeasy")
+
+	_, err = benchmark(fakeContext, []string{"not.a.metric"}, []string{"pmapi"}, 0, Options{Concurrency:1, Fetches:1})
+	assert.EqualError(t, err, "pmapi: Unknown metric name")
+}
+
+func TestWriteReports_printsARowPerLayer(t *testing.T) {
+	out := &bytes.Buffer{}
+	report := Report{Layer:"pmapi", Fetches:4, Values:12, Elapsed:time.Second, Mallocs:40, Bytes:4096,
+		Latencies:[]time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 4 * time.Millisecond}}
+
+	writeReports(out, []Report{report})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"pmapi", "4", "0", "4.0", "12.0", "1ms", "2.5ms", "2ms", "4ms", "4ms", "4ms", "10.0", "1024", "0", "0s"},
+		strings.Fields(lines[1]))
+}
+
+func TestMockServer_servesTheMetricsAsked(t *testing.T) {
+	server, names := mockServer(3, 50)
+
+	assert.Equal(t, []string{"bench.m0", "bench.m1", "bench.m2"}, names)
+	address, err := server.Start("localhost:0")
+	assert.NoError(t, err)
+	assert.NoError(t, server.Close())
+	assert.NotEmpty(t, address)
+}
+
+func TestRun_rejectsBadOptions(t *testing.T) {
+	errors := &bytes.Buffer{}
+
+	assert.Equal(t, 2, run([]string{"-mock", "lots"}, &bytes.Buffer{}, errors))
+	assert.Contains(t, errors.String(), "invalid -mock \"lots\"")
+	assert.Equal(t, 2, run([]string{"-c", "0"}, &bytes.Buffer{}, &bytes.Buffer{}))
+}
@@ -0,0 +1,159 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcpdiff compares two snapshots of metrics and reports metrics and instances that appeared
+or disappeared, changed values with their deltas and percentage changes, and changed units,
+semantics and types.
+
+	pcpdiff [-m names] [-threshold percent] before after
+	pcpdiff -save [-m names] source > snapshot.ndjson
+
+Each snapshot is one of
+
+	host:HOST             fetched from pmcd on HOST now
+	archive:PATH[@TIME]   values in an archive at TIME, or its start, in RFC 3339 form
+	FILE                  a snapshot saved earlier with -save, in pcpeasy's NDJSON form
+
+so a host can be saved before a deploy and compared with itself afterwards. -m limits the
+comparison to metrics under the comma separated names, and -threshold hides value changes
+smaller than a percentage. As with diff(1), the exit status is 0 when the snapshots are the same,
+1 when they differ and 2 on error.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pcpdiff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	names := flags.String("m", "", "comma separated metric `names` or prefixes to compare")
+	threshold := flags.Float64("threshold", 0, "hide value changes smaller than this `percent`")
+	save := flags.Bool("save", false, "write a snapshot of one source to standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: pcpdiff [-m names] [-threshold percent] before after")
+		fmt.Fprintln(stderr, "       pcpdiff -save [-m names] source > snapshot.ndjson")
+		flags.PrintDefaults()
+	}
+	if(flags.Parse(args) != nil) {
+		return 2
+	}
+	prefixes := []string{}
+	if(*names != "") {
+		prefixes = strings.Split(*names, ",")
+	}
+
+	if(*save) {
+		if(flags.NArg() != 1) {
+			flags.Usage()
+			return 2
+		}
+		metrics, err := loadSnapshot(flags.Arg(0), prefixes)
+		if(err == nil) {
+			err = pcpeasy.NewNDJSONWriter(stdout).Write(metrics)
+		}
+		if(err != nil) {
+			fmt.Fprintf(stderr, "pcpdiff: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+
+	if(flags.NArg() != 2) {
+		flags.Usage()
+		return 2
+	}
+	snapshots := make([][]pcpeasy.Metric, 2)
+	for i, source := range flags.Args() {
+		var err error
+		snapshots[i], err = loadSnapshot(source, prefixes)
+		if(err != nil) {
+			fmt.Fprintf(stderr, "pcpdiff: %v: %v\n", source, err)
+			return 2
+		}
+	}
+	diff := filterChanges(pcpeasy.DiffMetrics(snapshots[0], snapshots[1]), *threshold)
+	if(!writeDiff(stdout, diff)) {
+		return 0
+	}
+	return 1
+}
+
+/* filterChanges drops value changes smaller than threshold percent, and metrics left unchanged.
+   Changes from zero have no percentage and are always kept */
+func filterChanges(diff pcpeasy.SnapshotDiff, threshold float64) pcpeasy.SnapshotDiff {
+	if(threshold <= 0) {
+		return diff
+	}
+	changed := []pcpeasy.MetricDiff{}
+	for _, metric := range diff.Changed {
+		values := []pcpeasy.ValueChange{}
+		for _, value := range metric.Values {
+			if(value.Numeric && !math.IsNaN(value.Percent) && math.Abs(value.Percent) < threshold) {
+				continue
+			}
+			values = append(values, value)
+		}
+		metric.Values = values
+		if(len(metric.Metadata) > 0 || len(metric.AddedInstances) > 0 || len(metric.RemovedInstances) > 0 || len(metric.Values) > 0) {
+			changed = append(changed, metric)
+		}
+	}
+	diff.Changed = changed
+	return diff
+}
+
+/* writeDiff prints the diff, returning whether there was anything to print */
+func writeDiff(out io.Writer, diff pcpeasy.SnapshotDiff) bool {
+	for _, metric := range diff.Added {
+		fmt.Fprintf(out, "+ %v\n", metric.Name)
+	}
+	for _, metric := range diff.Removed {
+		fmt.Fprintf(out, "- %v\n", metric.Name)
+	}
+	for _, metric := range diff.Changed {
+		fmt.Fprintf(out, "~ %v\n", metric.Name)
+		for _, change := range metric.Metadata {
+			fmt.Fprintf(out, "    %v: %q -> %q\n", change.Field, change.Before, change.After)
+		}
+		for _, value := range metric.AddedInstances {
+			fmt.Fprintf(out, "    + instance %q\n", value.Instance)
+		}
+		for _, value := range metric.RemovedInstances {
+			fmt.Fprintf(out, "    - instance %q\n", value.Instance)
+		}
+		for _, value := range metric.Values {
+			fmt.Fprintf(out, "    %v\n", value)
+		}
+	}
+	return len(diff.Added) > 0 || len(diff.Removed) > 0 || len(diff.Changed) > 0
+}
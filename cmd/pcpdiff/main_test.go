@@ -0,0 +1,165 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+func load(one float32, five float32) pcpeasy.Metric {
+	return pcpeasy.Metric{Name:"kernel.all.load", Semantics:"instant", Type:reflect.Float32, Values:[]pcpeasy.MetricValue{
+		{Instance:"1 minute", InstanceID:1, Value:one},
+		{Instance:"5 minute", InstanceID:5, Value:five},
+	}}
+}
+
+func writeSnapshot(t *testing.T, metrics ...pcpeasy.Metric) string {
+	file, err := os.CreateTemp(t.TempDir(), "snapshot")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.NoError(t, pcpeasy.NewNDJSONWriter(file).Write(metrics))
+	return file.Name()
+}
+
+func pcpdiff(args ...string) (int, string, string) {
+	out, errors := &bytes.Buffer{}, &bytes.Buffer{}
+	status := run(args, out, errors)
+	return status, out.String(), errors.String()
+}
+
+func TestRun_reportsDifferencesBetweenSnapshots(t *testing.T) {
+	pswitch := pcpeasy.Metric{Name:"kernel.all.pswitch", Semantics:"counter", Type:reflect.Uint64,
+		Values:[]pcpeasy.MetricValue{{Value:uint64(100), InstanceID:pmapi.PmInNull}}}
+	before := writeSnapshot(t, load(0.5, 0.25), pswitch, pcpeasy.Metric{Name:"mem.util.free", Type:reflect.Uint64})
+	pswitch.Semantics = "instant"
+	after := writeSnapshot(t, load(0.75, 0.25), pswitch, pcpeasy.Metric{Name:"network.tcp.retrans", Type:reflect.Uint64})
+
+	status, out, _ := pcpdiff(before, after)
+
+	assert.Equal(t, 1, status)
+	assert.Equal(t, `+ network.tcp.retrans
+- mem.util.free
+~ kernel.all.load
+    [1 minute] 0.5 -> 0.75 (+0.25, +50.0%)
+~ kernel.all.pswitch
+    semantics: "counter" -> "instant"
+`, out)
+}
+
+func TestRun_exitsZeroForTheSameSnapshot(t *testing.T) {
+	snapshot := writeSnapshot(t, load(0.5, 0.25))
+
+	status, out, _ := pcpdiff(snapshot, snapshot)
+
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "", out)
+}
+
+func TestRun_limitsTheComparisonToNamedMetrics(t *testing.T) {
+	before := writeSnapshot(t, load(0.5, 0.25), pcpeasy.Metric{Name:"mem.util.free", Type:reflect.Uint64})
+	after := writeSnapshot(t, load(0.5, 0.25))
+
+	status, _, _ := pcpdiff("-m", "kernel.all", before, after)
+
+	assert.Equal(t, 0, status)
+}
+
+func TestRun_hidesChangesBelowTheThreshold(t *testing.T) {
+	before := writeSnapshot(t, load(0.5, 1))
+	after := writeSnapshot(t, load(0.51, 2))
+
+	_, out, _ := pcpdiff("-threshold", "5", before, after)
+
+	assert.Equal(t, "~ kernel.all.load\n    [5 minute] 1 -> 2 (+1, +100.0%)\n", out)
+}
+
+func TestRun_failsOnUnreadableSnapshots(t *testing.T) {
+	snapshot := writeSnapshot(t, load(0.5, 0.25))
+
+	status, _, errors := pcpdiff(snapshot, filepath.Join(t.TempDir(), "missing"))
+
+	assert.Equal(t, 2, status)
+	assert.Contains(t, errors, "missing")
+}
+
+func TestSnapshot_fetchesEveryMetricUnderThePrefixes(t *testing.T) {
+	fake := pmapitest.New()
+	fake.AddMetric("kernel.all.pswitch",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 0, 13), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemCounter},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:12345}})
+	fake.AddMetric("mem.util.free",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(58, 0, 2), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:1024}})
+
+	metrics, err := snapshot(pcpeasy.NewAgentWithPMAPI(fake), []string{"kernel"}, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "kernel.all.pswitch", metrics[0].Name)
+	assert.Equal(t, uint64(12345), metrics[0].Values[0].Value)
+}
+
+/* replay moves a second on with each fetch, as an archive context in interpolation mode does */
+type replay struct {
+	*pmapitest.Context
+}
+
+func (r replay) PmFetch(pmids ...pmapi.PmID) (*pmapi.PmResult, error) {
+	result, err := r.Context.PmFetch(pmids...)
+	r.Timestamp = r.Timestamp.Add(time.Second)
+	return result, err
+}
+
+func TestSnapshot_takesEveryBatchAtTheSameTime(t *testing.T) {
+	fake := pmapitest.New()
+	at := time.Unix(1700000000, 0)
+	for item := 0; item < fetchBatch + 50; item++ {
+		fake.AddMetric(fmt.Sprintf("x.m%03d", item),
+			pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 0, item), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemInstant},
+			map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:uint64(item)}})
+	}
+	/* A metric no value can be made for fails its batch, which is then fetched a name at a time */
+	fake.AddMetric("x.unfetchable",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 1, 0), Type:pmapi.PmTypeAggregate, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{}})
+	rewind := func() error {
+		fake.Timestamp = at
+		return nil
+	}
+
+	metrics, err := snapshot(pcpeasy.NewArchiveAgentWithPMAPI(replay{fake}), []string{"x"}, rewind)
+
+	assert.NoError(t, err)
+	assert.Len(t, metrics, fetchBatch + 50)
+	for _, metric := range metrics {
+		assert.Equal(t, at, metric.Timestamp, metric.Name)
+	}
+}
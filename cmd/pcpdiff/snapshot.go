@@ -0,0 +1,153 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"os"
+	"strings"
+	"time"
+)
+
+/* fetchBatch is how many metrics are fetched at once when taking a snapshot */
+const fetchBatch = 200
+
+/* agent is the part of the pcpeasy agent a snapshot is taken with */
+type agent interface {
+	Metrics(metric_names ...string) ([]pcpeasy.Metric, error)
+	MetricNames(prefix string) ([]string, error)
+}
+
+func loadSnapshot(source string, prefixes []string) ([]pcpeasy.Metric, error) {
+	switch {
+	case strings.HasPrefix(source, "host:"):
+		a, err := pcpeasy.NewAgent(strings.TrimPrefix(source, "host:"))
+		if(err != nil) {
+			return nil, err
+		}
+		return snapshot(a, prefixes, nil)
+	case strings.HasPrefix(source, "archive:"):
+		path, at := strings.TrimPrefix(source, "archive:"), time.Time{}
+		if at_sign := strings.LastIndex(path, "@"); at_sign >= 0 {
+			var err error
+			at, err = time.Parse(time.RFC3339, path[at_sign + 1:])
+			if(err != nil) {
+				return nil, err
+			}
+			path = path[:at_sign]
+		}
+		context, err := pmapi.PmNewContext(pmapi.PmContextArchive, path)
+		if(err != nil) {
+			return nil, err
+		}
+		defer context.Close()
+		if(at.IsZero()) {
+			label, err := context.PmGetArchiveLabel()
+			if(err != nil) {
+				return nil, err
+			}
+			at = label.Start
+		}
+		/* Each fetch interpolates a step further, so every one is taken back to at */
+		rewind := func() error {
+			return context.PmSetMode(pmapi.PmModeInterp, at, time.Second)
+		}
+		return snapshot(pcpeasy.NewArchiveAgentWithPMAPI(context), prefixes, rewind)
+	}
+	return readSnapshot(source, prefixes)
+}
+
+/* snapshot fetches every metric under prefixes, or every metric there is. Metrics that cannot be
+   fetched, which on a live host are usually ones whose PMDA has nothing to report, are left out.
+   Those an archive has no value for at the time are kept without values. rewind, when not nil, is
+   called before each fetch so that all of them are of the same point in an archive */
+func snapshot(a agent, prefixes []string, rewind func() error) ([]pcpeasy.Metric, error) {
+	if(len(prefixes) == 0) {
+		prefixes = []string{""}
+	}
+	names := []string{}
+	for _, prefix := range prefixes {
+		prefix_names, err := a.MetricNames(prefix)
+		if(err != nil) {
+			return nil, err
+		}
+		names = append(names, prefix_names...)
+	}
+
+	fetch := func(names ...string) ([]pcpeasy.Metric, error) {
+		if(rewind != nil) {
+			err := rewind()
+			if(err != nil) {
+				return nil, err
+			}
+		}
+		return a.Metrics(names...)
+	}
+
+	metrics := []pcpeasy.Metric{}
+	for start := 0; start < len(names); start += fetchBatch {
+		end := start + fetchBatch
+		if(end > len(names)) {
+			end = len(names)
+		}
+		batch, err := fetch(names[start:end]...)
+		if(err == nil) {
+			metrics = append(metrics, batch...)
+			continue
+		}
+		for _, name := range names[start:end] {
+			single, err := fetch(name)
+			if(err == nil) {
+				metrics = append(metrics, single...)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+func readSnapshot(path string, prefixes []string) ([]pcpeasy.Metric, error) {
+	file, err := os.Open(path)
+	if(err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+	metrics, err := pcpeasy.NewNDJSONReader(file).ReadAll()
+	if(err != nil || len(prefixes) == 0) {
+		return metrics, err
+	}
+	selected := []pcpeasy.Metric{}
+	for _, metric := range metrics {
+		if(underAny(metric.Name, prefixes)) {
+			selected = append(selected, metric)
+		}
+	}
+	return selected, nil
+}
+
+func underAny(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if(name == prefix || strings.HasPrefix(name, prefix + ".")) {
+			return true
+		}
+	}
+	return false
+}
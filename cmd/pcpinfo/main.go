@@ -0,0 +1,101 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcpinfo lists and describes PCP metrics in the manner of pminfo(1), from a live host or an
+archive. Its output follows pminfo's so the two can be compared.
+
+	pcpinfo [-h host | -a archive] [-dfTlm] [name ...]
+
+Each name is a metric or a PMNS prefix. Without names every metric is listed.
+
+	-d  descriptors: type, instance domain, semantics and units
+	-f  current values, or the first values in an archive
+	-T  help text
+	-l  labels
+	-m  PMIDs
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pcpinfo", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	host := flags.String("h", "localhost", "`host` to fetch metrics from")
+	archive := flags.String("a", "", "`archive` to read metrics from instead of a host")
+	options := Options{}
+	flags.BoolVar(&options.Descriptors, "d", false, "print metric descriptors")
+	flags.BoolVar(&options.Values, "f", false, "fetch and print values")
+	flags.BoolVar(&options.Help, "T", false, "print help text")
+	flags.BoolVar(&options.Labels, "l", false, "print labels")
+	flags.BoolVar(&options.PmIDs, "m", false, "print PMIDs")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: pcpinfo [-h host | -a archive] [-dfTlm] [name ...]")
+		flags.PrintDefaults()
+	}
+	if(flags.Parse(args) != nil) {
+		return 2
+	}
+
+	reporter, err := connect(*host, *archive, options, stdout, stderr)
+	if(err != nil) {
+		fmt.Fprintf(stderr, "pcpinfo: %v\n", err)
+		return 1
+	}
+	if(!reporter.Report(flags.Args()...)) {
+		return 1
+	}
+	return 0
+}
+
+/* connect opens a host context, or an archive context that is taken back to the start of the
+   archive before each fetch so -f reports its first values for every metric, as pminfo does */
+func connect(host string, archive string, options Options, stdout io.Writer, stderr io.Writer) (*Reporter, error) {
+	if(archive == "") {
+		context, err := pmapi.PmNewContext(pmapi.PmContextHost, host)
+		if(err != nil) {
+			return nil, err
+		}
+		return NewReporter(context, options, stdout, stderr), nil
+	}
+	context, err := pmapi.PmNewContext(pmapi.PmContextArchive, archive)
+	if(err != nil) {
+		return nil, err
+	}
+	label, err := context.PmGetArchiveLabel()
+	if(err != nil) {
+		return nil, err
+	}
+	rewind := func() error {
+		return context.PmSetMode(pmapi.PmModeForw, label.Start, 0)
+	}
+	return NewArchiveReporter(context, rewind, options, stdout, stderr), nil
+}
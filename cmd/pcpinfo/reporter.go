@@ -0,0 +1,289 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Options selects what is reported for each metric. With none set only names are printed */
+type Options struct {
+	Descriptors bool
+	Values bool
+	Help bool
+	Labels bool
+	PmIDs bool
+}
+
+/* verbose reports whether each metric gets a block of detail lines, which pminfo separates with a
+   blank line */
+func (o Options) verbose() bool {
+	return o.Descriptors || o.Values || o.Help || o.Labels
+}
+
+/* typeNames are the pmTypeStr names pminfo prints */
+var typeNames = map[int]string{
+	pmapi.PmType32:"32-bit int",
+	pmapi.PmTypeU32:"32-bit unsigned int",
+	pmapi.PmType64:"64-bit int",
+	pmapi.PmTypeU64:"64-bit unsigned int",
+	pmapi.PmTypeFloat:"float",
+	pmapi.PmTypeDouble:"double",
+	pmapi.PmTypeString:"string",
+}
+
+type Reporter struct {
+	pmapi pmapi.PMAPI
+	agent agent
+	/* Positions an archive before each fetch. nil for hosts */
+	rewind func() error
+	options Options
+	out io.Writer
+	errors io.Writer
+}
+
+/* agent is the part of the pcpeasy agent the reporter uses */
+type agent interface {
+	MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error)
+	MetricNames(prefix string) ([]string, error)
+}
+
+func NewReporter(context pmapi.PMAPI, options Options, out io.Writer, errors io.Writer) *Reporter {
+	return &Reporter{pmapi:context, agent:pcpeasy.NewAgentWithPMAPI(context), options:options, out:out, errors:errors}
+}
+
+/* NewArchiveReporter reports on an archive context, calling rewind before each fetch so that every
+   metric's values come from the same point in the archive */
+func NewArchiveReporter(context pmapi.PMAPI, rewind func() error, options Options, out io.Writer, errors io.Writer) *Reporter {
+	return &Reporter{pmapi:context, agent:pcpeasy.NewArchiveAgentWithPMAPI(context), rewind:rewind, options:options, out:out, errors:errors}
+}
+
+/* Report prints every metric under each prefix, or every metric when there are none. Failures are
+   written to the error writer and reporting carries on; Report returns false if there were any */
+func (r *Reporter) Report(prefixes ...string) bool {
+	if(len(prefixes) == 0) {
+		prefixes = []string{""}
+	}
+	ok := true
+	for _, prefix := range prefixes {
+		names, err := r.agent.MetricNames(prefix)
+		if(err != nil) {
+			fmt.Fprintf(r.errors, "%v: %v\n", prefix, err)
+			ok = false
+			continue
+		}
+		for _, name := range names {
+			err = r.report(name)
+			if(err != nil) {
+				fmt.Fprintf(r.errors, "%v: %v\n", name, err)
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+func (r *Reporter) report(name string) error {
+	pmids, err := r.pmapi.PmLookupName(name)
+	if(err != nil) {
+		return err
+	}
+	pmid := pmids[0]
+	desc, err := r.pmapi.PmLookupDesc(pmid)
+	if(err != nil) {
+		return err
+	}
+
+	if(r.options.verbose()) {
+		fmt.Fprintln(r.out)
+	}
+	fmt.Fprint(r.out, name)
+	if(r.options.PmIDs) {
+		fmt.Fprintf(r.out, " PMID: %v", pmapi.PmIDStr(pmid))
+	}
+	fmt.Fprintln(r.out)
+
+	if(r.options.Descriptors) {
+		r.printDesc(desc)
+	}
+	if(r.options.Labels) {
+		err = r.printLabels(desc)
+		if(err != nil) {
+			return err
+		}
+	}
+	if(r.options.Help) {
+		r.printHelp(pmid)
+	}
+	if(r.options.Values) {
+		return r.printValues(name)
+	}
+	return nil
+}
+
+func (r *Reporter) printDesc(desc pmapi.PmDesc) {
+	indom := "PM_INDOM_NULL"
+	if(desc.InDom != pmapi.PmInDomNull) {
+		indom = pmapi.PmInDomStr(desc.InDom)
+	}
+	units := pcpeasy.NewUnits(desc.Units).String()
+	if(units == "") {
+		units = "none"
+	}
+	fmt.Fprintf(r.out, "    Data Type: %v  InDom: %v 0x%x\n", typeNames[desc.Type], indom, uint32(desc.InDom))
+	fmt.Fprintf(r.out, "    Semantics: %v  Units: %v\n", semantics(desc.Sem), units)
+}
+
+func semantics(sem int) string {
+	switch sem {
+	case pmapi.PmSemCounter:
+		return "counter"
+	case pmapi.PmSemDiscrete:
+		return "discrete"
+	case pmapi.PmSemInstant:
+		return "instant"
+	}
+	return "unknown"
+}
+
+/* printLabels prints the metric's labels, then each instance's with the metric's merged in */
+func (r *Reporter) printLabels(desc pmapi.PmDesc) error {
+	label_sets, err := r.pmapi.PmLookupLabels(desc.PmID)
+	if(err != nil) {
+		return err
+	}
+	metric_labels := map[string]string{}
+	instance_labels := map[int]map[string]string{}
+	for _, label_set := range label_sets {
+		if(label_set.Inst == pmapi.PmInNull) {
+			for label, value := range label_set.Labels {
+				metric_labels[label] = value
+			}
+		} else {
+			instance_labels[label_set.Inst] = label_set.Labels
+		}
+	}
+	fmt.Fprintf(r.out, "    labels %v\n", labelsJSON(metric_labels))
+
+	instances := []int{}
+	for instance := range instance_labels {
+		instances = append(instances, instance)
+	}
+	sort.Ints(instances)
+	for _, instance := range instances {
+		merged := map[string]string{}
+		for label, value := range metric_labels {
+			merged[label] = value
+		}
+		for label, value := range instance_labels[instance] {
+			merged[label] = value
+		}
+		instance_name, _ := r.pmapi.PmNameInDom(desc.InDom, instance)
+		fmt.Fprintf(r.out, "    inst [%v or %q] labels %v\n", instance, instance_name, labelsJSON(merged))
+	}
+	return nil
+}
+
+/* labelsJSON writes labels as a JSON object with its keys sorted, as pminfo does. Values that
+   are JSON other than a string, which is how numbers, booleans and the like are kept, are written
+   as they are and the rest as strings */
+func labelsJSON(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := labels[name]
+		if(!json.Valid([]byte(value)) || strings.HasPrefix(value, "\"")) {
+			value = jsonString(value)
+		}
+		pairs[i] = jsonString(name) + ":" + value
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+/* jsonString quotes a string as JSON, leaving <, > and & as pminfo does */
+func jsonString(text string) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+func (r *Reporter) printHelp(pmid pmapi.PmID) {
+	fmt.Fprintln(r.out, "Help:")
+	help, err := r.pmapi.PmLookupText(pmid, pmapi.PmTextHelp)
+	if(err != nil) {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(r.out, help)
+}
+
+func (r *Reporter) printValues(name string) error {
+	if(r.rewind != nil) {
+		err := r.rewind()
+		if(err != nil) {
+			return err
+		}
+	}
+	metrics, err := r.agent.MetricsWithOptions(pcpeasy.FetchOptions{}, name)
+	if(err != nil) {
+		return err
+	}
+	metric := metrics[0]
+	if(len(metric.Values) == 0) {
+		fmt.Fprintln(r.out, "    No value(s) available!")
+		return nil
+	}
+	for _, value := range metric.Values {
+		if(metric.InDom == pmapi.PmInDomNull) {
+			fmt.Fprintf(r.out, "    value %v\n", formatValue(value.Value))
+		} else {
+			fmt.Fprintf(r.out, "    inst [%v or %q] value %v\n", value.InstanceID, value.Instance, formatValue(value.Value))
+		}
+	}
+	return nil
+}
+
+/* formatValue prints floats in their shortest exact form and strings quoted */
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(value)
+}
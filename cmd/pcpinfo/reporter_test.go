@@ -0,0 +1,149 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"bytes"
+)
+
+
+func report(options Options, prefixes ...string) (bool, string, string) {
+	out, errors := &bytes.Buffer{}, &bytes.Buffer{}
+	ok := NewReporter(pmapitest.NewSample(), options, out, errors).Report(prefixes...)
+	return ok, out.String(), errors.String()
+}
+
+func TestReporter_listsNamesUnderEachPrefix(t *testing.T) {
+	ok, out, _ := report(Options{}, "kernel.all")
+	assert.True(t, ok)
+	assert.Equal(t, "kernel.all.load\nkernel.all.pswitch\n", out)
+
+	_, out, _ = report(Options{PmIDs:true})
+	assert.Equal(t, "disk.dev.read_bytes PMID: 60.0.38\nkernel.all.load PMID: 60.2.0\nkernel.all.pswitch PMID: 60.0.13\nkernel.uname.release PMID: 60.12.0\n", out)
+}
+
+func TestReporter_printsDescriptors(t *testing.T) {
+	_, out, _ := report(Options{Descriptors:true}, "kernel.all")
+
+	assert.Equal(t, `
+kernel.all.load
+    Data Type: float  InDom: 60.2 0xf000002
+    Semantics: instant  Units: none
+
+kernel.all.pswitch
+    Data Type: 64-bit unsigned int  InDom: PM_INDOM_NULL 0xffffffff
+    Semantics: counter  Units: count
+`, out)
+}
+
+func TestReporter_printsValues(t *testing.T) {
+	_, out, _ := report(Options{Values:true})
+
+	assert.Equal(t, `
+disk.dev.read_bytes
+    inst [0 or "sda"] value 100
+    inst [1 or "sdb"] value 200
+
+kernel.all.load
+    inst [1 or "1 minute"] value 0.5
+    inst [5 or "5 minute"] value 0.25
+    inst [15 or "15 minute"] value 0.125
+
+kernel.all.pswitch
+    value 18446744073709551615
+
+kernel.uname.release
+    value "6.1.0"
+`, out)
+}
+
+func TestReporter_printsLabelsAndHelp(t *testing.T) {
+	_, out, _ := report(Options{Labels:true, Help:true}, "kernel.all")
+
+	assert.Equal(t, `
+kernel.all.load
+    labels {"agent":"linux","hostname":"web1"}
+    inst [5 or "5 minute"] labels {"agent":"linux","hostname":"web1","minutes":5}
+Help:
+Load averages over three intervals
+
+kernel.all.pswitch
+    labels {}
+Help:
+Error: One-line or help text is not available
+`, out)
+}
+
+func TestReporter_printsLabelValuesThatAreNotStringsAsTheyAre(t *testing.T) {
+	fake := pmapitest.NewSample()
+	fake.SetLabels("kernel.all.pswitch", []pmapi.PmLabelSet{
+		{Inst:pmapi.PmInNull, Labels:map[string]string{"userid":"0", "agent":"linux", "ok":"true", "site":"a & b", "quoted":"\"x\""}},
+	})
+	out := &bytes.Buffer{}
+
+	NewReporter(fake, Options{Labels:true}, out, &bytes.Buffer{}).Report("kernel.all.pswitch")
+
+	assert.Equal(t, "\nkernel.all.pswitch\n    labels {\"agent\":\"linux\",\"ok\":true,\"quoted\":\"\\\"x\\\"\",\"site\":\"a & b\",\"userid\":0}\n", out.String())
+}
+
+/* replay moves on to the archive's next record with each fetch, as a context in forward mode does */
+type replay struct {
+	*pmapitest.Context
+	record *uint64
+}
+
+func (r replay) PmFetch(pmids ...pmapi.PmID) (*pmapi.PmResult, error) {
+	r.SetValues("kernel.all.pswitch", map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:*r.record}})
+	*r.record++
+	return r.Context.PmFetch(pmids...)
+}
+
+func TestReporter_fetchesEveryValueFromTheStartOfAnArchive(t *testing.T) {
+	record := uint64(100)
+	rewind := func() error {
+		record = 100
+		return nil
+	}
+	out := &bytes.Buffer{}
+
+	NewArchiveReporter(replay{pmapitest.NewSample(), &record}, rewind, Options{Values:true}, out, &bytes.Buffer{}).
+		Report("kernel.all.pswitch", "kernel.all.pswitch")
+
+	assert.Equal(t, "\nkernel.all.pswitch\n    value 100\n\nkernel.all.pswitch\n    value 100\n", out.String())
+}
+
+func TestReporter_reportsUnknownNamesAndCarriesOn(t *testing.T) {
+	ok, out, errors := report(Options{}, "no.such", "kernel.uname")
+
+	assert.False(t, ok)
+	assert.Equal(t, "kernel.uname.release\n", out)
+	assert.Equal(t, "no.such: Unknown metric name\n", errors)
+}
+
+func TestRun_rejectsUnknownFlags(t *testing.T) {
+	out, errors := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, 2, run([]string{"-x"}, out, errors))
+	assert.Contains(t, errors.String(), "Usage: pcpinfo")
+}
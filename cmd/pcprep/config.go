@@ -0,0 +1,210 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Config is a pmrep(1) style configuration: an [options] section of defaults for the command line
+flags, and named metric sets each listing metrics in pmrep's compact form
+
+	[options]
+	interval = 5s
+	output = csv
+
+	[disk]
+	disk.dev.read_bytes = read,sd.*,Mbyte / sec,,10,1
+	disk.dev.write_bytes = write,sd.*,Mbyte / sec
+
+	[load]
+	kernel.all.load = load,1 minute
+	kernel.all.pswitch = pswitch,,,raw
+
+Blank lines and lines starting with # or ; are ignored.
+*/
+type Config struct {
+	Options map[string]string
+	Sets map[string][]MetricSpec
+}
+
+/*
+MetricSpec is one metric of a set: label,instances,units,type,width,precision. Every field is
+optional and trailing ones may be left out. Commas inside brackets, as in sd[a-z]{1,2}, or inside
+a field wrapped in double quotes do not end the field; the quotes are dropped.
+
+	label      column heading, the metric name by default
+	instances  regular expression instance names must match in full, all instances by default
+	units      units to scale values to, such as "Mbyte / sec"
+	type       "raw" to report a counter as it is rather than as a rate
+	width      table column width, wide enough for the heading by default
+	precision  decimal places for floating point values, 2 by default
+*/
+type MetricSpec struct {
+	Name string
+	Label string
+	Instances *regexp.Regexp
+	Units *pcpeasy.Units
+	Raw bool
+	Width int
+	Precision int
+}
+
+func ParseConfig(reader io.Reader) (*Config, error) {
+	config := &Config{Options:make(map[string]string), Sets:make(map[string][]MetricSpec)}
+	scanner := bufio.NewScanner(reader)
+	section := ""
+	for line_number := 1; scanner.Scan(); line_number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if(line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")) {
+			continue
+		}
+		if(strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]")) {
+			section = strings.TrimSpace(line[1:len(line) - 1])
+			if(section != "options") {
+				config.Sets[section] = config.Sets[section]
+			}
+			continue
+		}
+		equals := strings.Index(line, "=")
+		if(equals < 0) {
+			return nil, errors.New(fmt.Sprintf("config line %v: expected key = value", line_number))
+		}
+		key, value := strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals + 1:])
+		switch section {
+		case "":
+			return nil, errors.New(fmt.Sprintf("config line %v: %v is not in a section", line_number, key))
+		case "options":
+			config.Options[key] = value
+		default:
+			spec, err := parseMetricSpec(key, value)
+			if(err != nil) {
+				return nil, errors.New(fmt.Sprintf("config line %v: %v", line_number, err))
+			}
+			config.Sets[section] = append(config.Sets[section], spec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func parseMetricSpec(name string, value string) (MetricSpec, error) {
+	fields, err := splitFields(value)
+	if(err != nil) {
+		return MetricSpec{}, errors.New(fmt.Sprintf("%v: %v", name, err))
+	}
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	if(len(fields) > 6) {
+		return MetricSpec{}, errors.New(fmt.Sprintf("%v has %v fields, expected at most 6", name, len(fields)))
+	}
+
+	spec := MetricSpec{Name:name, Label:fields[0], Precision:2}
+	if(spec.Label == "") {
+		spec.Label = name
+	}
+	if(fields[1] != "") {
+		pattern, err := regexp.Compile("^(?:" + fields[1] + ")$")
+		if(err != nil) {
+			return MetricSpec{}, errors.New(fmt.Sprintf("%v instances: %v", name, err))
+		}
+		spec.Instances = pattern
+	}
+	if(fields[2] != "") {
+		units, err := pcpeasy.ParseUnits(fields[2])
+		if(err != nil) {
+			return MetricSpec{}, errors.New(fmt.Sprintf("%v: %v", name, err))
+		}
+		spec.Units = &units
+	}
+	switch fields[3] {
+	case "":
+	case "raw":
+		spec.Raw = true
+	default:
+		return MetricSpec{}, errors.New(fmt.Sprintf("%v: unknown type \"%v\"", name, fields[3]))
+	}
+	if(fields[4] != "") {
+		spec.Width, err = strconv.Atoi(fields[4])
+		if(err != nil || spec.Width < 1) {
+			return MetricSpec{}, errors.New(fmt.Sprintf("%v: invalid width \"%v\"", name, fields[4]))
+		}
+	}
+	if(fields[5] != "") {
+		spec.Precision, err = strconv.Atoi(fields[5])
+		if(err != nil || spec.Precision < 0) {
+			return MetricSpec{}, errors.New(fmt.Sprintf("%v: invalid precision \"%v\"", name, fields[5]))
+		}
+	}
+	return spec, nil
+}
+
+/* splitFields splits a metric spec on the commas that are neither in brackets nor quoted */
+func splitFields(value string) ([]string, error) {
+	fields := []string{}
+	field := strings.Builder{}
+	depth, quoted := 0, false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			continue
+		case quoted:
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case (r == ')' || r == ']' || r == '}') && depth > 0:
+			depth--
+		case r == ',' && depth == 0:
+			fields = append(fields, strings.TrimSpace(field.String()))
+			field.Reset()
+			continue
+		}
+		field.WriteRune(r)
+	}
+	if(quoted) {
+		return nil, errors.New("unterminated quote")
+	}
+	return append(fields, strings.TrimSpace(field.String())), nil
+}
+
+/* Metrics returns the metrics of the named sets in order */
+func (c *Config) Metrics(sets ...string) ([]MetricSpec, error) {
+	specs := []MetricSpec{}
+	for _, set := range sets {
+		set_specs, found := c.Sets[set]
+		if(!found) {
+			return nil, errors.New(fmt.Sprintf("no metric set [%v] in config", set))
+		}
+		specs = append(specs, set_specs...)
+	}
+	return specs, nil
+}
@@ -0,0 +1,119 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"strings"
+)
+
+const exampleConfig = `
+# Disk and load
+[options]
+interval = 5s
+output = csv
+
+[disk]
+disk.dev.read_bytes = read,sd.*,Mbyte / sec,,10,1
+
+[load]
+; load averages
+kernel.all.load = load , 1 minute
+kernel.all.pswitch = pswitch,,,raw
+`
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(exampleConfig))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"interval":"5s", "output":"csv"}, config.Options)
+	assert.Len(t, config.Sets, 2)
+
+	read := config.Sets["disk"][0]
+	assert.Equal(t, "disk.dev.read_bytes", read.Name)
+	assert.Equal(t, "read", read.Label)
+	assert.True(t, read.Instances.MatchString("sda"))
+	assert.False(t, read.Instances.MatchString("nvme0n1"))
+	assert.False(t, read.Instances.MatchString("xsda"))
+	assert.Equal(t, &pcpeasy.Units{DimSpace:1, DimTime:-1, ScaleSpace:pcpeasy.SpaceMByte, ScaleTime:pcpeasy.TimeSec}, read.Units)
+	assert.Equal(t, 10, read.Width)
+	assert.Equal(t, 1, read.Precision)
+
+	assert.Equal(t, []MetricSpec{
+		{Name:"kernel.all.load", Label:"load", Instances:config.Sets["load"][0].Instances, Precision:2},
+		{Name:"kernel.all.pswitch", Label:"pswitch", Raw:true, Precision:2},
+	}, config.Sets["load"])
+}
+
+func TestParseConfig_defaultsLabelsToTheMetricName(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader("[mem]\nmem.util.free =\n"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricSpec{{Name:"mem.util.free", Label:"mem.util.free", Precision:2}}, config.Sets["mem"])
+}
+
+func TestParseConfig_keepsCommasInBracketsAndQuotes(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader("[disk]\ndisk.dev.read = \"read, total\",sd[a-z]{1,2},,,12\n"))
+
+	assert.NoError(t, err)
+	read := config.Sets["disk"][0]
+	assert.Equal(t, "read, total", read.Label)
+	assert.True(t, read.Instances.MatchString("sdab"))
+	assert.False(t, read.Instances.MatchString("sdabc"))
+	assert.Equal(t, 12, read.Width)
+}
+
+var badConfigs = []struct{
+	config string
+	err string
+}{
+	{"mem.util.free = free", "config line 1: mem.util.free is not in a section"},
+	{"[mem]\nmem.util.free", "config line 2: expected key = value"},
+	{"[mem]\nmem.util.free = free,(,", "config line 2: mem.util.free instances: error parsing regexp: missing closing ): `^(?:(,)$`"},
+	{"[mem]\nmem.util.free = free,,fortnights", "config line 2: mem.util.free: invalid units \"fortnights\": unknown unit \"fortnights\""},
+	{"[mem]\nmem.util.free = free,,,cooked", "config line 2: mem.util.free: unknown type \"cooked\""},
+	{"[mem]\nmem.util.free = free,,,,wide", "config line 2: mem.util.free: invalid width \"wide\""},
+	{"[mem]\nmem.util.free = free,,,,8,-1", "config line 2: mem.util.free: invalid precision \"-1\""},
+	{"[mem]\nmem.util.free = \"free,,", "config line 2: mem.util.free: unterminated quote"},
+	{"[mem]\nmem.util.free = free,,,,8,2,extra", "config line 2: mem.util.free has 7 fields, expected at most 6"},
+}
+
+func TestParseConfig_reportsBadLines(t *testing.T) {
+	for _, test := range badConfigs {
+		_, err := ParseConfig(strings.NewReader(test.config))
+
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestConfig_Metrics_combinesSetsInOrder(t *testing.T) {
+	config, _ := ParseConfig(strings.NewReader(exampleConfig))
+
+	specs, err := config.Metrics("load", "disk")
+	assert.NoError(t, err)
+	assert.Equal(t, "kernel.all.load", specs[0].Name)
+	assert.Equal(t, "disk.dev.read_bytes", specs[2].Name)
+
+	_, err = config.Metrics("network")
+	assert.EqualError(t, err, "no metric set [network] in config")
+}
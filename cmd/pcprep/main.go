@@ -0,0 +1,222 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcprep reports metric sets from a configuration file as a table, CSV or JSON, in the
+manner of pmrep(1). See Config for the file format.
+
+	pcprep -c config [-o table|csv|json] [-h host | -a archive [-S start] [-T end]] [-t interval] [-s samples] set ...
+
+Options in the config file's [options] section (host, archive, start, end, interval, samples and
+output) are used when the flag is not given. Counters are reported as rates per second unless a
+metric's type is raw. Times are given in RFC 3339 form, e.g. 2024-03-01T09:00:00Z.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+/* fetcher is the part of the pcpeasy agent pcprep uses */
+type fetcher interface {
+	Metrics(metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+/* optionFlags maps [options] keys to the flags they default */
+var optionFlags = map[string]string{
+	"host":"h",
+	"archive":"a",
+	"start":"S",
+	"end":"T",
+	"interval":"t",
+	"samples":"s",
+	"output":"o",
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pcprep", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	config_path := flags.String("c", "", "`config` file of metric sets")
+	host := flags.String("h", "localhost", "`host` to fetch metrics from")
+	archive := flags.String("a", "", "`archive` to replay instead of fetching from a host")
+	start := flags.String("S", "", "`time` to start replaying the archive at")
+	end := flags.String("T", "", "`time` to stop replaying the archive at")
+	interval := flags.Duration("t", time.Second, "sampling `interval`")
+	samples := flags.Int("s", 0, "number of `samples` to report, zero for all")
+	output := flags.String("o", "table", "`output` format: table, csv or json")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: pcprep -c config [-o table|csv|json] [-h host | -a archive [-S start] [-T end]] [-t interval] [-s samples] set ...")
+		flags.PrintDefaults()
+	}
+	if(flags.Parse(args) != nil) {
+		return 2
+	}
+	if(*config_path == "" || flags.NArg() == 0) {
+		flags.Usage()
+		return 2
+	}
+
+	err := func() error {
+		config, err := loadConfig(*config_path)
+		if(err != nil) {
+			return err
+		}
+		err = applyOptions(flags, config.Options)
+		if(err != nil) {
+			return err
+		}
+		specs, err := config.Metrics(flags.Args()...)
+		if(err != nil) {
+			return err
+		}
+		format, err := ParseFormat(*output)
+		if(err != nil) {
+			return err
+		}
+		start_time, err := parseTime(*start)
+		if(err != nil) {
+			return err
+		}
+		end_time, err := parseTime(*end)
+		if(err != nil) {
+			return err
+		}
+
+		var source fetcher
+		pace := *interval
+		if(*archive != "") {
+			source, err = pcpeasy.NewArchiveAgent(*archive, start_time, *interval)
+			pace = 0
+		} else if(*start != "" || *end != "") {
+			return errors.New("start and end times need an archive")
+		} else {
+			source, err = pcpeasy.NewAgent(*host)
+		}
+		if(err != nil) {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return sample(ctx, source, NewReport(stdout, stderr, format, specs, nil), *samples, end_time, pace)
+	}()
+	if(err != nil) {
+		fmt.Fprintf(stderr, "pcprep: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func loadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if(err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseConfig(file)
+}
+
+/* applyOptions sets each flag not given on the command line from the config's [options] */
+func applyOptions(flags *flag.FlagSet, options map[string]string) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for option, value := range options {
+		name, found := optionFlags[option]
+		if(!found) {
+			return errors.New(fmt.Sprintf("unknown option \"%v\" in config", option))
+		}
+		if(given[name]) {
+			continue
+		}
+		err := flags.Set(name, value)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("option %v: %v", option, err))
+		}
+	}
+	return nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if(value == "") {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+/* sample writes the report until samples rows are written, the archive ends or passes end, or
+   ctx is done, then flushes any rows the report is still holding. Fetches are paced by pace; an
+   archive, with a pace of zero, is read straight through */
+func sample(ctx context.Context, source fetcher, report *Report, samples int, end time.Time, pace time.Duration) error {
+	err := fetchRows(ctx, source, report, samples, end, pace)
+	if(err != nil) {
+		return err
+	}
+	return report.Flush()
+}
+
+func fetchRows(ctx context.Context, source fetcher, report *Report, samples int, end time.Time, pace time.Duration) error {
+	var ticks <-chan time.Time
+	if(pace > 0) {
+		ticker := time.NewTicker(pace)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	names := report.Names()
+	for first := true; samples == 0 || report.Rows() < samples; first = false {
+		if(!first && ticks != nil) {
+			select {
+			case <-ctx.Done():
+			case <-ticks:
+			}
+		}
+		if(ctx.Err() != nil) {
+			return nil
+		}
+		metrics, err := source.Metrics(names...)
+		if(pcpeasy.IsEndOfArchive(err)) {
+			return nil
+		}
+		if(err != nil) {
+			return err
+		}
+		if(!end.IsZero() && metrics[0].Timestamp.After(end)) {
+			return nil
+		}
+		err = report.Write(metrics)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
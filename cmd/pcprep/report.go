@@ -0,0 +1,459 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format int
+
+const (
+	Table Format = iota
+	CSV
+	JSON
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "table":
+		return Table, nil
+	case "csv":
+		return CSV, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, errors.New(fmt.Sprintf("unknown output \"%v\", use table, csv or json", name))
+}
+
+/* column is one instance of one metric spec. instance is empty for metrics without an instance
+   domain */
+type column struct {
+	spec MetricSpec
+	index int
+	instance_id int
+	instance string
+	units string
+	width int
+}
+
+func (c column) heading() string {
+	if(c.instance == "") {
+		return c.spec.Label
+	}
+	return fmt.Sprintf("%v[%v]", c.spec.Label, c.instance)
+}
+
+/* output writes a report in one format */
+type output interface {
+	header(columns []column) error
+	row(timestamp time.Time, columns []column, values []interface{}) error
+}
+
+/*
+Report writes successive fetches of its metric specs as rows with a column per metric instance.
+Columns are fixed once every spec has reported a value, holding back rows for up to
+columnLookAhead fetches while waiting, so metrics that are late to have values still get their
+columns. Specs that report nothing by then keep a placeholder column of missing values and
+instances that appear after the columns are fixed are left out; both are warned about. When any
+spec reports a counter as a rate the first fetch only sets the base of the rates and no row is
+written for it.
+*/
+type Report struct {
+	specs []MetricSpec
+	output output
+	warnings io.Writer
+	rates []*pcpeasy.RateConverter
+	fetches int
+	found [][]column
+	last []pcpeasy.Metric
+	held []heldRow
+	columns []column
+	dropped map[string]bool
+	rows int
+}
+
+/* columnLookAhead is the most fetches held back waiting for every spec to report a value */
+const columnLookAhead = 10
+
+/* heldRow is a row fetched before the columns are fixed */
+type heldRow struct {
+	timestamp time.Time
+	values []map[int]interface{}
+}
+
+func NewReport(writer io.Writer, warnings io.Writer, format Format, specs []MetricSpec, location *time.Location) *Report {
+	if(location == nil) {
+		location = time.Local
+	}
+	rates := make([]*pcpeasy.RateConverter, len(specs))
+	for i := range specs {
+		rates[i] = pcpeasy.NewRateConverter()
+	}
+	report := &Report{specs:specs, warnings:warnings, rates:rates, found:make([][]column, len(specs)), dropped:make(map[string]bool)}
+	switch format {
+	case CSV:
+		report.output = &csvOutput{writer:csv.NewWriter(writer), location:location}
+	case JSON:
+		report.output = &jsonOutput{encoder:json.NewEncoder(writer), location:location}
+	default:
+		report.output = &tableOutput{writer:writer, location:location}
+	}
+	return report
+}
+
+/* Names returns the metrics to fetch for each Write, in order */
+func (r *Report) Names() []string {
+	names := make([]string, len(r.specs))
+	for i, spec := range r.specs {
+		names[i] = spec.Name
+	}
+	return names
+}
+
+/* Rows is the number of rows reported, including any held back until the columns are fixed */
+func (r *Report) Rows() int {
+	return r.rows + len(r.held)
+}
+
+/* Write reports one fetch of the metrics returned by Names */
+func (r *Report) Write(metrics []pcpeasy.Metric) error {
+	if(len(metrics) != len(r.specs)) {
+		return errors.New(fmt.Sprintf("expected %v metrics, got %v", len(r.specs), len(metrics)))
+	}
+	r.fetches++
+	any_rates := false
+	values := make([]map[int]interface{}, len(metrics))
+	for i, metric := range metrics {
+		spec := r.specs[i]
+		if(metric.Semantics == "counter" && !spec.Raw) {
+			metric = r.rates[i].Convert([]pcpeasy.Metric{metric})[0]
+			any_rates = true
+		}
+		if(spec.Units != nil) {
+			var err error
+			metric, err = metric.ConvertUnits(*spec.Units)
+			if(err != nil) {
+				return errors.New(fmt.Sprintf("%v: %v", spec.Name, err))
+			}
+		}
+		values[i] = make(map[int]interface{})
+		for _, value := range metric.Values {
+			values[i][value.InstanceID] = value.Value
+		}
+	}
+
+	if(r.columns != nil) {
+		r.warnDropped(metrics)
+		return r.writeRow(metrics[0].Timestamp, values)
+	}
+	r.find(metrics)
+	if(r.fetches > 1 || !any_rates) {
+		r.held = append(r.held, heldRow{timestamp:metrics[0].Timestamp, values:values})
+	}
+	if(r.fetches < columnLookAhead && !r.allFound()) {
+		return nil
+	}
+	return r.Flush()
+}
+
+/* Flush fixes the columns if they are not yet and writes any rows held back waiting for them */
+func (r *Report) Flush() error {
+	if(r.columns == nil) {
+		if(r.fetches == 0) {
+			return nil
+		}
+		r.columns = r.fixColumns()
+		err := r.output.header(r.columns)
+		if(err != nil) {
+			return err
+		}
+	}
+	held := r.held
+	r.held = nil
+	for _, row := range held {
+		err := r.writeRow(row.timestamp, row.values)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Report) writeRow(timestamp time.Time, values []map[int]interface{}) error {
+	row := make([]interface{}, len(r.columns))
+	for i, column := range r.columns {
+		row[i] = values[column.index][column.instance_id]
+	}
+	r.rows++
+	return r.output.row(timestamp, r.columns, row)
+}
+
+/* find adds a column for each instance selected by a spec that has not been seen before */
+func (r *Report) find(metrics []pcpeasy.Metric) {
+	for i, metric := range metrics {
+		spec := r.specs[i]
+		for _, value := range metric.Values {
+			if(!r.selected(metric, spec, value) || r.hasColumn(r.found[i], i, value.InstanceID)) {
+				continue
+			}
+			r.found[i] = append(r.found[i], r.column(i, metric, value.Instance, value.InstanceID))
+		}
+	}
+	r.last = metrics
+}
+
+func (r *Report) allFound() bool {
+	for _, columns := range r.found {
+		if(len(columns) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+/* fixColumns gives the columns found so far, with a placeholder for each spec that has none */
+func (r *Report) fixColumns() []column {
+	columns := []column{}
+	for i, found := range r.found {
+		if(len(found) == 0) {
+			fmt.Fprintf(r.warnings, "pcprep: warning: %v has no values, its column is left empty\n", r.specs[i].Name)
+			found = []column{r.column(i, r.last[i], "", pmapi.PmInNull)}
+		}
+		columns = append(columns, found...)
+	}
+	return columns
+}
+
+/* warnDropped warns, once each, of selected instances that appear after the columns are fixed */
+func (r *Report) warnDropped(metrics []pcpeasy.Metric) {
+	for i, metric := range metrics {
+		spec := r.specs[i]
+		for _, value := range metric.Values {
+			if(!r.selected(metric, spec, value) || r.hasColumn(r.columns, i, value.InstanceID)) {
+				continue
+			}
+			heading := fmt.Sprintf("%v[%v]", spec.Name, value.Instance)
+			if(!r.dropped[heading]) {
+				r.dropped[heading] = true
+				fmt.Fprintf(r.warnings, "pcprep: warning: %v appeared after the columns were fixed and is not reported\n", heading)
+			}
+		}
+	}
+}
+
+func (r *Report) selected(metric pcpeasy.Metric, spec MetricSpec, value pcpeasy.MetricValue) bool {
+	return metric.InDom == pmapi.PmInDomNull || spec.Instances == nil || spec.Instances.MatchString(value.Instance)
+}
+
+func (r *Report) hasColumn(columns []column, index int, instance_id int) bool {
+	for _, column := range columns {
+		if(column.index == index && column.instance_id == instance_id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) column(index int, metric pcpeasy.Metric, instance string, instance_id int) column {
+	spec := r.specs[index]
+	if(metric.InDom == pmapi.PmInDomNull) {
+		instance = ""
+	}
+	units := reportedUnits(metric, spec)
+	width := spec.Width
+	if(width == 0) {
+		width = maxLength(8, spec.Label, instance, units)
+	}
+	return column{spec:spec, index:index, instance_id:instance_id, instance:instance, units:units, width:width}
+}
+/* reportedUnits gives the units a metric's values are reported in once rate converted and scaled */
+func reportedUnits(metric pcpeasy.Metric, spec MetricSpec) string {
+	units := metric.Units
+	if(metric.Semantics == "counter" && !spec.Raw) {
+		units.ScaleTime = pcpeasy.TimeSec
+		units.DimTime--
+	}
+	if(spec.Units != nil) {
+		units = *spec.Units
+	}
+	if(units.IsDimensionless()) {
+		return "none"
+	}
+	return units.String()
+}
+
+func maxLength(minimum int, values ...string) int {
+	for _, value := range values {
+		if(len(value) > minimum) {
+			minimum = len(value)
+		}
+	}
+	return minimum
+}
+
+/* formatValue writes floats to precision decimal places. Missing values are empty */
+func formatValue(value interface{}, precision int) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', precision, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', precision, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+/* tableOutput aligns values under headings of labels, instances and units, as pmrep does */
+type tableOutput struct {
+	writer io.Writer
+	location *time.Location
+}
+
+const tableTimeFormat = "15:04:05"
+
+func (o *tableOutput) header(columns []column) error {
+	labels, instances, units := []string{pad("", len(tableTimeFormat))}, []string{pad("", len(tableTimeFormat))}, []string{pad("", len(tableTimeFormat))}
+	any_instances := false
+	for _, column := range columns {
+		labels = append(labels, pad(column.spec.Label, column.width))
+		instances = append(instances, pad(column.instance, column.width))
+		units = append(units, pad(column.units, column.width))
+		any_instances = any_instances || column.instance != ""
+	}
+	lines := [][]string{labels}
+	if(any_instances) {
+		lines = append(lines, instances)
+	}
+	lines = append(lines, units)
+	for _, line := range lines {
+		_, err := fmt.Fprintln(o.writer, strings.TrimRight(strings.Join(line, "  "), " "))
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *tableOutput) row(timestamp time.Time, columns []column, values []interface{}) error {
+	line := []string{timestamp.In(o.location).Format(tableTimeFormat)}
+	for i, column := range columns {
+		formatted := formatValue(values[i], column.spec.Precision)
+		if(values[i] == nil) {
+			formatted = "N/A"
+		}
+		line = append(line, pad(formatted, column.width))
+	}
+	_, err := fmt.Fprintln(o.writer, strings.Join(line, "  "))
+	return err
+}
+
+/* pad right aligns s in width columns */
+func pad(s string, width int) string {
+	return fmt.Sprintf("%*s", width, s)
+}
+
+/* csvOutput writes a heading row of label[instance] (units) then a row per sample, flushed as
+   each is written so the report can be followed live */
+type csvOutput struct {
+	writer *csv.Writer
+	location *time.Location
+}
+
+func (o *csvOutput) header(columns []column) error {
+	headings := []string{"Time"}
+	for _, column := range columns {
+		headings = append(headings, fmt.Sprintf("%v (%v)", column.heading(), column.units))
+	}
+	o.writer.Write(headings)
+	o.writer.Flush()
+	return o.writer.Error()
+}
+
+func (o *csvOutput) row(timestamp time.Time, columns []column, values []interface{}) error {
+	record := []string{timestamp.In(o.location).Format(time.RFC3339)}
+	for i, column := range columns {
+		record = append(record, formatValue(values[i], column.spec.Precision))
+	}
+	o.writer.Write(record)
+	o.writer.Flush()
+	return o.writer.Error()
+}
+
+/* jsonOutput writes a JSON object per sample, one per line:
+
+	{"timestamp":"2024-03-01T09:00:00Z","values":{"load":{"1 minute":0.5},"pswitch":1234}}
+
+Metrics without an instance domain map their label straight to the value. Missing values and
+non-finite floats are left out */
+type jsonOutput struct {
+	encoder *json.Encoder
+	location *time.Location
+}
+
+func (o *jsonOutput) header(columns []column) error {
+	return nil
+}
+
+func (o *jsonOutput) row(timestamp time.Time, columns []column, values []interface{}) error {
+	labelled := make(map[string]interface{})
+	for i, column := range columns {
+		value := values[i]
+		if(value == nil || !finite(value)) {
+			continue
+		}
+		if(column.instance == "") {
+			labelled[column.spec.Label] = value
+			continue
+		}
+		instances, ok := labelled[column.spec.Label].(map[string]interface{})
+		if(!ok) {
+			instances = make(map[string]interface{})
+			labelled[column.spec.Label] = instances
+		}
+		instances[column.instance] = value
+	}
+	return o.encoder.Encode(map[string]interface{}{
+		"timestamp":timestamp.In(o.location).Format(time.RFC3339Nano),
+		"values":labelled,
+	})
+}
+
+func finite(value interface{}) bool {
+	switch v := value.(type) {
+	case float32:
+		return !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+	case float64:
+		return !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"bytes"
+	"context"
+	"flag"
+	"strings"
+	"time"
+)
+
+var reportStart = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+const reportConfig = `
+[report]
+kernel.all.load = load,1 minute|5 minute,,,,1
+disk.dev.read_bytes = read,,Kbyte / sec
+kernel.all.pswitch = pswitch,,,raw
+`
+
+func reportSpecs(t *testing.T) []MetricSpec {
+	config, err := ParseConfig(strings.NewReader(reportConfig))
+	assert.NoError(t, err)
+	specs, _ := config.Metrics("report")
+	return specs
+}
+
+func fetched(at time.Duration, read_bytes uint64, pswitch uint64) []pcpeasy.Metric {
+	timestamp := reportStart.Add(at)
+	return []pcpeasy.Metric{
+		{Name:"kernel.all.load", Semantics:"instant", Timestamp:timestamp, InDom:pmapi.PmInDomFromParts(60, 2),
+			Values:[]pcpeasy.MetricValue{
+				{Instance:"1 minute", InstanceID:1, Value:float32(0.5)},
+				{Instance:"5 minute", InstanceID:5, Value:float32(0.25)},
+				{Instance:"15 minute", InstanceID:15, Value:float32(0.125)},
+			}},
+		{Name:"disk.dev.read_bytes", Semantics:"counter", Timestamp:timestamp, InDom:pmapi.PmInDomFromParts(60, 1),
+			Units:pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceByte},
+			Values:[]pcpeasy.MetricValue{{Instance:"sda", InstanceID:0, Value:read_bytes}}},
+		{Name:"kernel.all.pswitch", Semantics:"counter", Timestamp:timestamp, InDom:pmapi.PmInDomNull,
+			Units:pcpeasy.Units{DimCount:1},
+			Values:[]pcpeasy.MetricValue{{InstanceID:pmapi.PmInNull, Value:pswitch}}},
+	}
+}
+
+func writeReport(t *testing.T, format Format) string {
+	out := &bytes.Buffer{}
+	report := NewReport(out, &bytes.Buffer{}, format, reportSpecs(t), time.UTC)
+
+	assert.NoError(t, report.Write(fetched(0, 0, 100)))
+	assert.NoError(t, report.Write(fetched(2 * time.Second, 4096, 150)))
+	assert.Equal(t, 1, report.Rows())
+	return out.String()
+}
+
+func TestReport_writesATable(t *testing.T) {
+	assert.Equal(t, `              load      load         read   pswitch
+          1 minute  5 minute          sda
+              none      none  Kbyte / sec     count
+09:00:02       0.5       0.2         2.00       150
+`, writeReport(t, Table))
+}
+
+func TestReport_writesCSV(t *testing.T) {
+	assert.Equal(t, `Time,load[1 minute] (none),load[5 minute] (none),read[sda] (Kbyte / sec),pswitch (count)
+2024-03-01T09:00:02Z,0.5,0.2,2.00,150
+`, writeReport(t, CSV))
+}
+
+func TestReport_writesJSON(t *testing.T) {
+	assert.JSONEq(t, `{"timestamp":"2024-03-01T09:00:02Z","values":{
+		"load":{"1 minute":0.5,"5 minute":0.25},"read":{"sda":2},"pswitch":150}}`, writeReport(t, JSON))
+}
+
+func TestReport_marksInstancesThatDisappear(t *testing.T) {
+	out := &bytes.Buffer{}
+	report := NewReport(out, &bytes.Buffer{}, Table, reportSpecs(t)[:1], time.UTC)
+
+	report.Write(fetched(0, 0, 0)[:1])
+	gone := fetched(time.Second, 0, 0)[:1]
+	gone[0].Values = gone[0].Values[1:]
+	report.Write(gone)
+
+	assert.Contains(t, out.String(), "09:00:01       N/A       0.2\n")
+}
+
+func TestReport_waitsForEverySpecToReportBeforeFixingColumns(t *testing.T) {
+	out, warnings := &bytes.Buffer{}, &bytes.Buffer{}
+	report := NewReport(out, warnings, Table, reportSpecs(t)[:2], time.UTC)
+
+	late := fetched(0, 0, 0)[:2]
+	late[1].Values = nil
+	assert.NoError(t, report.Write(late))
+	assert.Equal(t, "", out.String())
+	assert.NoError(t, report.Write(fetched(time.Second, 0, 0)[:2]))
+	assert.NoError(t, report.Write(fetched(2 * time.Second, 2048, 0)[:2]))
+
+	assert.Equal(t, `              load      load         read
+          1 minute  5 minute          sda
+              none      none  Kbyte / sec
+09:00:01       0.5       0.2          N/A
+09:00:02       0.5       0.2         2.00
+`, out.String())
+	assert.Equal(t, "", warnings.String())
+}
+
+func TestReport_keepsAnEmptyColumnForSpecsThatNeverReport(t *testing.T) {
+	out, warnings := &bytes.Buffer{}, &bytes.Buffer{}
+	report := NewReport(out, warnings, CSV, reportSpecs(t)[2:], time.UTC)
+
+	for i := 0; i < columnLookAhead; i++ {
+		missing := fetched(time.Duration(i) * time.Second, 0, 0)[2:]
+		missing[0].Values = nil
+		assert.NoError(t, report.Write(missing))
+	}
+
+	assert.Equal(t, columnLookAhead, report.Rows())
+	assert.True(t, strings.HasPrefix(out.String(), "Time,pswitch (count)\n2024-03-01T09:00:00Z,\n"))
+	assert.Equal(t, "pcprep: warning: kernel.all.pswitch has no values, its column is left empty\n", warnings.String())
+}
+
+func TestReport_warnsOfInstancesThatAppearLater(t *testing.T) {
+	warnings := &bytes.Buffer{}
+	report := NewReport(&bytes.Buffer{}, warnings, CSV, reportSpecs(t)[1:2], time.UTC)
+
+	report.Write(fetched(0, 0, 0)[1:2])
+	for i := 1; i < 3; i++ {
+		later := fetched(time.Duration(i) * time.Second, 0, 0)[1:2]
+		later[0].Values = append(later[0].Values, pcpeasy.MetricValue{Instance:"sdb", InstanceID:1, Value:uint64(0)})
+		report.Write(later)
+	}
+
+	assert.Equal(t, "pcprep: warning: disk.dev.read_bytes[sdb] appeared after the columns were fixed and is not reported\n", warnings.String())
+}
+
+func TestSample_flushesRowsHeldByTheReport(t *testing.T) {
+	out := &bytes.Buffer{}
+	report := NewReport(out, &bytes.Buffer{}, CSV, reportSpecs(t)[:1], time.UTC)
+	source := &fakeFetcher{}
+	specs_source := fetcherFunc(func(metric_names ...string) ([]pcpeasy.Metric, error) {
+		metrics, _ := source.Metrics()
+		metrics[0].Values = nil
+		return metrics[:1], nil
+	})
+
+	err := sample(context.Background(), specs_source, report, 2, time.Time{}, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Time,load (none)\n2024-03-01T09:00:01Z,\n2024-03-01T09:00:02Z,\n", out.String())
+}
+
+type fetcherFunc func(metric_names ...string) ([]pcpeasy.Metric, error)
+
+func (f fetcherFunc) Metrics(metric_names ...string) ([]pcpeasy.Metric, error) {
+	return f(metric_names...)
+}
+
+func TestReport_rejectsUnitsOfOtherDimensions(t *testing.T) {
+	specs := reportSpecs(t)[1:2]
+	specs[0].Units = &pcpeasy.Units{DimTime:1, ScaleTime:pcpeasy.TimeSec}
+
+	err := NewReport(&bytes.Buffer{}, &bytes.Buffer{}, Table, specs, time.UTC).Write(fetched(0, 0, 0)[1:2])
+
+	assert.EqualError(t, err, "disk.dev.read_bytes: cannot convert \"byte / sec\" to \"sec\"")
+}
+
+type fakeFetcher struct {
+	fetches int
+}
+
+func (f *fakeFetcher) Metrics(metric_names ...string) ([]pcpeasy.Metric, error) {
+	f.fetches++
+	return fetched(time.Duration(f.fetches) * time.Second, uint64(f.fetches), uint64(f.fetches)), nil
+}
+
+func TestSample_writesTheNumberOfRowsAskedFor(t *testing.T) {
+	source := &fakeFetcher{}
+	report := NewReport(&bytes.Buffer{}, &bytes.Buffer{}, CSV, reportSpecs(t), time.UTC)
+
+	err := sample(context.Background(), source, report, 3, time.Time{}, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Rows())
+	assert.Equal(t, 4, source.fetches)
+}
+
+func TestSample_stopsAtTheEndTime(t *testing.T) {
+	source := &fakeFetcher{}
+	report := NewReport(&bytes.Buffer{}, &bytes.Buffer{}, CSV, reportSpecs(t), time.UTC)
+
+	err := sample(context.Background(), source, report, 0, reportStart.Add(3 * time.Second), 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Rows())
+}
+
+func TestApplyOptions_defaultsFlagsNotGiven(t *testing.T) {
+	flags := flag.NewFlagSet("pcprep", flag.ContinueOnError)
+	interval := flags.Duration("t", time.Second, "")
+	output := flags.String("o", "table", "")
+	flags.Parse([]string{"-o", "json"})
+
+	err := applyOptions(flags, map[string]string{"interval":"5s", "output":"csv"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5 * time.Second, *interval)
+	assert.Equal(t, "json", *output)
+	assert.EqualError(t, applyOptions(flags, map[string]string{"colour":"on"}), "unknown option \"colour\" in config")
+}
@@ -0,0 +1,407 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var systemMetrics = []string{
+	"hinv.ncpu",
+	"kernel.all.cpu.user",
+	"kernel.all.cpu.sys",
+	"mem.physmem",
+	"mem.util.used",
+	"disk.all.read_bytes",
+	"disk.all.write_bytes",
+	"network.interface.in.bytes",
+	"network.interface.out.bytes",
+}
+
+var processMetrics = []string{
+	"proc.psinfo.cmd",
+	"proc.psinfo.utime",
+	"proc.psinfo.stime",
+	"proc.psinfo.rss",
+}
+
+/* fetcher is the part of the pcpeasy agent the dashboard uses */
+type fetcher interface {
+	Metrics(metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+/* archiveContext is the part of a pmapi archive context needed to move about in it */
+type archiveContext interface {
+	PmSetMode(mode int, when time.Time, delta time.Duration) error
+}
+
+/* history is a fixed length series of values, oldest first */
+type history struct {
+	values []float64
+	length int
+}
+
+func (h *history) add(value float64) {
+	h.values = append(h.values, value)
+	if(len(h.values) > h.length) {
+		h.values = h.values[len(h.values) - h.length:]
+	}
+}
+
+func (h *history) last() float64 {
+	if(len(h.values) == 0) {
+		return 0
+	}
+	return h.values[len(h.values) - 1]
+}
+
+type Process struct {
+	PID int
+	Command string
+	/* Percentage of one CPU used since the previous sample */
+	CPU float64
+	/* Resident set size in bytes */
+	RSS float64
+}
+
+type SortKey int
+
+const (
+	SortCPU SortKey = iota
+	SortMemory
+	SortPID
+	SortCommand
+)
+
+var sortNames = map[SortKey]string{SortCPU:"cpu", SortMemory:"memory", SortPID:"pid", SortCommand:"command"}
+
+/* archive is where a host view is in an archive it replays */
+type archive struct {
+	context archiveContext
+	start time.Time
+	end time.Time
+	position time.Time
+}
+
+/* hostView is the dashboard for one host or archive: a history of each pane's values and the
+   latest process table */
+type hostView struct {
+	name string
+	source fetcher
+	archive *archive
+	interval time.Duration
+	history_length int
+
+	rates *pcpeasy.RateConverter
+	process_rates *pcpeasy.RateConverter
+	cpu_user, cpu_sys, memory_used history
+	disk_read, disk_write, network_in, network_out history
+	memory_total float64
+	processes []Process
+	timestamp time.Time
+	err error
+	/* Kept apart from err as many hosts and archives have no proc metrics */
+	process_err error
+}
+
+func newHostView(name string, source fetcher, interval time.Duration, history_length int) *hostView {
+	view := &hostView{name:name, source:source, interval:interval, history_length:history_length}
+	view.reset()
+	return view
+}
+
+/* reset forgets all history, as when moving to another point in an archive */
+func (v *hostView) reset() {
+	v.rates = pcpeasy.NewRateConverter()
+	v.process_rates = pcpeasy.NewRateConverter()
+	for _, h := range []*history{&v.cpu_user, &v.cpu_sys, &v.memory_used, &v.disk_read, &v.disk_write, &v.network_in, &v.network_out} {
+		*h = history{length:v.history_length}
+	}
+	v.processes = nil
+}
+
+/* refresh fetches a sample. Hosts without the proc PMDA still get their system panes */
+func (v *hostView) refresh() {
+	system, err := v.source.Metrics(systemMetrics...)
+	if(err != nil) {
+		v.err = err
+		return
+	}
+	v.err = nil
+	processes, err := v.source.Metrics(processMetrics...)
+	v.process_err = err
+	v.update(system, processes)
+}
+
+func (v *hostView) update(system []pcpeasy.Metric, processes []pcpeasy.Metric) {
+	metrics := make(map[string]pcpeasy.Metric)
+	for _, metric := range v.rates.Convert(system) {
+		metrics[metric.Name] = metric
+	}
+	if(len(system) > 0) {
+		v.timestamp = system[0].Timestamp
+		if(v.archive != nil) {
+			v.archive.position = v.timestamp
+		}
+	}
+
+	cpus := total(metrics["hinv.ncpu"])
+	if(cpus < 1) {
+		cpus = 1
+	}
+	/* Rates of millisecond CPU time counters are a utilisation of one CPU */
+	v.cpu_user.add(total(metrics["kernel.all.cpu.user"]) / cpus * 100)
+	v.cpu_sys.add(total(metrics["kernel.all.cpu.sys"]) / cpus * 100)
+	v.memory_total = total(metrics["mem.physmem"])
+	if(v.memory_total > 0) {
+		v.memory_used.add(total(metrics["mem.util.used"]) / v.memory_total * 100)
+	}
+	v.disk_read.add(total(metrics["disk.all.read_bytes"]))
+	v.disk_write.add(total(metrics["disk.all.write_bytes"]))
+	v.network_in.add(total(metrics["network.interface.in.bytes"], "lo"))
+	v.network_out.add(total(metrics["network.interface.out.bytes"], "lo"))
+
+	if(processes != nil) {
+		v.processes = v.processTable(v.process_rates.Convert(processes))
+	}
+}
+
+/* total sums a metric's values in its base units (bytes, seconds, counts), leaving out the
+   excluded instances */
+func total(metric pcpeasy.Metric, excluded ...string) float64 {
+	sum := 0.0
+	for _, value := range metric.Values {
+		if(contains(excluded, value.Instance)) {
+			continue
+		}
+		number, ok := value.Float()
+		if(ok) {
+			sum += number
+		}
+	}
+	base, err := metric.Units.ConvertValue(sum, metric.Units.BaseUnits())
+	if(err != nil) {
+		return sum
+	}
+	return base
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if(v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+/* processTable joins the proc metrics by instance. Instance names are "PID command" */
+func (v *hostView) processTable(metrics []pcpeasy.Metric) []Process {
+	by_instance := make(map[int]*Process)
+	for _, metric := range metrics {
+		for _, value := range metric.Values {
+			process, found := by_instance[value.InstanceID]
+			if(!found) {
+				process = &Process{PID:instancePID(value.Instance)}
+				by_instance[value.InstanceID] = process
+			}
+			switch metric.Name {
+			case "proc.psinfo.cmd":
+				process.Command, _ = value.Value.(string)
+			case "proc.psinfo.utime", "proc.psinfo.stime":
+				/* Already a utilisation of one CPU */
+				used, _ := value.Float()
+				process.CPU += used * 100
+			case "proc.psinfo.rss":
+				rss, _ := value.Float()
+				process.RSS, _ = metric.Units.ConvertValue(rss, metric.Units.BaseUnits())
+			}
+		}
+	}
+	processes := []Process{}
+	for _, process := range by_instance {
+		processes = append(processes, *process)
+	}
+	return processes
+}
+
+func instancePID(instance string) int {
+	fields := strings.Fields(instance)
+	if(len(fields) == 0) {
+		return 0
+	}
+	pid, _ := strconv.Atoi(fields[0])
+	return pid
+}
+
+/* sortProcesses orders processes by key, busiest or largest first for CPU and memory */
+func sortProcesses(processes []Process, key SortKey) {
+	sort.SliceStable(processes, func(i int, j int) bool {
+		a, b := processes[i], processes[j]
+		switch key {
+		case SortMemory:
+			if(a.RSS != b.RSS) {
+				return a.RSS > b.RSS
+			}
+		case SortCommand:
+			if(a.Command != b.Command) {
+				return a.Command < b.Command
+			}
+		case SortCPU:
+			if(a.CPU != b.CPU) {
+				return a.CPU > b.CPU
+			}
+		}
+		return a.PID < b.PID
+	})
+}
+
+/* seek moves an archive view to when and fills its history with the samples leading up to it */
+func (v *hostView) seek(when time.Time) {
+	if(v.archive == nil) {
+		return
+	}
+	if(when.Before(v.archive.start)) {
+		when = v.archive.start
+	}
+	if(when.After(v.archive.end)) {
+		when = v.archive.end
+	}
+	from := when.Add(-time.Duration(v.history_length) * v.interval)
+	if(from.Before(v.archive.start)) {
+		from = v.archive.start
+	}
+	v.reset()
+	v.err = v.archive.context.PmSetMode(pmapi.PmModeInterp, from, v.interval)
+	if(v.err != nil) {
+		return
+	}
+	for {
+		v.refresh()
+		if(v.err != nil || !v.archive.position.Before(when)) {
+			break
+		}
+	}
+	if(pcpeasy.IsEndOfArchive(v.err)) {
+		v.err = nil
+	}
+}
+
+/* Dashboard is the state of pcptop: the host views and how they are shown */
+type Dashboard struct {
+	hosts []*hostView
+	current int
+	sort SortKey
+	playing bool
+	quit bool
+}
+
+func (d *Dashboard) host() *hostView {
+	return d.hosts[d.current]
+}
+
+/* tick is called every interval. Live hosts are all refreshed so switching keeps their history;
+   an archive moves forward when playing */
+func (d *Dashboard) tick() {
+	if(d.host().archive != nil) {
+		if(d.playing) {
+			d.step(1)
+		}
+		return
+	}
+	for _, host := range d.hosts {
+		host.refresh()
+	}
+}
+
+/* step moves an archive view by intervals, forward or back */
+func (d *Dashboard) step(intervals int) {
+	host := d.host()
+	if(host.archive == nil) {
+		return
+	}
+	if(intervals == 1) {
+		host.refresh()
+		if(pcpeasy.IsEndOfArchive(host.err)) {
+			d.playing = false
+		}
+		return
+	}
+	host.seek(host.archive.position.Add(time.Duration(intervals) * host.interval))
+}
+
+/* Key is a keypress, named for the keys that are not characters */
+type Key string
+
+const (
+	KeyLeft Key = "left"
+	KeyRight Key = "right"
+	KeyTab Key = "tab"
+)
+
+func (d *Dashboard) press(key Key) {
+	switch key {
+	case "q", "\x03":
+		d.quit = true
+	case "h", KeyTab:
+		d.current = (d.current + 1) % len(d.hosts)
+	case "H":
+		d.current = (d.current + len(d.hosts) - 1) % len(d.hosts)
+	case "s":
+		d.sort = (d.sort + 1) % SortKey(len(sortNames))
+	case "c":
+		d.sort = SortCPU
+	case "m":
+		d.sort = SortMemory
+	case "p":
+		d.sort = SortPID
+	case "n":
+		d.sort = SortCommand
+	case " ":
+		d.playing = !d.playing
+	case KeyRight:
+		d.step(1)
+	case KeyLeft:
+		d.step(-1)
+	case "]":
+		d.step(d.tenth())
+	case "[":
+		d.step(-d.tenth())
+	}
+}
+
+/* tenth is the number of intervals in a tenth of the current archive */
+func (d *Dashboard) tenth() int {
+	host := d.host()
+	if(host.archive == nil) {
+		return 0
+	}
+	intervals := int(host.archive.end.Sub(host.archive.start) / host.interval / 10)
+	if(intervals < 2) {
+		return 2
+	}
+	return intervals
+}
@@ -0,0 +1,265 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"errors"
+	"strings"
+	"time"
+)
+
+var archiveStart = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+var kbytes = pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceKByte}
+var millisec = pcpeasy.Units{DimTime:1, ScaleTime:pcpeasy.TimeMSec}
+
+/* systemSample is a host with two CPUs that each second uses 500ms of user and 250ms of system
+   time across them, 1 MiB of 4 MiB memory, and reads 2 Kbyte and receives 3 Kbyte */
+func systemSample(timestamp time.Time, seconds uint64) []pcpeasy.Metric {
+	counter := func(name string, units pcpeasy.Units, per_second uint64) pcpeasy.Metric {
+		return pcpeasy.Metric{Name:name, Semantics:"counter", Units:units, Timestamp:timestamp,
+			Values:[]pcpeasy.MetricValue{{InstanceID:pmapi.PmInNull, Value:per_second * seconds}}}
+	}
+	instant := func(name string, units pcpeasy.Units, value uint64) pcpeasy.Metric {
+		return pcpeasy.Metric{Name:name, Semantics:"instant", Units:units, Timestamp:timestamp,
+			Values:[]pcpeasy.MetricValue{{InstanceID:pmapi.PmInNull, Value:value}}}
+	}
+	network := func(name string, per_second uint64) pcpeasy.Metric {
+		return pcpeasy.Metric{Name:name, Semantics:"counter", Units:pcpeasy.Units{DimSpace:1}, Timestamp:timestamp,
+			Values:[]pcpeasy.MetricValue{
+				{Instance:"eth0", InstanceID:2, Value:per_second * 1024 * seconds},
+				{Instance:"lo", InstanceID:1, Value:999999 * seconds},
+			}}
+	}
+	return []pcpeasy.Metric{
+		instant("hinv.ncpu", pcpeasy.Units{}, 2),
+		counter("kernel.all.cpu.user", millisec, 500),
+		counter("kernel.all.cpu.sys", millisec, 250),
+		instant("mem.physmem", kbytes, 4096),
+		instant("mem.util.used", kbytes, 1024),
+		counter("disk.all.read_bytes", kbytes, 2),
+		counter("disk.all.write_bytes", kbytes, 1),
+		network("network.interface.in.bytes", 3),
+		network("network.interface.out.bytes", 1),
+	}
+}
+
+func processSample(timestamp time.Time, seconds uint64) []pcpeasy.Metric {
+	values := func(first interface{}, second interface{}) []pcpeasy.MetricValue {
+		return []pcpeasy.MetricValue{{Instance:"000042 /usr/sbin/sshd", InstanceID:42, Value:first}, {Instance:"001234 postgres", InstanceID:1234, Value:second}}
+	}
+	return []pcpeasy.Metric{
+		{Name:"proc.psinfo.cmd", Semantics:"discrete", Timestamp:timestamp, Values:values("sshd", "postgres")},
+		{Name:"proc.psinfo.utime", Semantics:"counter", Units:millisec, Timestamp:timestamp, Values:values(uint64(10 * seconds), uint64(300 * seconds))},
+		{Name:"proc.psinfo.stime", Semantics:"counter", Units:millisec, Timestamp:timestamp, Values:values(uint64(10 * seconds), uint64(100 * seconds))},
+		{Name:"proc.psinfo.rss", Semantics:"instant", Units:kbytes, Timestamp:timestamp, Values:values(uint64(8192), uint64(2048))},
+	}
+}
+
+/* fakeArchive replays systemSample every interval from where PmSetMode puts it, without proc metrics */
+type fakeArchive struct {
+	position time.Time
+	interval time.Duration
+	end time.Time
+	modes []time.Time
+}
+
+func (a *fakeArchive) PmSetMode(mode int, when time.Time, delta time.Duration) error {
+	a.position, a.interval = when, delta
+	a.modes = append(a.modes, when)
+	return nil
+}
+
+func (a *fakeArchive) Metrics(metric_names ...string) ([]pcpeasy.Metric, error) {
+	if(metric_names[0] == processMetrics[0]) {
+		return nil, errors.New("Unknown metric name")
+	}
+	if(a.position.After(a.end)) {
+		return nil, pmapi.PmError{Code:pmapi.PmErrEOL, Message:"End of PCP archive log"}
+	}
+	sample := systemSample(a.position, uint64(a.position.Sub(archiveStart) / time.Second))
+	a.position = a.position.Add(a.interval)
+	return sample, nil
+}
+
+func archiveView(history_length int) (*hostView, *fakeArchive) {
+	source := &fakeArchive{end:archiveStart.Add(time.Minute)}
+	view := newHostView("web1", source, time.Second, history_length)
+	view.archive = &archive{context:source, start:archiveStart, end:source.end}
+	return view, source
+}
+
+func TestHostView_updateDerivesPanesFromRates(t *testing.T) {
+	view := newHostView("web1", nil, time.Second, 10)
+
+	view.update(systemSample(archiveStart, 1), processSample(archiveStart, 1))
+	view.update(systemSample(archiveStart.Add(time.Second), 2), processSample(archiveStart.Add(time.Second), 2))
+
+	assert.Equal(t, []float64{0, 25}, view.cpu_user.values)
+	assert.Equal(t, 12.5, view.cpu_sys.last())
+	assert.Equal(t, 25.0, view.memory_used.last())
+	assert.Equal(t, 4096.0 * 1024, view.memory_total)
+	assert.Equal(t, 2048.0, view.disk_read.last())
+	assert.Equal(t, 3072.0, view.network_in.last())
+	assert.Equal(t, 1024.0, view.network_out.last())
+	assert.ElementsMatch(t, []Process{
+		{PID:42, Command:"sshd", CPU:2, RSS:8192 * 1024},
+		{PID:1234, Command:"postgres", CPU:40, RSS:2048 * 1024},
+	}, view.processes)
+}
+
+func TestHistory_keepsTheMostRecentValues(t *testing.T) {
+	h := history{length:3}
+	for i := 1; i <= 5; i++ {
+		h.add(float64(i))
+	}
+	assert.Equal(t, []float64{3, 4, 5}, h.values)
+	assert.Equal(t, 5.0, h.last())
+}
+
+func TestSortProcesses(t *testing.T) {
+	processes := []Process{{PID:3, Command:"b", CPU:1, RSS:300}, {PID:1, Command:"c", CPU:5, RSS:100}, {PID:2, Command:"a", CPU:5, RSS:200}}
+	pids := func() []int {
+		return []int{processes[0].PID, processes[1].PID, processes[2].PID}
+	}
+
+	sortProcesses(processes, SortCPU)
+	assert.Equal(t, []int{1, 2, 3}, pids())
+	sortProcesses(processes, SortMemory)
+	assert.Equal(t, []int{3, 2, 1}, pids())
+	sortProcesses(processes, SortCommand)
+	assert.Equal(t, []int{2, 3, 1}, pids())
+	sortProcesses(processes, SortPID)
+	assert.Equal(t, []int{1, 2, 3}, pids())
+}
+
+func TestHostView_seekFillsHistoryUpToThePosition(t *testing.T) {
+	view, source := archiveView(5)
+
+	view.seek(archiveStart.Add(30 * time.Second))
+
+	assert.NoError(t, view.err)
+	assert.Equal(t, []time.Time{archiveStart.Add(25 * time.Second)}, source.modes)
+	assert.Equal(t, archiveStart.Add(30 * time.Second), view.archive.position)
+	/* The first of the six samples only sets the base of the rates */
+	assert.Len(t, view.disk_read.values, 5)
+	assert.EqualError(t, view.process_err, "Unknown metric name")
+}
+
+func TestHostView_seekStaysWithinTheArchive(t *testing.T) {
+	view, source := archiveView(5)
+
+	view.seek(archiveStart.Add(-time.Hour))
+	assert.Equal(t, archiveStart, source.modes[0])
+	assert.Equal(t, archiveStart, view.archive.position)
+
+	view.seek(archiveStart.Add(time.Hour))
+	assert.NoError(t, view.err)
+	assert.Equal(t, archiveStart.Add(time.Minute), view.archive.position)
+}
+
+func TestDashboard_stepsAndPlaysArchives(t *testing.T) {
+	view, _ := archiveView(5)
+	view.seek(archiveStart)
+	dashboard := &Dashboard{hosts:[]*hostView{view}}
+
+	dashboard.press(KeyRight)
+	assert.Equal(t, archiveStart.Add(time.Second), view.archive.position)
+	dashboard.press("]")
+	assert.Equal(t, archiveStart.Add(7 * time.Second), view.archive.position)
+	dashboard.press(KeyLeft)
+	assert.Equal(t, archiveStart.Add(6 * time.Second), view.archive.position)
+
+	dashboard.tick()
+	assert.Equal(t, archiveStart.Add(6 * time.Second), view.archive.position)
+	dashboard.press(" ")
+	dashboard.tick()
+	assert.Equal(t, archiveStart.Add(7 * time.Second), view.archive.position)
+
+	view.seek(archiveStart.Add(time.Minute))
+	dashboard.tick()
+	assert.False(t, dashboard.playing)
+	assert.True(t, pcpeasy.IsEndOfArchive(view.err))
+}
+
+func TestDashboard_switchesHostsAndSorts(t *testing.T) {
+	dashboard := &Dashboard{hosts:[]*hostView{newHostView("a", nil, time.Second, 5), newHostView("b", nil, time.Second, 5)}}
+
+	dashboard.press(KeyTab)
+	assert.Equal(t, "b", dashboard.host().name)
+	dashboard.press("h")
+	assert.Equal(t, "a", dashboard.host().name)
+	dashboard.press("H")
+	assert.Equal(t, "b", dashboard.host().name)
+
+	dashboard.press("s")
+	assert.Equal(t, SortMemory, dashboard.sort)
+	dashboard.press("n")
+	assert.Equal(t, SortCommand, dashboard.sort)
+	dashboard.press("q")
+	assert.True(t, dashboard.quit)
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "  ▁▅█", sparkline([]float64{0, 50, 100}, 5, 100))
+	assert.Equal(t, "▅█", sparkline([]float64{9, 1, 2}, 2, 0))
+	assert.Equal(t, "▁▁", sparkline([]float64{0, 0}, 2, 0))
+}
+
+func TestSlider(t *testing.T) {
+	a := &archive{start:archiveStart, end:archiveStart.Add(time.Hour), position:archiveStart.Add(30 * time.Minute)}
+
+	assert.Equal(t, "09:00:00 [-----|------] 10:00:00", slider(a, 32))
+}
+
+func TestRender(t *testing.T) {
+	view := newHostView("web1", nil, time.Second, 10)
+	view.update(systemSample(archiveStart, 1), processSample(archiveStart, 1))
+	view.update(systemSample(archiveStart.Add(time.Second), 2), processSample(archiveStart.Add(time.Second), 2))
+	dashboard := &Dashboard{hosts:[]*hostView{view}}
+
+	lines := render(dashboard, 60, 16)
+
+	assert.Len(t, lines, 16)
+	assert.Equal(t, "pcptop  web1 (1/1)  2024-03-01 09:00:01  sort:cpu  h:host s:", lines[0])
+	assert.Equal(t, "CPU   user  25.0%  sys  12.5%", lines[1])
+	assert.Equal(t, "MEM   used  25.0% of 4.0 MiB", lines[3])
+	assert.Equal(t, "DISK  read 2.0 KiB/s  write 1.0 KiB/s", lines[5])
+	assert.Equal(t, "NET   in 3.0 KiB/s  out 1.0 KiB/s", lines[7])
+	assert.Equal(t, "    PID   CPU%        RSS  COMMAND", lines[10])
+	assert.Equal(t, "   1234   40.0    2.0 MiB  postgres", lines[11])
+	assert.Equal(t, "     42    2.0    8.0 MiB  sshd", lines[12])
+	assert.Equal(t, "", lines[15])
+	for _, line := range lines {
+		assert.True(t, len([]rune(line)) <= 60, line)
+	}
+	assert.True(t, strings.HasSuffix(lines[2], "▁▄"), lines[2])
+}
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t, []Key{"q", KeyLeft, KeyRight, KeyTab, " "}, parseKeys("q\x1b[D\x1b[C\t "))
+	assert.Equal(t, []Key{"a"}, parseKeys("\x1b[Aa"))
+	assert.Equal(t, []Key{}, parseKeys("\x1b["))
+}
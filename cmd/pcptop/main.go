@@ -0,0 +1,141 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcptop is a top-like terminal dashboard of CPU, memory, disk and network use with
+sparklines of their recent history, and a table of processes from the proc metrics.
+
+	pcptop [-h host,...] [-a archive,...] [-t interval]
+
+Several hosts or archives can be given and switched between with h (or tab) and H. Processes are
+sorted by CPU, memory, PID or command with c, m, p and n, or s to cycle through them. An archive
+is shown from its start: space plays it, the arrow keys step an interval back or forward, and [
+and ] jump a tenth of the archive. q quits.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/* historyLength is the number of samples kept for sparklines, enough for a wide terminal */
+const historyLength = 512
+
+func main() {
+	hosts := flag.String("h", "localhost", "comma separated `hosts` to show")
+	archives := flag.String("a", "", "comma separated `archives` to replay instead of hosts")
+	interval := flag.Duration("t", 2 * time.Second, "sampling `interval`")
+	flag.Parse()
+	if(*interval <= 0) {
+		fmt.Fprintln(os.Stderr, "pcptop: interval must be positive")
+		os.Exit(2)
+	}
+
+	dashboard, err := newDashboard(*hosts, *archives, *interval)
+	if(err != nil) {
+		fmt.Fprintf(os.Stderr, "pcptop: %v\n", err)
+		os.Exit(1)
+	}
+	err = show(dashboard, *interval)
+	if(err != nil) {
+		fmt.Fprintf(os.Stderr, "pcptop: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newDashboard(hosts string, archives string, interval time.Duration) (*Dashboard, error) {
+	dashboard := &Dashboard{}
+	if(archives != "") {
+		for _, path := range strings.Split(archives, ",") {
+			view, err := openArchive(path, interval)
+			if(err != nil) {
+				return nil, err
+			}
+			dashboard.hosts = append(dashboard.hosts, view)
+		}
+		return dashboard, nil
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		agent, err := pcpeasy.NewAgent(host)
+		if(err != nil) {
+			return nil, err
+		}
+		view := newHostView(host, agent, interval, historyLength)
+		view.refresh()
+		dashboard.hosts = append(dashboard.hosts, view)
+	}
+	return dashboard, nil
+}
+
+func openArchive(path string, interval time.Duration) (*hostView, error) {
+	context, err := pmapi.PmNewContext(pmapi.PmContextArchive, path)
+	if(err != nil) {
+		return nil, err
+	}
+	label, err := context.PmGetArchiveLabel()
+	if(err != nil) {
+		return nil, err
+	}
+	end, err := context.PmGetArchiveEnd()
+	if(err != nil) {
+		return nil, err
+	}
+	view := newHostView(label.Hostname + " " + path, pcpeasy.NewAgentWithPMAPI(context), interval, historyLength)
+	view.archive = &archive{context:context, start:label.Start, end:end, position:label.Start}
+	view.seek(label.Start)
+	return view, nil
+}
+
+/* show runs the dashboard on the terminal until q is pressed */
+func show(dashboard *Dashboard, interval time.Duration) error {
+	t, err := openTerminal()
+	if(err != nil) {
+		return err
+	}
+	defer t.close()
+
+	keys := make(chan Key)
+	go readKeys(t.in, keys)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for !dashboard.quit {
+		width, height := t.size()
+		err = t.draw(render(dashboard, width, height))
+		if(err != nil) {
+			return err
+		}
+		select {
+		case key, ok := <-keys:
+			if(!ok) {
+				return nil
+			}
+			dashboard.press(key)
+		case <-ticker.C:
+			dashboard.tick()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const sparks = "▁▂▃▄▅▆▇█"
+
+/* sparkline draws the last width values, scaled so ceiling is a full bar. A ceiling of zero
+   scales to the largest value shown */
+func sparkline(values []float64, width int, ceiling float64) string {
+	if(width <= 0) {
+		return ""
+	}
+	if(len(values) > width) {
+		values = values[len(values) - width:]
+	}
+	if(ceiling <= 0) {
+		for _, value := range values {
+			if(value > ceiling) {
+				ceiling = value
+			}
+		}
+	}
+	levels := []rune(sparks)
+	line := strings.Repeat(" ", width - len(values))
+	for _, value := range values {
+		level := 0
+		if(ceiling > 0) {
+			level = int(value / ceiling * float64(len(levels) - 1) + 0.5)
+		}
+		if(level < 0) {
+			level = 0
+		}
+		if(level >= len(levels)) {
+			level = len(levels) - 1
+		}
+		line += string(levels[level])
+	}
+	return line
+}
+
+/* sum adds two histories value by value, for a sparkline of both */
+func sum(a history, b history) []float64 {
+	n := len(a.values)
+	if(len(b.values) < n) {
+		n = len(b.values)
+	}
+	summed := make([]float64, n)
+	for i := range summed {
+		summed[i] = a.values[len(a.values) - n + i] + b.values[len(b.values) - n + i]
+	}
+	return summed
+}
+
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units) - 1 {
+		bytes /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %v", bytes, units[unit])
+}
+
+/* render lays the dashboard out in lines of at most width columns, filling height */
+func render(d *Dashboard, width int, height int) []string {
+	host := d.host()
+	spark_width := width - 6
+	lines := []string{}
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	keys := "h:host s:sort q:quit"
+	if(host.archive != nil) {
+		keys = "space:play ←/→:step [/]:jump " + keys
+	}
+	timestamp := "-"
+	if(!host.timestamp.IsZero()) {
+		timestamp = host.timestamp.Format("2006-01-02 15:04:05")
+	}
+	add("pcptop  %v (%v/%v)  %v  sort:%v  %v", host.name, d.current + 1, len(d.hosts), timestamp, sortNames[d.sort], keys)
+
+	add("CPU   user %5.1f%%  sys %5.1f%%", host.cpu_user.last(), host.cpu_sys.last())
+	add("      %v", sparkline(sum(host.cpu_user, host.cpu_sys), spark_width, 100))
+	add("MEM   used %5.1f%% of %v", host.memory_used.last(), formatBytes(host.memory_total))
+	add("      %v", sparkline(host.memory_used.values, spark_width, 100))
+	add("DISK  read %v/s  write %v/s", formatBytes(host.disk_read.last()), formatBytes(host.disk_write.last()))
+	add("      %v", sparkline(sum(host.disk_read, host.disk_write), spark_width, 0))
+	add("NET   in %v/s  out %v/s", formatBytes(host.network_in.last()), formatBytes(host.network_out.last()))
+	add("      %v", sparkline(sum(host.network_in, host.network_out), spark_width, 0))
+	if(host.archive != nil) {
+		add("      %v", slider(host.archive, width - 6))
+	}
+
+	status := ""
+	if(host.err != nil) {
+		status = host.err.Error()
+	} else if(host.process_err != nil) {
+		status = "processes: " + host.process_err.Error()
+	}
+
+	add("")
+	add("%7v %6v %10v  %v", "PID", "CPU%", "RSS", "COMMAND")
+	processes := append([]Process{}, host.processes...)
+	sortProcesses(processes, d.sort)
+	for _, process := range processes {
+		if(len(lines) >= height - 1) {
+			break
+		}
+		add("%7v %6.1f %10v  %v", process.PID, process.CPU, formatBytes(process.RSS), process.Command)
+	}
+	for len(lines) < height - 1 {
+		add("")
+	}
+	add("%v", status)
+
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+	return lines
+}
+
+/* slider shows where in the archive the view is */
+func slider(a *archive, width int) string {
+	start, end := a.start.Format("15:04:05"), a.end.Format("15:04:05")
+	bar := width - len(start) - len(end) - 4
+	if(bar < 3) {
+		return ""
+	}
+	position := 0
+	if(a.end.After(a.start)) {
+		position = int(float64(bar - 1) * float64(a.position.Sub(a.start)) / float64(a.end.Sub(a.start)))
+	}
+	if(position < 0) {
+		position = 0
+	}
+	if(position > bar - 1) {
+		position = bar - 1
+	}
+	return fmt.Sprintf("%v [%v|%v] %v", start, strings.Repeat("-", position), strings.Repeat("-", bar - 1 - position), end)
+}
+
+/* truncate cuts a line to width runes */
+func truncate(line string, width int) string {
+	if(utf8.RuneCountInString(line) <= width) {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
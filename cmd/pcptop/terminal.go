@@ -0,0 +1,116 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+)
+
+/* terminal puts the controlling terminal in raw mode on the alternate screen for the dashboard,
+   restoring it on close */
+type terminal struct {
+	in *os.File
+	out *bufio.Writer
+	state *term.State
+}
+
+func openTerminal() (*terminal, error) {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if(err != nil) {
+		return nil, err
+	}
+	t := &terminal{in:os.Stdin, out:bufio.NewWriter(os.Stdout), state:state}
+	/* Alternate screen, hidden cursor */
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	return t, t.out.Flush()
+}
+
+func (t *terminal) close() {
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	term.Restore(int(t.in.Fd()), t.state)
+}
+
+func (t *terminal) size() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if(err != nil) {
+		return 80, 24
+	}
+	return width, height
+}
+
+/* draw replaces the screen with lines. Raw mode needs explicit carriage returns */
+func (t *terminal) draw(lines []string) error {
+	t.out.WriteString("\x1b[H")
+	t.out.WriteString(strings.Join(lines, "\x1b[K\r\n"))
+	t.out.WriteString("\x1b[K\x1b[J")
+	return t.out.Flush()
+}
+
+/* readKeys delivers keypresses until reading fails, naming the escape sequences of arrow keys */
+func readKeys(in io.Reader, keys chan<- Key) {
+	buffer := make([]byte, 16)
+	for {
+		n, err := in.Read(buffer)
+		if(err != nil) {
+			close(keys)
+			return
+		}
+		for _, key := range parseKeys(string(buffer[:n])) {
+			keys <- key
+		}
+	}
+}
+
+func parseKeys(input string) []Key {
+	keys := []Key{}
+	for len(input) > 0 {
+		switch {
+		case strings.HasPrefix(input, "\x1b[C"):
+			keys = append(keys, KeyRight)
+			input = input[3:]
+		case strings.HasPrefix(input, "\x1b[D"):
+			keys = append(keys, KeyLeft)
+			input = input[3:]
+		case strings.HasPrefix(input, "\x1b["):
+			/* Other escape sequences are ignored */
+			input = input[minimum(3, len(input)):]
+		case input[0] == '\t':
+			keys = append(keys, KeyTab)
+			input = input[1:]
+		default:
+			keys = append(keys, Key(input[:1]))
+			input = input[1:]
+		}
+	}
+	return keys
+}
+
+func minimum(a int, b int) int {
+	if(a < b) {
+		return a
+	}
+	return b
+}
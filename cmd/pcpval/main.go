@@ -0,0 +1,170 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pcpval samples one metric at an interval and prints a column per instance, in the manner
+of pmval(1). Counters are reported as rates per second unless -r is given, and values can be
+scaled to other units with -u, such as -u "Mbyte / sec".
+
+	pcpval [-h host | -a archive [-S start] [-T end]] [-t interval] [-s samples] [-i instances] [-r] [-u units] metric
+
+An archive is replayed from -S, or its beginning, to -T, or its end, with values interpolated
+every interval. Times are given in RFC 3339 form, e.g. 2024-03-01T09:00:00Z.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+/* fetcher is the part of the pcpeasy agent pcpval uses */
+type fetcher interface {
+	MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pcpval", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	host := flags.String("h", "localhost", "`host` to fetch metrics from")
+	archive := flags.String("a", "", "`archive` to replay instead of fetching from a host")
+	start := flags.String("S", "", "`time` to start replaying the archive at")
+	end := flags.String("T", "", "`time` to stop replaying the archive at")
+	interval := flags.Duration("t", time.Second, "sampling `interval`")
+	samples := flags.Int("s", 0, "number of `samples` to print, zero for all")
+	instances := flags.String("i", "", "comma separated `instances` to report")
+	raw := flags.Bool("r", false, "report counters as they are rather than as rates")
+	units := flags.String("u", "", "`units` to scale values to")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: pcpval [-h host | -a archive [-S start] [-T end]] [-t interval] [-s samples] [-i instances] [-r] [-u units] metric")
+		flags.PrintDefaults()
+	}
+	if(flags.Parse(args) != nil) {
+		return 2
+	}
+	if(flags.NArg() != 1) {
+		flags.Usage()
+		return 2
+	}
+
+	err := func() error {
+		options := Options{Raw:*raw, Interval:*interval, Samples:*samples}
+		if(*units != "") {
+			parsed, err := pcpeasy.ParseUnits(*units)
+			if(err != nil) {
+				return err
+			}
+			options.Units = &parsed
+		}
+		fetch := pcpeasy.FetchOptions{}
+		if(*instances != "") {
+			fetch.Instances = &pcpeasy.InstanceFilter{Names:strings.Split(*instances, ",")}
+		}
+		start_time, err := parseTime(*start)
+		if(err != nil) {
+			return err
+		}
+		end_time, err := parseTime(*end)
+		if(err != nil) {
+			return err
+		}
+
+		var source fetcher
+		pace := *interval
+		if(*archive != "") {
+			options.Source = "archive:   " + *archive
+			source, err = pcpeasy.NewArchiveAgent(*archive, start_time, *interval)
+			pace = 0
+		} else if(*start != "" || *end != "") {
+			return errors.New("-S and -T need an archive")
+		} else {
+			options.Source = "host:      " + *host
+			source, err = pcpeasy.NewAgent(*host)
+		}
+		if(err != nil) {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return sample(ctx, source, NewPrinter(stdout, options), flags.Arg(0), fetch, *samples, end_time, pace)
+	}()
+	if(err != nil) {
+		fmt.Fprintf(stderr, "pcpval: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func parseTime(value string) (time.Time, error) {
+	if(value == "") {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+/* sample prints the metric until samples have been printed, the archive ends or passes end, or
+   ctx is done. Fetches are paced by pace; an archive, with a pace of zero, is read straight through */
+func sample(ctx context.Context, source fetcher, printer *Printer, name string, fetch pcpeasy.FetchOptions, samples int, end time.Time, pace time.Duration) error {
+	var ticks <-chan time.Time
+	if(pace > 0) {
+		ticker := time.NewTicker(pace)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for first := true; samples == 0 || printer.Lines() < samples; first = false {
+		if(!first && ticks != nil) {
+			select {
+			case <-ctx.Done():
+			case <-ticks:
+			}
+		}
+		if(ctx.Err() != nil) {
+			return nil
+		}
+		metrics, err := source.MetricsWithOptions(fetch, name)
+		if(pcpeasy.IsEndOfArchive(err)) {
+			return nil
+		}
+		if(err != nil) {
+			return err
+		}
+		if(!end.IsZero() && metrics[0].Timestamp.After(end)) {
+			return nil
+		}
+		err = printer.Print(metrics[0])
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	/* Report counters as they are rather than as rates */
+	Raw bool
+	/* Units to scale values to, after any rate conversion. Nil leaves them as they are */
+	Units *pcpeasy.Units
+	/* Where to read from, shown in the header, e.g. "host: localhost" */
+	Source string
+	Interval time.Duration
+	/* Number of samples asked for, zero for all */
+	Samples int
+	/* Zone timestamps are printed in. Defaults to local time */
+	Location *time.Location
+}
+
+/* column is one instance of the metric, or its only value when it has no instance domain */
+type column struct {
+	id int
+	name string
+}
+
+/* Printer writes samples of one metric as a line of values per sample and a column per instance,
+   in the manner of pmval(1). Columns are fixed by the first sample */
+type Printer struct {
+	out io.Writer
+	options Options
+	rates *pcpeasy.RateConverter
+	columns []column
+	width int
+	lines int
+}
+
+func NewPrinter(out io.Writer, options Options) *Printer {
+	if(options.Location == nil) {
+		options.Location = time.Local
+	}
+	return &Printer{out:out, options:options, rates:pcpeasy.NewRateConverter()}
+}
+
+func (p *Printer) Print(metric pcpeasy.Metric) error {
+	if(p.columns == nil) {
+		err := p.header(metric)
+		if(err != nil) {
+			return err
+		}
+	}
+	if(!p.options.Raw) {
+		metric = p.rates.Convert([]pcpeasy.Metric{metric})[0]
+		/* The first sample of a counter only sets the base of its rates */
+		if(len(metric.Values) == 0) {
+			return nil
+		}
+	}
+	if(p.options.Units != nil) {
+		var err error
+		metric, err = metric.ConvertUnits(*p.options.Units)
+		if(err != nil) {
+			return err
+		}
+	}
+
+	values := make(map[int]interface{})
+	for _, value := range metric.Values {
+		values[value.InstanceID] = value.Value
+	}
+	line := []string{metric.Timestamp.In(p.options.Location).Format("15:04:05.000")}
+	for _, column := range p.columns {
+		value, found := values[column.id]
+		formatted := "?"
+		if(found) {
+			formatted = formatValue(value)
+		}
+		line = append(line, pad(formatted, p.width))
+	}
+	p.lines++
+	_, err := fmt.Fprintln(p.out, strings.Join(line, " "))
+	return err
+}
+
+/* Lines is the number of samples printed, which for rates is one fewer than fetched */
+func (p *Printer) Lines() int {
+	return p.lines
+}
+
+/* header describes the metric and fixes the columns from its instances */
+func (p *Printer) header(metric pcpeasy.Metric) error {
+	p.columns = []column{}
+	p.width = 10
+	for _, value := range metric.Values {
+		p.columns = append(p.columns, column{id:value.InstanceID, name:value.Instance})
+		if(len(value.Instance) > p.width) {
+			p.width = len(value.Instance)
+		}
+	}
+	if(len(p.columns) == 0) {
+		return errors.New(fmt.Sprintf("%v: no values available", metric.Name))
+	}
+
+	semantics := semanticsDescriptions[metric.Semantics]
+	units := unitsString(metric.Units)
+	target := metric.Units
+	if(metric.Semantics == "counter" && !p.options.Raw) {
+		semantics += " (converting to rate)"
+		target.ScaleTime = pcpeasy.TimeSec
+		target.DimTime--
+	}
+	if(p.options.Units != nil) {
+		_, err := target.ConvertValue(1, *p.options.Units)
+		if(err != nil) {
+			return err
+		}
+		target = *p.options.Units
+	}
+	if(target != metric.Units) {
+		units += fmt.Sprintf(" (converting to %v)", unitsString(target))
+	}
+	samples := "all"
+	if(p.options.Samples > 0) {
+		samples = strconv.Itoa(p.options.Samples)
+	}
+
+	fmt.Fprintf(p.out, "metric:    %v\n", metric.Name)
+	fmt.Fprintf(p.out, "%v\n", p.options.Source)
+	fmt.Fprintf(p.out, "semantics: %v\n", semantics)
+	fmt.Fprintf(p.out, "units:     %v\n", units)
+	fmt.Fprintf(p.out, "samples:   %v\n", samples)
+	fmt.Fprintf(p.out, "interval:  %.2f sec\n", p.options.Interval.Seconds())
+	if(metric.InDom != pmapi.PmInDomNull) {
+		names := []string{strings.Repeat(" ", len("15:04:05.000"))}
+		for _, column := range p.columns {
+			names = append(names, pad(column.name, p.width))
+		}
+		fmt.Fprintln(p.out)
+		fmt.Fprintln(p.out, strings.Join(names, " "))
+	}
+	fmt.Fprintln(p.out)
+	return nil
+}
+
+var semanticsDescriptions = map[string]string{
+	"counter":"cumulative counter",
+	"instant":"instantaneous value",
+	"discrete":"discrete instantaneous value",
+}
+
+func unitsString(units pcpeasy.Units) string {
+	if(units.IsDimensionless()) {
+		return "none"
+	}
+	return units.String()
+}
+
+/* formatValue prints floats to two decimal places, as pmval does, and strings quoted */
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', 2, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(value)
+}
+
+/* pad right aligns s in width columns */
+func pad(s string, width int) string {
+	return fmt.Sprintf("%*s", width, s)
+}
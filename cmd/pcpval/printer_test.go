@@ -0,0 +1,166 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"bytes"
+	"context"
+	"time"
+)
+
+var sampledAt = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func readBytes(at time.Duration, sda uint64, sdb uint64) pcpeasy.Metric {
+	return pcpeasy.Metric{
+		Name:"disk.dev.read_bytes",
+		Semantics:"counter",
+		Units:pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceKByte},
+		Timestamp:sampledAt.Add(at),
+		InDom:pmapi.PmInDomFromParts(60, 1),
+		Values:[]pcpeasy.MetricValue{{Instance:"sda", InstanceID:0, Value:sda}, {Instance:"nvme0n1p1", InstanceID:1, Value:sdb}},
+	}
+}
+
+func options() Options {
+	return Options{Source:"host:      web1", Interval:time.Second, Location:time.UTC}
+}
+
+func TestPrinter_printsCountersAsRates(t *testing.T) {
+	out := &bytes.Buffer{}
+	printer := NewPrinter(out, options())
+
+	assert.NoError(t, printer.Print(readBytes(0, 100, 0)))
+	assert.NoError(t, printer.Print(readBytes(time.Second, 1124, 3)))
+	assert.NoError(t, printer.Print(readBytes(2 * time.Second, 1124, 10)))
+
+	assert.Equal(t, `metric:    disk.dev.read_bytes
+host:      web1
+semantics: cumulative counter (converting to rate)
+units:     Kbyte (converting to Kbyte / sec)
+samples:   all
+interval:  1.00 sec
+
+                    sda  nvme0n1p1
+
+09:00:01.000    1024.00       3.00
+09:00:02.000       0.00       7.00
+`, out.String())
+	assert.Equal(t, 2, printer.Lines())
+}
+
+func TestPrinter_scalesUnits(t *testing.T) {
+	out := &bytes.Buffer{}
+	scaled := options()
+	scaled.Units = &pcpeasy.Units{DimSpace:1, DimTime:-1, ScaleSpace:pcpeasy.SpaceMByte, ScaleTime:pcpeasy.TimeSec}
+	scaled.Samples = 1
+	printer := NewPrinter(out, scaled)
+
+	printer.Print(readBytes(0, 0, 0))
+	printer.Print(readBytes(2 * time.Second, 1024, 4096))
+
+	assert.Contains(t, out.String(), "units:     Kbyte (converting to Mbyte / sec)\nsamples:   1\n")
+	assert.Contains(t, out.String(), "09:00:02.000       0.50       2.00\n")
+}
+
+func TestPrinter_rejectsUnitsOfOtherDimensions(t *testing.T) {
+	wrong := options()
+	wrong.Units = &pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceMByte}
+
+	err := NewPrinter(&bytes.Buffer{}, wrong).Print(readBytes(0, 0, 0))
+
+	assert.EqualError(t, err, "cannot convert \"Kbyte / sec\" to \"Mbyte\"")
+}
+
+func TestPrinter_printsRawValuesAndMissingInstances(t *testing.T) {
+	out := &bytes.Buffer{}
+	raw := options()
+	raw.Raw = true
+	printer := NewPrinter(out, raw)
+
+	printer.Print(readBytes(0, 100, 5))
+	missing := readBytes(time.Second, 200, 0)
+	missing.Values = missing.Values[:1]
+	printer.Print(missing)
+
+	assert.Contains(t, out.String(), "semantics: cumulative counter\nunits:     Kbyte\n")
+	assert.Contains(t, out.String(), "09:00:00.000        100          5\n09:00:01.000        200          ?\n")
+}
+
+func TestPrinter_printsMetricsWithoutAnInDomInOneColumn(t *testing.T) {
+	out := &bytes.Buffer{}
+	printer := NewPrinter(out, options())
+
+	printer.Print(pcpeasy.Metric{Name:"kernel.uname.sysname", Semantics:"discrete", InDom:pmapi.PmInDomNull, Timestamp:sampledAt,
+		Values:[]pcpeasy.MetricValue{{InstanceID:pmapi.PmInNull, Value:"Linux"}}})
+
+	assert.Equal(t, `metric:    kernel.uname.sysname
+host:      web1
+semantics: discrete instantaneous value
+units:     none
+samples:   all
+interval:  1.00 sec
+
+09:00:00.000    "Linux"
+`, out.String())
+}
+
+func TestSample_stopsAfterSamplesPrinted(t *testing.T) {
+	fake := pmapitest.New()
+	fake.AddMetric("kernel.all.pswitch",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 0, 13), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemCounter},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:10}})
+	out := &bytes.Buffer{}
+	printer := NewPrinter(out, options())
+
+	err := sample(context.Background(), pcpeasy.NewAgentWithPMAPI(fake), printer, "kernel.all.pswitch", pcpeasy.FetchOptions{}, 2, time.Time{}, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, printer.Lines())
+}
+
+func TestSample_stopsAtTheEndTime(t *testing.T) {
+	fake := pmapitest.New()
+	fake.Timestamp = sampledAt
+	fake.AddMetric("kernel.all.pswitch",
+		pmapi.PmDesc{PmID:pmapi.PmIDFromParts(60, 0, 13), Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:10}})
+	printer := NewPrinter(&bytes.Buffer{}, options())
+
+	err := sample(context.Background(), pcpeasy.NewAgentWithPMAPI(fake), printer, "kernel.all.pswitch", pcpeasy.FetchOptions{}, 0, sampledAt.Add(-time.Second), 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, printer.Lines())
+}
+
+func TestRun_requiresOneMetricAndAnArchiveForTimes(t *testing.T) {
+	errors := &bytes.Buffer{}
+	assert.Equal(t, 2, run([]string{}, &bytes.Buffer{}, errors))
+	assert.Contains(t, errors.String(), "Usage: pcpval")
+
+	errors.Reset()
+	assert.Equal(t, 1, run([]string{"-S", "2024-03-01T09:00:00Z", "kernel.all.load"}, &bytes.Buffer{}, errors))
+	assert.Equal(t, "pcpval: -S and -T need an archive\n", errors.String())
+}
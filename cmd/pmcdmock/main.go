@@ -0,0 +1,100 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Command pmcdmock serves scripted metrics over the PCP protocol, standing in for pmcd(1) so that
+integration tests can run where PCP is not installed.
+
+	pmcdmock [-a address] [-p port] [-sample] [-D] [script.json ...]
+
+Scripts are JSON files in the form read by pmcdtest.Server.LoadScript, loaded in order. -sample
+serves the part of pmcd's sample PMDA used by this project's tests, so with the default port of
+44321 they run against pmcdmock as they would against pmcd:
+
+	pmcdmock -sample &
+	go test ./pmapi/ ./pcpeasy/
+
+With another port, point clients at localhost:PORT or set PMCD_PORT. -D logs each PDU received.
+*/
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmcdtest"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+func main() {
+	address := flag.String("a", "localhost", "`address` to listen on")
+	port := flag.Int("p", 44321, "`port` to listen on")
+	sample := flag.Bool("sample", false, "serve the sample PMDA metrics the project's tests use")
+	debug := flag.Bool("D", false, "log each PDU received")
+	flag.Parse()
+	if(!*sample && flag.NArg() == 0) {
+		fmt.Fprintln(os.Stderr, "pmcdmock: give -sample or a script to serve")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	server, err := newServer(*sample, flag.Args())
+	if(err != nil) {
+		fmt.Fprintf(os.Stderr, "pmcdmock: %v\n", err)
+		os.Exit(1)
+	}
+	if(*debug) {
+		server.Log = os.Stderr
+	}
+	listening, err := server.Start(net.JoinHostPort(*address, strconv.Itoa(*port)))
+	if(err != nil) {
+		fmt.Fprintf(os.Stderr, "pmcdmock: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "pmcdmock: listening on %v\n", listening)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	server.Close()
+}
+
+func newServer(sample bool, scripts []string) (*pmcdtest.Server, error) {
+	server := pmcdtest.New()
+	if(sample) {
+		server = pmcdtest.Sample()
+	}
+	for _, path := range scripts {
+		file, err := os.Open(path)
+		if(err != nil) {
+			return nil, err
+		}
+		err = server.LoadScript(file)
+		file.Close()
+		if(err != nil) {
+			return nil, errors.New(fmt.Sprintf("%v: %v", path, err))
+		}
+	}
+	return server, nil
+}
@@ -0,0 +1,62 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func writeScript(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "script.json")
+	assert.NoError(t, os.WriteFile(path, []byte(text), 0644))
+	return path
+}
+
+func TestNewServer_addsScriptsToTheSample(t *testing.T) {
+	script := writeScript(t, `{"metrics": {"mock.extra": {"pmid": "200.0.1", "value": 7}}}`)
+	server, err := newServer(true, []string{script})
+	assert.NoError(t, err)
+	address, _ := server.Start("localhost:0")
+	defer server.Close()
+
+	connection, _ := net.Dial("tcp", address)
+	defer connection.Close()
+	pdu.Read(connection)
+	pdu.Write(connection, pdu.EncodeNameList(pdu.NameList{Names:[]string{"mock.extra", "sample.colour"}}))
+	reply, _ := pdu.Read(connection)
+	ids, err := pdu.DecodeIDList(reply)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ids.Status)
+}
+
+func TestNewServer_namesTheScriptInErrors(t *testing.T) {
+	script := writeScript(t, `{"metrics": {"mock.extra": {"pmid": "200.0"}}}`)
+
+	_, err := newServer(false, []string{script})
+
+	assert.EqualError(t, err, script + `: mock.extra: invalid pmid "200.0"`)
+}
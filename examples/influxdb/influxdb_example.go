@@ -0,0 +1,27 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/influxdb"
+	"context"
+	"fmt"
+	"time"
+)
+
+func main() {
+	a, err := pcpeasy.NewAgent("localhost")
+	if(err != nil) {
+		panic(err)
+	}
+
+	writer := influxdb.NewWriter("http://localhost:8086/write?db=pcp", influxdb.WriterOptions{
+		MaxRetries:3,
+		OnError:func(err error) {
+			fmt.Println(err)
+		},
+	})
+	samples := a.Watch(context.Background(), 10 * time.Second, "kernel.all.load", "disk.dev.read_bytes")
+	writer.Run(context.Background(), samples, time.Minute)
+}
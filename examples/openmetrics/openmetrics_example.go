@@ -0,0 +1,19 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/openmetrics"
+	"net/http"
+)
+
+func main() {
+	a, err := pcpeasy.NewAgent("localhost")
+	if(err != nil) {
+		panic(err)
+	}
+
+	http.Handle("/metrics", openmetrics.NewHandler(a, "pcp", "kernel.all.load", "disk.dev.read_bytes", "mem.util.free"))
+	panic(http.ListenAndServe(":9101", nil))
+}
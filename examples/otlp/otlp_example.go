@@ -0,0 +1,26 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/otlp"
+	"context"
+	"fmt"
+	"time"
+)
+
+func main() {
+	a, err := pcpeasy.NewAgent("localhost")
+	if(err != nil) {
+		panic(err)
+	}
+
+	exporter := otlp.NewHTTPExporter("http://localhost:4318/v1/metrics", otlp.ExporterOptions{
+		ResourceAttributes:map[string]string{"service.name":"pcp"},
+	})
+	samples := a.Watch(context.Background(), 10 * time.Second, "kernel.all.load", "disk.dev.read_bytes")
+	exporter.Run(context.Background(), samples, func(err error) {
+		fmt.Println(err)
+	})
+}
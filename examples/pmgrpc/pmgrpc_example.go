@@ -0,0 +1,26 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmgrpc"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmgrpc/pcppb"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"google.golang.org/grpc"
+	"net"
+)
+
+func main() {
+	context, err := pmapi.PmNewContext(pmapi.PmContextHost, "localhost")
+	if(err != nil) {
+		panic(err)
+	}
+	listener, err := net.Listen("tcp", ":50051")
+	if(err != nil) {
+		panic(err)
+	}
+
+	server := grpc.NewServer()
+	pcppb.RegisterPCPServer(server, pmgrpc.NewServer(context))
+	panic(server.Serve(listener))
+}
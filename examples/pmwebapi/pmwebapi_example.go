@@ -0,0 +1,13 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmwebapi"
+	"net/http"
+)
+
+func main() {
+	server := pmwebapi.NewServer(pmwebapi.Options{})
+	panic(http.ListenAndServe(":44322", server))
+}
@@ -0,0 +1,24 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/promcollector"
+	"net/http"
+)
+
+func main() {
+	a, err := pcpeasy.NewAgent("localhost")
+	if(err != nil) {
+		panic(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(promcollector.New(a, "pcp", "kernel.all.load", "disk.dev.read_bytes", "mem.util.free"))
+
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	panic(http.ListenAndServe(":9101", nil))
+}
@@ -0,0 +1,23 @@
+//Copyright (c) 2016 Ryan Doyle
+
+package main
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/tabular"
+	"os"
+	"time"
+)
+
+func main() {
+	a, err := pcpeasy.NewArchiveAgent(os.Args[1], time.Time{}, time.Minute)
+	if(err != nil) {
+		panic(err)
+	}
+
+	writer := tabular.NewWriter(os.Stdout, tabular.Options{})
+	err = writer.WriteArchive(a, "kernel.all.load", "mem.util.free")
+	if(err != nil) {
+		panic(err)
+	}
+}
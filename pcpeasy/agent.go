@@ -25,6 +25,9 @@ import (
 	"github.com/ryandoyle/pcpeasygo/pmapi"
 	"errors"
 	"fmt"
+	"io"
+	"time"
+	"sync"
 )
 
 type Metric struct {
@@ -33,30 +36,116 @@ type Metric struct {
 	Semantics string
 	Type reflect.Kind
 	Units Units
-}
-
-type Units struct {
-	Domain string
-	Range string
+	/* Metadata carried through from pmapi for storage and correlation */
+	Timestamp time.Time
+	PmID pmapi.PmID
+	InDom pmapi.PmInDom
+	PmType int
+	/* Context, domain, indom, cluster and item labels merged in that order. Only set when requested */
+	Labels map[string]string
+	/* One-line help text. Only set when requested */
+	Help string
 }
 
 type MetricValue struct {
 	Value interface{}
 	Instance string
+	/* pmapi.PmInNull for metrics without an instance domain */
+	InstanceID int
+	/* The metric's labels merged with those of the instance. Only set when requested */
+	Labels map[string]string
 }
 
 type agent struct {
 	pmapi         pmapi.PMAPI
 	pmDescAdapter pmDescAdapter
 	pmValueAdapter pmValueAdapter
+	/* Creates a fresh context to the same host, used by reconnect() */
+	connect func() (pmapi.PMAPI, error)
+	/* Guards use and replacement of the pmapi context */
+	lock sync.Mutex
+	/* Set for archive agents, whose interpolated fetches have no values for metrics not yet logged */
+	archive bool
 }
 
 func NewAgent(host string) (*agent, error) {
-	pmapi, err := pmapi.PmNewContext(pmapi.PmContextHost, host)
+	connect := func() (pmapi.PMAPI, error) {
+		context, err := pmapi.PmNewContext(pmapi.PmContextHost, host)
+		if(err != nil) {
+			return nil, err
+		}
+		return context, nil
+	}
+	context, err := connect()
 	if (err != nil) {
 		return nil, err
 	}
-	return &agent{pmapi:pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:pmapi}}, nil
+	return &agent{pmapi:context, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:context}, connect:connect}, nil
+}
+
+/* NewAgentWithPMAPI creates an agent over an existing PMAPI implementation, such as a fake in
+   tests. The agent cannot reconnect as it does not know how the context was created */
+func NewAgentWithPMAPI(context pmapi.PMAPI) *agent {
+	return &agent{pmapi:context, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:context}}
+}
+
+/* NewArchiveAgent replays a PCP archive. Each fetch returns values interpolated interval after the
+   last, starting at start or, when start is zero, at the beginning of the archive. Metrics with no
+   value to interpolate at a point, such as counters before their second sample, are returned
+   without Values. Fetching past the end of the archive returns an error for which IsEndOfArchive
+   is true */
+func NewArchiveAgent(archive string, start time.Time, interval time.Duration) (*agent, error) {
+	if(interval <= 0) {
+		return nil, errors.New("archive interval must be positive")
+	}
+	context, err := pmapi.PmNewContext(pmapi.PmContextArchive, archive)
+	if(err != nil) {
+		return nil, err
+	}
+	if(start.IsZero()) {
+		label, err := context.PmGetArchiveLabel()
+		if(err != nil) {
+			return nil, err
+		}
+		start = label.Start
+	}
+	err = context.PmSetMode(pmapi.PmModeInterp, start, interval)
+	if(err != nil) {
+		return nil, err
+	}
+	return NewArchiveAgentWithPMAPI(context), nil
+}
+
+/* NewArchiveAgentWithPMAPI creates an agent over an archive context the caller positions with
+   PmSetMode, returning metrics without Values where the archive has none as NewArchiveAgent does */
+func NewArchiveAgentWithPMAPI(context pmapi.PMAPI) *agent {
+	a := NewAgentWithPMAPI(context)
+	a.archive = true
+	return a
+}
+
+/* IsEndOfArchive reports whether a fetch from an archive agent failed for having run out of archive */
+func IsEndOfArchive(err error) bool {
+	return pmapi.IsPmError(err, pmapi.PmErrEOL)
+}
+
+/* reconnect replaces the agent's context with a new one to the same host, closing the old one */
+func (a *agent) reconnect() error {
+	if(a.connect == nil) {
+		return errors.New("agent has no connection to re-establish")
+	}
+	context, err := a.connect()
+	if(err != nil) {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if closer, ok := a.pmapi.(io.Closer); ok {
+		closer.Close()
+	}
+	a.pmapi = context
+	a.pmValueAdapter = pmValueAdapterImpl{pmapi:context}
+	return nil
 }
 
 func (a *agent) Metric(metric_name string) (Metric, error) {
@@ -67,6 +156,28 @@ func (a *agent) Metric(metric_name string) (Metric, error) {
 }
 
 func (a *agent) Metrics(metric_strings ...string) ([]Metric, error) {
+	return a.MetricsWithOptions(FetchOptions{}, metric_strings...)
+}
+
+/* MetricsWithLabels is Metrics with the Labels of each metric and value filled in */
+func (a *agent) MetricsWithLabels(metric_strings ...string) ([]Metric, error) {
+	return a.MetricsWithOptions(FetchOptions{Labels:true}, metric_strings...)
+}
+
+/* FetchOptions asks for more than Metrics returns by default. Labels and HelpText each cost an
+   extra round trip per metric */
+type FetchOptions struct {
+	/* Restricts instances with a pmcd fetch profile, see MetricsMatching */
+	Instances *InstanceFilter
+	Labels bool
+	/* Fill in Metric.Help. Metrics without help text are left empty rather than failing */
+	HelpText bool
+}
+
+func (a *agent) MetricsWithOptions(options FetchOptions, metric_strings ...string) ([]Metric, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	pmids, err := a.pmapi.PmLookupName(metric_strings...)
 	if(err != nil) {
 		return nil, err
@@ -77,6 +188,15 @@ func (a *agent) Metrics(metric_strings ...string) ([]Metric, error) {
 		pmid_names[pmid] = metric_strings[i]
 	}
 
+	var profile *fetchProfile
+	if(options.Instances != nil) {
+		profile, err = a.installProfile(*options.Instances, pmids)
+		if(err != nil) {
+			return nil, err
+		}
+		defer a.resetProfile(profile)
+	}
+
 	pm_result, err := a.pmapi.PmFetch(pmids...)
 	if(err != nil) {
 		return nil, err
@@ -89,10 +209,22 @@ func (a *agent) Metrics(metric_strings ...string) ([]Metric, error) {
 
 	metrics := make([]Metric, pm_result.NumPmID)
 	for i, pm_value_set := range pm_result.VSet {
-		metric, err := a.buildMetricFromPmValueSet(pm_value_set, pmid_names)
+		metric, err := a.buildMetricFromPmValueSet(pm_value_set, pmid_names, pm_result.Timestamp, profile)
 		if(err != nil) {
 			return nil, err
 		}
+		if(options.Labels) {
+			err = a.applyLabels(&metric)
+			if(err != nil) {
+				return nil, err
+			}
+		}
+		if(options.HelpText) {
+			metric.Help, err = a.helpText(metric.PmID)
+			if(err != nil) {
+				return nil, err
+			}
+		}
 		metrics[i] = metric
 	}
 
@@ -100,14 +232,14 @@ func (a *agent) Metrics(metric_strings ...string) ([]Metric, error) {
 	return metrics, nil
 }
 
-func (a *agent) buildMetricFromPmValueSet(vset *pmapi.PmValueSet, pmid_names map[pmapi.PmID]string) (Metric, error) {
-	metric_desc, err :=  a.pmapi.PmLookupDesc(vset.PmID)
+func (a *agent) buildMetricFromPmValueSet(vset *pmapi.PmValueSet, pmid_names map[pmapi.PmID]string, timestamp time.Time, profile *fetchProfile) (Metric, error) {
+	metric_desc, err :=  a.lookupDesc(vset.PmID, profile)
 	if(err != nil) {
 		return Metric{}, err
 	}
 	metric_name := pmid_names[metric_desc.PmID]
 	metric_info := a.pmDescAdapter.toMetricInfo(metric_desc)
-	metric_values, err := a.buildMetricValues(vset, metric_desc)
+	metric_values, err := a.buildMetricValues(vset, metric_desc, profile)
 	if(err != nil) {
 		return Metric{}, err
 	}
@@ -115,17 +247,41 @@ func (a *agent) buildMetricFromPmValueSet(vset *pmapi.PmValueSet, pmid_names map
 		Type:metric_info._type,
 		Name:metric_name,
 		Semantics:metric_info.semantics,
-		Units:Units{Domain:metric_info.units.domain, Range:metric_info.units._range},
+		Units:metric_info.units,
 		Values:metric_values,
+		Timestamp:timestamp,
+		PmID:metric_desc.PmID,
+		InDom:metric_desc.InDom,
+		PmType:metric_desc.Type,
 	}, nil
 }
 
-func (a *agent) buildMetricValues(vset *pmapi.PmValueSet, metric_desc pmapi.PmDesc) ([]MetricValue, error) {
+/* lookupDesc reuses the descriptor looked up while installing a profile, if there was one */
+func (a *agent) lookupDesc(pmid pmapi.PmID, profile *fetchProfile) (pmapi.PmDesc, error) {
+	if(profile != nil) {
+		if metric_desc, found := profile.descs[pmid]; found {
+			return metric_desc, nil
+		}
+	}
+	return a.pmapi.PmLookupDesc(pmid)
+}
+
+func (a *agent) buildMetricValues(vset *pmapi.PmValueSet, metric_desc pmapi.PmDesc, profile *fetchProfile) ([]MetricValue, error) {
+	/* A filter that matches no instances legitimately leaves a metric without values */
+	if(vset.NumVal == 0 && profile != nil && metric_desc.InDom != pmapi.PmInDomNull) {
+		return []MetricValue{}, nil
+	}
+	/* As does an archive before a metric's first sample, or a counter's second when interpolating */
+	if(vset.NumVal == 0 && a.archive) {
+		return []MetricValue{}, nil
+	}
 	if(vset.NumVal <= 0) {
 		return nil, errors.New(fmt.Sprintf("metric \"%v\" contains no values or error \"%v\"", vset.PmID, vset.NumVal))
 	}
 	if(metric_desc.InDom == pmapi.PmInDomNull) {
 		return a.buildMetricValuesForNullInstance(vset, metric_desc)
+	} else if(profile != nil) {
+		return a.buildMetricValuesForProfile(vset, metric_desc, profile)
 	} else {
 		return a.buildMetricValuesForInstances(vset, metric_desc)
 	}
@@ -140,6 +296,7 @@ func (a *agent) buildMetricValuesForNullInstance(vset *pmapi.PmValueSet, metric_
 
 	return []MetricValue{{
 		Instance:"",
+		InstanceID:pmapi.PmInNull,
 		Value:value,
 	}}, nil
 }
@@ -150,17 +307,67 @@ func (a *agent) buildMetricValuesForInstances(vset *pmapi.PmValueSet, metric_des
 		return nil, err
 	}
 
+	return a.buildInstanceValues(vset, metric_desc, func(instance_id int) (string, error) {
+		instance := ids_to_instance_names[instance_id]
+		if(instance == "") {
+			return "", errors.New(fmt.Sprintf("Instance name for ID %v not found", instance_id))
+		}
+		return instance, nil
+	})
+}
+
+func (a *agent) buildInstanceValues(vset *pmapi.PmValueSet, metric_desc pmapi.PmDesc, instance_name func(int) (string, error)) ([]MetricValue, error) {
 	metric_values := make([]MetricValue, len(vset.VList))
 	for i, pm_value := range vset.VList {
 		value, err := a.pmValueAdapter.toUntypedMetric(vset.ValFmt, metric_desc.Type, pm_value)
 		if(err != nil) {
 			return nil, err
 		}
-		instance := ids_to_instance_names[pm_value.Inst]
-		if(instance == "") {
-			return nil, errors.New(fmt.Sprintf("Instance name for ID %v not found", pm_value.Inst))
+		instance, err := instance_name(pm_value.Inst)
+		if(err != nil) {
+			return nil, err
 		}
-		metric_values[i] = MetricValue{Instance:instance, Value:value}
+		metric_values[i] = MetricValue{Instance:instance, InstanceID:pm_value.Inst, Value:value}
 	}
 	return metric_values, nil
-}
\ No newline at end of file
+}
+
+/* applyLabels merges the metric level label sets onto the metric, and those plus the instance's
+   own set onto each value, with later (more specific) sets taking precedence */
+func (a *agent) applyLabels(metric *Metric) error {
+	label_sets, err := a.pmapi.PmLookupLabels(metric.PmID)
+	if(err != nil) {
+		return err
+	}
+
+	metric.Labels = make(map[string]string)
+	instance_labels := make(map[int]map[string]string)
+	for _, label_set := range label_sets {
+		if(label_set.Inst == pmapi.PmInNull) {
+			mergeLabels(metric.Labels, label_set.Labels)
+		} else {
+			instance_labels[label_set.Inst] = label_set.Labels
+		}
+	}
+	for i := range metric.Values {
+		labels := make(map[string]string)
+		mergeLabels(labels, metric.Labels)
+		mergeLabels(labels, instance_labels[metric.Values[i].InstanceID])
+		metric.Values[i].Labels = labels
+	}
+	return nil
+}
+
+func (a *agent) helpText(pmid pmapi.PmID) (string, error) {
+	help, err := a.pmapi.PmLookupText(pmid, pmapi.PmTextOneline)
+	if(pmapi.IsPmError(err, pmapi.PmErrText)) {
+		return "", nil
+	}
+	return help, err
+}
+
+func mergeLabels(into map[string]string, from map[string]string) {
+	for name, value := range from {
+		into[name] = value
+	}
+}
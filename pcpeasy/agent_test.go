@@ -41,6 +41,24 @@ func TestNewAgent_returnsAnErrorForAnInvalidConnection(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNewArchiveAgent_returnsAnErrorForAMissingArchive(t *testing.T) {
+	_, err := NewArchiveAgent("/does/not/exist", time.Time{}, time.Second)
+
+	assert.Error(t, err)
+}
+
+func TestNewArchiveAgent_returnsAnErrorForANonPositiveInterval(t *testing.T) {
+	_, err := NewArchiveAgent("/does/not/exist", time.Time{}, 0)
+
+	assert.EqualError(t, err, "archive interval must be positive")
+}
+
+func TestIsEndOfArchive(t *testing.T) {
+	assert.True(t, IsEndOfArchive(pmapi.PmError{Code:pmapi.PmErrEOL}))
+	assert.False(t, IsEndOfArchive(pmapi.PmError{Code:pmapi.PmErrEOF}))
+	assert.False(t, IsEndOfArchive(errors.New("other")))
+}
+
 type MockPMAPI struct {
 	mock.Mock
 }
@@ -114,6 +132,51 @@ func (m *MockPMAPI) PmGetInDom(indom pmapi.PmInDom) (map[int]string, error) {
 
 }
 
+func (m *MockPMAPI) PmLookupInDom(indom pmapi.PmInDom, name string) (int, error) {
+	args := m.Called(indom, name)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPMAPI) PmNameInDom(indom pmapi.PmInDom, instance int) (string, error) {
+	args := m.Called(indom, instance)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockPMAPI) PmAddProfile(indom pmapi.PmInDom, instances ...int) error {
+	args := m.Called(indom, instances)
+	return args.Error(0)
+}
+
+func (m *MockPMAPI) PmDelProfile(indom pmapi.PmInDom, instances ...int) error {
+	args := m.Called(indom, instances)
+	return args.Error(0)
+}
+
+func (m *MockPMAPI) PmLookupLabels(pmid pmapi.PmID) ([]pmapi.PmLabelSet, error) {
+	args := m.Called(pmid)
+	label_sets := args.Get(0)
+	err := args.Error(1)
+	if(label_sets == nil) {
+		return nil, err
+	}
+	return label_sets.([]pmapi.PmLabelSet), err
+}
+
+func (m *MockPMAPI) PmLookupText(pmid pmapi.PmID, level int) (string, error) {
+	args := m.Called(pmid, level)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockPMAPI) PmGetChildrenStatus(name string) (map[string]bool, error) {
+	args := m.Called(name)
+	children := args.Get(0)
+	err := args.Error(1)
+	if(children == nil) {
+		return nil, err
+	}
+	return children.(map[string]bool), err
+}
+
 func (m *MockPmDescAdapter) toMetricInfo(pm_desc pmapi.PmDesc) metricInfo {
 	args := m.Called(pm_desc)
 	return args.Get(0).(metricInfo)
@@ -189,7 +252,7 @@ func TestAgent_Metrics_returnsAMetricResult(t *testing.T) {
 		}},
 	}
 	pm_desc := pmapi.PmDesc{Type:pmapi.PmType64, InDom:pmapi.PmInDomNull, PmID:pmapi.PmID(123)}
-	metric_info := metricInfo{_type:reflect.Int64, semantics:"counter", units:metricUnits{_range:"seconds", domain:"megabytes"}}
+	metric_info := metricInfo{_type:reflect.Int64, semantics:"counter", units:Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeSec}}
 
 	mock_pmapi.On("PmLookupName", []string{"my.metric"}).Return([]pmapi.PmID{123}, nil)
 	mock_pmapi.On("PmFetch", []pmapi.PmID{123}).Return(pm_result, nil)
@@ -203,8 +266,12 @@ func TestAgent_Metrics_returnsAMetricResult(t *testing.T) {
 		Name: "my.metric",
 		Semantics: "counter",
 		Type: reflect.Int64,
-		Units: Units{Domain:"megabytes", Range: "seconds"},
-		Values: []MetricValue{{Instance: "", Value: int64(222)}},
+		Units: Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeSec},
+		Values: []MetricValue{{Instance: "", InstanceID: pmapi.PmInNull, Value: int64(222)}},
+		Timestamp: time.Unix(123,456),
+		PmID: pmapi.PmID(123),
+		InDom: pmapi.PmInDomNull,
+		PmType: pmapi.PmType64,
 	}}
 
 	assert.NoError(t, err)
@@ -235,7 +302,7 @@ func TestAgent_Metrics_returnsAMetricResultForAMetricWithInstances(t *testing.T)
 		}},
 	}
 	pm_desc := pmapi.PmDesc{Type:pmapi.PmType64, InDom:indom, PmID:pmid}
-	metric_info := metricInfo{_type:reflect.Int64, semantics:"counter", units:metricUnits{_range:"seconds", domain:"megabytes"}}
+	metric_info := metricInfo{_type:reflect.Int64, semantics:"counter", units:Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeSec}}
 
 	mock_pmapi.On("PmLookupName", []string{"my.metric"}).Return([]pmapi.PmID{123}, nil)
 	mock_pmapi.On("PmFetch", []pmapi.PmID{123}).Return(pm_result, nil)
@@ -251,11 +318,15 @@ func TestAgent_Metrics_returnsAMetricResultForAMetricWithInstances(t *testing.T)
 		Name: "my.metric",
 		Semantics: "counter",
 		Type: reflect.Int64,
-		Units: Units{Domain:"megabytes", Range: "seconds"},
+		Units: Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeSec},
 		Values: []MetricValue{
-			{Instance: "inst1", Value: int64(881)},
-			{Instance: "inst2", Value: int64(882)},
+			{Instance: "inst1", InstanceID: instance_1, Value: int64(881)},
+			{Instance: "inst2", InstanceID: instance_2, Value: int64(882)},
 		},
+		Timestamp: time.Unix(123,456),
+		PmID: pmid,
+		InDom: indom,
+		PmType: pmapi.PmType64,
 	}}
 
 	assert.NoError(t, err)
@@ -293,4 +364,113 @@ func TestAgent_Metrics_returnsAErrorIfTheMetricContainsAnErrorEncodedInNumVal(t
 
 	assert.Nil(t, actual_metrics)
 	assert.EqualError(t, err, "metric \"123\" contains no values or error \"-12345\"")
-}
\ No newline at end of file
+}
+
+func TestAgent_Metrics_returnsMetricsWithoutValuesFromAnArchive(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}, archive:true}
+	pmids := []pmapi.PmID{123, 456}
+	timestamp := time.Unix(1700000000, 0)
+	pm_result := &pmapi.PmResult{
+		Timestamp:timestamp,
+		NumPmID:2,
+		VSet:[]*pmapi.PmValueSet{
+			{NumVal:0, PmID:123, ValFmt:pmapi.PmValInsitu, VList:[]*pmapi.PmValue{}},
+			{NumVal:0, PmID:456, ValFmt:pmapi.PmValInsitu, VList:[]*pmapi.PmValue{}},
+		},
+	}
+
+	mock_pmapi.On("PmLookupName", []string{"kernel.all.pswitch", "disk.dev.read"}).Return(pmids, nil)
+	mock_pmapi.On("PmFetch", pmids).Return(pm_result, nil)
+	mock_pmapi.On("PmLookupDesc", pmapi.PmID(123)).Return(pmapi.PmDesc{PmID:123, Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemCounter}, nil)
+	mock_pmapi.On("PmLookupDesc", pmapi.PmID(456)).Return(pmapi.PmDesc{PmID:456, Type:pmapi.PmTypeU32, InDom:pmapi.PmInDom(60), Sem:pmapi.PmSemCounter}, nil)
+
+	metrics, err := agent.Metrics("kernel.all.pswitch", "disk.dev.read")
+
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "kernel.all.pswitch", metrics[0].Name)
+	assert.Equal(t, []MetricValue{}, metrics[0].Values)
+	assert.Equal(t, timestamp, metrics[0].Timestamp)
+	assert.Equal(t, "disk.dev.read", metrics[1].Name)
+	assert.Equal(t, []MetricValue{}, metrics[1].Values)
+}
+
+func TestAgent_MetricsWithLabels_mergesMetricAndInstanceLabels(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	indom := pmapi.PmInDom(555)
+	pmid := pmapi.PmID(123)
+	pm_value_1 := &pmapi.PmValue{Inst:1}
+	pm_value_2 := &pmapi.PmValue{Inst:2}
+	pm_result := &pmapi.PmResult{
+		NumPmID:1,
+		VSet:[]*pmapi.PmValueSet{{NumVal:2, PmID:pmid, ValFmt:pmapi.PmValInsitu, VList:[]*pmapi.PmValue{pm_value_1, pm_value_2}}},
+	}
+	label_sets := []pmapi.PmLabelSet{
+		{Inst:pmapi.PmInNull, Labels:map[string]string{"hostname":"web1", "agent":"linux"}},
+		{Inst:pmapi.PmInNull, Labels:map[string]string{"agent":"sample"}},
+		{Inst:1, Labels:map[string]string{"device_type":"ssd"}},
+	}
+
+	mock_pmapi.On("PmLookupName", []string{"my.metric"}).Return([]pmapi.PmID{pmid}, nil)
+	mock_pmapi.On("PmFetch", []pmapi.PmID{pmid}).Return(pm_result, nil)
+	mock_pmapi.On("PmLookupDesc", pmid).Return(pmapi.PmDesc{Type:pmapi.PmType32, InDom:indom, PmID:pmid}, nil)
+	mock_pmapi.On("PmGetInDom", indom).Return(map[int]string{1:"sda", 2:"sdb"}, nil)
+	mock_pmapi.On("PmExtractValue", pmapi.PmValInsitu, pmapi.PmType32, pm_value_1).Return(pmapi.PmAtomValue{Int32:1}, nil)
+	mock_pmapi.On("PmExtractValue", pmapi.PmValInsitu, pmapi.PmType32, pm_value_2).Return(pmapi.PmAtomValue{Int32:2}, nil)
+	mock_pmapi.On("PmLookupLabels", pmid).Return(label_sets, nil)
+
+	metrics, err := agent.MetricsWithLabels("my.metric")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hostname":"web1", "agent":"sample"}, metrics[0].Labels)
+	assert.Equal(t, map[string]string{"hostname":"web1", "agent":"sample", "device_type":"ssd"}, metrics[0].Values[0].Labels)
+	assert.Equal(t, map[string]string{"hostname":"web1", "agent":"sample"}, metrics[0].Values[1].Labels)
+}
+
+func TestAgent_MetricsWithLabels_returnsAnErrorIfLabelsCannotBeLookedUp(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	mock_pmapi.On("PmLookupLabels", pmapi.PmID(123)).Return(nil, errors.New("labels error"))
+
+	_, err := agent.MetricsWithLabels("my.metric")
+
+	assert.EqualError(t, err, "labels error")
+}
+
+func TestAgent_MetricsWithOptions_fillsInHelpText(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	mock_pmapi.On("PmLookupText", pmapi.PmID(123), pmapi.PmTextOneline).Return("My metric", nil)
+
+	metrics, err := agent.MetricsWithOptions(FetchOptions{HelpText:true}, "my.metric")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "My metric", metrics[0].Help)
+}
+
+func TestAgent_MetricsWithOptions_leavesHelpEmptyWhenThereIsNoText(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	mock_pmapi.On("PmLookupText", pmapi.PmID(123), pmapi.PmTextOneline).Return("", pmapi.PmError{Code:pmapi.PmErrText})
+
+	metrics, err := agent.MetricsWithOptions(FetchOptions{HelpText:true}, "my.metric")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", metrics[0].Help)
+}
+
+func TestAgent_MetricsWithOptions_returnsOtherHelpTextErrors(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	mock_pmapi.On("PmLookupText", pmapi.PmID(123), pmapi.PmTextOneline).Return("", errors.New("IPC protocol failure"))
+
+	_, err := agent.MetricsWithOptions(FetchOptions{HelpText:true}, "my.metric")
+
+	assert.EqualError(t, err, "IPC protocol failure")
+}
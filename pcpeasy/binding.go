@@ -0,0 +1,309 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"reflect"
+	"errors"
+	"fmt"
+	"strings"
+	"math"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+)
+
+/*
+FetchInto fills the fields of the struct pointed to by target in a single fetch. Fields are
+bound to metrics with a "pcp" struct tag:
+
+	type Load struct {
+		One float64 `pcp:"kernel.all.load[1 minute]"`
+		Idle uint64 `pcp:"kernel.all.cpu.idle"`
+		Reads map[string]uint64 `pcp:"disk.dev.read"`
+	}
+
+A scalar field takes the single value of a metric without an instance domain, or the named
+instance given in brackets. A map field keyed by string (instance name) or int (instance ID)
+takes every instance of the metric, replacing the map so instances that have gone since an earlier
+fetch go with it. Untagged struct fields are walked recursively and fields
+tagged "-" are skipped.
+*/
+func (a *agent) FetchInto(target interface{}) error {
+	bindings, err := fieldBindings(target)
+	if(err != nil) {
+		return err
+	}
+	if(len(bindings) == 0) {
+		return nil
+	}
+
+	metrics, err := a.Metrics(uniqueMetricNames(bindings)...)
+	if(err != nil) {
+		return err
+	}
+	return bindMetrics(bindings, metrics)
+}
+
+type fieldBinding struct {
+	field reflect.Value
+	path string
+	metric string
+	instance string
+	has_instance bool
+}
+
+func fieldBindings(target interface{}) ([]fieldBinding, error) {
+	value := reflect.ValueOf(target)
+	if(value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct) {
+		return nil, errors.New(fmt.Sprintf("FetchInto requires a non-nil pointer to a struct, got %T", target))
+	}
+	return collectFieldBindings(value.Elem(), "")
+}
+
+func collectFieldBindings(strct reflect.Value, prefix string) ([]fieldBinding, error) {
+	bindings := []fieldBinding{}
+	strct_type := strct.Type()
+	for i := 0; i < strct.NumField(); i++ {
+		field_type := strct_type.Field(i)
+		if(field_type.PkgPath != "") {
+			/* unexported */
+			continue
+		}
+		path := prefix + field_type.Name
+		tag, tagged := field_type.Tag.Lookup("pcp")
+		if(tag == "-") {
+			continue
+		}
+		if(!tagged) {
+			if(field_type.Type.Kind() == reflect.Struct) {
+				nested, err := collectFieldBindings(strct.Field(i), path + ".")
+				if(err != nil) {
+					return nil, err
+				}
+				bindings = append(bindings, nested...)
+			}
+			continue
+		}
+		binding, err := parseFieldTag(tag)
+		if(err != nil) {
+			return nil, errors.New(fmt.Sprintf("field %v: %v", path, err))
+		}
+		binding.field = strct.Field(i)
+		binding.path = path
+		if(binding.field.Kind() == reflect.Map && binding.has_instance) {
+			return nil, errors.New(fmt.Sprintf("field %v: map fields take every instance, remove \"[%v]\" from the tag", path, binding.instance))
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+/* parseFieldTag splits "metric.name[instance name]" into its metric and optional instance */
+func parseFieldTag(tag string) (fieldBinding, error) {
+	open := strings.Index(tag, "[")
+	if(open < 0) {
+		if(tag == "") {
+			return fieldBinding{}, errors.New("empty pcp tag")
+		}
+		return fieldBinding{metric:tag}, nil
+	}
+	if(!strings.HasSuffix(tag, "]") || open == 0) {
+		return fieldBinding{}, errors.New(fmt.Sprintf("malformed pcp tag \"%v\", expected \"metric.name[instance]\"", tag))
+	}
+	return fieldBinding{metric:tag[:open], instance:tag[open + 1:len(tag) - 1], has_instance:true}, nil
+}
+
+func uniqueMetricNames(bindings []fieldBinding) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, binding := range bindings {
+		if(!seen[binding.metric]) {
+			seen[binding.metric] = true
+			names = append(names, binding.metric)
+		}
+	}
+	return names
+}
+
+func bindMetrics(bindings []fieldBinding, metrics []Metric) error {
+	metrics_by_name := make(map[string]Metric)
+	for _, metric := range metrics {
+		metrics_by_name[metric.Name] = metric
+	}
+
+	for _, binding := range bindings {
+		metric, found := metrics_by_name[binding.metric]
+		if(!found) {
+			return errors.New(fmt.Sprintf("field %v: metric \"%v\" was not fetched", binding.path, binding.metric))
+		}
+		var err error
+		if(binding.field.Kind() == reflect.Map) {
+			err = bindInstances(binding.field, metric)
+		} else {
+			err = bindValue(binding, metric)
+		}
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("field %v: %v", binding.path, err))
+		}
+	}
+	return nil
+}
+
+func bindValue(binding fieldBinding, metric Metric) error {
+	if(!binding.has_instance) {
+		if(metric.InDom != pmapi.PmInDomNull || len(metric.Values) != 1) {
+			return errors.New(fmt.Sprintf("metric \"%v\" has %v instances, name one in the tag or use a map field", metric.Name, len(metric.Values)))
+		}
+		return assignValue(binding.field, metric.Values[0].Value)
+	}
+	for _, metric_value := range metric.Values {
+		if(metric_value.Instance == binding.instance) {
+			return assignValue(binding.field, metric_value.Value)
+		}
+	}
+	return errors.New(fmt.Sprintf("metric \"%v\" has no instance \"%v\"", metric.Name, binding.instance))
+}
+
+func bindInstances(field reflect.Value, metric Metric) error {
+	map_type := field.Type()
+	key_kind := map_type.Key().Kind()
+	if(key_kind != reflect.String && !isIntKind(key_kind)) {
+		return errors.New(fmt.Sprintf("map key must be a string (instance name) or int (instance ID), not %v", map_type.Key()))
+	}
+	instances := reflect.MakeMapWithSize(map_type, len(metric.Values))
+	for _, metric_value := range metric.Values {
+		element := reflect.New(map_type.Elem()).Elem()
+		err := assignValue(element, metric_value.Value)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("instance \"%v\": %v", metric_value.Instance, err))
+		}
+		key := reflect.New(map_type.Key()).Elem()
+		if(key_kind == reflect.String) {
+			key.SetString(metric_value.Instance)
+		} else {
+			key.SetInt(int64(metric_value.InstanceID))
+		}
+		instances.SetMapIndex(key, element)
+	}
+	field.Set(instances)
+	return nil
+}
+
+/* assignValue converts one of the metric value types produced by toUntypedMetric into the
+   field's kind, refusing conversions that change kind (string <-> number) or lose range */
+func assignValue(field reflect.Value, value interface{}) error {
+	if(field.Kind() == reflect.Interface && reflect.TypeOf(value).Implements(field.Type())) {
+		field.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	source := reflect.ValueOf(value)
+	source_kind := source.Kind()
+	field_kind := field.Kind()
+
+	switch {
+	case field_kind == reflect.String:
+		if(source_kind != reflect.String) {
+			break
+		}
+		field.SetString(source.String())
+		return nil
+	case source_kind == reflect.String:
+		break
+	case isIntKind(field_kind):
+		switch {
+		case isIntKind(source_kind):
+			return setInt(field, source.Int(), value)
+		case isUintKind(source_kind):
+			if(source.Uint() > math.MaxInt64) {
+				return overflowError(value, field)
+			}
+			return setInt(field, int64(source.Uint()), value)
+		case isFloatKind(source_kind):
+			if(source.Float() != math.Trunc(source.Float())) {
+				return errors.New(fmt.Sprintf("cannot assign fractional value %v to %v", value, field.Type()))
+			}
+			return setInt(field, int64(source.Float()), value)
+		}
+	case isUintKind(field_kind):
+		switch {
+		case isIntKind(source_kind):
+			if(source.Int() < 0) {
+				return overflowError(value, field)
+			}
+			return setUint(field, uint64(source.Int()), value)
+		case isUintKind(source_kind):
+			return setUint(field, source.Uint(), value)
+		case isFloatKind(source_kind):
+			if(source.Float() < 0 || source.Float() != math.Trunc(source.Float())) {
+				return errors.New(fmt.Sprintf("cannot assign value %v to %v", value, field.Type()))
+			}
+			return setUint(field, uint64(source.Float()), value)
+		}
+	case isFloatKind(field_kind):
+		switch {
+		case isIntKind(source_kind):
+			field.SetFloat(float64(source.Int()))
+			return nil
+		case isUintKind(source_kind):
+			field.SetFloat(float64(source.Uint()))
+			return nil
+		case isFloatKind(source_kind):
+			if(field.OverflowFloat(source.Float())) {
+				return overflowError(value, field)
+			}
+			field.SetFloat(source.Float())
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("cannot assign %v value to field of type %v", source.Type(), field.Type()))
+}
+
+func setInt(field reflect.Value, i int64, value interface{}) error {
+	if(field.OverflowInt(i)) {
+		return overflowError(value, field)
+	}
+	field.SetInt(i)
+	return nil
+}
+
+func setUint(field reflect.Value, u uint64, value interface{}) error {
+	if(field.OverflowUint(u)) {
+		return overflowError(value, field)
+	}
+	field.SetUint(u)
+	return nil
+}
+
+func overflowError(value interface{}, field reflect.Value) error {
+	return errors.New(fmt.Sprintf("value %v overflows %v", value, field.Type()))
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uint64
+}
+
+func isFloatKind(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
@@ -0,0 +1,239 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"reflect"
+	"time"
+)
+
+var loadMetric = Metric{
+	Name:"kernel.all.load",
+	InDom:pmapi.PmInDom(40),
+	Values:[]MetricValue{
+		{Instance:"1 minute", InstanceID:1, Value:float32(0.5)},
+		{Instance:"5 minute", InstanceID:5, Value:float32(0.25)},
+		{Instance:"15 minute", InstanceID:15, Value:float32(0.125)},
+	},
+}
+
+var uptimeMetric = Metric{
+	Name:"kernel.all.uptime",
+	InDom:pmapi.PmInDomNull,
+	Values:[]MetricValue{{Instance:"", InstanceID:pmapi.PmInNull, Value:uint32(3600)}},
+}
+
+var unameMetric = Metric{
+	Name:"kernel.uname.sysname",
+	InDom:pmapi.PmInDomNull,
+	Values:[]MetricValue{{Instance:"", InstanceID:pmapi.PmInNull, Value:"Linux"}},
+}
+
+func bindTo(target interface{}, metrics ...Metric) error {
+	bindings, err := fieldBindings(target)
+	if(err != nil) {
+		return err
+	}
+	return bindMetrics(bindings, metrics)
+}
+
+func TestBindMetrics_bindsNamedInstancesAndSingularMetrics(t *testing.T) {
+	var target struct {
+		One float64 `pcp:"kernel.all.load[1 minute]"`
+		Fifteen float32 `pcp:"kernel.all.load[15 minute]"`
+		Uptime int64 `pcp:"kernel.all.uptime"`
+		Sysname string `pcp:"kernel.uname.sysname"`
+	}
+
+	err := bindTo(&target, loadMetric, uptimeMetric, unameMetric)
+
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0.5), target.One)
+	assert.Equal(t, float32(0.125), target.Fifteen)
+	assert.Equal(t, int64(3600), target.Uptime)
+	assert.Equal(t, "Linux", target.Sysname)
+}
+
+func TestBindMetrics_bindsWholeInstanceDomainsIntoMaps(t *testing.T) {
+	var target struct {
+		ByName map[string]float64 `pcp:"kernel.all.load"`
+		ByID map[int]float64 `pcp:"kernel.all.load"`
+	}
+
+	err := bindTo(&target, loadMetric)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"1 minute":0.5, "5 minute":0.25, "15 minute":0.125}, target.ByName)
+	assert.Equal(t, map[int]float64{1:0.5, 5:0.25, 15:0.125}, target.ByID)
+}
+
+func TestBindMetrics_walksNestedStructsAndSkipsIgnoredFields(t *testing.T) {
+	var target struct {
+		Kernel struct {
+			Uptime uint32 `pcp:"kernel.all.uptime"`
+		}
+		Ignored string `pcp:"-"`
+		untagged int
+	}
+
+	err := bindTo(&target, uptimeMetric)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3600), target.Kernel.Uptime)
+}
+
+func TestBindMetrics_errorsForMismatchedKinds(t *testing.T) {
+	var target struct {
+		Sysname int `pcp:"kernel.uname.sysname"`
+	}
+
+	err := bindTo(&target, unameMetric)
+
+	assert.EqualError(t, err, "field Sysname: cannot assign string value to field of type int")
+}
+
+func TestBindMetrics_errorsForFractionalValuesInIntegerFields(t *testing.T) {
+	var target struct {
+		One int `pcp:"kernel.all.load[1 minute]"`
+	}
+
+	err := bindTo(&target, loadMetric)
+
+	assert.EqualError(t, err, "field One: cannot assign fractional value 0.5 to int")
+}
+
+func TestBindMetrics_errorsForOverflowingValues(t *testing.T) {
+	var target struct {
+		Uptime int8 `pcp:"kernel.all.uptime"`
+	}
+
+	err := bindTo(&target, uptimeMetric)
+
+	assert.EqualError(t, err, "field Uptime: value 3600 overflows int8")
+}
+
+func TestBindMetrics_errorsForMissingInstances(t *testing.T) {
+	var target struct {
+		Ten float64 `pcp:"kernel.all.load[10 minute]"`
+	}
+
+	err := bindTo(&target, loadMetric)
+
+	assert.EqualError(t, err, "field Ten: metric \"kernel.all.load\" has no instance \"10 minute\"")
+}
+
+func TestBindMetrics_errorsForScalarFieldsBoundToInstanceDomains(t *testing.T) {
+	var target struct {
+		Load float64 `pcp:"kernel.all.load"`
+	}
+
+	err := bindTo(&target, loadMetric)
+
+	assert.EqualError(t, err, "field Load: metric \"kernel.all.load\" has 3 instances, name one in the tag or use a map field")
+}
+
+func TestFieldBindings_errorsForNonStructPointers(t *testing.T) {
+	var target struct{}
+
+	_, err := fieldBindings(target)
+
+	assert.EqualError(t, err, "FetchInto requires a non-nil pointer to a struct, got struct {}")
+}
+
+func TestFieldBindings_errorsForMalformedTags(t *testing.T) {
+	var target struct {
+		Load float64 `pcp:"kernel.all.load[1 minute"`
+	}
+
+	_, err := fieldBindings(&target)
+
+	assert.EqualError(t, err, "field Load: malformed pcp tag \"kernel.all.load[1 minute\", expected \"metric.name[instance]\"")
+}
+
+func TestFieldBindings_errorsForMapFieldsWithAnInstance(t *testing.T) {
+	var target struct {
+		Load map[string]float64 `pcp:"kernel.all.load[1 minute]"`
+	}
+
+	_, err := fieldBindings(&target)
+
+	assert.EqualError(t, err, "field Load: map fields take every instance, remove \"[1 minute]\" from the tag")
+}
+
+func TestAgent_FetchInto_fetchesEachMetricOnce(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	mock_pmdesc_adapter := &MockPmDescAdapter{}
+	mock_pmvalue_adapter := &MockPmValueAdapter{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:mock_pmdesc_adapter, pmValueAdapter:mock_pmvalue_adapter}
+
+	indom := pmapi.PmInDom(40)
+	pmid := pmapi.PmID(123)
+	pm_value_1 := &pmapi.PmValue{Inst:1}
+	pm_value_5 := &pmapi.PmValue{Inst:5}
+	pm_result := &pmapi.PmResult{
+		NumPmID:1,
+		Timestamp:time.Unix(123,456),
+		VSet:[]*pmapi.PmValueSet{{
+			NumVal:2,
+			PmID:pmid,
+			ValFmt:pmapi.PmValInsitu,
+			VList:[]*pmapi.PmValue{pm_value_1, pm_value_5},
+		}},
+	}
+	pm_desc := pmapi.PmDesc{Type:pmapi.PmTypeFloat, InDom:indom, PmID:pmid}
+
+	mock_pmapi.On("PmLookupName", []string{"kernel.all.load"}).Return([]pmapi.PmID{pmid}, nil)
+	mock_pmapi.On("PmFetch", []pmapi.PmID{pmid}).Return(pm_result, nil).Once()
+	mock_pmapi.On("PmLookupDesc", pmid).Return(pm_desc, nil)
+	mock_pmapi.On("PmGetInDom", indom).Return(map[int]string{1:"1 minute", 5:"5 minute"}, nil)
+	mock_pmdesc_adapter.On("toMetricInfo", pm_desc).Return(metricInfo{_type:reflect.Float32})
+	mock_pmvalue_adapter.On("toUntypedMetric", pmapi.PmValInsitu, pmapi.PmTypeFloat, pm_value_1).Return(float32(0.5), nil)
+	mock_pmvalue_adapter.On("toUntypedMetric", pmapi.PmValInsitu, pmapi.PmTypeFloat, pm_value_5).Return(float32(0.25), nil)
+
+	var load struct {
+		One float64 `pcp:"kernel.all.load[1 minute]"`
+		Five float64 `pcp:"kernel.all.load[5 minute]"`
+	}
+	err := agent.FetchInto(&load)
+
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0.5), load.One)
+	assert.Equal(t, float64(0.25), load.Five)
+}
+
+func TestAgent_FetchInto_dropsInstancesThatHaveGone(t *testing.T) {
+	fake := pmapitest.NewSample()
+	agent := NewAgentWithPMAPI(fake)
+	var disks struct {
+		Reads map[string]uint64 `pcp:"disk.dev.read_bytes"`
+	}
+
+	assert.NoError(t, agent.FetchInto(&disks))
+	assert.Equal(t, map[string]uint64{"sda":100, "sdb":200}, disks.Reads)
+	fake.SetValues("disk.dev.read_bytes", map[int]pmapi.PmAtomValue{1:{UInt64:250}})
+	assert.NoError(t, agent.FetchInto(&disks))
+
+	assert.Equal(t, map[string]uint64{"sdb":250}, disks.Reads)
+}
@@ -0,0 +1,190 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+/* SnapshotDiff is what changed between two snapshots of metrics, such as two hosts, two archives
+   or one host before and after a deploy. Metrics are matched by name and instances by name */
+type SnapshotDiff struct {
+	/* Metrics only in the later snapshot */
+	Added []Metric
+	/* Metrics only in the earlier snapshot */
+	Removed []Metric
+	/* Metrics in both whose metadata, instances or values differ */
+	Changed []MetricDiff
+}
+
+type MetricDiff struct {
+	Name string
+	Metadata []MetadataChange
+	AddedInstances []MetricValue
+	RemovedInstances []MetricValue
+	/* Values of instances in both snapshots that differ */
+	Values []ValueChange
+}
+
+/* MetadataChange is a descriptor field that differs, written as Units and Semantics write it */
+type MetadataChange struct {
+	Field string
+	Before string
+	After string
+}
+
+/* ValueChange is one instance's value in each snapshot. Instance is empty for metrics without an
+   instance domain. Delta and Percent are set for numeric values; Percent is NaN when Before is zero */
+type ValueChange struct {
+	Instance string
+	Before interface{}
+	After interface{}
+	Numeric bool
+	Delta float64
+	Percent float64
+}
+
+/* DiffMetrics compares two snapshots. Each list of results is sorted by name */
+func DiffMetrics(before []Metric, after []Metric) SnapshotDiff {
+	diff := SnapshotDiff{Added:[]Metric{}, Removed:[]Metric{}, Changed:[]MetricDiff{}}
+	before_by_name := metricsByName(before)
+	after_by_name := metricsByName(after)
+
+	for name, metric := range after_by_name {
+		if _, found := before_by_name[name]; !found {
+			diff.Added = append(diff.Added, metric)
+		}
+	}
+	for name, earlier := range before_by_name {
+		later, found := after_by_name[name]
+		if(!found) {
+			diff.Removed = append(diff.Removed, earlier)
+			continue
+		}
+		metric_diff := diffMetric(earlier, later)
+		if(len(metric_diff.Metadata) > 0 || len(metric_diff.AddedInstances) > 0 || len(metric_diff.RemovedInstances) > 0 || len(metric_diff.Values) > 0) {
+			diff.Changed = append(diff.Changed, metric_diff)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i int, j int) bool { return diff.Added[i].Name < diff.Added[j].Name })
+	sort.Slice(diff.Removed, func(i int, j int) bool { return diff.Removed[i].Name < diff.Removed[j].Name })
+	sort.Slice(diff.Changed, func(i int, j int) bool { return diff.Changed[i].Name < diff.Changed[j].Name })
+	return diff
+}
+
+func metricsByName(metrics []Metric) map[string]Metric {
+	by_name := make(map[string]Metric)
+	for _, metric := range metrics {
+		by_name[metric.Name] = metric
+	}
+	return by_name
+}
+
+func diffMetric(before Metric, after Metric) MetricDiff {
+	diff := MetricDiff{Name:before.Name, Metadata:[]MetadataChange{}, AddedInstances:[]MetricValue{}, RemovedInstances:[]MetricValue{}, Values:[]ValueChange{}}
+	metadata := []MetadataChange{
+		{"units", before.Units.String(), after.Units.String()},
+		{"semantics", before.Semantics, after.Semantics},
+		{"type", before.Type.String(), after.Type.String()},
+	}
+	for _, change := range metadata {
+		if(change.Before != change.After) {
+			diff.Metadata = append(diff.Metadata, change)
+		}
+	}
+
+	before_values := valuesByInstance(before)
+	after_values := valuesByInstance(after)
+	for _, value := range after.Values {
+		if _, found := before_values[value.Instance]; !found {
+			diff.AddedInstances = append(diff.AddedInstances, value)
+		}
+	}
+	for _, earlier := range before.Values {
+		later, found := after_values[earlier.Instance]
+		if(!found) {
+			diff.RemovedInstances = append(diff.RemovedInstances, earlier)
+			continue
+		}
+		if(reflect.DeepEqual(earlier.Value, later.Value)) {
+			continue
+		}
+		diff.Values = append(diff.Values, valueChange(earlier, later))
+	}
+	return diff
+}
+
+func valuesByInstance(metric Metric) map[string]MetricValue {
+	by_instance := make(map[string]MetricValue)
+	for _, value := range metric.Values {
+		by_instance[value.Instance] = value
+	}
+	return by_instance
+}
+
+func valueChange(before MetricValue, after MetricValue) ValueChange {
+	change := ValueChange{Instance:before.Instance, Before:before.Value, After:after.Value}
+	earlier, before_numeric := before.Float()
+	later, after_numeric := after.Float()
+	if(!before_numeric || !after_numeric) {
+		return change
+	}
+	change.Numeric = true
+	change.Delta = later - earlier
+	change.Percent = math.NaN()
+	if(earlier != 0) {
+		change.Percent = change.Delta / math.Abs(earlier) * 100
+	}
+	/* Integers far apart lose nothing by being subtracted before conversion */
+	switch b := before.Value.(type) {
+	case uint64:
+		if a, ok := after.Value.(uint64); ok {
+			if(a >= b) {
+				change.Delta = float64(a - b)
+			} else {
+				change.Delta = -float64(b - a)
+			}
+		}
+	case int64:
+		if a, ok := after.Value.(int64); ok {
+			change.Delta = float64(a - b)
+		}
+	}
+	return change
+}
+
+func (c ValueChange) String() string {
+	instance := ""
+	if(c.Instance != "") {
+		instance = fmt.Sprintf("[%v] ", c.Instance)
+	}
+	if(!c.Numeric) {
+		return fmt.Sprintf("%v%#v -> %#v", instance, c.Before, c.After)
+	}
+	if(math.IsNaN(c.Percent)) {
+		return fmt.Sprintf("%v%v -> %v (%+g)", instance, c.Before, c.After, c.Delta)
+	}
+	return fmt.Sprintf("%v%v -> %v (%+g, %+.1f%%)", instance, c.Before, c.After, c.Delta, c.Percent)
+}
@@ -0,0 +1,98 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+)
+
+func loadSnapshot(one float32, five float32) Metric {
+	return Metric{Name:"kernel.all.load", Semantics:"instant", Type:reflect.Float32, Values:[]MetricValue{
+		{Instance:"1 minute", InstanceID:1, Value:one},
+		{Instance:"5 minute", InstanceID:5, Value:five},
+	}}
+}
+
+func TestDiffMetrics_reportsAddedAndRemovedMetrics(t *testing.T) {
+	before := []Metric{{Name:"mem.util.free"}, {Name:"kernel.all.pswitch"}, loadSnapshot(1, 1)}
+	after := []Metric{{Name:"network.tcp.retrans"}, loadSnapshot(1, 1), {Name:"disk.all.read"}}
+
+	diff := DiffMetrics(before, after)
+
+	assert.Equal(t, []Metric{{Name:"disk.all.read"}, {Name:"network.tcp.retrans"}}, diff.Added)
+	assert.Equal(t, []Metric{{Name:"kernel.all.pswitch"}, {Name:"mem.util.free"}}, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
+
+func TestDiffMetrics_reportsValueChanges(t *testing.T) {
+	diff := DiffMetrics([]Metric{loadSnapshot(0.5, 2)}, []Metric{loadSnapshot(0.75, 2)})
+
+	assert.Equal(t, []MetricDiff{{
+		Name:"kernel.all.load",
+		Metadata:[]MetadataChange{},
+		AddedInstances:[]MetricValue{},
+		RemovedInstances:[]MetricValue{},
+		Values:[]ValueChange{{Instance:"1 minute", Before:float32(0.5), After:float32(0.75), Numeric:true, Delta:0.25, Percent:50}},
+	}}, diff.Changed)
+	assert.Equal(t, "[1 minute] 0.5 -> 0.75 (+0.25, +50.0%)", diff.Changed[0].Values[0].String())
+}
+
+func TestDiffMetrics_reportsInstancesThatComeAndGo(t *testing.T) {
+	before := loadSnapshot(1, 1)
+	after := loadSnapshot(1, 1)
+	after.Values[1] = MetricValue{Instance:"15 minute", InstanceID:15, Value:float32(1)}
+
+	diff := DiffMetrics([]Metric{before}, []Metric{after})
+
+	assert.Equal(t, []MetricValue{after.Values[1]}, diff.Changed[0].AddedInstances)
+	assert.Equal(t, []MetricValue{before.Values[1]}, diff.Changed[0].RemovedInstances)
+	assert.Empty(t, diff.Changed[0].Values)
+}
+
+func TestDiffMetrics_reportsMetadataChanges(t *testing.T) {
+	before := Metric{Name:"disk.all.read_bytes", Semantics:"counter", Type:reflect.Uint32, Units:Units{DimSpace:1, ScaleSpace:SpaceKByte}}
+	after := Metric{Name:"disk.all.read_bytes", Semantics:"instant", Type:reflect.Uint64, Units:Units{DimSpace:1, ScaleSpace:SpaceByte}}
+
+	diff := DiffMetrics([]Metric{before}, []Metric{after})
+
+	assert.Equal(t, []MetadataChange{
+		{"units", "Kbyte", "byte"},
+		{"semantics", "counter", "instant"},
+		{"type", "uint32", "uint64"},
+	}, diff.Changed[0].Metadata)
+}
+
+func TestDiffMetrics_computesLargeIntegerDeltasExactly(t *testing.T) {
+	before := Metric{Name:"kernel.all.pswitch", Values:[]MetricValue{{Value:uint64(18446744073709551615)}}}
+	after := Metric{Name:"kernel.all.pswitch", Values:[]MetricValue{{Value:uint64(18446744073709550615)}}}
+
+	change := DiffMetrics([]Metric{before}, []Metric{after}).Changed[0].Values[0]
+
+	assert.Equal(t, -1000.0, change.Delta)
+}
+
+func TestValueChange_String(t *testing.T) {
+	assert.Equal(t, "0 -> 5 (+5)", ValueChange{Before:uint32(0), After:uint32(5), Numeric:true, Delta:5, Percent:math.NaN()}.String())
+	assert.Equal(t, "[sda] \"ext4\" -> \"xfs\"", ValueChange{Instance:"sda", Before:"ext4", After:"xfs"}.String())
+}
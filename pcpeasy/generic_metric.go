@@ -56,4 +56,23 @@ func (a pmValueAdapterImpl) toUntypedMetric(value_format int, metric_type int, p
 	}
 	/* Shouldn't ever get here as PmExtractValue would exit earlier */
 	return nil, nil
-}
\ No newline at end of file
+}
+
+/* Float returns the value as a float64 for numeric metrics. ok is false for strings */
+func (v MetricValue) Float() (value float64, ok bool) {
+	switch typed := v.Value.(type) {
+	case int32:
+		return float64(typed), true
+	case uint32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case uint64:
+		return float64(typed), true
+	case float32:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
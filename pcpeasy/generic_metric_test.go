@@ -25,6 +25,7 @@ import (
 	"github.com/ryandoyle/pcpeasygo/pmapi"
 	"github.com/stretchr/testify/assert"
 	"errors"
+	"math"
 )
 
 func Test_ToUntypedMetric_forInt32(t *testing.T) {
@@ -129,4 +130,19 @@ func Test_ToUntypedMetric_returnsAnErrorIfPmExtractValueReturnsAnError(t *testin
 	_, actual_err := adapter.toUntypedMetric(pmapi.PmValDptr, pmapi.PmTypeString, pm_value)
 
 	assert.Equal(t, err, actual_err)
-}
\ No newline at end of file
+}
+
+func TestMetricValue_Float_convertsNumericValues(t *testing.T) {
+	for _, value := range []interface{}{int32(-2), uint32(2), int64(-2), uint64(2), float32(2.5), float64(2.5)} {
+		float, ok := MetricValue{Value:value}.Float()
+
+		assert.True(t, ok, "%T", value)
+		assert.InDelta(t, 2, math.Abs(float), 0.5, "%T", value)
+	}
+}
+
+func TestMetricValue_Float_isNotOkForStrings(t *testing.T) {
+	_, ok := MetricValue{Value:"hullo"}.Float()
+
+	assert.False(t, ok)
+}
@@ -0,0 +1,150 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package graphite sends PCP metrics fetched through pcpeasy to Carbon, over either the plaintext
+or the pickle protocol.
+
+A value's path is the optional prefix, the PCP metric name and, for metrics with an instance
+domain, the instance name with each byte other than a letter, digit or hyphen written as an
+underscore and two hex digits, so distinct instances never share a path. The sda instance of
+disk.dev.read_bytes under the prefix pcp.web1 becomes pcp.web1.disk.dev.read_bytes.sda and sda.1
+becomes pcp.web1.disk.dev.read_bytes.sda_2e1. String metrics are skipped.
+*/
+package graphite
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Point is one value on its way to Carbon */
+type Point struct {
+	Path string
+	Value float64
+	Timestamp time.Time
+}
+
+/* Points flattens metrics into one point per numeric value. Metrics without a fetch timestamp
+   are stamped with now */
+func Points(prefix string, metrics []pcpeasy.Metric, now time.Time) []Point {
+	points := []Point{}
+	for _, metric := range metrics {
+		timestamp := metric.Timestamp
+		if(timestamp.IsZero()) {
+			timestamp = now
+		}
+		for _, metric_value := range metric.Values {
+			value, ok := metric_value.Float()
+			if(!ok || math.IsNaN(value) || math.IsInf(value, 0)) {
+				continue
+			}
+			points = append(points, Point{Path:Path(prefix, metric.Name, metric_value.Instance), Value:value, Timestamp:timestamp})
+		}
+	}
+	return points
+}
+
+/* Path joins the prefix, metric name and encoded instance name */
+func Path(prefix string, metric_name string, instance string) string {
+	path := []string{}
+	if(prefix != "") {
+		path = append(path, strings.Trim(prefix, "."))
+	}
+	path = append(path, metric_name)
+	if(instance != "") {
+		path = append(path, EncodeInstance(instance))
+	}
+	return strings.Join(path, ".")
+}
+
+/* EncodeInstance makes an instance name safe to use as one node of a path. Letters, digits and
+   hyphens are kept and every other byte, underscores included, becomes _ and its two hex digits */
+func EncodeInstance(instance string) string {
+	const hex = "0123456789abcdef"
+	encoded := make([]byte, 0, len(instance))
+	for i := 0; i < len(instance); i++ {
+		c := instance[i]
+		if(c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			encoded = append(encoded, c)
+		} else {
+			encoded = append(encoded, '_', hex[c >> 4], hex[c & 0xf])
+		}
+	}
+	return string(encoded)
+}
+
+/* AppendPlaintext appends points as "path value timestamp" lines */
+func AppendPlaintext(buffer []byte, points []Point) []byte {
+	for _, point := range points {
+		buffer = append(buffer, point.Path...)
+		buffer = append(buffer, ' ')
+		buffer = strconv.AppendFloat(buffer, point.Value, 'f', -1, 64)
+		buffer = append(buffer, ' ')
+		buffer = strconv.AppendInt(buffer, point.Timestamp.Unix(), 10)
+		buffer = append(buffer, '\n')
+	}
+	return buffer
+}
+
+/* Pickle opcodes, from Python's pickletools */
+const (
+	pickleProto = 0x80
+	pickleEmptyList = ']'
+	pickleMark = '('
+	pickleBinUnicode = 'X'
+	pickleBinFloat = 'G'
+	pickleTuple2 = 0x86
+	pickleAppends = 'e'
+	pickleStop = '.'
+)
+
+/* AppendPickle appends points as one pickle protocol message: a four byte big-endian length
+   followed by a protocol 2 pickle of [(path, (timestamp, value)), ...] */
+func AppendPickle(buffer []byte, points []Point) []byte {
+	pickle := []byte{pickleProto, 2, pickleEmptyList, pickleMark}
+	for _, point := range points {
+		pickle = append(pickle, pickleBinUnicode)
+		length := make([]byte, 4)
+		binary.LittleEndian.PutUint32(length, uint32(len(point.Path)))
+		pickle = append(pickle, length...)
+		pickle = append(pickle, point.Path...)
+		pickle = appendPickleFloat(pickle, float64(point.Timestamp.Unix()))
+		pickle = appendPickleFloat(pickle, point.Value)
+		pickle = append(pickle, pickleTuple2, pickleTuple2)
+	}
+	pickle = append(pickle, pickleAppends, pickleStop)
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(pickle)))
+	buffer = append(buffer, length...)
+	return append(buffer, pickle...)
+}
+
+func appendPickleFloat(pickle []byte, value float64) []byte {
+	bits := make([]byte, 8)
+	binary.BigEndian.PutUint64(bits, math.Float64bits(value))
+	pickle = append(pickle, pickleBinFloat)
+	return append(pickle, bits...)
+}
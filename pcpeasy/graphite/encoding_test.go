@@ -0,0 +1,86 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package graphite
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"math"
+	"time"
+)
+
+var timestamp = time.Unix(1700000000, 250000000)
+
+func TestPoints_flattensNumericValuesIntoPaths(t *testing.T) {
+	metrics := []pcpeasy.Metric{
+		{Name:"disk.dev.read_bytes", Timestamp:timestamp, Values:[]pcpeasy.MetricValue{
+			{Value:uint64(100), Instance:"sda"},
+			{Value:uint64(200), Instance:"/dev/mapper/vg0 root.lv"},
+		}},
+		{Name:"kernel.all.load", Values:[]pcpeasy.MetricValue{{Value:float32(0.5)}, {Value:math.NaN()}}},
+		{Name:"kernel.uname.release", Values:[]pcpeasy.MetricValue{{Value:"6.1.0"}}},
+	}
+	now := time.Unix(1800000000, 0)
+
+	points := Points("pcp.web1.", metrics, now)
+
+	assert.Equal(t, []Point{
+		{Path:"pcp.web1.disk.dev.read_bytes.sda", Value:100, Timestamp:timestamp},
+		{Path:"pcp.web1.disk.dev.read_bytes._2fdev_2fmapper_2fvg0_20root_2elv", Value:200, Timestamp:timestamp},
+		{Path:"pcp.web1.kernel.all.load", Value:0.5, Timestamp:now},
+	}, points)
+}
+
+func TestEncodeInstance_givesDistinctInstancesDistinctNodes(t *testing.T) {
+	instances := []string{"sda.1", "sda_1", "sda 1", "sda_2e1", "a b", "a_b", "a_20b", "état", "_"}
+	nodes := map[string]string{}
+	for _, instance := range instances {
+		node := EncodeInstance(instance)
+		assert.Regexp(t, `^[-a-zA-Z0-9_]+$`, node)
+		assert.NotContains(t, nodes, node, "%q and %q", nodes[node], instance)
+		nodes[node] = instance
+	}
+	assert.Equal(t, "sda_2e1", EncodeInstance("sda.1"))
+	assert.Equal(t, "sda_5f1", EncodeInstance("sda_1"))
+	assert.Equal(t, "_c3_a9tat", EncodeInstance("état"))
+	assert.Equal(t, "eth0-1", EncodeInstance("eth0-1"))
+}
+
+func TestAppendPlaintext(t *testing.T) {
+	encoded := AppendPlaintext(nil, []Point{{Path:"a.b", Value:1.5, Timestamp:timestamp}, {Path:"c", Value:1e21, Timestamp:timestamp}})
+
+	assert.Equal(t, "a.b 1.5 1700000000\nc 1000000000000000000000 1700000000\n", string(encoded))
+}
+
+func TestAppendPickle(t *testing.T) {
+	encoded := AppendPickle(nil, []Point{{Path:"a.b", Value:1.5, Timestamp:time.Unix(1, 0)}})
+
+	/* pickle.loads(encoded[4:]) == [("a.b", (1.0, 1.5))] */
+	assert.Equal(t, []byte{
+		0, 0, 0, 34,
+		0x80, 2, ']', '(',
+		'X', 3, 0, 0, 0, 'a', '.', 'b',
+		'G', 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+		'G', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0x86, 0x86, 'e', '.',
+	}, encoded)
+}
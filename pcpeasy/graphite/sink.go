@@ -0,0 +1,189 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package graphite
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type Protocol int
+
+const (
+	/* Lines of "path value timestamp", usually on port 2003 */
+	Plaintext Protocol = iota
+	/* Length prefixed pickles of many points at once, usually on port 2004 */
+	Pickle
+)
+
+/* Carbon refuses pickles over its size limit, so larger batches are split */
+const pointsPerPickle = 500
+
+type SinkOptions struct {
+	Protocol Protocol
+	/* Prepended to every path, such as "pcp.web1" */
+	Prefix string
+	/* Points held while Carbon is unreachable. The oldest are dropped beyond this. Defaults to 10000 */
+	BufferSize int
+	/* Minimum time between connection attempts. Defaults to five seconds */
+	ReconnectInterval time.Duration
+	/* Defaults to five seconds each */
+	DialTimeout time.Duration
+	WriteTimeout time.Duration
+}
+
+/*
+A Sink buffers points and writes them to Carbon, connecting on first use and reconnecting after a
+failed write. Points stay buffered until a write succeeds, so a batch cut off part way through is
+sent again in full; Carbon keeps the last value written for a path and timestamp, so the repeats
+are harmless.
+
+Points already accepted by the kernel for a connection that Carbon has since closed cannot be
+recovered, so a restart of Carbon can lose the writes made just before it is noticed.
+*/
+type Sink struct {
+	address string
+	options SinkOptions
+	lock sync.Mutex
+	connection net.Conn
+	last_dial time.Time
+	points []Point
+	dropped int
+	dial func(network string, address string, timeout time.Duration) (net.Conn, error)
+}
+
+func NewSink(address string, options SinkOptions) *Sink {
+	if(options.BufferSize <= 0) {
+		options.BufferSize = 10000
+	}
+	if(options.ReconnectInterval <= 0) {
+		options.ReconnectInterval = 5 * time.Second
+	}
+	if(options.DialTimeout <= 0) {
+		options.DialTimeout = 5 * time.Second
+	}
+	if(options.WriteTimeout <= 0) {
+		options.WriteTimeout = 5 * time.Second
+	}
+	return &Sink{address:address, options:options, dial:net.DialTimeout}
+}
+
+/* Write buffers the metrics and tries to send everything buffered */
+func (s *Sink) Write(metrics []pcpeasy.Metric) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.points = append(s.points, Points(s.options.Prefix, metrics, time.Now())...)
+	if(len(s.points) > s.options.BufferSize) {
+		overflow := len(s.points) - s.options.BufferSize
+		s.dropped += overflow
+		s.points = append([]Point{}, s.points[overflow:]...)
+	}
+	return s.flush()
+}
+
+/* Flush tries to send everything buffered, connecting first if need be */
+func (s *Sink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush()
+}
+
+/* Dropped is the number of points discarded because the buffer was full */
+func (s *Sink) Dropped() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+/* Close closes the connection to Carbon. Buffered points are not sent, call Flush first */
+func (s *Sink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.disconnect()
+}
+
+func (s *Sink) flush() error {
+	if(len(s.points) == 0) {
+		return nil
+	}
+	err := s.connect()
+	if(err != nil) {
+		return err
+	}
+
+	for len(s.points) > 0 {
+		batch := s.points
+		if(s.options.Protocol == Pickle && len(batch) > pointsPerPickle) {
+			batch = batch[:pointsPerPickle]
+		}
+		err = s.send(batch)
+		if(err != nil) {
+			s.disconnect()
+			return errors.New(fmt.Sprintf("writing to carbon at %v: %v", s.address, err))
+		}
+		s.points = s.points[len(batch):]
+	}
+	s.points = nil
+	return nil
+}
+
+func (s *Sink) send(batch []Point) error {
+	var encoded []byte
+	if(s.options.Protocol == Pickle) {
+		encoded = AppendPickle(nil, batch)
+	} else {
+		encoded = AppendPlaintext(nil, batch)
+	}
+	s.connection.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout))
+	_, err := s.connection.Write(encoded)
+	return err
+}
+
+/* connect dials Carbon unless already connected, waiting ReconnectInterval between attempts */
+func (s *Sink) connect() error {
+	if(s.connection != nil) {
+		return nil
+	}
+	if(!s.last_dial.IsZero() && time.Since(s.last_dial) < s.options.ReconnectInterval) {
+		return errors.New(fmt.Sprintf("not connected to carbon at %v, waiting to reconnect", s.address))
+	}
+	s.last_dial = time.Now()
+	connection, err := s.dial("tcp", s.address, s.options.DialTimeout)
+	if(err != nil) {
+		return errors.New(fmt.Sprintf("connecting to carbon at %v: %v", s.address, err))
+	}
+	s.connection = connection
+	return nil
+}
+
+func (s *Sink) disconnect() error {
+	if(s.connection == nil) {
+		return nil
+	}
+	err := s.connection.Close()
+	s.connection = nil
+	return err
+}
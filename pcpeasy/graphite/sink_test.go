@@ -0,0 +1,185 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package graphite
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+/* carbon stands in for a Carbon listener, passing each line received to lines */
+func carbon(t *testing.T, listener net.Listener, lines chan<- string) {
+	for {
+		connection, err := listener.Accept()
+		if(err != nil) {
+			return
+		}
+		go func() {
+			scanner := bufio.NewScanner(connection)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+	}
+}
+
+func load(value float64) []pcpeasy.Metric {
+	return []pcpeasy.Metric{{Name:"kernel.all.load", Timestamp:timestamp, Values:[]pcpeasy.MetricValue{{Value:value}}}}
+}
+
+func receive(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("carbon received nothing")
+		return ""
+	}
+}
+
+func TestSink_writesPlaintext(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go carbon(t, listener, lines)
+	sink := NewSink(listener.Addr().String(), SinkOptions{Prefix:"pcp"})
+	defer sink.Close()
+
+	assert.NoError(t, sink.Write(load(0.5)))
+	assert.NoError(t, sink.Write(load(1.5)))
+
+	assert.Equal(t, "pcp.kernel.all.load 0.5 1700000000", receive(t, lines))
+	assert.Equal(t, "pcp.kernel.all.load 1.5 1700000000", receive(t, lines))
+}
+
+func TestSink_buffersUntilCarbonIsReachable(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+	sink := NewSink(address, SinkOptions{ReconnectInterval:time.Nanosecond})
+	defer sink.Close()
+
+	assert.Error(t, sink.Write(load(1)))
+	assert.Error(t, sink.Write(load(2)))
+
+	listener, err := net.Listen("tcp", address)
+	if(err != nil) {
+		t.Skip("could not listen on the same address again: ", err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go carbon(t, listener, lines)
+
+	assert.NoError(t, sink.Flush())
+	assert.Equal(t, "kernel.all.load 1 1700000000", receive(t, lines))
+	assert.Equal(t, "kernel.all.load 2 1700000000", receive(t, lines))
+}
+
+func TestSink_dropsTheOldestPointsWhenTheBufferIsFull(t *testing.T) {
+	sink := NewSink("127.0.0.1:1", SinkOptions{BufferSize:2})
+	sink.dial = func(string, string, time.Duration) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	sink.Write(load(1))
+	sink.Write(load(2))
+	sink.Write(load(3))
+
+	assert.Equal(t, 1, sink.Dropped())
+	assert.Equal(t, []float64{2, 3}, []float64{sink.points[0].Value, sink.points[1].Value})
+}
+
+func TestSink_waitsBetweenConnectionAttempts(t *testing.T) {
+	dials := 0
+	sink := NewSink("127.0.0.1:1", SinkOptions{ReconnectInterval:time.Hour})
+	sink.dial = func(string, string, time.Duration) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+
+	assert.EqualError(t, sink.Write(load(1)), "connecting to carbon at 127.0.0.1:1: connection refused")
+	assert.EqualError(t, sink.Write(load(2)), "not connected to carbon at 127.0.0.1:1, waiting to reconnect")
+	assert.Equal(t, 1, dials)
+}
+
+func TestSink_reconnectsAfterCarbonClosesTheConnection(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	lines := make(chan string, 100)
+	go func() {
+		/* The first connection is closed straight away, as by a restart */
+		connection, err := listener.Accept()
+		if(err != nil) {
+			return
+		}
+		connection.Close()
+		carbon(t, listener, lines)
+	}()
+	sink := NewSink(listener.Addr().String(), SinkOptions{ReconnectInterval:time.Nanosecond})
+	defer sink.Close()
+
+	failed := false
+	for i := 0; i < 100 && !failed; i++ {
+		failed = sink.Write(load(float64(i))) != nil
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, failed)
+	assert.NoError(t, sink.Write(load(-1)))
+
+	for {
+		if(receive(t, lines) == "kernel.all.load -1 1700000000") {
+			break
+		}
+	}
+}
+
+func TestSink_writesPickles(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	expected := AppendPickle(nil, []Point{{Path:"kernel.all.load", Value:1.5, Timestamp:timestamp}})
+	received := make(chan []byte, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if(err != nil) {
+			return
+		}
+		buffer := make([]byte, len(expected))
+		io.ReadFull(connection, buffer)
+		received <- buffer
+	}()
+	sink := NewSink(listener.Addr().String(), SinkOptions{Protocol:Pickle})
+	defer sink.Close()
+
+	sink.Write(load(1.5))
+
+	select {
+	case buffer := <-received:
+		assert.Equal(t, expected, buffer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("carbon received nothing")
+	}
+}
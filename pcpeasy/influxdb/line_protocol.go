@@ -0,0 +1,140 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package influxdb writes PCP metrics fetched through pcpeasy as InfluxDB line protocol.
+
+Each value becomes one point. The metric name is the measurement, the instance and PCP labels are
+tags, the value is a field named value and the fetch timestamp is written in nanoseconds:
+
+	disk.dev.read_bytes,hostname=web1,instance=sda,instid=0 value=102400i 1700000000250000000
+
+Signed and 32 bit unsigned integers are written as integer fields. 64 bit unsigned integers are
+written as unsigned fields (u suffix), which InfluxDB 1.x only accepts with unsigned support
+enabled.
+*/
+package influxdb
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	InstanceTag = "instance"
+	InstanceIDTag = "instid"
+	ValueField = "value"
+)
+
+/* Encode writes one line per metric value */
+func Encode(writer io.Writer, metrics []pcpeasy.Metric) error {
+	_, err := io.WriteString(writer, string(AppendLines(nil, metrics)))
+	return err
+}
+
+/* AppendLines appends one newline terminated line per metric value to buffer */
+func AppendLines(buffer []byte, metrics []pcpeasy.Metric) []byte {
+	for _, metric := range metrics {
+		for _, metric_value := range metric.Values {
+			field, ok := formatField(metric_value.Value)
+			if(!ok) {
+				continue
+			}
+			buffer = append(buffer, measurementEscaper.Replace(metric.Name)...)
+			buffer = appendTags(buffer, metric_value)
+			buffer = append(buffer, ' ')
+			buffer = append(buffer, ValueField...)
+			buffer = append(buffer, '=')
+			buffer = append(buffer, field...)
+			if(!metric.Timestamp.IsZero()) {
+				buffer = append(buffer, ' ')
+				buffer = strconv.AppendInt(buffer, metric.Timestamp.UnixNano(), 10)
+			}
+			buffer = append(buffer, '\n')
+		}
+	}
+	return buffer
+}
+
+/* appendTags writes the PCP labels and instance as tags, sorted by key as InfluxDB recommends.
+   Tags with empty values are not allowed and are left out */
+func appendTags(buffer []byte, metric_value pcpeasy.MetricValue) []byte {
+	tags := make(map[string]string)
+	for name, value := range metric_value.Labels {
+		tags[name] = value
+	}
+	if(metric_value.Instance != "") {
+		tags[InstanceTag] = metric_value.Instance
+		tags[InstanceIDTag] = strconv.Itoa(metric_value.InstanceID)
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if(key != "" && value != "") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buffer = append(buffer, ',')
+		buffer = append(buffer, tagEscaper.Replace(key)...)
+		buffer = append(buffer, '=')
+		buffer = append(buffer, tagEscaper.Replace(tags[key])...)
+	}
+	return buffer
+}
+
+func formatField(value interface{}) (string, bool) {
+	switch typed := value.(type) {
+	case int32:
+		return strconv.FormatInt(int64(typed), 10) + "i", true
+	case uint32:
+		return strconv.FormatUint(uint64(typed), 10) + "i", true
+	case int64:
+		return strconv.FormatInt(typed, 10) + "i", true
+	case uint64:
+		return strconv.FormatUint(typed, 10) + "u", true
+	case float32:
+		return formatFloat(float64(typed))
+	case float64:
+		return formatFloat(typed)
+	case string:
+		return "\"" + fieldStringEscaper.Replace(typed) + "\"", true
+	}
+	return "", false
+}
+
+/* formatFloat leaves out NaN and infinities, which line protocol cannot represent */
+func formatFloat(value float64) (string, bool) {
+	if(math.IsNaN(value) || math.IsInf(value, 0)) {
+		return "", false
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), true
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	fieldStringEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`)
+)
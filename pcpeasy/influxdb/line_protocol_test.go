@@ -0,0 +1,79 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package influxdb
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"bytes"
+	"math"
+	"reflect"
+	"time"
+)
+
+var timestamp = time.Unix(1700000000, 250000000)
+
+func TestEncode_writesOnePointPerValue(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{
+		{Name:"disk.dev.read_bytes", Type:reflect.Uint64, Timestamp:timestamp, Values:[]pcpeasy.MetricValue{
+			{Value:uint64(100), Instance:"sda", InstanceID:0, Labels:map[string]string{"hostname":"web1"}},
+			{Value:uint64(200), Instance:"sdb", InstanceID:1, Labels:map[string]string{"hostname":"web1", "device_type":"ssd"}},
+		}},
+		{Name:"kernel.all.load", Type:reflect.Float32, Timestamp:timestamp, Values:[]pcpeasy.MetricValue{
+			{Value:float32(0.5), InstanceID:-1},
+		}},
+	}
+
+	err := Encode(&out, metrics)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `disk.dev.read_bytes,hostname=web1,instance=sda,instid=0 value=100u 1700000000250000000
+disk.dev.read_bytes,device_type=ssd,hostname=web1,instance=sdb,instid=1 value=200u 1700000000250000000
+kernel.all.load value=0.5 1700000000250000000
+`, out.String())
+}
+
+func TestEncode_escapesMeasurementsTagsAndStrings(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{
+		{Name:"odd name,here", Type:reflect.String, Values:[]pcpeasy.MetricValue{
+			{Value:"say \"hi\" \\o/", Instance:"a b,c=d", InstanceID:7, Labels:map[string]string{"empty":""}},
+		}},
+	}
+
+	Encode(&out, metrics)
+
+	assert.Equal(t, `odd\ name\,here,instance=a\ b\,c\=d,instid=7 value="say \"hi\" \\o/"` + "\n", out.String())
+}
+
+func TestEncode_writesSignedIntegersAndSkipsValuesLineProtocolCannotHold(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{
+		{Name:"a", Values:[]pcpeasy.MetricValue{{Value:int32(-3)}, {Value:uint32(4)}, {Value:int64(-5)}}},
+		{Name:"b", Values:[]pcpeasy.MetricValue{{Value:math.NaN()}, {Value:math.Inf(1)}}},
+	}
+
+	Encode(&out, metrics)
+
+	assert.Equal(t, "a value=-3i\na value=4i\na value=-5i\n", out.String())
+}
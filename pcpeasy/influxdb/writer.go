@@ -0,0 +1,240 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package influxdb
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type WriterOptions struct {
+	/* Lines sent per request. Defaults to 5000 */
+	BatchSize int
+	/* Further attempts at a batch after a network error, 429 or 5xx response */
+	MaxRetries int
+	/* Wait before the first retry, doubling for each one after. Defaults to one second. A
+	   Retry-After header from the server takes precedence */
+	RetryInterval time.Duration
+	/* Sent as "Authorization: Token <Token>" when set, as InfluxDB 2 expects */
+	Token string
+	/* Defaults to a client with a 10 second timeout */
+	Client *http.Client
+	/* Receives errors from Run, which carries on after them. Errors are dropped when nil */
+	OnError func(error)
+}
+
+/*
+A Writer buffers line protocol and sends it to an InfluxDB write endpoint in batches, such as
+http://localhost:8086/write?db=pcp for 1.x or http://localhost:8086/api/v2/write?org=o&bucket=pcp
+for 2.x. Timestamps are in nanoseconds, the default precision of both.
+
+A batch that still fails once its retries are used up is dropped and the error returned, so a
+long outage does not grow the buffer without bound.
+*/
+type Writer struct {
+	url string
+	options WriterOptions
+	lock sync.Mutex
+	buffer []byte
+	lines int
+}
+
+func NewWriter(url string, options WriterOptions) *Writer {
+	if(options.BatchSize <= 0) {
+		options.BatchSize = 5000
+	}
+	if(options.RetryInterval <= 0) {
+		options.RetryInterval = time.Second
+	}
+	if(options.Client == nil) {
+		options.Client = &http.Client{Timeout:10 * time.Second}
+	}
+	return &Writer{url:url, options:options}
+}
+
+/* Write buffers the metrics, sending full batches as they fill */
+func (w *Writer) Write(metrics []pcpeasy.Metric) error {
+	return w.WriteContext(context.Background(), metrics)
+}
+
+/* WriteContext is Write, giving up on retries once ctx is done. Batches are sent without holding
+   the buffer, so other writes and flushes carry on while one is retried */
+func (w *Writer) WriteContext(ctx context.Context, metrics []pcpeasy.Metric) error {
+	w.lock.Lock()
+	length := len(w.buffer)
+	w.buffer = AppendLines(w.buffer, metrics)
+	w.lines += bytes.Count(w.buffer[length:], []byte{'\n'})
+	w.lock.Unlock()
+
+	for {
+		batch := w.takeBatch()
+		if(batch == nil) {
+			return nil
+		}
+		err := w.send(ctx, batch)
+		if(err != nil) {
+			return err
+		}
+	}
+}
+
+/* takeBatch removes a full batch from the buffer, or returns nil when there is none */
+func (w *Writer) takeBatch() []byte {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if(w.lines < w.options.BatchSize) {
+		return nil
+	}
+	batch_length := batchLength(w.buffer, w.options.BatchSize)
+	batch := w.buffer[:batch_length:batch_length]
+	w.buffer = w.buffer[batch_length:]
+	w.lines -= w.options.BatchSize
+	return batch
+}
+
+/* Flush sends whatever is buffered */
+func (w *Writer) Flush() error {
+	return w.FlushContext(context.Background())
+}
+
+/* FlushContext is Flush, giving up on retries once ctx is done */
+func (w *Writer) FlushContext(ctx context.Context) error {
+	w.lock.Lock()
+	if(w.lines == 0) {
+		w.lock.Unlock()
+		return nil
+	}
+	batch := w.buffer
+	w.buffer = nil
+	w.lines = 0
+	w.lock.Unlock()
+	return w.send(ctx, batch)
+}
+
+/*
+Run writes the samples from a pcpeasy Watch, flushing every flush_interval and once more when the
+channel closes or ctx is done. Retries stop when ctx is done, so the last flush is attempted once.
+Samples carrying a fetch error are skipped. Errors go to WriterOptions.OnError.
+*/
+func (w *Writer) Run(ctx context.Context, samples <-chan pcpeasy.Sample, flush_interval time.Duration) {
+	ticker := time.NewTicker(flush_interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case sample, ok := <-samples:
+			if(!ok) {
+				w.handleError(w.FlushContext(ctx))
+				return
+			}
+			if(sample.Err != nil) {
+				continue
+			}
+			w.handleError(w.WriteContext(ctx, sample.Metrics))
+		case <-ticker.C:
+			w.handleError(w.FlushContext(ctx))
+		case <-ctx.Done():
+			w.handleError(w.FlushContext(ctx))
+			return
+		}
+	}
+}
+
+func (w *Writer) handleError(err error) {
+	if(err != nil && w.options.OnError != nil) {
+		w.options.OnError(err)
+	}
+}
+
+/* send posts a batch, retrying failures that may be temporary until ctx is done */
+func (w *Writer) send(ctx context.Context, batch []byte) error {
+	wait := w.options.RetryInterval
+	for attempt := 0; ; attempt++ {
+		retry_after, err := w.post(batch)
+		if(err == nil) {
+			return nil
+		}
+		if(retry_after < 0 || attempt >= w.options.MaxRetries) {
+			return err
+		}
+		if(retry_after == 0) {
+			retry_after = wait
+			wait *= 2
+		}
+		timer := time.NewTimer(retry_after)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+/* post makes one attempt at a batch. retry_after is negative when the failure is permanent, and
+   otherwise the wait the server asked for, if any */
+func (w *Writer) post(batch []byte) (retry_after time.Duration, err error) {
+	request, err := http.NewRequest("POST", w.url, bytes.NewReader(batch))
+	if(err != nil) {
+		return -1, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if(w.options.Token != "") {
+		request.Header.Set("Authorization", "Token " + w.options.Token)
+	}
+
+	response, err := w.options.Client.Do(request)
+	if(err != nil) {
+		return 0, err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+
+	if(response.StatusCode >= 200 && response.StatusCode < 300) {
+		return 0, nil
+	}
+	err = errors.New(fmt.Sprintf("influxdb write failed with %v: %v", response.Status, string(bytes.TrimSpace(body))))
+	if(response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500) {
+		seconds, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+		if(seconds < 0) {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, err
+	}
+	return -1, err
+}
+
+/* batchLength is the length of the first lines lines of buffer */
+func batchLength(buffer []byte, lines int) int {
+	length := 0
+	for i := 0; i < lines; i++ {
+		length += bytes.IndexByte(buffer[length:], '\n') + 1
+	}
+	return length
+}
@@ -0,0 +1,236 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package influxdb
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* server stands in for InfluxDB, answering with statuses in turn and recording each body */
+type server struct {
+	lock sync.Mutex
+	statuses []int
+	bodies []string
+	headers []http.Header
+}
+
+func (s *server) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	body, _ := ioutil.ReadAll(request.Body)
+	s.bodies = append(s.bodies, string(body))
+	s.headers = append(s.headers, request.Header)
+	status := http.StatusNoContent
+	if(len(s.statuses) > 0) {
+		status = s.statuses[0]
+		s.statuses = s.statuses[1:]
+	}
+	if(status >= 400) {
+		http.Error(response, `{"error":"try later"}`, status)
+		return
+	}
+	response.WriteHeader(status)
+}
+
+func (s *server) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+func point(value int64) []pcpeasy.Metric {
+	return []pcpeasy.Metric{{Name:"m", Values:[]pcpeasy.MetricValue{{Value:value}}}}
+}
+
+func TestWriter_sendsFullBatches(t *testing.T) {
+	stand_in := &server{}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL + "/write?db=pcp", WriterOptions{BatchSize:2, Token:"secret"})
+
+	assert.NoError(t, writer.Write(point(1)))
+	assert.Empty(t, stand_in.received())
+	assert.NoError(t, writer.Write(append(point(2), point(3)...)))
+	assert.Equal(t, []string{"m value=1i\nm value=2i\n"}, stand_in.received())
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, []string{"m value=1i\nm value=2i\n", "m value=3i\n"}, stand_in.received())
+	assert.Equal(t, "Token secret", stand_in.headers[0].Get("Authorization"))
+}
+
+func TestWriter_flushWithNothingBufferedSendsNothing(t *testing.T) {
+	stand_in := &server{}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+
+	assert.NoError(t, NewWriter(influx.URL, WriterOptions{}).Flush())
+	assert.Empty(t, stand_in.received())
+}
+
+func TestWriter_retriesTemporaryFailures(t *testing.T) {
+	stand_in := &server{statuses:[]int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:2, RetryInterval:time.Millisecond})
+
+	writer.Write(point(1))
+	err := writer.Flush()
+
+	assert.NoError(t, err)
+	assert.Len(t, stand_in.received(), 3)
+}
+
+func TestWriter_givesUpAfterMaxRetriesAndDropsTheBatch(t *testing.T) {
+	stand_in := &server{statuses:[]int{500, 500, 500}}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:1, RetryInterval:time.Millisecond})
+
+	writer.Write(point(1))
+	err := writer.Flush()
+
+	assert.EqualError(t, err, "influxdb write failed with 500 Internal Server Error: {\"error\":\"try later\"}")
+	assert.Len(t, stand_in.received(), 2)
+	assert.NoError(t, writer.Flush())
+	assert.Len(t, stand_in.received(), 2)
+}
+
+func TestWriter_doesNotRetryRejectedWrites(t *testing.T) {
+	stand_in := &server{statuses:[]int{http.StatusBadRequest}}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:3, RetryInterval:time.Millisecond})
+
+	writer.Write(point(1))
+	err := writer.Flush()
+
+	assert.Error(t, err)
+	assert.Len(t, stand_in.received(), 1)
+}
+
+func TestWriter_retriesNetworkErrors(t *testing.T) {
+	influx := httptest.NewServer(&server{})
+	influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:1, RetryInterval:time.Millisecond})
+
+	writer.Write(point(1))
+
+	assert.Error(t, writer.Flush())
+}
+
+func TestWriter_runWritesSamplesAndFlushesWhenTheChannelCloses(t *testing.T) {
+	stand_in := &server{}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{})
+	samples := make(chan pcpeasy.Sample, 3)
+	samples <- pcpeasy.Sample{Metrics:point(1)}
+	samples <- pcpeasy.Sample{Err:context.DeadlineExceeded}
+	samples <- pcpeasy.Sample{Metrics:point(2)}
+	close(samples)
+
+	writer.Run(context.Background(), samples, time.Hour)
+
+	assert.Equal(t, "m value=1i\nm value=2i\n", strings.Join(stand_in.received(), ""))
+}
+
+func TestWriter_runReportsErrors(t *testing.T) {
+	influx := httptest.NewServer(&server{statuses:[]int{http.StatusUnauthorized}})
+	defer influx.Close()
+	reported := []error{}
+	writer := NewWriter(influx.URL, WriterOptions{OnError:func(err error) {
+		reported = append(reported, err)
+	}})
+	samples := make(chan pcpeasy.Sample, 1)
+	samples <- pcpeasy.Sample{Metrics:point(1)}
+	close(samples)
+
+	writer.Run(context.Background(), samples, time.Hour)
+
+	assert.Len(t, reported, 1)
+}
+
+func TestWriter_writesWhileAnotherBatchIsRetried(t *testing.T) {
+	stand_in := &server{statuses:[]int{503, 503, 503}}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:3, RetryInterval:time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	writer.Write(point(1))
+	flushed := make(chan error)
+	go func() {
+		flushed <- writer.FlushContext(ctx)
+	}()
+	for len(stand_in.received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	written := make(chan error)
+	go func() {
+		written <- writer.Write(point(2))
+	}()
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write waited for the batch being retried")
+	}
+	cancel()
+	assert.Error(t, <-flushed)
+}
+
+func TestWriter_runStopsRetryingWhenCancelled(t *testing.T) {
+	stand_in := &server{statuses:[]int{503, 503, 503}}
+	influx := httptest.NewServer(stand_in)
+	defer influx.Close()
+	reported := []error{}
+	writer := NewWriter(influx.URL, WriterOptions{MaxRetries:3, RetryInterval:time.Hour, OnError:func(err error) {
+		reported = append(reported, err)
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	samples := make(chan pcpeasy.Sample, 1)
+	samples <- pcpeasy.Sample{Metrics:point(1)}
+
+	done := make(chan bool)
+	go func() {
+		writer.Run(ctx, samples, time.Millisecond)
+		close(done)
+	}()
+	for len(stand_in.received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept retrying after ctx was done")
+	}
+	assert.Len(t, reported, 1)
+}
@@ -0,0 +1,150 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"regexp"
+	"sort"
+)
+
+/*
+InstanceFilter selects instances by exact name, by numeric ID or by a pattern matched against
+instance names. An instance is kept if it satisfies any of the criteria, so an empty
+InstanceFilter{} selects nothing.
+*/
+type InstanceFilter struct {
+	Names []string
+	IDs []int
+	Pattern *regexp.Regexp
+}
+
+/*
+MetricsMatching is Metrics restricted to the instances selected by filter. The filter is sent to
+pmcd as a fetch profile so unselected instances are never transferred. Metrics without an instance
+domain are returned unfiltered.
+*/
+func (a *agent) MetricsMatching(filter InstanceFilter, metric_strings ...string) ([]Metric, error) {
+	return a.MetricsWithOptions(FetchOptions{Instances:&filter}, metric_strings...)
+}
+
+/* fetchProfile records what was looked up while installing a profile so the fetch that follows
+   does not have to look it up again */
+type fetchProfile struct {
+	descs map[pmapi.PmID]pmapi.PmDesc
+	/* Selected instances for each profiled indom. Names are empty for instances selected by ID */
+	instances map[pmapi.PmInDom]map[int]string
+}
+
+func (a *agent) installProfile(filter InstanceFilter, pmids []pmapi.PmID) (*fetchProfile, error) {
+	profile := &fetchProfile{
+		descs:make(map[pmapi.PmID]pmapi.PmDesc),
+		instances:make(map[pmapi.PmInDom]map[int]string),
+	}
+	for _, pmid := range pmids {
+		metric_desc, err := a.pmapi.PmLookupDesc(pmid)
+		if(err != nil) {
+			a.resetProfile(profile)
+			return nil, err
+		}
+		profile.descs[pmid] = metric_desc
+
+		indom := metric_desc.InDom
+		if _, profiled := profile.instances[indom]; profiled || indom == pmapi.PmInDomNull {
+			continue
+		}
+		instances, err := a.selectInstances(filter, indom)
+		if(err != nil) {
+			a.resetProfile(profile)
+			return nil, err
+		}
+		profile.instances[indom] = instances
+
+		/* Exclude everything, then add back the selected instances */
+		err = a.pmapi.PmDelProfile(indom)
+		if(err == nil && len(instances) > 0) {
+			err = a.pmapi.PmAddProfile(indom, sortedInstanceIDs(instances)...)
+		}
+		if(err != nil) {
+			a.resetProfile(profile)
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+/* resetProfile puts every profiled indom back to including all instances */
+func (a *agent) resetProfile(profile *fetchProfile) {
+	for indom := range profile.instances {
+		a.pmapi.PmAddProfile(indom)
+	}
+}
+
+/* selectInstances resolves a filter against an indom. Only a Pattern needs the whole indom;
+   names are looked up individually and IDs are taken as given */
+func (a *agent) selectInstances(filter InstanceFilter, indom pmapi.PmInDom) (map[int]string, error) {
+	instances := make(map[int]string)
+	for _, instance_id := range filter.IDs {
+		instances[instance_id] = ""
+	}
+	for _, name := range filter.Names {
+		instance_id, err := a.pmapi.PmLookupInDom(indom, name)
+		if(pmapi.IsPmError(err, pmapi.PmErrInst)) {
+			/* The instance has gone away (or never existed), so there is nothing to fetch */
+			continue
+		}
+		if(err != nil) {
+			return nil, err
+		}
+		instances[instance_id] = name
+	}
+	if(filter.Pattern != nil) {
+		ids_to_instance_names, err := a.pmapi.PmGetInDom(indom)
+		if(err != nil) {
+			return nil, err
+		}
+		for instance_id, name := range ids_to_instance_names {
+			if(filter.Pattern.MatchString(name)) {
+				instances[instance_id] = name
+			}
+		}
+	}
+	return instances, nil
+}
+
+func (a *agent) buildMetricValuesForProfile(vset *pmapi.PmValueSet, metric_desc pmapi.PmDesc, profile *fetchProfile) ([]MetricValue, error) {
+	known_names := profile.instances[metric_desc.InDom]
+	return a.buildInstanceValues(vset, metric_desc, func(instance_id int) (string, error) {
+		if instance, found := known_names[instance_id]; found && instance != "" {
+			return instance, nil
+		}
+		return a.pmapi.PmNameInDom(metric_desc.InDom, instance_id)
+	})
+}
+
+func sortedInstanceIDs(instances map[int]string) []int {
+	ids := make([]int, 0, len(instances))
+	for instance_id := range instances {
+		ids = append(ids, instance_id)
+	}
+	sort.Ints(ids)
+	return ids
+}
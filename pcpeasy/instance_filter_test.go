@@ -0,0 +1,155 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"errors"
+	"regexp"
+	"time"
+)
+
+var procInDom = pmapi.PmInDom(3)
+var procPmID = pmapi.PmID(300)
+
+func filterTestAgent() (*agent, *MockPMAPI) {
+	mock_pmapi := &MockPMAPI{}
+	pm_desc := pmapi.PmDesc{Type:pmapi.PmType32, InDom:procInDom, PmID:procPmID}
+	mock_pmapi.On("PmLookupName", []string{"proc.psinfo.rss"}).Return([]pmapi.PmID{procPmID}, nil)
+	mock_pmapi.On("PmLookupDesc", procPmID).Return(pm_desc, nil)
+	mock_pmapi.On("PmDelProfile", procInDom, []int(nil)).Return(nil)
+	mock_pmapi.On("PmAddProfile", procInDom, []int(nil)).Return(nil)
+	return &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}, mock_pmapi
+}
+
+func mockProcFetch(mock_pmapi *MockPMAPI, values map[int]int32) {
+	vlist := []*pmapi.PmValue{}
+	for _, instance_id := range sortedInstanceIDs(map[int]string{1:"", 20:"", 300:""}) {
+		value, selected := values[instance_id]
+		if(!selected) {
+			continue
+		}
+		pm_value := &pmapi.PmValue{Inst:instance_id}
+		mock_pmapi.On("PmExtractValue", pmapi.PmValInsitu, pmapi.PmType32, pm_value).Return(pmapi.PmAtomValue{Int32:value}, nil)
+		vlist = append(vlist, pm_value)
+	}
+	pm_result := &pmapi.PmResult{
+		NumPmID:1,
+		Timestamp:time.Unix(123,456),
+		VSet:[]*pmapi.PmValueSet{{NumVal:len(vlist), PmID:procPmID, ValFmt:pmapi.PmValInsitu, VList:vlist}},
+	}
+	mock_pmapi.On("PmFetch", []pmapi.PmID{procPmID}).Return(pm_result, nil)
+}
+
+func TestAgent_MetricsMatching_restrictsTheProfileToNamedInstances(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mock_pmapi.On("PmLookupInDom", procInDom, "000020 sshd").Return(20, nil)
+	mock_pmapi.On("PmLookupInDom", procInDom, "000099 gone").Return(0, pmapi.PmError{Code:pmapi.PmErrInst, Message:"Unknown or illegal instance identifier"})
+	mock_pmapi.On("PmAddProfile", procInDom, []int{20}).Return(nil)
+	mockProcFetch(mock_pmapi, map[int]int32{20:2048})
+
+	metrics, err := agent.MetricsMatching(InstanceFilter{Names:[]string{"000020 sshd", "000099 gone"}}, "proc.psinfo.rss")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricValue{{Instance:"000020 sshd", InstanceID:20, Value:int32(2048)}}, metrics[0].Values)
+	mock_pmapi.AssertCalled(t, "PmAddProfile", procInDom, []int{20})
+	mock_pmapi.AssertNotCalled(t, "PmGetInDom", procInDom)
+}
+
+func TestAgent_MetricsMatching_returnsErrorsLookingUpNamesOtherThanUnknownInstances(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mock_pmapi.On("PmLookupInDom", procInDom, "000020 sshd").Return(0, pmapi.PmError{Code:pmapi.PmErrIPC, Message:"IPC protocol failure"})
+
+	_, err := agent.MetricsMatching(InstanceFilter{Names:[]string{"000020 sshd"}}, "proc.psinfo.rss")
+
+	assert.EqualError(t, err, "IPC protocol failure")
+	mock_pmapi.AssertNotCalled(t, "PmFetch", []pmapi.PmID{procPmID})
+}
+
+func TestAgent_MetricsMatching_resolvesNamesForInstancesSelectedByID(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mock_pmapi.On("PmAddProfile", procInDom, []int{1, 300}).Return(nil)
+	mock_pmapi.On("PmNameInDom", procInDom, 1).Return("000001 init", nil)
+	mock_pmapi.On("PmNameInDom", procInDom, 300).Return("000300 bash", nil)
+	mockProcFetch(mock_pmapi, map[int]int32{1:10, 300:30})
+
+	metrics, err := agent.MetricsMatching(InstanceFilter{IDs:[]int{300, 1}}, "proc.psinfo.rss")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricValue{
+		{Instance:"000001 init", InstanceID:1, Value:int32(10)},
+		{Instance:"000300 bash", InstanceID:300, Value:int32(30)},
+	}, metrics[0].Values)
+}
+
+func TestAgent_MetricsMatching_matchesPatternsAgainstTheInstanceDomain(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mock_pmapi.On("PmGetInDom", procInDom).Return(map[int]string{1:"000001 init", 20:"000020 sshd", 300:"000300 sshd-session"}, nil)
+	mock_pmapi.On("PmAddProfile", procInDom, []int{20, 300}).Return(nil)
+	mockProcFetch(mock_pmapi, map[int]int32{20:2, 300:3})
+
+	metrics, err := agent.MetricsMatching(InstanceFilter{Pattern:regexp.MustCompile(`sshd`)}, "proc.psinfo.rss")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricValue{
+		{Instance:"000020 sshd", InstanceID:20, Value:int32(2)},
+		{Instance:"000300 sshd-session", InstanceID:300, Value:int32(3)},
+	}, metrics[0].Values)
+}
+
+func TestAgent_MetricsMatching_returnsNoValuesWhenNothingMatches(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mockProcFetch(mock_pmapi, map[int]int32{})
+
+	metrics, err := agent.MetricsMatching(InstanceFilter{}, "proc.psinfo.rss")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricValue{}, metrics[0].Values)
+	mock_pmapi.AssertNotCalled(t, "PmAddProfile", procInDom, []int{})
+}
+
+func TestAgent_MetricsMatching_resetsTheProfileAfterFetching(t *testing.T) {
+	agent, mock_pmapi := filterTestAgent()
+	mock_pmapi.On("PmAddProfile", procInDom, []int{20}).Return(nil)
+	mock_pmapi.On("PmNameInDom", procInDom, 20).Return("000020 sshd", nil)
+	mockProcFetch(mock_pmapi, map[int]int32{20:2})
+
+	agent.MetricsMatching(InstanceFilter{IDs:[]int{20}}, "proc.psinfo.rss")
+
+	mock_pmapi.AssertCalled(t, "PmAddProfile", procInDom, []int(nil))
+}
+
+func TestAgent_MetricsMatching_returnsAnErrorIfTheProfileCannotBeInstalled(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi}
+	pm_desc := pmapi.PmDesc{Type:pmapi.PmType32, InDom:procInDom, PmID:procPmID}
+	mock_pmapi.On("PmLookupName", []string{"proc.psinfo.rss"}).Return([]pmapi.PmID{procPmID}, nil)
+	mock_pmapi.On("PmLookupDesc", procPmID).Return(pm_desc, nil)
+	mock_pmapi.On("PmDelProfile", procInDom, []int(nil)).Return(errors.New("profile error"))
+	mock_pmapi.On("PmAddProfile", procInDom, []int(nil)).Return(nil)
+
+	_, err := agent.MetricsMatching(InstanceFilter{IDs:[]int{20}}, "proc.psinfo.rss")
+
+	assert.EqualError(t, err, "profile error")
+	mock_pmapi.AssertNotCalled(t, "PmFetch", []pmapi.PmID{procPmID})
+}
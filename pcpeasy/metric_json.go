@@ -0,0 +1,281 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+type metricJSON struct {
+	Name string `json:"name"`
+	PmID pmapi.PmID `json:"pmid"`
+	InDom *pmapi.PmInDom `json:"indom"`
+	Type string `json:"type"`
+	Semantics string `json:"semantics"`
+	Units unitsJSON `json:"units"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Help string `json:"help,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Values []metricValueJSON `json:"values"`
+}
+
+type unitsJSON struct {
+	DimSpace int `json:"dimSpace"`
+	DimTime int `json:"dimTime"`
+	DimCount int `json:"dimCount"`
+	ScaleSpace SpaceScale `json:"scaleSpace"`
+	ScaleTime TimeScale `json:"scaleTime"`
+	ScaleCount CountScale `json:"scaleCount"`
+}
+
+type metricValueJSON struct {
+	Instance string `json:"instance,omitempty"`
+	InstanceID *int `json:"instanceId,omitempty"`
+	Value json.RawMessage `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var typeNames = map[reflect.Kind]string{
+	reflect.Int32:"32",
+	reflect.Uint32:"U32",
+	reflect.Int64:"64",
+	reflect.Uint64:"U64",
+	reflect.Float32:"FLOAT",
+	reflect.Float64:"DOUBLE",
+	reflect.String:"STRING",
+}
+
+var pmTypes = map[reflect.Kind]int{
+	reflect.Int32:pmapi.PmType32,
+	reflect.Uint32:pmapi.PmTypeU32,
+	reflect.Int64:pmapi.PmType64,
+	reflect.Uint64:pmapi.PmTypeU64,
+	reflect.Float32:pmapi.PmTypeFloat,
+	reflect.Float64:pmapi.PmTypeDouble,
+	reflect.String:pmapi.PmTypeString,
+}
+
+/*
+MarshalJSON writes a metric in this shape:
+
+	{
+	  "name": "disk.dev.read_bytes",
+	  "pmid": 251658292,
+	  "indom": 251658241,
+	  "type": "U64",
+	  "semantics": "counter",
+	  "units": {"dimSpace": 1, "dimTime": 0, "dimCount": 0, "scaleSpace": 1, "scaleTime": 0, "scaleCount": 0},
+	  "timestamp": "2023-11-14T22:13:20.25Z",
+	  "help": "per-disk read bytes",
+	  "labels": {"hostname": "web1"},
+	  "values": [
+	    {"instance": "sda", "instanceId": 0, "value": "102400", "labels": {"hostname": "web1"}}
+	  ]
+	}
+
+type is the PCP type name: 32, U32, 64, U64, FLOAT, DOUBLE or STRING. Values of the 64 bit types
+are written as decimal strings, since many JSON readers hold numbers as doubles and would lose
+precision. FLOAT and DOUBLE values are numbers, apart from "NaN", "Infinity" and "-Infinity".
+indom is null and instance and instanceId are left out for metrics without an instance domain.
+timestamp, help and labels are left out when not set. Fields may be added but existing fields
+will keep their names and meaning.
+*/
+func (m Metric) MarshalJSON() ([]byte, error) {
+	type_name, found := typeNames[m.Type]
+	if(!found) {
+		return nil, errors.New(fmt.Sprintf("metric \"%v\" has no JSON representation for type %v", m.Name, m.Type))
+	}
+	encoded := metricJSON{
+		Name:m.Name,
+		PmID:m.PmID,
+		Type:type_name,
+		Semantics:m.Semantics,
+		Units:unitsJSON(m.Units),
+		Help:m.Help,
+		Labels:m.Labels,
+		Values:make([]metricValueJSON, len(m.Values)),
+	}
+	if(m.InDom != pmapi.PmInDomNull) {
+		indom := m.InDom
+		encoded.InDom = &indom
+	}
+	if(!m.Timestamp.IsZero()) {
+		timestamp := m.Timestamp.UTC()
+		encoded.Timestamp = &timestamp
+	}
+	for i, metric_value := range m.Values {
+		value, err := marshalValue(metric_value.Value)
+		if(err != nil) {
+			return nil, errors.New(fmt.Sprintf("metric \"%v\": %v", m.Name, err))
+		}
+		encoded.Values[i] = metricValueJSON{Instance:metric_value.Instance, Value:value, Labels:metric_value.Labels}
+		if(metric_value.InstanceID != pmapi.PmInNull) {
+			instance_id := metric_value.InstanceID
+			encoded.Values[i].InstanceID = &instance_id
+		}
+	}
+	/* <, > and & are left for the encoder calling this to escape, which json.Marshal does and
+	   NDJSONWriter does not */
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(encoded)
+	if(err != nil) {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+/* UnmarshalJSON reads the shape written by MarshalJSON, restoring each value to its Go type */
+func (m *Metric) UnmarshalJSON(data []byte) error {
+	var decoded metricJSON
+	err := json.Unmarshal(data, &decoded)
+	if(err != nil) {
+		return err
+	}
+	kind := reflect.Invalid
+	for type_kind, type_name := range typeNames {
+		if(type_name == decoded.Type) {
+			kind = type_kind
+		}
+	}
+	if(kind == reflect.Invalid) {
+		return errors.New(fmt.Sprintf("metric \"%v\" has unknown type \"%v\"", decoded.Name, decoded.Type))
+	}
+
+	metric := Metric{
+		Name:decoded.Name,
+		PmID:decoded.PmID,
+		InDom:pmapi.PmInDomNull,
+		Type:kind,
+		PmType:pmTypes[kind],
+		Semantics:decoded.Semantics,
+		Units:Units(decoded.Units),
+		Help:decoded.Help,
+		Labels:decoded.Labels,
+		Values:make([]MetricValue, len(decoded.Values)),
+	}
+	if(decoded.InDom != nil) {
+		metric.InDom = *decoded.InDom
+	}
+	if(decoded.Timestamp != nil) {
+		metric.Timestamp = *decoded.Timestamp
+	}
+	for i, decoded_value := range decoded.Values {
+		value, err := unmarshalValue(kind, decoded_value.Value)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("metric \"%v\": %v", decoded.Name, err))
+		}
+		metric.Values[i] = MetricValue{Instance:decoded_value.Instance, InstanceID:pmapi.PmInNull, Value:value, Labels:decoded_value.Labels}
+		if(decoded_value.InstanceID != nil) {
+			metric.Values[i].InstanceID = *decoded_value.InstanceID
+		}
+	}
+	*m = metric
+	return nil
+}
+
+func marshalValue(value interface{}) (json.RawMessage, error) {
+	switch typed := value.(type) {
+	case int64:
+		return json.Marshal(strconv.FormatInt(typed, 10))
+	case uint64:
+		return json.Marshal(strconv.FormatUint(typed, 10))
+	case float32:
+		return marshalFloat(float64(typed), 32)
+	case float64:
+		return marshalFloat(typed, 64)
+	case int32, uint32, string:
+		return json.Marshal(typed)
+	}
+	return nil, errors.New(fmt.Sprintf("cannot write value of type %T", value))
+}
+
+func marshalFloat(value float64, bits int) (json.RawMessage, error) {
+	switch {
+	case math.IsNaN(value):
+		return json.RawMessage(`"NaN"`), nil
+	case math.IsInf(value, 1):
+		return json.RawMessage(`"Infinity"`), nil
+	case math.IsInf(value, -1):
+		return json.RawMessage(`"-Infinity"`), nil
+	}
+	/* Shortest form that reads back to the same float32, so 0.1 is not written as 0.10000000149011612 */
+	return json.RawMessage(strconv.FormatFloat(value, 'g', -1, bits)), nil
+}
+
+func unmarshalValue(kind reflect.Kind, raw json.RawMessage) (interface{}, error) {
+	var err error
+	switch kind {
+	case reflect.Int32:
+		var value int32
+		err = json.Unmarshal(raw, &value)
+		return value, err
+	case reflect.Uint32:
+		var value uint32
+		err = json.Unmarshal(raw, &value)
+		return value, err
+	case reflect.Int64:
+		var text string
+		if err = json.Unmarshal(raw, &text); err == nil {
+			return strconv.ParseInt(text, 10, 64)
+		}
+	case reflect.Uint64:
+		var text string
+		if err = json.Unmarshal(raw, &text); err == nil {
+			return strconv.ParseUint(text, 10, 64)
+		}
+	case reflect.Float32:
+		value, err := unmarshalFloat(raw, 32)
+		return float32(value), err
+	case reflect.Float64:
+		return unmarshalFloat(raw, 64)
+	case reflect.String:
+		var value string
+		err = json.Unmarshal(raw, &value)
+		return value, err
+	}
+	return nil, err
+}
+
+func unmarshalFloat(raw json.RawMessage, bits int) (float64, error) {
+	var text string
+	if(json.Unmarshal(raw, &text) == nil) {
+		switch text {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		return 0, errors.New(fmt.Sprintf("cannot read \"%v\" as a number", text))
+	}
+	return strconv.ParseFloat(string(raw), bits)
+}
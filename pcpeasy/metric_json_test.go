@@ -0,0 +1,165 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+)
+
+func diskReadBytesMetric() Metric {
+	return Metric{
+		Name:"disk.dev.read_bytes",
+		PmID:pmapi.PmID(251658292),
+		InDom:pmapi.PmInDom(251658241),
+		Type:reflect.Uint64,
+		PmType:pmapi.PmTypeU64,
+		Semantics:"counter",
+		Units:Units{DimSpace:1, ScaleSpace:SpaceKByte},
+		Timestamp:time.Unix(1700000000, 250000000).UTC(),
+		Help:"per-disk read bytes",
+		Labels:map[string]string{"hostname":"web1"},
+		Values:[]MetricValue{
+			{Instance:"sda", InstanceID:0, Value:uint64(18446744073709551615), Labels:map[string]string{"hostname":"web1"}},
+		},
+	}
+}
+
+func TestMetric_MarshalJSON(t *testing.T) {
+	encoded, err := json.Marshal(diskReadBytesMetric())
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name":"disk.dev.read_bytes",
+		"pmid":251658292,
+		"indom":251658241,
+		"type":"U64",
+		"semantics":"counter",
+		"units":{"dimSpace":1,"dimTime":0,"dimCount":0,"scaleSpace":1,"scaleTime":0,"scaleCount":0},
+		"timestamp":"2023-11-14T22:13:20.25Z",
+		"help":"per-disk read bytes",
+		"labels":{"hostname":"web1"},
+		"values":[{"instance":"sda","instanceId":0,"value":"18446744073709551615","labels":{"hostname":"web1"}}]
+	}`, string(encoded))
+}
+
+func TestMetric_MarshalJSON_leavesOutInstancesAndUnsetFields(t *testing.T) {
+	metric := Metric{Name:"kernel.all.load", InDom:pmapi.PmInDomNull, Type:reflect.Float32, Semantics:"instant",
+		Values:[]MetricValue{{InstanceID:pmapi.PmInNull, Value:float32(0.1)}}}
+
+	encoded, err := json.Marshal(metric)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name":"kernel.all.load",
+		"pmid":0,
+		"indom":null,
+		"type":"FLOAT",
+		"semantics":"instant",
+		"units":{"dimSpace":0,"dimTime":0,"dimCount":0,"scaleSpace":0,"scaleTime":0,"scaleCount":0},
+		"values":[{"value":0.1}]
+	}`, string(encoded))
+}
+
+func TestMetric_MarshalJSON_returnsAnErrorForUnknownTypes(t *testing.T) {
+	_, err := Metric{Name:"broken", Type:reflect.Invalid}.MarshalJSON()
+
+	assert.EqualError(t, err, "metric \"broken\" has no JSON representation for type invalid")
+}
+
+func TestMetric_UnmarshalJSON_roundTripsEveryType(t *testing.T) {
+	values := []interface{}{int32(-1), uint32(4294967295), int64(-9223372036854775808), uint64(18446744073709551615), float32(0.1), 1e300, "a \"string\" <b>"}
+	for _, value := range values {
+		metric := diskReadBytesMetric()
+		metric.Type = reflect.TypeOf(value).Kind()
+		metric.PmType = pmTypes[metric.Type]
+		metric.Values[0].Value = value
+
+		encoded, err := json.Marshal(metric)
+		assert.NoError(t, err)
+		var decoded Metric
+		err = json.Unmarshal(encoded, &decoded)
+
+		assert.NoError(t, err)
+		assert.Equal(t, metric, decoded)
+	}
+}
+
+func TestMetric_UnmarshalJSON_readsNonFiniteFloats(t *testing.T) {
+	metric := Metric{Name:"x", InDom:pmapi.PmInDomNull, Type:reflect.Float64, PmType:pmapi.PmTypeDouble,
+		Values:[]MetricValue{{InstanceID:pmapi.PmInNull, Value:math.Inf(1)}, {InstanceID:pmapi.PmInNull, Value:math.Inf(-1)}, {InstanceID:pmapi.PmInNull, Value:math.NaN()}}}
+
+	encoded, _ := json.Marshal(metric)
+	var decoded Metric
+	err := json.Unmarshal(encoded, &decoded)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `[{"value":"Infinity"},{"value":"-Infinity"},{"value":"NaN"}]`)
+	assert.Equal(t, math.Inf(1), decoded.Values[0].Value)
+	assert.Equal(t, math.Inf(-1), decoded.Values[1].Value)
+	assert.True(t, math.IsNaN(decoded.Values[2].Value.(float64)))
+}
+
+func TestMetric_UnmarshalJSON_returnsErrorsForBadInput(t *testing.T) {
+	var decoded Metric
+
+	assert.EqualError(t, json.Unmarshal([]byte(`{"name":"x","type":"BLOB","values":[]}`), &decoded), "metric \"x\" has unknown type \"BLOB\"")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"name":"x","type":"U64","values":[{"value":"-1"}]}`), &decoded), "metric \"x\": strconv.ParseUint: parsing \"-1\": invalid syntax")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"name":"x","type":"DOUBLE","values":[{"value":"lots"}]}`), &decoded), "metric \"x\": cannot read \"lots\" as a number")
+}
+
+func TestNDJSON_writesOneMetricPerLineAndReadsThemBack(t *testing.T) {
+	var buffer bytes.Buffer
+	first := diskReadBytesMetric()
+	second := diskReadBytesMetric()
+	second.Name = "disk.dev.write_bytes"
+
+	err := NewNDJSONWriter(&buffer).Write([]Metric{first, second})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(buffer.Bytes(), []byte("\n")))
+
+	metrics, err := NewNDJSONReader(&buffer).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Metric{first, second}, metrics)
+}
+
+func TestNDJSON_writesAmpersandsAsTheyAre(t *testing.T) {
+	var buffer bytes.Buffer
+	metric := diskReadBytesMetric()
+	metric.Values[0].Instance = "sda & <sdb>"
+
+	assert.NoError(t, NewNDJSONWriter(&buffer).Write([]Metric{metric}))
+	assert.Contains(t, buffer.String(), `"instance":"sda & <sdb>"`)
+
+	marshalled, err := json.Marshal(metric)
+	assert.NoError(t, err)
+	assert.Contains(t, string(marshalled), `"instance":"sda \u0026 \u003csdb\u003e"`)
+
+	metrics, err := NewNDJSONReader(&buffer).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Metric{metric}, metrics)
+}
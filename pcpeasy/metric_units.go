@@ -24,19 +24,51 @@ import (
 	"github.com/ryandoyle/pcpeasygo/pmapi"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"math"
+	"errors"
 )
 
 type metricInfo struct {
 	semantics string
-	units metricUnits
+	units Units
 	_type reflect.Kind
 }
 
-type metricUnits struct {
-	domain string
-	_range string
+/* Units mirrors pmUnits: a signed dimension for each of space, time and count,
+   plus the scale the dimension is measured in. A zero dimension means the
+   corresponding scale is ignored. */
+type Units struct {
+	DimSpace int
+	DimTime int
+	DimCount int
+	ScaleSpace SpaceScale
+	ScaleTime TimeScale
+	ScaleCount CountScale
 }
 
+type SpaceScale uint
+type TimeScale uint
+type CountScale int
+
+const (
+	SpaceByte = SpaceScale(pmapi.PmSpaceByte)
+	SpaceKByte = SpaceScale(pmapi.PmSpaceKByte)
+	SpaceMByte = SpaceScale(pmapi.PmSpaceMByte)
+	SpaceGByte = SpaceScale(pmapi.PmSpaceGByte)
+	SpaceTByte = SpaceScale(pmapi.PmSpaceTByte)
+	SpacePByte = SpaceScale(pmapi.PmSpacePByte)
+	SpaceEByte = SpaceScale(pmapi.PmSpaceEByte)
+
+	TimeNSec = TimeScale(pmapi.PmTimeNSec)
+	TimeUSec = TimeScale(pmapi.PmTimeUSec)
+	TimeMSec = TimeScale(pmapi.PmTimeMSec)
+	TimeSec = TimeScale(pmapi.PmTimeSec)
+	TimeMin = TimeScale(pmapi.PmTimeMin)
+	TimeHour = TimeScale(pmapi.PmTimeHour)
+)
+
 type pmDescAdapter interface {
 	toMetricInfo(pm_desc pmapi.PmDesc) metricInfo
 }
@@ -46,7 +78,7 @@ type pmDescAdapterImpl struct {}
 func (a pmDescAdapterImpl) toMetricInfo(pm_desc pmapi.PmDesc) metricInfo {
 	return metricInfo{
 		semantics:semanticsString(pm_desc.Sem),
-		units:createMetricUnits(pm_desc.Units),
+		units:NewUnits(pm_desc.Units),
 		_type:createType(pm_desc.Type),
 	}
 }
@@ -70,70 +102,330 @@ func createType(_type int) reflect.Kind {
 	return reflect.Invalid
 }
 
-func createMetricUnits(units pmapi.PmUnits) metricUnits {
-	if(units.DimSpace == 1 && units.DimTime == 0 && units.DimCount == 0) {
-		return metricUnits{domain:spaceUnits(units)}
-	} else if(units.DimSpace == 1 && units.DimTime == -1 && units.DimCount == 0) {
-		return metricUnits{domain:spaceUnits(units), _range:timeUnits(units)}
-	} else if(units.DimSpace == 1 && units.DimTime == 0 && units.DimCount == -1) {
-		return metricUnits{domain:spaceUnits(units), _range:countUnits(units)}
-	} else if(units.DimSpace == 0 && units.DimTime == 1 && units.DimCount == 0) {
-		return metricUnits{domain:timeUnits(units)}
-	} else if(units.DimSpace == -1 && units.DimTime == 1 && units.DimCount == 0) {
-		return metricUnits{domain:timeUnits(units), _range:spaceUnits(units)}
-	} else if(units.DimSpace == 0 && units.DimTime == 1 && units.DimCount == -1) {
-		return metricUnits{domain:timeUnits(units), _range:countUnits(units)}
-	} else if(units.DimSpace == 0 && units.DimTime == 0 && units.DimCount == 1) {
-		return metricUnits{domain:countUnits(units)}
-	} else if(units.DimSpace == -1 && units.DimTime == 0 && units.DimCount == 1) {
-		return metricUnits{domain:countUnits(units), _range:spaceUnits(units)}
-	} else if(units.DimSpace == 0 && units.DimTime == -1 && units.DimCount == 1) {
-		return metricUnits{domain:countUnits(units), _range:timeUnits(units)}
-	}
-	return metricUnits{}
-}
-
-func countUnits(units pmapi.PmUnits) string {
-	return fmt.Sprintf("count%v", units.ScaleCount)
-}
-
-func timeUnits(units pmapi.PmUnits) string {
-	switch units.ScaleTime {
-	case pmapi.PmTimeNSec:
-		return "nanoseconds"
-	case pmapi.PmTimeUSec:
-		return "microseconds"
-	case pmapi.PmTimeMSec:
-		return "milliseconds"
-	case pmapi.PmTimeSec:
-		return "seconds"
-	case pmapi.PmTimeMin:
-		return "minutes"
-	case pmapi.PmTimeHour:
-		return "hours"
-	}
-	return "unknown time unit"
-}
-
-
-func spaceUnits(units pmapi.PmUnits) string {
-	switch units.ScaleSpace {
-	case pmapi.PmSpaceByte:
-		return "bytes"
-	case pmapi.PmSpaceKByte:
-		return "kilobytes"
-	case pmapi.PmSpaceMByte:
-		return "megabytes"
-	case pmapi.PmSpaceGByte:
-		return "gigabytes"
-	case pmapi.PmSpaceTByte:
-		return "terabytes"
-	case pmapi.PmSpacePByte:
-		return "petabytes"
-	case pmapi.PmSpaceEByte:
-		return "exabytes"
-	}
-	return "unknown bytes"
+func NewUnits(units pmapi.PmUnits) Units {
+	return Units{
+		DimSpace:units.DimSpace,
+		DimTime:units.DimTime,
+		DimCount:units.DimCount,
+		ScaleSpace:SpaceScale(units.ScaleSpace),
+		ScaleTime:TimeScale(units.ScaleTime),
+		ScaleCount:CountScale(units.ScaleCount),
+	}
+}
+
+func (u Units) PmUnits() pmapi.PmUnits {
+	return pmapi.PmUnits{
+		DimSpace:u.DimSpace,
+		DimTime:u.DimTime,
+		DimCount:u.DimCount,
+		ScaleSpace:uint(u.ScaleSpace),
+		ScaleTime:uint(u.ScaleTime),
+		ScaleCount:int(u.ScaleCount),
+	}
+}
+
+func (u Units) IsDimensionless() bool {
+	return u.DimSpace == 0 && u.DimTime == 0 && u.DimCount == 0
+}
+
+/* BaseUnits returns the same dimensions measured in bytes, seconds and single counts */
+func (u Units) BaseUnits() Units {
+	return Units{DimSpace:u.DimSpace, DimTime:u.DimTime, DimCount:u.DimCount, ScaleSpace:SpaceByte, ScaleTime:TimeSec}
+}
+
+/* ConvertValue rescales a value measured in u to the units to, in the manner of pmConvScale(3).
+   Both must have the same dimensions */
+func (u Units) ConvertValue(value float64, to Units) (float64, error) {
+	if(u.DimSpace != to.DimSpace || u.DimTime != to.DimTime || u.DimCount != to.DimCount) {
+		return 0, errors.New(fmt.Sprintf("cannot convert \"%v\" to \"%v\"", u, to))
+	}
+	from_factor, err := u.baseFactor()
+	if(err != nil) {
+		return 0, err
+	}
+	to_factor, err := to.baseFactor()
+	if(err != nil) {
+		return 0, err
+	}
+	return value * from_factor / to_factor, nil
+}
+
+/* baseFactor is what a value in these units is multiplied by to express it in BaseUnits */
+func (u Units) baseFactor() (float64, error) {
+	factor := 1.0
+	if(u.DimSpace != 0) {
+		if(u.ScaleSpace > SpaceEByte) {
+			return 0, errors.New(fmt.Sprintf("cannot scale unknown space unit \"%v\"", u.ScaleSpace))
+		}
+		factor *= math.Pow(math.Pow(1024, float64(u.ScaleSpace)), float64(u.DimSpace))
+	}
+	if(u.DimTime != 0) {
+		seconds, found := secondsPerTimeScale[u.ScaleTime]
+		if(!found) {
+			return 0, errors.New(fmt.Sprintf("cannot scale unknown time unit \"%v\"", u.ScaleTime))
+		}
+		factor *= math.Pow(seconds, float64(u.DimTime))
+	}
+	if(u.DimCount != 0) {
+		factor *= math.Pow(10, float64(int(u.ScaleCount) * u.DimCount))
+	}
+	return factor, nil
+}
+
+var secondsPerTimeScale = map[TimeScale]float64{
+	TimeNSec:1e-9,
+	TimeUSec:1e-6,
+	TimeMSec:1e-3,
+	TimeSec:1,
+	TimeMin:60,
+	TimeHour:3600,
+}
+
+/* String renders the units the same way pmUnitsStr(3) does, e.g. "Kbyte / sec",
+   "count x 10^3" or "/ sec", except that a scaled count with a power is bracketed, as in
+   "(count x 10^3)^2", so it cannot be read as another scale. Dimensionless units render as an
+   empty string. */
+func (u Units) String() string {
+	numerator := u.dimensionStrings(func(dim int) int { return dim })
+	denominator := u.dimensionStrings(func(dim int) int { return -dim })
+
+	if(len(denominator) == 0) {
+		return strings.Join(numerator, " ")
+	}
+	if(len(numerator) == 0) {
+		return "/ " + strings.Join(denominator, " ")
+	}
+	return strings.Join(numerator, " ") + " / " + strings.Join(denominator, " ")
+}
+
+/* ParseUnits reads units written the way String writes them, such as "Kbyte / sec",
+   "count x 10^3", "(count x 10)^2" or "/ sec". An empty string or "none" is dimensionless */
+func ParseUnits(units_string string) (Units, error) {
+	units := Units{}
+	trimmed := strings.TrimSpace(units_string)
+	if(trimmed == "" || trimmed == "none") {
+		return units, nil
+	}
+	numerator, denominator := trimmed, ""
+	if slash := strings.Index(trimmed, "/"); slash >= 0 {
+		numerator, denominator = trimmed[:slash], trimmed[slash+1:]
+	}
+	err := units.parseTerms(numerator, 1)
+	if(err == nil) {
+		err = units.parseTerms(denominator, -1)
+	}
+	if(err != nil) {
+		return Units{}, errors.New(fmt.Sprintf("invalid units \"%v\": %v", units_string, err))
+	}
+	return units, nil
+}
+
+/* parseTerms sets a dimension for each space, time or count term, negated by sign for terms
+   below the line */
+func (u *Units) parseTerms(terms string, sign int) error {
+	tokens := strings.Fields(terms)
+	for i := 0; i < len(tokens); i++ {
+		unit, power := tokens[i], 1
+		if caret := strings.Index(unit, "^"); caret >= 0 {
+			parsed, err := strconv.Atoi(unit[caret+1:])
+			if(err != nil || parsed < 1) {
+				return errors.New(fmt.Sprintf("bad power in \"%v\"", tokens[i]))
+			}
+			unit, power = unit[:caret], parsed
+		}
+		dim := sign * power
+
+		if(unit == "count" || tokens[i] == "(count") {
+			if(u.DimCount != 0) {
+				return errors.New("count given twice")
+			}
+			u.DimCount = dim
+			if(i + 2 < len(tokens) && tokens[i + 1] == "x") {
+				scale_token := tokens[i + 2]
+				if(tokens[i] == "(count") {
+					var err error
+					scale_token, power, err = parseBracketedPower(scale_token)
+					if(err != nil) {
+						return err
+					}
+					u.DimCount = sign * power
+				}
+				scale, err := parseCountScale(scale_token)
+				if(err != nil) {
+					return err
+				}
+				u.ScaleCount = scale
+				i += 2
+			} else if(tokens[i] == "(count") {
+				return errors.New("unclosed bracket")
+			}
+			continue
+		}
+		if space, found := spaceScaleNamed(unit); found {
+			if(u.DimSpace != 0) {
+				return errors.New("space given twice")
+			}
+			u.DimSpace, u.ScaleSpace = dim, space
+			continue
+		}
+		if time, found := timeScaleNamed(unit); found {
+			if(u.DimTime != 0) {
+				return errors.New("time given twice")
+			}
+			u.DimTime, u.ScaleTime = dim, time
+			continue
+		}
+		return errors.New(fmt.Sprintf("unknown unit \"%v\"", unit))
+	}
+	return nil
+}
+
+/* parseBracketedPower splits the "10^N)^P" ending "(count x 10^N)^P" into "10^N" and P */
+func parseBracketedPower(token string) (string, int, error) {
+	close := strings.Index(token, ")")
+	if(close < 0) {
+		return "", 0, errors.New("unclosed bracket")
+	}
+	power := 1
+	if(close + 1 < len(token)) {
+		var err error
+		power, err = strconv.Atoi(strings.TrimPrefix(token[close + 1:], "^"))
+		if(err != nil || power < 1 || !strings.HasPrefix(token[close + 1:], "^")) {
+			return "", 0, errors.New(fmt.Sprintf("bad power in \"%v\"", token))
+		}
+	}
+	return token[:close], power, nil
+}
+
+/* parseCountScale reads the "10" or "10^N" of "count x 10^N" */
+func parseCountScale(token string) (CountScale, error) {
+	if(token == "10") {
+		return 1, nil
+	}
+	if(strings.HasPrefix(token, "10^")) {
+		scale, err := strconv.Atoi(strings.TrimPrefix(token, "10^"))
+		if(err == nil) {
+			return CountScale(scale), nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("bad count scale \"%v\"", token))
+}
+
+func spaceScaleNamed(name string) (SpaceScale, bool) {
+	for scale := SpaceByte; scale <= SpaceEByte; scale++ {
+		if(scale.String() == name) {
+			return scale, true
+		}
+	}
+	return 0, false
+}
+
+func timeScaleNamed(name string) (TimeScale, bool) {
+	for scale := range secondsPerTimeScale {
+		if(scale.String() == name) {
+			return scale, true
+		}
+	}
+	return 0, false
+}
+
+/* ConvertUnits returns a copy of the metric with its numeric values converted to float64s in to,
+   which must have the same dimensions as the metric's units. String values are left alone */
+func (m Metric) ConvertUnits(to Units) (Metric, error) {
+	factor, err := m.Units.ConvertValue(1, to)
+	if(err != nil) {
+		return Metric{}, err
+	}
+	values := make([]MetricValue, len(m.Values))
+	for i, value := range m.Values {
+		values[i] = value
+		if number, ok := value.Float(); ok {
+			values[i].Value = number * factor
+		}
+	}
+	m.Values = values
+	m.Units = to
+	if(m.Type != reflect.String) {
+		m.Type = reflect.Float64
+		m.PmType = pmapi.PmTypeDouble
+	}
+	return m, nil
+}
+
+/* dimensionStrings collects the space, time and count terms (in that order)
+   whose dimension, after applying sign, is positive */
+func (u Units) dimensionStrings(sign func(int) int) []string {
+	terms := []string{}
+	if dim := sign(u.DimSpace); dim > 0 {
+		terms = append(terms, withPower(u.ScaleSpace.String(), dim))
+	}
+	if dim := sign(u.DimTime); dim > 0 {
+		terms = append(terms, withPower(u.ScaleTime.String(), dim))
+	}
+	if dim := sign(u.DimCount); dim > 0 {
+		count := u.ScaleCount.String()
+		if(dim != 1 && u.ScaleCount != 0) {
+			count = "(" + count + ")"
+		}
+		terms = append(terms, withPower(count, dim))
+	}
+	return terms
+}
+
+func withPower(unit string, dim int) string {
+	if(dim == 1) {
+		return unit
+	}
+	return fmt.Sprintf("%v^%v", unit, dim)
+}
+
+func (s SpaceScale) String() string {
+	switch s {
+	case SpaceByte:
+		return "byte"
+	case SpaceKByte:
+		return "Kbyte"
+	case SpaceMByte:
+		return "Mbyte"
+	case SpaceGByte:
+		return "Gbyte"
+	case SpaceTByte:
+		return "Tbyte"
+	case SpacePByte:
+		return "Pbyte"
+	case SpaceEByte:
+		return "Ebyte"
+	}
+	return fmt.Sprintf("space-%d", uint(s))
+}
+
+func (t TimeScale) String() string {
+	switch t {
+	case TimeNSec:
+		return "nanosec"
+	case TimeUSec:
+		return "microsec"
+	case TimeMSec:
+		return "millisec"
+	case TimeSec:
+		return "sec"
+	case TimeMin:
+		return "min"
+	case TimeHour:
+		return "hour"
+	}
+	return fmt.Sprintf("time-%d", uint(t))
+}
+
+/* CountScale is a power of ten, so a scale of 3 is "count x 10^3" */
+func (c CountScale) String() string {
+	switch c {
+	case 0:
+		return "count"
+	case 1:
+		return "count x 10"
+	}
+	return fmt.Sprintf("count x 10^%d", int(c))
 }
 
 func semanticsString(i int) string {
@@ -146,4 +438,4 @@ func semanticsString(i int) string {
 		return "instant"
 	}
 	return "unknown"
-}
\ No newline at end of file
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/ryandoyle/pcpeasygo/pmapi"
 	"reflect"
+	"strings"
 )
 
 var metricUnitsTests = []struct{
@@ -37,22 +38,10 @@ var metricUnitsTests = []struct{
 	{"instant semantics", pmapi.PmDesc{Sem:pmapi.PmSemInstant}, metricInfo{semantics:"instant", _type:reflect.Int32}},
 	{"unknown semantics", pmapi.PmDesc{Sem:-123}, metricInfo{semantics:"unknown", _type:reflect.Int32}},
 
-	{"bytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceByte}}, metricInfo{units:metricUnits{domain:"bytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"kilobytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceKByte}}, metricInfo{units:metricUnits{domain:"kilobytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"megabytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceMByte}}, metricInfo{units:metricUnits{domain:"megabytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"gigabytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceGByte}}, metricInfo{units:metricUnits{domain:"gigabytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"terabytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceTByte}}, metricInfo{units:metricUnits{domain:"terabytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"petabytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpacePByte}}, metricInfo{units:metricUnits{domain:"petabytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"exabytes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceEByte}}, metricInfo{units:metricUnits{domain:"exabytes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"count0 domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:0}}, metricInfo{units:metricUnits{domain:"count0"}, semantics:"unknown", _type:reflect.Int32}},
-	{"count1 domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:1}}, metricInfo{units:metricUnits{domain:"count1"}, semantics:"unknown", _type:reflect.Int32}},
-	{"count2 domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:2}}, metricInfo{units:metricUnits{domain:"count2"}, semantics:"unknown", _type:reflect.Int32}},
-	{"nanoseconds domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeNSec}}, metricInfo{units:metricUnits{domain:"nanoseconds"}, semantics:"unknown", _type:reflect.Int32}},
-	{"microseconds domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeUSec}}, metricInfo{units:metricUnits{domain:"microseconds"}, semantics:"unknown", _type:reflect.Int32}},
-	{"milliseconds domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeMSec}}, metricInfo{units:metricUnits{domain:"milliseconds"}, semantics:"unknown", _type:reflect.Int32}},
-	{"seconds domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeSec}}, metricInfo{units:metricUnits{domain:"seconds"}, semantics:"unknown", _type:reflect.Int32}},
-	{"minutes domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeMin}}, metricInfo{units:metricUnits{domain:"minutes"}, semantics:"unknown", _type:reflect.Int32}},
-	{"hours domain", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeHour}}, metricInfo{units:metricUnits{domain:"hours"}, semantics:"unknown", _type:reflect.Int32}},
+	{"space units", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceKByte}}, metricInfo{units:Units{DimSpace:1, ScaleSpace:SpaceKByte}, semantics:"unknown", _type:reflect.Int32}},
+	{"time units", pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeMSec}}, metricInfo{units:Units{DimTime:1, ScaleTime:TimeMSec}, semantics:"unknown", _type:reflect.Int32}},
+	{"count units", pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:3}}, metricInfo{units:Units{DimCount:1, ScaleCount:3}, semantics:"unknown", _type:reflect.Int32}},
+	{"squared space per time", pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:2,ScaleSpace:pmapi.PmSpaceByte, DimTime:-1, ScaleTime:pmapi.PmTimeSec}}, metricInfo{units:Units{DimSpace:2, DimTime:-1, ScaleSpace:SpaceByte, ScaleTime:TimeSec}, semantics:"unknown", _type:reflect.Int32}},
 
 	/* Types */
 	{"int32 type", pmapi.PmDesc{Type:pmapi.PmType32}, metricInfo{_type:reflect.Int32, semantics:"unknown"}},
@@ -64,40 +53,6 @@ var metricUnitsTests = []struct{
 	{"string type", pmapi.PmDesc{Type:pmapi.PmTypeString}, metricInfo{_type:reflect.String, semantics:"unknown"}},
 	{"unknown type", pmapi.PmDesc{Type:pmapi.PmTypeEvent}, metricInfo{_type:reflect.Invalid, semantics:"unknown"}},
 
-	/* Metrics with a range dimension */
-	{
-		"bytes/seconds",
-	 	pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceByte, DimTime:-1, ScaleTime:pmapi.PmTimeSec}},
-		metricInfo{units:metricUnits{domain:"bytes", _range:"seconds"}, semantics:"unknown", _type:reflect.Int32},
-	},
-	{
-		"bytes/count3",
-		pmapi.PmDesc{Units:pmapi.PmUnits{DimSpace:1,ScaleSpace:pmapi.PmSpaceByte, DimCount:-1, ScaleCount:3}},
-		metricInfo{units:metricUnits{domain:"bytes", _range:"count3"}, semantics:"unknown", _type:reflect.Int32},
-	},
-
-	{
-		"seconds/bytes",
-		pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeSec, DimSpace:-1, ScaleSpace:pmapi.PmSpaceByte}},
-		metricInfo{units:metricUnits{domain:"seconds", _range:"bytes"}, semantics:"unknown", _type:reflect.Int32},
-	},
-	{
-		"seconds/count3",
-		pmapi.PmDesc{Units:pmapi.PmUnits{DimTime:1,ScaleTime:pmapi.PmTimeSec, DimCount:-1, ScaleCount:3}},
-		metricInfo{units:metricUnits{domain:"seconds", _range:"count3"}, semantics:"unknown", _type:reflect.Int32},
-	},
-
-	{
-		"count3/bytes",
-		pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:3, DimSpace:-1, ScaleSpace:pmapi.PmSpaceByte}},
-		metricInfo{units:metricUnits{domain:"count3", _range:"bytes"}, semantics:"unknown", _type:reflect.Int32},
-	},
-	{
-		"count3/seconds",
-		pmapi.PmDesc{Units:pmapi.PmUnits{DimCount:1,ScaleCount:3, DimTime:-1, ScaleTime:pmapi.PmTimeSec}},
-		metricInfo{units:metricUnits{domain:"count3", _range:"seconds"}, semantics:"unknown", _type:reflect.Int32},
-	},
-
 }
 
 func TestToMetricUnits(t *testing.T) {
@@ -105,4 +60,177 @@ func TestToMetricUnits(t *testing.T) {
 	for test_number, test := range metricUnitsTests {
 		assert.Equal(t, adapter.toMetricInfo(test.in), test.out, "test number: %v, description: \"%v\" ", test_number, test.desc)
 	}
-}
\ No newline at end of file
+}
+
+var unitsStringTests = []struct{
+	desc string
+	in Units
+	out string
+}{
+	{"dimensionless", Units{}, ""},
+	{"bytes", Units{DimSpace:1, ScaleSpace:SpaceByte}, "byte"},
+	{"kilobytes", Units{DimSpace:1, ScaleSpace:SpaceKByte}, "Kbyte"},
+	{"megabytes", Units{DimSpace:1, ScaleSpace:SpaceMByte}, "Mbyte"},
+	{"gigabytes", Units{DimSpace:1, ScaleSpace:SpaceGByte}, "Gbyte"},
+	{"terabytes", Units{DimSpace:1, ScaleSpace:SpaceTByte}, "Tbyte"},
+	{"petabytes", Units{DimSpace:1, ScaleSpace:SpacePByte}, "Pbyte"},
+	{"exabytes", Units{DimSpace:1, ScaleSpace:SpaceEByte}, "Ebyte"},
+	{"unknown space scale", Units{DimSpace:1, ScaleSpace:SpaceScale(12)}, "space-12"},
+	{"nanoseconds", Units{DimTime:1, ScaleTime:TimeNSec}, "nanosec"},
+	{"microseconds", Units{DimTime:1, ScaleTime:TimeUSec}, "microsec"},
+	{"milliseconds", Units{DimTime:1, ScaleTime:TimeMSec}, "millisec"},
+	{"seconds", Units{DimTime:1, ScaleTime:TimeSec}, "sec"},
+	{"minutes", Units{DimTime:1, ScaleTime:TimeMin}, "min"},
+	{"hours", Units{DimTime:1, ScaleTime:TimeHour}, "hour"},
+	{"unknown time scale", Units{DimTime:1, ScaleTime:TimeScale(9)}, "time-9"},
+	{"count", Units{DimCount:1}, "count"},
+	{"count x 10", Units{DimCount:1, ScaleCount:1}, "count x 10"},
+	{"count x 10^6", Units{DimCount:1, ScaleCount:6}, "count x 10^6"},
+	{"count x 10^-3", Units{DimCount:1, ScaleCount:-3}, "count x 10^-3"},
+	{"ignores scale of zero dimension", Units{DimTime:1, ScaleTime:TimeSec, ScaleSpace:SpaceMByte}, "sec"},
+
+	{"kilobytes per second", Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceKByte, ScaleTime:TimeSec}, "Kbyte / sec"},
+	{"count per second", Units{DimCount:1, DimTime:-1, ScaleTime:TimeSec}, "count / sec"},
+	{"bytes per count", Units{DimSpace:1, DimCount:-1, ScaleSpace:SpaceByte, ScaleCount:3}, "byte / count x 10^3"},
+	{"milliseconds per count", Units{DimTime:1, DimCount:-1, ScaleTime:TimeMSec}, "millisec / count"},
+	{"per second", Units{DimTime:-1, ScaleTime:TimeSec}, "/ sec"},
+	{"squared space per time", Units{DimSpace:2, DimTime:-1, ScaleSpace:SpaceByte, ScaleTime:TimeSec}, "byte^2 / sec"},
+	{"count per squared time", Units{DimCount:1, DimTime:-2, ScaleTime:TimeSec}, "count / sec^2"},
+	{"space and time per count", Units{DimSpace:1, DimTime:1, DimCount:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeMin}, "Mbyte min / count"},
+	{"squared count", Units{DimCount:2}, "count^2"},
+	{"squared count x 10", Units{DimCount:2, ScaleCount:1}, "(count x 10)^2"},
+	{"squared count x 10^3", Units{DimCount:2, ScaleCount:3}, "(count x 10^3)^2"},
+	{"space per squared count x 10^-2", Units{DimSpace:1, DimCount:-2, ScaleSpace:SpaceByte, ScaleCount:-2}, "byte / (count x 10^-2)^2"},
+}
+
+func TestUnits_String(t *testing.T) {
+	for test_number, test := range unitsStringTests {
+		assert.Equal(t, test.out, test.in.String(), "test number: %v, description: \"%v\" ", test_number, test.desc)
+	}
+}
+
+func TestUnits_roundTripsToPmUnits(t *testing.T) {
+	pm_units := pmapi.PmUnits{DimSpace:2, DimTime:-1, DimCount:1, ScaleSpace:pmapi.PmSpaceGByte, ScaleTime:pmapi.PmTimeUSec, ScaleCount:-2}
+
+	assert.Equal(t, pm_units, NewUnits(pm_units).PmUnits())
+}
+
+func TestUnits_IsDimensionless(t *testing.T) {
+	assert.True(t, Units{ScaleSpace:SpaceKByte}.IsDimensionless())
+	assert.False(t, Units{DimCount:1}.IsDimensionless())
+}
+
+var convertValueTests = []struct{
+	desc string
+	from Units
+	to Units
+	in float64
+	out float64
+}{
+	{"kilobytes to bytes", Units{DimSpace:1, ScaleSpace:SpaceKByte}, Units{DimSpace:1, ScaleSpace:SpaceByte}, 2, 2048},
+	{"bytes to megabytes", Units{DimSpace:1, ScaleSpace:SpaceByte}, Units{DimSpace:1, ScaleSpace:SpaceMByte}, 3 * 1024 * 1024, 3},
+	{"milliseconds to seconds", Units{DimTime:1, ScaleTime:TimeMSec}, Units{DimTime:1, ScaleTime:TimeSec}, 1500, 1.5},
+	{"hours to minutes", Units{DimTime:1, ScaleTime:TimeHour}, Units{DimTime:1, ScaleTime:TimeMin}, 2, 120},
+	{"count x 10^3 to count", Units{DimCount:1, ScaleCount:3}, Units{DimCount:1}, 4, 4000},
+	{"Kbyte / sec to byte / min", Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceKByte, ScaleTime:TimeSec}, Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceByte, ScaleTime:TimeMin}, 1, 1024 * 60},
+	{"dimensionless", Units{}, Units{}, 7, 7},
+}
+
+func TestUnits_ConvertValue(t *testing.T) {
+	for test_number, test := range convertValueTests {
+		converted, err := test.from.ConvertValue(test.in, test.to)
+
+		assert.NoError(t, err, "test number: %v, description: \"%v\" ", test_number, test.desc)
+		assert.InDelta(t, test.out, converted, 1e-9, "test number: %v, description: \"%v\" ", test_number, test.desc)
+	}
+}
+
+func TestUnits_ConvertValue_returnsAnErrorForDifferentDimensions(t *testing.T) {
+	_, err := Units{DimSpace:1, ScaleSpace:SpaceKByte}.ConvertValue(1, Units{DimTime:1, ScaleTime:TimeSec})
+
+	assert.EqualError(t, err, "cannot convert \"Kbyte\" to \"sec\"")
+}
+
+func TestUnits_ConvertValue_returnsAnErrorForUnknownScales(t *testing.T) {
+	_, err := Units{DimTime:1, ScaleTime:TimeScale(9)}.ConvertValue(1, Units{DimTime:1, ScaleTime:TimeSec})
+
+	assert.EqualError(t, err, "cannot scale unknown time unit \"time-9\"")
+}
+
+func TestUnits_BaseUnits(t *testing.T) {
+	units := Units{DimSpace:1, DimTime:-1, DimCount:1, ScaleSpace:SpaceGByte, ScaleTime:TimeMin, ScaleCount:2}
+
+	assert.Equal(t, Units{DimSpace:1, DimTime:-1, DimCount:1, ScaleSpace:SpaceByte, ScaleTime:TimeSec}, units.BaseUnits())
+}
+
+func TestParseUnits_readsWhatStringWrites(t *testing.T) {
+	for test_number, test := range unitsStringTests {
+		if(strings.HasPrefix(test.desc, "unknown")) {
+			continue
+		}
+		parsed, err := ParseUnits(test.out)
+
+		assert.NoError(t, err, "test number: %v, description: \"%v\" ", test_number, test.desc)
+		assert.Equal(t, test.out, parsed.String(), "test number: %v, description: \"%v\" ", test_number, test.desc)
+	}
+}
+
+var unitsRoundTripTests = []Units{
+	{DimSpace:1, DimTime:-1, ScaleSpace:SpaceKByte, ScaleTime:TimeSec},
+	{DimCount:1, ScaleCount:2},
+	{DimCount:2, ScaleCount:1},
+	{DimCount:2, ScaleCount:3},
+	{DimCount:3, ScaleCount:-1},
+	{DimCount:-2, ScaleCount:6, DimTime:1, ScaleTime:TimeMSec},
+	{DimCount:-3},
+}
+
+func TestParseUnits_roundTripsString(t *testing.T) {
+	for test_number, units := range unitsRoundTripTests {
+		parsed, err := ParseUnits(units.String())
+
+		assert.NoError(t, err, "test number: %v, units: \"%v\"", test_number, units)
+		assert.Equal(t, units, parsed, "test number: %v, units: \"%v\"", test_number, units)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	parsed, err := ParseUnits("Mbyte / sec")
+	assert.NoError(t, err)
+	assert.Equal(t, Units{DimSpace:1, DimTime:-1, ScaleSpace:SpaceMByte, ScaleTime:TimeSec}, parsed)
+
+	parsed, err = ParseUnits("count x 10^3 / millisec")
+	assert.NoError(t, err)
+	assert.Equal(t, Units{DimCount:1, DimTime:-1, ScaleCount:3, ScaleTime:TimeMSec}, parsed)
+
+	parsed, err = ParseUnits("none")
+	assert.NoError(t, err)
+	assert.Equal(t, Units{}, parsed)
+}
+
+func TestParseUnits_returnsAnErrorForUnknownUnits(t *testing.T) {
+	_, err := ParseUnits("Kbyte / fortnight")
+	assert.EqualError(t, err, "invalid units \"Kbyte / fortnight\": unknown unit \"fortnight\"")
+
+	_, err = ParseUnits("sec sec")
+	assert.EqualError(t, err, "invalid units \"sec sec\": time given twice")
+
+	_, err = ParseUnits("(count x 10^3")
+	assert.EqualError(t, err, "invalid units \"(count x 10^3\": unclosed bracket")
+}
+
+func TestMetric_ConvertUnits(t *testing.T) {
+	metric := Metric{Name:"mem.util.free", Type:reflect.Uint64, PmType:pmapi.PmTypeU64, Units:Units{DimSpace:1, ScaleSpace:SpaceKByte},
+		Values:[]MetricValue{{Value:uint64(2048)}}}
+
+	converted, err := metric.ConvertUnits(Units{DimSpace:1, ScaleSpace:SpaceMByte})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []MetricValue{{Value:2.0}}, converted.Values)
+	assert.Equal(t, reflect.Float64, converted.Type)
+	assert.Equal(t, pmapi.PmTypeDouble, converted.PmType)
+	assert.Equal(t, uint64(2048), metric.Values[0].Value)
+
+	_, err = metric.ConvertUnits(Units{DimTime:1, ScaleTime:TimeSec})
+	assert.EqualError(t, err, "cannot convert \"Kbyte\" to \"sec\"")
+}
@@ -0,0 +1,80 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+/* NDJSONWriter writes metrics one per line in the JSON shape described by Metric.MarshalJSON, so
+   a stream of samples can be appended to a file and read back with NDJSONReader. <, > and & in
+   names, help text and labels are written as they are */
+type NDJSONWriter struct {
+	encoder *json.Encoder
+}
+
+func NewNDJSONWriter(writer io.Writer) *NDJSONWriter {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return &NDJSONWriter{encoder:encoder}
+}
+
+func (w *NDJSONWriter) Write(metrics []Metric) error {
+	for _, metric := range metrics {
+		err := w.encoder.Encode(metric)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+type NDJSONReader struct {
+	decoder *json.Decoder
+}
+
+func NewNDJSONReader(reader io.Reader) *NDJSONReader {
+	return &NDJSONReader{decoder:json.NewDecoder(bufio.NewReader(reader))}
+}
+
+/* Read returns the next metric, or io.EOF after the last */
+func (r *NDJSONReader) Read() (Metric, error) {
+	var metric Metric
+	err := r.decoder.Decode(&metric)
+	return metric, err
+}
+
+/* ReadAll returns every remaining metric */
+func (r *NDJSONReader) ReadAll() ([]Metric, error) {
+	metrics := []Metric{}
+	for {
+		metric, err := r.Read()
+		if(err == io.EOF) {
+			return metrics, nil
+		}
+		if(err != nil) {
+			return metrics, err
+		}
+		metrics = append(metrics, metric)
+	}
+}
@@ -0,0 +1,318 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package openmetrics writes PCP metrics fetched through pcpeasy in the OpenMetrics or Prometheus
+text exposition formats, without depending on a Prometheus client library.
+
+Names are built as in the promcollector package, so kernel.all.load becomes pcp_kernel_all_load.
+Metrics measured in space or time are converted to bytes and seconds and have the unit appended to
+their name, such as pcp_disk_dev_read_bytes_total. String metrics become info metrics carrying the
+string in a value label.
+*/
+package openmetrics
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format int
+
+const (
+	OpenMetrics Format = iota
+	/* The Prometheus text format, version 0.0.4 */
+	PrometheusText
+)
+
+const (
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	PrometheusTextContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+const (
+	InstanceNameLabel = "instname"
+	InstanceIDLabel = "instid"
+	/* Carries the value of string metrics, which are exposed as info metrics */
+	InfoValueLabel = "value"
+)
+
+func (f Format) ContentType() string {
+	if(f == PrometheusText) {
+		return PrometheusTextContentType
+	}
+	return OpenMetricsContentType
+}
+
+type Encoder struct {
+	writer io.Writer
+	format Format
+	namespace string
+	/* Write each sample with the time pmcd took it */
+	Timestamps bool
+}
+
+func NewEncoder(writer io.Writer, format Format, namespace string) *Encoder {
+	return &Encoder{writer:writer, format:format, namespace:namespace}
+}
+
+/* Encode writes the metrics as one complete exposition, ending with # EOF for OpenMetrics */
+func (e *Encoder) Encode(metrics []pcpeasy.Metric) error {
+	for _, metric := range metrics {
+		err := e.encodeMetric(metric)
+		if(err != nil) {
+			return err
+		}
+	}
+	if(e.format == OpenMetrics) {
+		_, err := io.WriteString(e.writer, "# EOF\n")
+		return err
+	}
+	return nil
+}
+
+func (e *Encoder) encodeMetric(metric pcpeasy.Metric) error {
+	f := e.family(metric)
+
+	lines := []string{}
+	if(metric.Help != "") {
+		lines = append(lines, fmt.Sprintf("# HELP %v %v", f.name, e.escapeHelp(metric.Help)))
+	}
+	lines = append(lines, fmt.Sprintf("# TYPE %v %v", f.name, f.metric_type))
+	if(f.unit != "" && e.format == OpenMetrics) {
+		lines = append(lines, fmt.Sprintf("# UNIT %v %v", f.name, f.unit))
+	}
+
+	for _, metric_value := range metric.Values {
+		labels := sampleLabels(metric_value)
+		var value string
+		if(metric.Type == reflect.String) {
+			labels = append(labels, label{InfoValueLabel, fmt.Sprint(metric_value.Value)})
+			value = "1"
+		} else {
+			var ok bool
+			value, ok = formatValue(metric_value, f.scale)
+			if(!ok) {
+				continue
+			}
+		}
+		line := f.sample_name + formatLabels(labels) + " " + value
+		if(e.Timestamps && !metric.Timestamp.IsZero()) {
+			line = line + " " + e.formatTimestamp(metric.Timestamp)
+		}
+		lines = append(lines, line)
+	}
+
+	_, err := io.WriteString(e.writer, strings.Join(lines, "\n") + "\n")
+	return err
+}
+
+/* family is how a PCP metric is named and typed in the exposition */
+type family struct {
+	name string
+	sample_name string
+	metric_type string
+	unit string
+	/* Multiplies values into the unit, 1 when there is no unit */
+	scale float64
+}
+
+func (e *Encoder) family(metric pcpeasy.Metric) family {
+	name := pcpeasy.PrometheusMetricName(metric.Name)
+	if(e.namespace != "") {
+		name = pcpeasy.PrometheusMetricName(e.namespace) + "_" + name
+	}
+
+	if(metric.Type == reflect.String) {
+		name = strings.TrimSuffix(name, "_info")
+		if(e.format == PrometheusText) {
+			/* 0.0.4 has no info type, the convention is a gauge suffixed _info */
+			return family{name:name + "_info", sample_name:name + "_info", metric_type:"gauge", scale:1}
+		}
+		return family{name:name, sample_name:name + "_info", metric_type:"info", scale:1}
+	}
+
+	unit, scale := baseUnit(metric.Units)
+	if(metric.Semantics == "counter") {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	if(unit != "" && !strings.HasSuffix(name, "_" + unit)) {
+		name = name + "_" + unit
+	}
+
+	switch metric.Semantics {
+	case "counter":
+		if(e.format == PrometheusText) {
+			return family{name:name + "_total", sample_name:name + "_total", metric_type:"counter", unit:unit, scale:scale}
+		}
+		return family{name:name, sample_name:name + "_total", metric_type:"counter", unit:unit, scale:scale}
+	case "instant", "discrete":
+		return family{name:name, sample_name:name, metric_type:"gauge", unit:unit, scale:scale}
+	}
+	if(e.format == PrometheusText) {
+		return family{name:name, sample_name:name, metric_type:"untyped", unit:unit, scale:scale}
+	}
+	return family{name:name, sample_name:name, metric_type:"unknown", unit:unit, scale:scale}
+}
+
+/* baseUnit names the OpenMetrics base unit for units made of single space and time dimensions,
+   such as bytes, seconds or bytes_per_second, along with the factor values are scaled by. Other
+   units, including any count dimension, are left unnamed and unscaled */
+func baseUnit(units pcpeasy.Units) (string, float64) {
+	if(units.DimCount != 0 || units.IsDimensionless()) {
+		return "", 1
+	}
+	dimension_names := []struct{
+		dimension int
+		name string
+	}{
+		{units.DimSpace, "bytes"},
+		{units.DimTime, "seconds"},
+	}
+	per := []string{}
+	name := []string{}
+	for _, dimension_name := range dimension_names {
+		switch dimension_name.dimension {
+		case 0:
+		case 1:
+			name = append(name, dimension_name.name)
+		case -1:
+			per = append(per, strings.TrimSuffix(dimension_name.name, "s"))
+		default:
+			return "", 1
+		}
+	}
+	if(len(per) > 0) {
+		name = append(name, "per", strings.Join(per, "_"))
+	}
+
+	scale, err := units.ConvertValue(1, units.BaseUnits())
+	if(err != nil) {
+		return "", 1
+	}
+	return strings.Join(name, "_"), scale
+}
+
+/* formatValue keeps integers exact when they need no scaling. ok is false for non-numeric values */
+func formatValue(metric_value pcpeasy.MetricValue, scale float64) (string, bool) {
+	if(scale == 1) {
+		switch typed := metric_value.Value.(type) {
+		case int32:
+			return strconv.FormatInt(int64(typed), 10), true
+		case uint32:
+			return strconv.FormatUint(uint64(typed), 10), true
+		case int64:
+			return strconv.FormatInt(typed, 10), true
+		case uint64:
+			return strconv.FormatUint(typed, 10), true
+		}
+	}
+	value, ok := metric_value.Float()
+	if(!ok) {
+		return "", false
+	}
+	return formatFloat(value * scale), true
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+/* formatTimestamp writes seconds with a fraction for OpenMetrics and integer milliseconds for the
+   Prometheus text format */
+func (e *Encoder) formatTimestamp(timestamp time.Time) string {
+	if(e.format == PrometheusText) {
+		return strconv.FormatInt(timestamp.UnixNano() / int64(time.Millisecond), 10)
+	}
+	seconds := strconv.FormatInt(timestamp.Unix(), 10)
+	if(timestamp.Nanosecond() == 0) {
+		return seconds
+	}
+	return seconds + "." + strings.TrimRight(fmt.Sprintf("%09d", timestamp.Nanosecond()), "0")
+}
+
+type label struct {
+	name string
+	value string
+}
+
+/* sampleLabels converts a value's PCP labels and instance into sorted label pairs. The instance
+   labels win over a PCP label that sanitizes to the same name */
+func sampleLabels(metric_value pcpeasy.MetricValue) []label {
+	by_name := make(map[string]string)
+	for name, value := range metric_value.Labels {
+		label_name := pcpeasy.PrometheusLabelName(name)
+		if(strings.HasPrefix(label_name, "__") || label_name == InfoValueLabel) {
+			continue
+		}
+		by_name[label_name] = value
+	}
+	if(metric_value.Instance != "") {
+		by_name[InstanceNameLabel] = metric_value.Instance
+		by_name[InstanceIDLabel] = strconv.Itoa(metric_value.InstanceID)
+	}
+
+	labels := make([]label, 0, len(by_name))
+	for name, value := range by_name {
+		labels = append(labels, label{name, value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+func formatLabels(labels []label) string {
+	if(len(labels) == 0) {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = l.name + "=\"" + labelValueEscaper.Replace(l.value) + "\""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+/* Help text in 0.0.4 leaves double quotes alone */
+var prometheusHelpEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+
+func (e *Encoder) escapeHelp(help string) string {
+	if(e.format == PrometheusText) {
+		return prometheusHelpEscaper.Replace(help)
+	}
+	return labelValueEscaper.Replace(help)
+}
@@ -0,0 +1,180 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package openmetrics
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+)
+
+func fetch(t *testing.T, names ...string) []pcpeasy.Metric {
+	agent := pcpeasy.NewAgentWithPMAPI(pmapitest.NewSample())
+	metrics, err := agent.MetricsWithOptions(pcpeasy.FetchOptions{Labels:true, HelpText:true}, names...)
+	assert.NoError(t, err)
+	return metrics
+}
+
+func TestEncoder_writesOpenMetrics(t *testing.T) {
+	var out bytes.Buffer
+
+	err := NewEncoder(&out, OpenMetrics, "pcp").Encode(fetch(t, "disk.dev.read_bytes", "kernel.all.load", "kernel.uname.release"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP pcp_disk_dev_read_bytes per-disk read bytes
+# TYPE pcp_disk_dev_read_bytes counter
+# UNIT pcp_disk_dev_read_bytes bytes
+pcp_disk_dev_read_bytes_total{agent="linux",hostname="web1",instid="0",instname="sda"} 102400
+pcp_disk_dev_read_bytes_total{agent="linux",device_type="ssd",hostname="web1",instid="1",instname="sdb"} 204800
+# HELP pcp_kernel_all_load 1, 5 and 15 minute load average
+# TYPE pcp_kernel_all_load gauge
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="1",instname="1 minute"} 0.5
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="5",instname="5 minute",minutes="5"} 0.25
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="15",instname="15 minute"} 0.125
+# TYPE pcp_kernel_uname_release info
+pcp_kernel_uname_release_info{value="6.1.0"} 1
+# EOF
+`, out.String())
+}
+
+func TestEncoder_writesPrometheusText(t *testing.T) {
+	var out bytes.Buffer
+
+	err := NewEncoder(&out, PrometheusText, "pcp").Encode(fetch(t, "disk.dev.read_bytes", "kernel.uname.release"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP pcp_disk_dev_read_bytes_total per-disk read bytes
+# TYPE pcp_disk_dev_read_bytes_total counter
+pcp_disk_dev_read_bytes_total{agent="linux",hostname="web1",instid="0",instname="sda"} 102400
+pcp_disk_dev_read_bytes_total{agent="linux",device_type="ssd",hostname="web1",instid="1",instname="sdb"} 204800
+# TYPE pcp_kernel_uname_release_info gauge
+pcp_kernel_uname_release_info{value="6.1.0"} 1
+`, out.String())
+}
+
+func TestEncoder_writesTimestampsInEachFormat(t *testing.T) {
+	var open_metrics, prometheus_text bytes.Buffer
+	metrics := fetch(t, "kernel.uname.release")
+
+	encoder := NewEncoder(&open_metrics, OpenMetrics, "")
+	encoder.Timestamps = true
+	encoder.Encode(metrics)
+	encoder = NewEncoder(&prometheus_text, PrometheusText, "")
+	encoder.Timestamps = true
+	encoder.Encode(metrics)
+
+	assert.Contains(t, open_metrics.String(), "\nkernel_uname_release_info{value=\"6.1.0\"} 1 1700000000.25\n")
+	assert.Contains(t, prometheus_text.String(), "\nkernel_uname_release_info{value=\"6.1.0\"} 1 1700000000250\n")
+}
+
+func TestEncoder_escapesHelpAndLabelValues(t *testing.T) {
+	var open_metrics, prometheus_text bytes.Buffer
+	metrics := []pcpeasy.Metric{{
+		Name:"test.escaping",
+		Semantics:"instant",
+		Type:reflect.Int32,
+		Help:"a \"quoted\" back\\slash\nnewline",
+		Values:[]pcpeasy.MetricValue{{Value:int32(1), Instance:"a \"b\"\\\n", InstanceID:3}},
+	}}
+
+	NewEncoder(&open_metrics, OpenMetrics, "").Encode(metrics)
+	NewEncoder(&prometheus_text, PrometheusText, "").Encode(metrics)
+
+	assert.Contains(t, open_metrics.String(), `# HELP test_escaping a \"quoted\" back\\slash\nnewline` + "\n")
+	assert.Contains(t, prometheus_text.String(), `# HELP test_escaping a "quoted" back\\slash\nnewline` + "\n")
+	assert.Contains(t, open_metrics.String(), `test_escaping{instid="3",instname="a \"b\"\\\n"} 1` + "\n")
+}
+
+func TestEncoder_scalesRatesToBytesPerSecond(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{{
+		Name:"network.rate",
+		Semantics:"instant",
+		Type:reflect.Float64,
+		Units:pcpeasy.Units{DimSpace:1, DimTime:-1, ScaleSpace:pcpeasy.SpaceKByte, ScaleTime:pcpeasy.TimeMin},
+		Values:[]pcpeasy.MetricValue{{Value:float64(6)}},
+	}}
+
+	NewEncoder(&out, OpenMetrics, "").Encode(metrics)
+
+	assert.Equal(t, "# TYPE network_rate_bytes_per_second gauge\n# UNIT network_rate_bytes_per_second bytes_per_second\nnetwork_rate_bytes_per_second 102.4\n# EOF\n", out.String())
+}
+
+func TestEncoder_leavesCountUnitsUnnamed(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{{
+		Name:"kernel.all.pswitch",
+		Semantics:"counter",
+		Type:reflect.Uint64,
+		Units:pcpeasy.Units{DimCount:1},
+		Values:[]pcpeasy.MetricValue{{Value:uint64(18446744073709551615)}},
+	}}
+
+	NewEncoder(&out, OpenMetrics, "").Encode(metrics)
+
+	assert.Equal(t, "# TYPE kernel_all_pswitch counter\nkernel_all_pswitch_total 18446744073709551615\n# EOF\n", out.String())
+}
+
+func TestFormatFloat_writesSpecialValues(t *testing.T) {
+	assert.Equal(t, "NaN", formatFloat(math.NaN()))
+	assert.Equal(t, "+Inf", formatFloat(math.Inf(1)))
+	assert.Equal(t, "-Inf", formatFloat(math.Inf(-1)))
+	assert.Equal(t, "1.5e+20", formatFloat(1.5e20))
+}
+
+type failingSource struct{}
+
+func (failingSource) MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHandler_negotiatesTheFormatFromAccept(t *testing.T) {
+	handler := NewHandler(pcpeasy.NewAgentWithPMAPI(pmapitest.NewSample()), "pcp", "kernel.all.load")
+
+	request := httptest.NewRequest("GET", "/metrics", nil)
+	request.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	open_metrics := httptest.NewRecorder()
+	handler.ServeHTTP(open_metrics, request)
+	prometheus_text := httptest.NewRecorder()
+	handler.ServeHTTP(prometheus_text, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, OpenMetricsContentType, open_metrics.Header().Get("Content-Type"))
+	assert.Contains(t, open_metrics.Body.String(), "# EOF\n")
+	assert.Equal(t, PrometheusTextContentType, prometheus_text.Header().Get("Content-Type"))
+	assert.NotContains(t, prometheus_text.Body.String(), "# EOF")
+	assert.Contains(t, prometheus_text.Body.String(), "# HELP pcp_kernel_all_load 1, 5 and 15 minute load average\n")
+}
+
+func TestHandler_returnsAnErrorWhenTheFetchFails(t *testing.T) {
+	response := httptest.NewRecorder()
+
+	NewHandler(failingSource{}, "pcp", "kernel.all.load").ServeHTTP(response, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "connection refused")
+}
@@ -0,0 +1,79 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package openmetrics
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+/* Source is satisfied by the agent returned from pcpeasy.NewAgent */
+type Source interface {
+	MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+type Handler struct {
+	source Source
+	namespace string
+	metric_names []string
+	/* Passed on to the Encoder */
+	Timestamps bool
+}
+
+/* NewHandler serves the named metrics, with their labels and help text, on each request */
+func NewHandler(source Source, namespace string, metric_names ...string) *Handler {
+	return &Handler{source:source, namespace:namespace, metric_names:metric_names}
+}
+
+func (h *Handler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	metrics, err := h.source.MetricsWithOptions(pcpeasy.FetchOptions{Labels:true, HelpText:true}, h.metric_names...)
+	if(err != nil) {
+		http.Error(response, "error fetching metrics from pmcd: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := negotiateFormat(request.Header.Get("Accept"))
+	/* Encode in full first so a failure can still be reported with a status code */
+	var body bytes.Buffer
+	encoder := NewEncoder(&body, format, h.namespace)
+	encoder.Timestamps = h.Timestamps
+	err = encoder.Encode(metrics)
+	if(err != nil) {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", format.ContentType())
+	response.Write(body.Bytes())
+}
+
+/* negotiateFormat picks OpenMetrics only when the scraper asks for it, as Prometheus does */
+func negotiateFormat(accept string) Format {
+	for _, media_range := range strings.Split(accept, ",") {
+		media_type := strings.TrimSpace(strings.Split(media_range, ";")[0])
+		if(media_type == "application/openmetrics-text") {
+			return OpenMetrics
+		}
+	}
+	return PrometheusText
+}
@@ -0,0 +1,268 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package otlp exports PCP metrics fetched through pcpeasy to an OpenTelemetry collector over
+OTLP/HTTP or OTLP/gRPC.
+
+Counter metrics become monotonic cumulative sums and instant and discrete metrics become gauges.
+PCP does not record when a counter started, so each series' start time is when the exporter first
+saw it, and is moved to the current sample when the counter goes backwards. A series missing from
+a sample, such as one of an exited process, is forgotten and starts afresh if it returns. Metrics measured in
+space or time are converted to bytes and seconds with the UCUM units By and s. String metrics are
+skipped.
+
+The host name becomes the host.name resource attribute. PCP labels become data point attributes,
+along with pcp.instance.name and pcp.instance.id for metrics with an instance domain.
+*/
+package otlp
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeName = "github.com/ryandoyle/pcpeasygo/pcpeasy/otlp"
+	HostNameAttribute = "host.name"
+	InstanceNameAttribute = "pcp.instance.name"
+	InstanceIDAttribute = "pcp.instance.id"
+	/* The PCP label pmcd gives every metric with the name of its host */
+	hostnameLabel = "hostname"
+)
+
+/* converter turns samples into export requests, remembering when each counter series of the last
+   sample started */
+type converter struct {
+	host_name string
+	resource_attributes map[string]string
+	lock sync.Mutex
+	counters map[string]counterSeries
+}
+
+type counterSeries struct {
+	start time.Time
+	last float64
+}
+
+func newConverter(host_name string, resource_attributes map[string]string) *converter {
+	return &converter{host_name:host_name, resource_attributes:resource_attributes, counters:make(map[string]counterSeries)}
+}
+
+/* resource carries the configured attributes and host name. Without a configured host name, the
+   hostname label pmcd attaches to every metric is used when the sample was fetched with labels */
+func (c *converter) resource(metrics []pcpeasy.Metric) (*resourcepb.Resource, string) {
+	attributes := map[string]string{}
+	for name, value := range c.resource_attributes {
+		attributes[name] = value
+	}
+	host_name := c.host_name
+	for _, metric := range metrics {
+		if(host_name != "") {
+			break
+		}
+		host_name = metric.Labels[hostnameLabel]
+	}
+	if(host_name != "") {
+		attributes[HostNameAttribute] = host_name
+	}
+	return &resourcepb.Resource{Attributes:keyValues(attributes)}, host_name
+}
+
+func (c *converter) request(metrics []pcpeasy.Metric, now time.Time) *collectorpb.ExportMetricsServiceRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	resource, host_name := c.resource(metrics)
+	otel_metrics := []*metricspb.Metric{}
+	counters := make(map[string]counterSeries, len(c.counters))
+	for _, metric := range metrics {
+		if(metric.Type == reflect.String) {
+			continue
+		}
+		otel_metrics = append(otel_metrics, c.metric(metric, host_name, now, counters))
+	}
+	c.counters = counters
+	return &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics:[]*metricspb.ResourceMetrics{{
+			Resource:resource,
+			ScopeMetrics:[]*metricspb.ScopeMetrics{{
+				Scope:&commonpb.InstrumentationScope{Name:ScopeName},
+				Metrics:otel_metrics,
+			}},
+		}},
+	}
+}
+
+/* metric converts one PCP metric, recording its counter series in counters */
+func (c *converter) metric(metric pcpeasy.Metric, host_name string, now time.Time, counters map[string]counterSeries) *metricspb.Metric {
+	timestamp := metric.Timestamp
+	if(timestamp.IsZero()) {
+		timestamp = now
+	}
+	unit, scale := ucumUnit(metric.Units)
+
+	points := []*metricspb.NumberDataPoint{}
+	for _, metric_value := range metric.Values {
+		point := numberDataPoint(metric_value, scale)
+		if(point == nil) {
+			continue
+		}
+		point.TimeUnixNano = uint64(timestamp.UnixNano())
+		point.Attributes = keyValues(pointAttributes(metric_value, host_name))
+		if(metric.Semantics == "counter") {
+			point.StartTimeUnixNano = uint64(c.counterStart(metric, metric_value, point, timestamp, counters).UnixNano())
+		}
+		points = append(points, point)
+	}
+
+	otel_metric := &metricspb.Metric{Name:metric.Name, Description:metric.Help, Unit:unit}
+	if(metric.Semantics == "counter") {
+		otel_metric.Data = &metricspb.Metric_Sum{Sum:&metricspb.Sum{
+			DataPoints:points,
+			AggregationTemporality:metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:true,
+		}}
+	} else {
+		otel_metric.Data = &metricspb.Metric_Gauge{Gauge:&metricspb.Gauge{DataPoints:points}}
+	}
+	return otel_metric
+}
+
+/* counterStart is when the series was first seen, or the current sample if it went backwards */
+func (c *converter) counterStart(metric pcpeasy.Metric, metric_value pcpeasy.MetricValue, point *metricspb.NumberDataPoint, timestamp time.Time, counters map[string]counterSeries) time.Time {
+	value := point.GetAsDouble()
+	if _, is_int := point.Value.(*metricspb.NumberDataPoint_AsInt); is_int {
+		value = float64(point.GetAsInt())
+	}
+	key := metric.Name + "|" + strconv.Itoa(metric_value.InstanceID)
+	series, seen := c.counters[key]
+	if(!seen || value < series.last) {
+		series.start = timestamp
+	}
+	series.last = value
+	counters[key] = series
+	return series.start
+}
+
+/* numberDataPoint keeps integers as integers where they fit and need no scaling */
+func numberDataPoint(metric_value pcpeasy.MetricValue, scale float64) *metricspb.NumberDataPoint {
+	if(scale == 1) {
+		switch typed := metric_value.Value.(type) {
+		case int32:
+			return &metricspb.NumberDataPoint{Value:&metricspb.NumberDataPoint_AsInt{AsInt:int64(typed)}}
+		case uint32:
+			return &metricspb.NumberDataPoint{Value:&metricspb.NumberDataPoint_AsInt{AsInt:int64(typed)}}
+		case int64:
+			return &metricspb.NumberDataPoint{Value:&metricspb.NumberDataPoint_AsInt{AsInt:typed}}
+		case uint64:
+			if(typed <= math.MaxInt64) {
+				return &metricspb.NumberDataPoint{Value:&metricspb.NumberDataPoint_AsInt{AsInt:int64(typed)}}
+			}
+		}
+	}
+	value, ok := metric_value.Float()
+	if(!ok) {
+		return nil
+	}
+	return &metricspb.NumberDataPoint{Value:&metricspb.NumberDataPoint_AsDouble{AsDouble:value * scale}}
+}
+
+/* pointAttributes are the value's PCP labels and instance. A hostname label already carried by the
+   resource is left out */
+func pointAttributes(metric_value pcpeasy.MetricValue, host_name string) map[string]string {
+	attributes := map[string]string{}
+	for name, value := range metric_value.Labels {
+		if(name != hostnameLabel || value != host_name) {
+			attributes[name] = value
+		}
+	}
+	if(metric_value.Instance != "") {
+		attributes[InstanceNameAttribute] = metric_value.Instance
+		attributes[InstanceIDAttribute] = strconv.Itoa(metric_value.InstanceID)
+	}
+	return attributes
+}
+
+func keyValues(attributes map[string]string) []*commonpb.KeyValue {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key_values := make([]*commonpb.KeyValue, len(names))
+	for i, name := range names {
+		key_values[i] = &commonpb.KeyValue{
+			Key:name,
+			Value:&commonpb.AnyValue{Value:&commonpb.AnyValue_StringValue{StringValue:attributes[name]}},
+		}
+	}
+	return key_values
+}
+
+/* ucumUnit names units made of single space and time dimensions in UCUM, such as By, s or By/s,
+   along with the factor values are scaled by to reach them. Dimensionless metrics are "1" and
+   anything else is left unnamed and unscaled */
+func ucumUnit(units pcpeasy.Units) (string, float64) {
+	if(units.IsDimensionless()) {
+		return "1", 1
+	}
+	if(units.DimCount != 0) {
+		return "", 1
+	}
+	numerator := []string{}
+	denominator := []string{}
+	for _, dimension := range []struct{
+		dimension int
+		unit string
+	}{{units.DimSpace, "By"}, {units.DimTime, "s"}} {
+		switch dimension.dimension {
+		case 0:
+		case 1:
+			numerator = append(numerator, dimension.unit)
+		case -1:
+			denominator = append(denominator, dimension.unit)
+		default:
+			return "", 1
+		}
+	}
+	scale, err := units.ConvertValue(1, units.BaseUnits())
+	if(err != nil) {
+		return "", 1
+	}
+	unit := strings.Join(numerator, ".")
+	if(unit == "") {
+		unit = "1"
+	}
+	if(len(denominator) > 0) {
+		unit = unit + "/" + strings.Join(denominator, ".")
+	}
+	return unit, scale
+}
@@ -0,0 +1,153 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package otlp
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"reflect"
+	"time"
+)
+
+var (
+	first = time.Unix(1700000000, 0)
+	second = time.Unix(1700000010, 0)
+	third = time.Unix(1700000020, 0)
+)
+
+func diskReads(timestamp time.Time, sda uint64) []pcpeasy.Metric {
+	return []pcpeasy.Metric{{
+		Name:"disk.dev.read_bytes",
+		Semantics:"counter",
+		Type:reflect.Uint64,
+		Help:"per-disk read bytes",
+		Units:pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceKByte},
+		Timestamp:timestamp,
+		Labels:map[string]string{"hostname":"web1"},
+		Values:[]pcpeasy.MetricValue{
+			{Value:sda, Instance:"sda", InstanceID:0, Labels:map[string]string{"hostname":"web1", "device_type":"ssd"}},
+		},
+	}}
+}
+
+func attributes(key_values []*commonpb.KeyValue) map[string]string {
+	attributes := map[string]string{}
+	for _, key_value := range key_values {
+		attributes[key_value.Key] = key_value.Value.GetStringValue()
+	}
+	return attributes
+}
+
+func TestConverter_sendsCountersAsCumulativeSums(t *testing.T) {
+	converter := newConverter("", map[string]string{"service.name":"pcp"})
+
+	request := converter.request(diskReads(first, 100), time.Now())
+
+	resource_metrics := request.ResourceMetrics[0]
+	assert.Equal(t, map[string]string{"service.name":"pcp", "host.name":"web1"}, attributes(resource_metrics.Resource.Attributes))
+	assert.Equal(t, ScopeName, resource_metrics.ScopeMetrics[0].Scope.Name)
+	metric := resource_metrics.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "disk.dev.read_bytes", metric.Name)
+	assert.Equal(t, "per-disk read bytes", metric.Description)
+	assert.Equal(t, "By", metric.Unit)
+	sum := metric.GetSum()
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, sum.AggregationTemporality)
+	point := sum.DataPoints[0]
+	assert.Equal(t, float64(102400), point.GetAsDouble())
+	assert.Equal(t, uint64(first.UnixNano()), point.TimeUnixNano)
+	assert.Equal(t, uint64(first.UnixNano()), point.StartTimeUnixNano)
+	assert.Equal(t, map[string]string{"device_type":"ssd", "pcp.instance.name":"sda", "pcp.instance.id":"0"}, attributes(point.Attributes))
+}
+
+func TestConverter_keepsTheCounterStartTimeUntilItGoesBackwards(t *testing.T) {
+	converter := newConverter("", nil)
+
+	converter.request(diskReads(first, 100), time.Now())
+	still_rising := converter.request(diskReads(second, 200), time.Now())
+	reset := converter.request(diskReads(third, 5), time.Now())
+
+	point := still_rising.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum().DataPoints[0]
+	assert.Equal(t, uint64(first.UnixNano()), point.StartTimeUnixNano)
+	assert.Equal(t, uint64(second.UnixNano()), point.TimeUnixNano)
+	point = reset.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum().DataPoints[0]
+	assert.Equal(t, uint64(third.UnixNano()), point.StartTimeUnixNano)
+}
+
+func TestConverter_forgetsCountersMissingFromASample(t *testing.T) {
+	converter := newConverter("", nil)
+	without_sda := diskReads(second, 0)
+	without_sda[0].Values = nil
+
+	converter.request(diskReads(first, 100), time.Now())
+	converter.request(without_sda, time.Now())
+	assert.Empty(t, converter.counters)
+	returned := converter.request(diskReads(third, 200), time.Now())
+
+	point := returned.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum().DataPoints[0]
+	assert.Equal(t, uint64(third.UnixNano()), point.StartTimeUnixNano)
+}
+
+func TestConverter_sendsInstantMetricsAsGauges(t *testing.T) {
+	converter := newConverter("db1", nil)
+	metrics := []pcpeasy.Metric{
+		{Name:"kernel.all.nprocs", Semantics:"instant", Type:reflect.Int32, Values:[]pcpeasy.MetricValue{
+			{Value:int32(321), InstanceID:-1, Labels:map[string]string{"hostname":"web1"}},
+		}},
+		{Name:"kernel.uname.release", Semantics:"discrete", Type:reflect.String, Values:[]pcpeasy.MetricValue{{Value:"6.1.0"}}},
+	}
+
+	request := converter.request(metrics, first)
+
+	assert.Equal(t, map[string]string{"host.name":"db1"}, attributes(request.ResourceMetrics[0].Resource.Attributes))
+	otel_metrics := request.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Len(t, otel_metrics, 1)
+	assert.Equal(t, "1", otel_metrics[0].Unit)
+	point := otel_metrics[0].GetGauge().DataPoints[0]
+	assert.Equal(t, int64(321), point.GetAsInt())
+	assert.Equal(t, uint64(first.UnixNano()), point.TimeUnixNano)
+	assert.Equal(t, uint64(0), point.StartTimeUnixNano)
+	/* Differs from the configured host name, so it is kept */
+	assert.Equal(t, map[string]string{"hostname":"web1"}, attributes(point.Attributes))
+}
+
+func TestUcumUnit(t *testing.T) {
+	units := []struct{
+		units pcpeasy.Units
+		unit string
+		scale float64
+	}{
+		{pcpeasy.Units{}, "1", 1},
+		{pcpeasy.Units{DimTime:1, ScaleTime:pcpeasy.TimeMSec}, "s", 0.001},
+		{pcpeasy.Units{DimSpace:1, DimTime:-1, ScaleSpace:pcpeasy.SpaceMByte, ScaleTime:pcpeasy.TimeSec}, "By/s", 1024 * 1024},
+		{pcpeasy.Units{DimTime:-1, ScaleTime:pcpeasy.TimeSec}, "1/s", 1},
+		{pcpeasy.Units{DimCount:1}, "", 1},
+		{pcpeasy.Units{DimSpace:2}, "", 1},
+	}
+	for _, test := range units {
+		unit, scale := ucumUnit(test.units)
+		assert.Equal(t, test.unit, unit, test.units.String())
+		assert.Equal(t, test.scale, scale, test.units.String())
+	}
+}
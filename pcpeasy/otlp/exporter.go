@@ -0,0 +1,130 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package otlp
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type ExporterOptions struct {
+	/* Sent as host.name. Defaults to the hostname label of the metrics, when fetched with labels */
+	HostName string
+	/* Further resource attributes, such as service.name */
+	ResourceAttributes map[string]string
+	/* Sent with every request, as HTTP headers or gRPC metadata. Often used for authentication */
+	Headers map[string]string
+	/* Client used by NewHTTPExporter. Defaults to one with a 10 second timeout */
+	Client *http.Client
+}
+
+type Exporter struct {
+	converter *converter
+	export func(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) error
+}
+
+/* NewHTTPExporter posts protobuf encoded requests to an OTLP/HTTP endpoint, such as
+   http://localhost:4318/v1/metrics */
+func NewHTTPExporter(endpoint string, options ExporterOptions) *Exporter {
+	client := options.Client
+	if(client == nil) {
+		client = &http.Client{Timeout:10 * time.Second}
+	}
+	export := func(ctx context.Context, export_request *collectorpb.ExportMetricsServiceRequest) error {
+		body, err := proto.Marshal(export_request)
+		if(err != nil) {
+			return err
+		}
+		request, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if(err != nil) {
+			return err
+		}
+		request = request.WithContext(ctx)
+		request.Header.Set("Content-Type", "application/x-protobuf")
+		for name, value := range options.Headers {
+			request.Header.Set(name, value)
+		}
+		response, err := client.Do(request)
+		if(err != nil) {
+			return err
+		}
+		defer response.Body.Close()
+		response_body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		if(response.StatusCode < 200 || response.StatusCode >= 300) {
+			return errors.New(fmt.Sprintf("otlp export failed with %v: %v", response.Status, string(bytes.TrimSpace(response_body))))
+		}
+		return nil
+	}
+	return &Exporter{converter:newConverter(options.HostName, options.ResourceAttributes), export:export}
+}
+
+/* NewGRPCExporter exports over an OTLP/gRPC connection, usually to port 4317. The caller dials the
+   connection, choosing its transport security, and closes it when done */
+func NewGRPCExporter(connection grpc.ClientConnInterface, options ExporterOptions) *Exporter {
+	client := collectorpb.NewMetricsServiceClient(connection)
+	export := func(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) error {
+		for name, value := range options.Headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, name, value)
+		}
+		_, err := client.Export(ctx, request)
+		return err
+	}
+	return &Exporter{converter:newConverter(options.HostName, options.ResourceAttributes), export:export}
+}
+
+/* Export sends one sample of metrics. Counters need to be exported from every sample, in order,
+   for their start times to be tracked */
+func (e *Exporter) Export(ctx context.Context, metrics []pcpeasy.Metric) error {
+	return e.export(ctx, e.converter.request(metrics, time.Now()))
+}
+
+/* Run exports the samples from a pcpeasy Watch until the channel closes or ctx is done. Samples
+   carrying a fetch error are skipped. Export errors are passed to on_error, when not nil */
+func (e *Exporter) Run(ctx context.Context, samples <-chan pcpeasy.Sample, on_error func(error)) {
+	for {
+		select {
+		case sample, ok := <-samples:
+			if(!ok) {
+				return
+			}
+			if(sample.Err != nil) {
+				continue
+			}
+			err := e.Export(ctx, sample.Metrics)
+			if(err != nil && on_error != nil) {
+				on_error(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
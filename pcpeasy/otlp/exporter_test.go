@@ -0,0 +1,121 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package otlp
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestHTTPExporter_postsProtobuf(t *testing.T) {
+	var received collectorpb.ExportMetricsServiceRequest
+	var headers http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		proto.Unmarshal(body, &received)
+		headers = request.Header
+	}))
+	defer collector.Close()
+	exporter := NewHTTPExporter(collector.URL + "/v1/metrics", ExporterOptions{Headers:map[string]string{"Authorization":"Bearer secret"}})
+
+	err := exporter.Export(context.Background(), diskReads(first, 100))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	assert.Equal(t, "Bearer secret", headers.Get("Authorization"))
+	assert.Equal(t, "disk.dev.read_bytes", received.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name)
+}
+
+func TestHTTPExporter_returnsRejections(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		http.Error(response, "bad data", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	err := NewHTTPExporter(collector.URL, ExporterOptions{}).Export(context.Background(), diskReads(first, 100))
+
+	assert.EqualError(t, err, "otlp export failed with 400 Bad Request: bad data")
+}
+
+type metricsService struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	requests chan *collectorpb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (s *metricsService) Export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+	s.metadata <- incoming
+	s.requests <- request
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestGRPCExporter_exportsToTheMetricsService(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	service := &metricsService{requests:make(chan *collectorpb.ExportMetricsServiceRequest, 1), metadata:make(chan metadata.MD, 1)}
+	collectorpb.RegisterMetricsServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+	connection, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer connection.Close()
+	exporter := NewGRPCExporter(connection, ExporterOptions{Headers:map[string]string{"api-key":"secret"}})
+
+	err = exporter.Export(context.Background(), diskReads(first, 100))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret"}, (<-service.metadata).Get("api-key"))
+	assert.Equal(t, "disk.dev.read_bytes", (<-service.requests).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name)
+}
+
+func TestExporter_runExportsEachSample(t *testing.T) {
+	exported := 0
+	collector := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		exported++
+	}))
+	defer collector.Close()
+	samples := make(chan pcpeasy.Sample, 3)
+	samples <- pcpeasy.Sample{Metrics:diskReads(first, 100)}
+	samples <- pcpeasy.Sample{Err:context.Canceled}
+	samples <- pcpeasy.Sample{Metrics:diskReads(second, 200)}
+	close(samples)
+
+	NewHTTPExporter(collector.URL, ExporterOptions{}).Run(context.Background(), samples, nil)
+
+	assert.Equal(t, 2, exported)
+}
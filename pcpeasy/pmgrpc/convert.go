@@ -0,0 +1,115 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmgrpc
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmgrpc/pcppb"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var types = map[int]pcppb.Type{
+	pmapi.PmType32:pcppb.Type_TYPE_32,
+	pmapi.PmTypeU32:pcppb.Type_TYPE_U32,
+	pmapi.PmType64:pcppb.Type_TYPE_64,
+	pmapi.PmTypeU64:pcppb.Type_TYPE_U64,
+	pmapi.PmTypeFloat:pcppb.Type_TYPE_FLOAT,
+	pmapi.PmTypeDouble:pcppb.Type_TYPE_DOUBLE,
+	pmapi.PmTypeString:pcppb.Type_TYPE_STRING,
+}
+
+var semantics = map[int]pcppb.Semantics{
+	pmapi.PmSemCounter:pcppb.Semantics_SEMANTICS_COUNTER,
+	pmapi.PmSemInstant:pcppb.Semantics_SEMANTICS_INSTANT,
+	pmapi.PmSemDiscrete:pcppb.Semantics_SEMANTICS_DISCRETE,
+}
+
+/* semanticsNames maps pcpeasy's Metric.Semantics back to the enum */
+var semanticsNames = map[string]pcppb.Semantics{
+	"counter":pcppb.Semantics_SEMANTICS_COUNTER,
+	"instant":pcppb.Semantics_SEMANTICS_INSTANT,
+	"discrete":pcppb.Semantics_SEMANTICS_DISCRETE,
+}
+
+func convertMetrics(metrics []pcpeasy.Metric) []*pcppb.Metric {
+	converted := make([]*pcppb.Metric, len(metrics))
+	for i, metric := range metrics {
+		converted[i] = convertMetric(metric)
+	}
+	return converted
+}
+
+func convertMetric(metric pcpeasy.Metric) *pcppb.Metric {
+	converted := &pcppb.Metric{
+		Name:metric.Name,
+		Pmid:uint32(metric.PmID),
+		Indom:indom(metric.InDom),
+		Type:types[metric.PmType],
+		Semantics:semanticsNames[metric.Semantics],
+		Units:convertUnits(metric.Units),
+		Timestamp:timestamppb.New(metric.Timestamp),
+		Labels:metric.Labels,
+		Help:metric.Help,
+		Values:make([]*pcppb.Value, len(metric.Values)),
+	}
+	for i, value := range metric.Values {
+		converted.Values[i] = convertValue(metric, value)
+	}
+	return converted
+}
+
+func convertValue(metric pcpeasy.Metric, value pcpeasy.MetricValue) *pcppb.Value {
+	converted := &pcppb.Value{Instance:value.Instance, Labels:value.Labels}
+	if(metric.InDom != pmapi.PmInDomNull) {
+		id := int32(value.InstanceID)
+		converted.InstanceId = &id
+	}
+	switch v := value.Value.(type) {
+	case int32:
+		converted.Value = &pcppb.Value_Int32Value{Int32Value:v}
+	case uint32:
+		converted.Value = &pcppb.Value_Uint32Value{Uint32Value:v}
+	case int64:
+		converted.Value = &pcppb.Value_Int64Value{Int64Value:v}
+	case uint64:
+		converted.Value = &pcppb.Value_Uint64Value{Uint64Value:v}
+	case float32:
+		converted.Value = &pcppb.Value_FloatValue{FloatValue:v}
+	case float64:
+		converted.Value = &pcppb.Value_DoubleValue{DoubleValue:v}
+	case string:
+		converted.Value = &pcppb.Value_StringValue{StringValue:v}
+	}
+	return converted
+}
+
+func convertUnits(units pcpeasy.Units) *pcppb.Units {
+	return &pcppb.Units{
+		DimSpace:int32(units.DimSpace),
+		DimTime:int32(units.DimTime),
+		DimCount:int32(units.DimCount),
+		ScaleSpace:uint32(units.ScaleSpace),
+		ScaleTime:uint32(units.ScaleTime),
+		ScaleCount:int32(units.ScaleCount),
+		Display:units.String(),
+	}
+}
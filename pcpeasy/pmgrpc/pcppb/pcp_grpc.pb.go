@@ -0,0 +1,324 @@
+// Copyright (c) 2016 Ryan Doyle
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: pcp.proto
+
+package pcppb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	PCP_Fetch_FullMethodName     = "/pcpeasy.v1.PCP/Fetch"
+	PCP_Lookup_FullMethodName    = "/pcpeasy.v1.PCP/Lookup"
+	PCP_Describe_FullMethodName  = "/pcpeasy.v1.PCP/Describe"
+	PCP_Instances_FullMethodName = "/pcpeasy.v1.PCP/Instances"
+	PCP_Watch_FullMethodName     = "/pcpeasy.v1.PCP/Watch"
+)
+
+// PCPClient is the client API for PCP service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PCP gives typed access to the metrics of one PCP context.
+type PCPClient interface {
+	// Fetch returns the current values of the named metrics.
+	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error)
+	// Lookup resolves metric names to PMIDs, or lists the metrics under a PMNS prefix.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// Describe returns the descriptors, help text and labels of the named metrics.
+	Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error)
+	// Instances lists the instances of an instance domain.
+	Instances(ctx context.Context, in *InstancesRequest, opts ...grpc.CallOption) (*InstancesResponse, error)
+	// Watch fetches the named metrics every interval until the client cancels.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PCP_WatchClient, error)
+}
+
+type pCPClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPCPClient(cc grpc.ClientConnInterface) PCPClient {
+	return &pCPClient{cc}
+}
+
+func (c *pCPClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FetchResponse)
+	err := c.cc.Invoke(ctx, PCP_Fetch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pCPClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, PCP_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pCPClient) Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeResponse)
+	err := c.cc.Invoke(ctx, PCP_Describe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pCPClient) Instances(ctx context.Context, in *InstancesRequest, opts ...grpc.CallOption) (*InstancesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InstancesResponse)
+	err := c.cc.Invoke(ctx, PCP_Instances_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pCPClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PCP_WatchClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PCP_ServiceDesc.Streams[0], PCP_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pCPWatchClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PCP_WatchClient interface {
+	Recv() (*WatchResponse, error)
+	grpc.ClientStream
+}
+
+type pCPWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *pCPWatchClient) Recv() (*WatchResponse, error) {
+	m := new(WatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PCPServer is the server API for PCP service.
+// All implementations must embed UnimplementedPCPServer
+// for forward compatibility
+//
+// PCP gives typed access to the metrics of one PCP context.
+type PCPServer interface {
+	// Fetch returns the current values of the named metrics.
+	Fetch(context.Context, *FetchRequest) (*FetchResponse, error)
+	// Lookup resolves metric names to PMIDs, or lists the metrics under a PMNS prefix.
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// Describe returns the descriptors, help text and labels of the named metrics.
+	Describe(context.Context, *DescribeRequest) (*DescribeResponse, error)
+	// Instances lists the instances of an instance domain.
+	Instances(context.Context, *InstancesRequest) (*InstancesResponse, error)
+	// Watch fetches the named metrics every interval until the client cancels.
+	Watch(*WatchRequest, PCP_WatchServer) error
+	mustEmbedUnimplementedPCPServer()
+}
+
+// UnimplementedPCPServer must be embedded to have forward compatible implementations.
+type UnimplementedPCPServer struct {
+}
+
+func (UnimplementedPCPServer) Fetch(context.Context, *FetchRequest) (*FetchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Fetch not implemented")
+}
+func (UnimplementedPCPServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedPCPServer) Describe(context.Context, *DescribeRequest) (*DescribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Describe not implemented")
+}
+func (UnimplementedPCPServer) Instances(context.Context, *InstancesRequest) (*InstancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Instances not implemented")
+}
+func (UnimplementedPCPServer) Watch(*WatchRequest, PCP_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedPCPServer) mustEmbedUnimplementedPCPServer() {}
+
+// UnsafePCPServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PCPServer will
+// result in compilation errors.
+type UnsafePCPServer interface {
+	mustEmbedUnimplementedPCPServer()
+}
+
+func RegisterPCPServer(s grpc.ServiceRegistrar, srv PCPServer) {
+	s.RegisterService(&PCP_ServiceDesc, srv)
+}
+
+func _PCP_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PCPServer).Fetch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PCP_Fetch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PCPServer).Fetch(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PCP_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PCPServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PCP_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PCPServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PCP_Describe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PCPServer).Describe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PCP_Describe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PCPServer).Describe(ctx, req.(*DescribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PCP_Instances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PCPServer).Instances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PCP_Instances_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PCPServer).Instances(ctx, req.(*InstancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PCP_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PCPServer).Watch(m, &pCPWatchServer{ServerStream: stream})
+}
+
+type PCP_WatchServer interface {
+	Send(*WatchResponse) error
+	grpc.ServerStream
+}
+
+type pCPWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *pCPWatchServer) Send(m *WatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// PCP_ServiceDesc is the grpc.ServiceDesc for PCP service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PCP_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pcpeasy.v1.PCP",
+	HandlerType: (*PCPServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Fetch",
+			Handler:    _PCP_Fetch_Handler,
+		},
+		{
+			MethodName: "Lookup",
+			Handler:    _PCP_Lookup_Handler,
+		},
+		{
+			MethodName: "Describe",
+			Handler:    _PCP_Describe_Handler,
+		},
+		{
+			MethodName: "Instances",
+			Handler:    _PCP_Instances_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _PCP_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pcp.proto",
+}
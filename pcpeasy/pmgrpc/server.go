@@ -0,0 +1,254 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package pmgrpc serves PCP metrics over gRPC with the PCP service defined in package pcppb, so
+services written in Go can read metrics without libpcp on their own machines.
+
+	listener, err := net.Listen("tcp", ":50051")
+	context, err := pmapi.PmNewContext(pmapi.PmContextHost, "localhost")
+	server := grpc.NewServer()
+	pcppb.RegisterPCPServer(server, pmgrpc.NewServer(context))
+	server.Serve(listener)
+
+Unknown metric names, PMIDs, instance domains and instances are reported with codes.NotFound,
+malformed requests with codes.InvalidArgument, the end of an archive with codes.OutOfRange and
+other failures talking to pmcd with codes.Unavailable.
+*/
+package pmgrpc
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmgrpc/pcppb"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sort"
+)
+
+type Server struct {
+	pcppb.UnimplementedPCPServer
+	pmapi pmapi.PMAPI
+	agent agent
+}
+
+/* agent is the part of the pcpeasy agent the server uses */
+type agent interface {
+	MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error)
+	MetricNames(prefix string) ([]string, error)
+	WatchWithOptions(ctx context.Context, options pcpeasy.WatchOptions, metric_names ...string) <-chan pcpeasy.Sample
+}
+
+/* NewServer serves the metrics of context, which may be a host, local or archive context */
+func NewServer(context pmapi.PMAPI) *Server {
+	return &Server{pmapi:context, agent:pcpeasy.NewAgentWithPMAPI(context)}
+}
+
+func (s *Server) Fetch(ctx context.Context, request *pcppb.FetchRequest) (*pcppb.FetchResponse, error) {
+	if(len(request.Names) == 0) {
+		return nil, status.Error(codes.InvalidArgument, "no metrics named")
+	}
+	options := pcpeasy.FetchOptions{Labels:request.Labels, HelpText:request.HelpText}
+	if(len(request.Instances) > 0) {
+		options.Instances = &pcpeasy.InstanceFilter{Names:request.Instances}
+	}
+	metrics, err := s.agent.MetricsWithOptions(options, request.Names...)
+	if(err != nil) {
+		return nil, statusError(err)
+	}
+	return &pcppb.FetchResponse{Metrics:convertMetrics(metrics)}, nil
+}
+
+func (s *Server) Lookup(ctx context.Context, request *pcppb.LookupRequest) (*pcppb.LookupResponse, error) {
+	names := request.Names
+	if(len(names) == 0) {
+		var err error
+		names, err = s.agent.MetricNames(request.Prefix)
+		if(err != nil) {
+			return nil, statusError(err)
+		}
+	}
+	pmids, err := s.lookupNames(names...)
+	if(err != nil) {
+		return nil, err
+	}
+	metrics := make([]*pcppb.MetricID, len(names))
+	for i, pmid := range pmids {
+		metrics[i] = &pcppb.MetricID{Name:names[i], Pmid:uint32(pmid)}
+	}
+	return &pcppb.LookupResponse{Metrics:metrics}, nil
+}
+
+func (s *Server) Describe(ctx context.Context, request *pcppb.DescribeRequest) (*pcppb.DescribeResponse, error) {
+	if(len(request.Names) == 0) {
+		return nil, status.Error(codes.InvalidArgument, "no metrics named")
+	}
+	pmids, err := s.lookupNames(request.Names...)
+	if(err != nil) {
+		return nil, err
+	}
+	descriptors := make([]*pcppb.Descriptor, len(pmids))
+	for i, pmid := range pmids {
+		descriptors[i], err = s.describe(request.Names[i], pmid)
+		if(err != nil) {
+			return nil, statusError(err)
+		}
+	}
+	return &pcppb.DescribeResponse{Descriptors:descriptors}, nil
+}
+
+/* lookupNames looks up the PMIDs of names, answering with codes.NotFound when any is unknown.
+   PmLookupName fails only when none are known, giving the rest PM_ID_NULL */
+func (s *Server) lookupNames(names ...string) ([]pmapi.PmID, error) {
+	pmids, err := s.pmapi.PmLookupName(names...)
+	if(err != nil) {
+		return nil, statusError(err)
+	}
+	for i, pmid := range pmids {
+		if(pmid == pmapi.PmIDNull) {
+			return nil, status.Errorf(codes.NotFound, "%v: Unknown metric name", names[i])
+		}
+	}
+	return pmids, nil
+}
+
+/* describe looks up a metric's descriptor, help text and labels. Missing text and labels are left
+   empty rather than failing */
+func (s *Server) describe(name string, pmid pmapi.PmID) (*pcppb.Descriptor, error) {
+	desc, err := s.pmapi.PmLookupDesc(pmid)
+	if(err != nil) {
+		return nil, err
+	}
+	descriptor := &pcppb.Descriptor{
+		Name:name,
+		Pmid:uint32(pmid),
+		Indom:indom(desc.InDom),
+		Type:types[desc.Type],
+		Semantics:semantics[desc.Sem],
+		Units:convertUnits(pcpeasy.NewUnits(desc.Units)),
+		Labels:map[string]string{},
+	}
+	descriptor.OneLineText, _ = s.pmapi.PmLookupText(pmid, pmapi.PmTextOneline)
+	descriptor.HelpText, _ = s.pmapi.PmLookupText(pmid, pmapi.PmTextHelp)
+	if label_sets, err := s.pmapi.PmLookupLabels(pmid); err == nil {
+		for _, label_set := range label_sets {
+			if(label_set.Inst == pmapi.PmInNull) {
+				for label, value := range label_set.Labels {
+					descriptor.Labels[label] = value
+				}
+			}
+		}
+	}
+	return descriptor, nil
+}
+
+func (s *Server) Instances(ctx context.Context, request *pcppb.InstancesRequest) (*pcppb.InstancesResponse, error) {
+	var indom pmapi.PmInDom
+	switch domain := request.Domain.(type) {
+	case *pcppb.InstancesRequest_Indom:
+		indom = pmapi.PmInDom(domain.Indom)
+	case *pcppb.InstancesRequest_Name:
+		pmids, err := s.lookupNames(domain.Name)
+		if(err != nil) {
+			return nil, err
+		}
+		desc, err := s.pmapi.PmLookupDesc(pmids[0])
+		if(err != nil) {
+			return nil, statusError(err)
+		}
+		if(desc.InDom == pmapi.PmInDomNull) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v has no instance domain", domain.Name)
+		}
+		indom = desc.InDom
+	default:
+		return nil, status.Error(codes.InvalidArgument, "no instance domain or metric named")
+	}
+
+	instances, err := s.pmapi.PmGetInDom(indom)
+	if(err != nil) {
+		return nil, statusError(err)
+	}
+	ids := []int{}
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	response := &pcppb.InstancesResponse{Indom:uint32(indom), Instances:make([]*pcppb.Instance, len(ids))}
+	for i, id := range ids {
+		response.Instances[i] = &pcppb.Instance{Id:int32(id), Name:instances[id]}
+	}
+	return response, nil
+}
+
+/* Watch streams a sample every interval until the client goes away. The client is waited for
+   rather than samples dropped, so a slow client skips intervals. Names are checked before the
+   first sample; later fetch failures are sent as samples with Error set */
+func (s *Server) Watch(request *pcppb.WatchRequest, stream pcppb.PCP_WatchServer) error {
+	if(len(request.Names) == 0) {
+		return status.Error(codes.InvalidArgument, "no metrics named")
+	}
+	interval := request.Interval.AsDuration()
+	if(interval <= 0) {
+		return status.Error(codes.InvalidArgument, "watch interval must be positive")
+	}
+	_, err := s.lookupNames(request.Names...)
+	if(err != nil) {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	samples := s.agent.WatchWithOptions(ctx, pcpeasy.WatchOptions{Interval:interval, Policy:pcpeasy.BlockSampling}, request.Names...)
+	for sample := range samples {
+		response := &pcppb.WatchResponse{Timestamp:timestamppb.New(sample.Timestamp)}
+		if(sample.Err != nil) {
+			response.Error = sample.Err.Error()
+		} else {
+			response.Metrics = convertMetrics(sample.Metrics)
+		}
+		err = stream.Send(response)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+/* statusError gives err the gRPC code clients should see */
+func statusError(err error) error {
+	for _, code := range []int{pmapi.PmErrName, pmapi.PmErrPmID, pmapi.PmErrInDom, pmapi.PmErrInst} {
+		if(pmapi.IsPmError(err, code)) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+	}
+	if(pcpeasy.IsEndOfArchive(err)) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func indom(indom pmapi.PmInDom) uint32 {
+	if(indom == pmapi.PmInDomNull) {
+		return 0
+	}
+	return uint32(indom)
+}
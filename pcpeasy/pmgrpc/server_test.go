@@ -0,0 +1,233 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmgrpc
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/pmgrpc/pcppb"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net"
+	"time"
+)
+
+var loadInDom = pmapitest.SampleLoadInDom
+var loadPmID = pmapitest.SampleLoadPmID
+var pswitchPmID = pmapitest.SamplePswitchPmID
+var fetchedAt = pmapitest.SampleTimestamp
+
+/* client serves a fake context over an in-memory connection */
+func client(t *testing.T) (pcppb.PCPClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pcppb.RegisterPCPServer(server, NewServer(pmapitest.NewSample()))
+	go server.Serve(listener)
+	connection, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	return pcppb.NewPCPClient(connection), func() {
+		connection.Close()
+		server.Stop()
+	}
+}
+
+func assertProtoEqual(t *testing.T, expected proto.Message, actual proto.Message) {
+	assert.True(t, proto.Equal(expected, actual), "expected %v\nactual   %v", expected, actual)
+}
+
+func instanceID(id int32) *int32 {
+	return &id
+}
+
+func TestServer_Fetch_returnsTypedValues(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	response, err := pcp.Fetch(context.Background(), &pcppb.FetchRequest{Names:[]string{"kernel.all.load", "kernel.all.pswitch"}})
+
+	assert.NoError(t, err)
+	assert.Len(t, response.Metrics, 2)
+	load, pswitch := response.Metrics[0], response.Metrics[1]
+	assert.Equal(t, "kernel.all.load", load.Name)
+	assert.Equal(t, uint32(loadPmID), load.Pmid)
+	assert.Equal(t, uint32(loadInDom), load.Indom)
+	assert.Equal(t, pcppb.Type_TYPE_FLOAT, load.Type)
+	assert.Equal(t, pcppb.Semantics_SEMANTICS_INSTANT, load.Semantics)
+	assert.True(t, fetchedAt.Equal(load.Timestamp.AsTime()))
+	assertProtoEqual(t, &pcppb.Value{Instance:"1 minute", InstanceId:instanceID(1), Value:&pcppb.Value_FloatValue{FloatValue:0.5}}, load.Values[0])
+	assert.Len(t, load.Values, 3)
+
+	assert.Equal(t, uint32(0), pswitch.Indom)
+	assert.Equal(t, pcppb.Semantics_SEMANTICS_COUNTER, pswitch.Semantics)
+	assertProtoEqual(t, &pcppb.Units{DimCount:1, Display:"count"}, pswitch.Units)
+	assertProtoEqual(t, &pcppb.Value{Value:&pcppb.Value_Uint64Value{Uint64Value:18446744073709551615}}, pswitch.Values[0])
+}
+
+func TestServer_Fetch_appliesOptions(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	response, err := pcp.Fetch(context.Background(), &pcppb.FetchRequest{
+		Names:[]string{"kernel.all.load"}, Instances:[]string{"5 minute"}, Labels:true, HelpText:true})
+
+	assert.NoError(t, err)
+	load := response.Metrics[0]
+	assert.Equal(t, map[string]string{"hostname":"web1", "agent":"linux"}, load.Labels)
+	assert.Equal(t, "1, 5 and 15 minute load average", load.Help)
+	assert.Len(t, load.Values, 1)
+	assert.Equal(t, "5 minute", load.Values[0].Instance)
+}
+
+func TestServer_Fetch_reportsUnknownNames(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	_, err := pcp.Fetch(context.Background(), &pcppb.FetchRequest{Names:[]string{"no.such.metric"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = pcp.Fetch(context.Background(), &pcppb.FetchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_Lookup_resolvesNamesAndPrefixes(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	by_name, err := pcp.Lookup(context.Background(), &pcppb.LookupRequest{Names:[]string{"kernel.all.pswitch"}})
+	assert.NoError(t, err)
+	by_prefix, err := pcp.Lookup(context.Background(), &pcppb.LookupRequest{Prefix:"kernel.all"})
+	assert.NoError(t, err)
+
+	assertProtoEqual(t, &pcppb.LookupResponse{Metrics:[]*pcppb.MetricID{{Name:"kernel.all.pswitch", Pmid:uint32(pswitchPmID)}}}, by_name)
+	assertProtoEqual(t, &pcppb.LookupResponse{Metrics:[]*pcppb.MetricID{
+		{Name:"kernel.all.load", Pmid:uint32(loadPmID)},
+		{Name:"kernel.all.pswitch", Pmid:uint32(pswitchPmID)},
+	}}, by_prefix)
+}
+
+func TestServer_reportsUnknownNamesAmongKnownOnes(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+	names := []string{"kernel.all.load", "no.such.metric"}
+
+	_, lookup_err := pcp.Lookup(context.Background(), &pcppb.LookupRequest{Names:names})
+	_, describe_err := pcp.Describe(context.Background(), &pcppb.DescribeRequest{Names:names})
+	stream, err := pcp.Watch(context.Background(), &pcppb.WatchRequest{Names:names, Interval:durationpb.New(time.Second)})
+	assert.NoError(t, err)
+	_, watch_err := stream.Recv()
+
+	for _, err := range []error{lookup_err, describe_err, watch_err} {
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, "no.such.metric: Unknown metric name", status.Convert(err).Message())
+	}
+}
+
+func TestServer_Describe_returnsDescriptorsAndText(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	response, err := pcp.Describe(context.Background(), &pcppb.DescribeRequest{Names:[]string{"kernel.all.load", "kernel.all.pswitch"}})
+
+	assert.NoError(t, err)
+	assertProtoEqual(t, &pcppb.DescribeResponse{Descriptors:[]*pcppb.Descriptor{
+		{Name:"kernel.all.load", Pmid:uint32(loadPmID), Indom:uint32(loadInDom), Type:pcppb.Type_TYPE_FLOAT,
+			Semantics:pcppb.Semantics_SEMANTICS_INSTANT, Units:&pcppb.Units{},
+			OneLineText:"1, 5 and 15 minute load average", HelpText:"Load averages over three intervals",
+			Labels:map[string]string{"hostname":"web1", "agent":"linux"}},
+		{Name:"kernel.all.pswitch", Pmid:uint32(pswitchPmID), Type:pcppb.Type_TYPE_U64,
+			Semantics:pcppb.Semantics_SEMANTICS_COUNTER, Units:&pcppb.Units{DimCount:1, Display:"count"}},
+	}}, response)
+}
+
+func TestServer_Instances_listsByInDomOrMetric(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+	expected := &pcppb.InstancesResponse{Indom:uint32(loadInDom), Instances:[]*pcppb.Instance{
+		{Id:1, Name:"1 minute"}, {Id:5, Name:"5 minute"}, {Id:15, Name:"15 minute"}}}
+
+	by_indom, err := pcp.Instances(context.Background(), &pcppb.InstancesRequest{Domain:&pcppb.InstancesRequest_Indom{Indom:uint32(loadInDom)}})
+	assert.NoError(t, err)
+	by_name, err := pcp.Instances(context.Background(), &pcppb.InstancesRequest{Domain:&pcppb.InstancesRequest_Name{Name:"kernel.all.load"}})
+	assert.NoError(t, err)
+
+	assertProtoEqual(t, expected, by_indom)
+	assertProtoEqual(t, expected, by_name)
+}
+
+func TestServer_Instances_reportsMetricsWithoutAnInDom(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	_, err := pcp.Instances(context.Background(), &pcppb.InstancesRequest{Domain:&pcppb.InstancesRequest_Name{Name:"kernel.all.pswitch"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "kernel.all.pswitch has no instance domain", status.Convert(err).Message())
+
+	_, err = pcp.Instances(context.Background(), &pcppb.InstancesRequest{Domain:&pcppb.InstancesRequest_Indom{Indom:uint32(pmapi.PmInDomFromParts(1, 1))}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_Watch_streamsSamplesUntilCancelled(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := pcp.Watch(ctx, &pcppb.WatchRequest{Names:[]string{"kernel.all.pswitch"}, Interval:durationpb.New(time.Millisecond)})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		sample, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, "", sample.Error)
+		assert.Equal(t, "kernel.all.pswitch", sample.Metrics[0].Name)
+	}
+	cancel()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestServer_Watch_checksTheRequestBeforeStreaming(t *testing.T) {
+	pcp, done := client(t)
+	defer done()
+
+	for request, code := range map[*pcppb.WatchRequest]codes.Code{
+		{Names:[]string{"kernel.all.load"}}:codes.InvalidArgument,
+		{Interval:durationpb.New(time.Second)}:codes.InvalidArgument,
+		{Names:[]string{"no.such.metric"}, Interval:durationpb.New(time.Second)}:codes.NotFound,
+	} {
+		stream, err := pcp.Watch(context.Background(), request)
+		assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, code, status.Code(err), "%v", request)
+	}
+}
@@ -0,0 +1,66 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"sort"
+)
+
+/* MetricNames returns the sorted names of every metric under prefix in the PMNS, like pminfo(1).
+   An empty prefix lists every metric and a prefix naming a metric returns just that metric */
+func (a *agent) MetricNames(prefix string) ([]string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	names := []string{}
+	err := a.traverse(prefix, &names)
+	if(err != nil) {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (a *agent) traverse(name string, names *[]string) error {
+	children, err := a.pmapi.PmGetChildrenStatus(name)
+	if(err != nil) {
+		return err
+	}
+	if(len(children) == 0) {
+		*names = append(*names, name)
+		return nil
+	}
+	for child, leaf := range children {
+		child_name := child
+		if(name != "") {
+			child_name = name + "." + child
+		}
+		if(leaf) {
+			*names = append(*names, child_name)
+			continue
+		}
+		err = a.traverse(child_name, names)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"errors"
+)
+
+func TestAgent_MetricNames_walksThePMNSBelowThePrefix(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi}
+	mock_pmapi.On("PmGetChildrenStatus", "kernel").Return(map[string]bool{"all":false, "uname":false}, nil)
+	mock_pmapi.On("PmGetChildrenStatus", "kernel.all").Return(map[string]bool{"load":true, "cpu":false}, nil)
+	mock_pmapi.On("PmGetChildrenStatus", "kernel.all.cpu").Return(map[string]bool{"user":true, "sys":true}, nil)
+	mock_pmapi.On("PmGetChildrenStatus", "kernel.uname").Return(map[string]bool{"release":true}, nil)
+
+	names, err := agent.MetricNames("kernel")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kernel.all.cpu.sys", "kernel.all.cpu.user", "kernel.all.load", "kernel.uname.release"}, names)
+}
+
+func TestAgent_MetricNames_returnsAMetricNamedByThePrefix(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi}
+	mock_pmapi.On("PmGetChildrenStatus", "kernel.all.load").Return(map[string]bool{}, nil)
+
+	names, _ := agent.MetricNames("kernel.all.load")
+
+	assert.Equal(t, []string{"kernel.all.load"}, names)
+}
+
+func TestAgent_MetricNames_returnsErrors(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi}
+	mock_pmapi.On("PmGetChildrenStatus", "nope").Return(nil, errors.New("Unknown metric name"))
+
+	_, err := agent.MetricNames("nope")
+
+	assert.EqualError(t, err, "Unknown metric name")
+}
@@ -0,0 +1,275 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmwebapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var typeNames = map[int]string{
+	pmapi.PmType32:"32",
+	pmapi.PmTypeU32:"u32",
+	pmapi.PmType64:"64",
+	pmapi.PmTypeU64:"u64",
+	pmapi.PmTypeFloat:"float",
+	pmapi.PmTypeDouble:"double",
+	pmapi.PmTypeString:"string",
+}
+
+var semanticsNames = map[int]string{
+	pmapi.PmSemCounter:"counter",
+	pmapi.PmSemInstant:"instant",
+	pmapi.PmSemDiscrete:"discrete",
+}
+
+func (s *Server) context(session *session, request *http.Request) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	if(session.spec.Archive != "") {
+		result["archivefile"] = session.spec.Archive
+	} else if(!session.spec.Local) {
+		result["hostspec"] = session.spec.Hostspec
+	}
+	return result, nil
+}
+
+func (s *Server) metric(session *session, request *http.Request) (map[string]interface{}, error) {
+	names := requestNames(request)
+	if(len(names) == 0) {
+		prefix := request.URL.Query().Get("prefix")
+		var err error
+		names, err = session.agent.MetricNames(prefix)
+		if(err != nil) {
+			return nil, upstreamError(prefixDescription(prefix), err)
+		}
+	}
+
+	pmids, err := lookup(session.pmapi, names...)
+	if(err != nil) {
+		return nil, err
+	}
+	metrics := make([]map[string]interface{}, len(names))
+	for i, pmid := range pmids {
+		metrics[i], err = describe(session.pmapi, names[i], pmid)
+		if(err != nil) {
+			return nil, upstreamError(names[i], err)
+		}
+	}
+	return map[string]interface{}{"metrics":metrics}, nil
+}
+
+/* lookup gives the PMIDs of names, failing with a 404 when any of them is unknown */
+func lookup(context pmapi.PMAPI, names ...string) ([]pmapi.PmID, error) {
+	pmids, err := context.PmLookupName(names...)
+	if(err != nil) {
+		return nil, upstreamError(strings.Join(names, ","), err)
+	}
+	unknown := []string{}
+	for i, pmid := range pmids {
+		if(pmid == pmapi.PmIDNull) {
+			unknown = append(unknown, names[i])
+		}
+	}
+	if(len(unknown) > 0) {
+		return nil, upstreamError(strings.Join(unknown, ","), pmapi.PmError{Code:pmapi.PmErrName, Message:"Unknown metric name"})
+	}
+	return pmids, nil
+}
+
+func prefixDescription(prefix string) string {
+	if(prefix == "") {
+		return "PMNS root"
+	}
+	return prefix
+}
+
+/* describe gives a metric's descriptor, help text and labels. Missing text and labels are left
+   out, as pmproxy does */
+func describe(context pmapi.PMAPI, name string, pmid pmapi.PmID) (map[string]interface{}, error) {
+	desc, err := context.PmLookupDesc(pmid)
+	if(err != nil) {
+		return nil, err
+	}
+	units := pcpeasy.NewUnits(desc.Units).String()
+	if(units == "") {
+		units = "none"
+	}
+	metric := map[string]interface{}{
+		"name":name,
+		"pmid":pmapi.PmIDStr(pmid),
+		"type":typeNames[desc.Type],
+		"sem":semanticsNames[desc.Sem],
+		"units":units,
+	}
+	if(desc.InDom != pmapi.PmInDomNull) {
+		metric["indom"] = pmapi.PmInDomStr(desc.InDom)
+	}
+	if text, err := context.PmLookupText(pmid, pmapi.PmTextOneline); err == nil {
+		metric["text-oneline"] = text
+	}
+	if text, err := context.PmLookupText(pmid, pmapi.PmTextHelp); err == nil {
+		metric["text-help"] = text
+	}
+	if label_sets, err := context.PmLookupLabels(pmid); err == nil {
+		labels := map[string]string{}
+		for _, label_set := range label_sets {
+			if(label_set.Inst == pmapi.PmInNull) {
+				for label, value := range label_set.Labels {
+					labels[label] = value
+				}
+			}
+		}
+		metric["labels"] = labels
+	}
+	return metric, nil
+}
+
+func (s *Server) fetch(session *session, request *http.Request) (map[string]interface{}, error) {
+	names := requestNames(request)
+	if(len(names) == 0) {
+		return nil, badRequest("no metrics named, use names=")
+	}
+	metrics, err := session.agent.MetricsWithOptions(pcpeasy.FetchOptions{}, names...)
+	if(err != nil) {
+		/* Unknown names only show in the fetch as a missing value, so they are looked for apart */
+		_, lookup_err := lookup(session.pmapi, names...)
+		if(lookup_err != nil) {
+			return nil, lookup_err
+		}
+		return nil, upstreamError(strings.Join(names, ","), err)
+	}
+
+	timestamp := 0.0
+	values := make([]map[string]interface{}, len(metrics))
+	for i, metric := range metrics {
+		if(timestamp == 0) {
+			timestamp = float64(metric.Timestamp.UnixNano() / 1000) / 1e6
+		}
+		instances := make([]map[string]interface{}, len(metric.Values))
+		for j, metric_value := range metric.Values {
+			var instance interface{}
+			if(metric_value.InstanceID != pmapi.PmInNull) {
+				instance = metric_value.InstanceID
+			}
+			instances[j] = map[string]interface{}{"instance":instance, "value":jsonValue(metric_value.Value)}
+		}
+		values[i] = map[string]interface{}{"pmid":pmapi.PmIDStr(metric.PmID), "name":metric.Name, "instances":instances}
+	}
+	return map[string]interface{}{"timestamp":timestamp, "values":values}, nil
+}
+
+/* jsonValue replaces the floating point values JSON cannot hold with null */
+func jsonValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case float32:
+		if(math.IsNaN(float64(typed)) || math.IsInf(float64(typed), 0)) {
+			return nil
+		}
+	case float64:
+		if(math.IsNaN(typed) || math.IsInf(typed, 0)) {
+			return nil
+		}
+	}
+	return value
+}
+
+func (s *Server) indom(session *session, request *http.Request) (map[string]interface{}, error) {
+	query := request.URL.Query()
+	var indom pmapi.PmInDom
+	switch {
+	case query.Get("indom") != "":
+		var ok bool
+		indom, ok = parseInDom(query.Get("indom"))
+		if(!ok) {
+			return nil, badRequest("invalid indom \"%v\"", query.Get("indom"))
+		}
+	case query.Get("name") != "":
+		pmids, err := lookup(session.pmapi, query.Get("name"))
+		if(err != nil) {
+			return nil, err
+		}
+		desc, err := session.pmapi.PmLookupDesc(pmids[0])
+		if(err != nil) {
+			return nil, upstreamError(query.Get("name"), err)
+		}
+		if(desc.InDom == pmapi.PmInDomNull) {
+			return nil, badRequest("%v has no instance domain", query.Get("name"))
+		}
+		indom = desc.InDom
+	default:
+		return nil, badRequest("no instance domain named, use indom= or name=")
+	}
+
+	names, err := session.pmapi.PmGetInDom(indom)
+	if(err != nil) {
+		return nil, upstreamError(pmapi.PmInDomStr(indom), err)
+	}
+	ids := make([]int, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	instances := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		instances[i] = map[string]interface{}{"instance":id, "name":names[id]}
+	}
+	return map[string]interface{}{"indom":pmapi.PmInDomStr(indom), "instances":instances}, nil
+}
+
+/* requestNames collects the metric names from names= (comma separated) and name= parameters */
+func requestNames(request *http.Request) []string {
+	query := request.URL.Query()
+	names := []string{}
+	for _, list := range query["names"] {
+		for _, name := range strings.Split(list, ",") {
+			if(name != "") {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range query["name"] {
+		names = append(names, name)
+	}
+	return names
+}
+
+/* parseInDom reads an instance domain as domain.serial, or as a plain number */
+func parseInDom(text string) (pmapi.PmInDom, bool) {
+	parts := strings.Split(text, ".")
+	if(len(parts) == 1) {
+		number, err := strconv.ParseUint(text, 10, 32)
+		return pmapi.PmInDom(number), err == nil
+	}
+	if(len(parts) != 2) {
+		return 0, false
+	}
+	domain, domain_err := strconv.Atoi(parts[0])
+	serial, serial_err := strconv.Atoi(parts[1])
+	if(domain_err != nil || serial_err != nil) {
+		return 0, false
+	}
+	return pmapi.PmInDomFromParts(domain, serial), true
+}
@@ -0,0 +1,332 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package pmwebapi serves PCP metrics over HTTP with the /pmapi endpoints of pmproxy(1), so web
+clients written for pmproxy can use a small Go service instead.
+
+	GET /pmapi/context?hostspec=HOST&polltimeout=SECONDS
+	GET /pmapi/context?archivefile=PATH
+	GET /pmapi/metric?names=NAME,...   or   ?prefix=PREFIX
+	GET /pmapi/fetch?names=NAME,...
+	GET /pmapi/indom?indom=DOMAIN.SERIAL   or   ?name=METRIC
+
+Requests name a context with context=ID. Without one, a context is created from the hostspec,
+archivefile or local parameters (a host of localhost by default) and its ID returned in the
+response for later requests. A context not used for its poll timeout, five seconds unless the
+client asks otherwise, is closed.
+
+Clients choose what the server connects to, so only localhost may be named until Options allows
+more: AllowedHosts lists other hosts, ArchiveDir the directory archives may be opened from and
+AllowLocal permits local contexts.
+
+Errors are returned as {"context": ID, "message": "...", "success": false} with a 400 status for
+malformed requests, 403 for contexts the options do not allow, 404 for unknown contexts, metrics,
+instance domains and instances, and 502 when a context cannot be created or pmcd fails.
+*/
+package pmwebapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ContextSpec says where a context gets its metrics from. Exactly one field is set */
+type ContextSpec struct {
+	Hostspec string
+	Archive string
+	Local bool
+}
+
+type Options struct {
+	/* How long an unused context lives when the client does not give a polltimeout. Defaults
+	   to five seconds, as pmproxy does */
+	PollTimeout time.Duration
+	/* Opens a context. Defaults to pmapi.PmNewContext */
+	Connect func(spec ContextSpec) (pmapi.PMAPI, error)
+	/* Hosts besides localhost that clients may open contexts to, as given in hostspec */
+	AllowedHosts []string
+	/* The directory archivefile names archives in. Archives cannot be opened without one */
+	ArchiveDir string
+	/* Lets clients open local contexts */
+	AllowLocal bool
+}
+
+type Server struct {
+	options Options
+	mux *http.ServeMux
+	lock sync.Mutex
+	contexts map[int]*session
+	next_id int
+	now func() time.Time
+}
+
+/* session is one client context. Its lock serialises use of the pmapi context */
+type session struct {
+	id int
+	spec ContextSpec
+	lock sync.Mutex
+	pmapi pmapi.PMAPI
+	agent agent
+	timeout time.Duration
+	expires time.Time
+}
+
+/* agent is the part of the pcpeasy agent the endpoints use */
+type agent interface {
+	MetricsWithOptions(options pcpeasy.FetchOptions, metric_names ...string) ([]pcpeasy.Metric, error)
+	MetricNames(prefix string) ([]string, error)
+}
+
+/* requestError carries the status to answer a failed request with */
+type requestError struct {
+	status int
+	message string
+}
+
+func (e requestError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return requestError{status:http.StatusBadRequest, message:fmt.Sprintf(format, args...)}
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return requestError{status:http.StatusForbidden, message:fmt.Sprintf(format, args...)}
+}
+
+/* notFoundCodes are the errors pmcd gives for names and identifiers it does not know */
+var notFoundCodes = []int{pmapi.PmErrName, pmapi.PmErrPmID, pmapi.PmErrInDom, pmapi.PmErrInst}
+
+/* upstreamError reports what failed for subject: something pmcd does not know, or a failure of
+   pmcd or the connection to it */
+func upstreamError(subject string, err error) error {
+	status := http.StatusBadGateway
+	for _, code := range notFoundCodes {
+		if(pmapi.IsPmError(err, code)) {
+			status = http.StatusNotFound
+		}
+	}
+	return requestError{status:status, message:fmt.Sprintf("%v: %v", subject, err)}
+}
+
+func NewServer(options Options) *Server {
+	if(options.PollTimeout <= 0) {
+		options.PollTimeout = 5 * time.Second
+	}
+	if(options.Connect == nil) {
+		options.Connect = connect
+	}
+	s := &Server{options:options, mux:http.NewServeMux(), contexts:make(map[int]*session), next_id:1, now:time.Now}
+	s.handle("/pmapi/context", s.context)
+	s.handle("/pmapi/metric", s.metric)
+	s.handle("/pmapi/fetch", s.fetch)
+	s.handle("/pmapi/indom", s.indom)
+	return s
+}
+
+func (s *Server) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	s.mux.ServeHTTP(response, request)
+}
+
+func connect(spec ContextSpec) (pmapi.PMAPI, error) {
+	switch {
+	case spec.Archive != "":
+		return pmapi.PmNewContext(pmapi.PmContextArchive, spec.Archive)
+	case spec.Local:
+		return pmapi.PmNewContext(pmapi.PmContextLocal, "")
+	}
+	return pmapi.PmNewContext(pmapi.PmContextHost, spec.Hostspec)
+}
+
+/* handle wraps an endpoint with context lookup and JSON encoding. The endpoint's result is
+   written with the context ID added */
+func (s *Server) handle(path string, endpoint func(*session, *http.Request) (map[string]interface{}, error)) {
+	s.mux.HandleFunc(path, func(response http.ResponseWriter, request *http.Request) {
+		session, err := s.session(request)
+		if(err != nil) {
+			writeError(response, 0, err)
+			return
+		}
+		session.lock.Lock()
+		result, err := endpoint(session, request)
+		session.lock.Unlock()
+		if(err != nil) {
+			writeError(response, session.id, err)
+			return
+		}
+		result["context"] = session.id
+		writeJSON(response, http.StatusOK, result)
+	})
+}
+
+/* session finds the context named by the request, or creates one, and extends its life */
+func (s *Server) session(request *http.Request) (*session, error) {
+	query := request.URL.Query()
+	timeout := s.options.PollTimeout
+	if(query.Get("polltimeout") != "") {
+		seconds, err := strconv.Atoi(query.Get("polltimeout"))
+		if(err != nil || seconds <= 0) {
+			return nil, badRequest("invalid polltimeout \"%v\"", query.Get("polltimeout"))
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	s.lock.Lock()
+	expired := s.expire(s.now())
+	s.lock.Unlock()
+	closeSessions(expired)
+
+	if(query.Get("context") != "") {
+		id, err := strconv.Atoi(query.Get("context"))
+		if(err != nil) {
+			return nil, badRequest("invalid context \"%v\"", query.Get("context"))
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		found, ok := s.contexts[id]
+		if(!ok) {
+			return nil, requestError{status:http.StatusNotFound, message:fmt.Sprintf("unknown context identifier %v", id)}
+		}
+		if(query.Get("polltimeout") != "") {
+			found.timeout = timeout
+		}
+		found.expires = s.now().Add(found.timeout)
+		return found, nil
+	}
+
+	spec := ContextSpec{Hostspec:query.Get("hostspec"), Archive:query.Get("archivefile"), Local:query.Get("local") != ""}
+	if(spec.Hostspec == "") {
+		spec.Hostspec = query.Get("hostname")
+	}
+	if(spec.Hostspec == "" && spec.Archive == "" && !spec.Local) {
+		spec.Hostspec = "localhost"
+	}
+	spec, err := s.allow(spec)
+	if(err != nil) {
+		return nil, err
+	}
+	/* Connecting takes as long as the host takes to answer, which other requests do not wait for */
+	context, err := s.options.Connect(spec)
+	if(err != nil) {
+		return nil, requestError{status:http.StatusBadGateway, message:err.Error()}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	created := &session{
+		id:s.next_id,
+		spec:spec,
+		pmapi:context,
+		agent:pcpeasy.NewAgentWithPMAPI(context),
+		timeout:timeout,
+		expires:s.now().Add(timeout),
+	}
+	s.next_id++
+	s.contexts[created.id] = created
+	return created, nil
+}
+
+/* allow checks the options permit a context to be opened from the spec, returning it with any
+   archive resolved within the archive directory */
+func (s *Server) allow(spec ContextSpec) (ContextSpec, error) {
+	switch {
+	case spec.Archive != "":
+		if(s.options.ArchiveDir == "") {
+			return spec, forbidden("archives cannot be opened")
+		}
+		dir := filepath.Clean(s.options.ArchiveDir)
+		path := spec.Archive
+		if(!filepath.IsAbs(path)) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+		relative, err := filepath.Rel(dir, path)
+		if(err != nil || relative == ".." || strings.HasPrefix(relative, ".." + string(filepath.Separator))) {
+			return spec, forbidden("archive %v is outside the archive directory", spec.Archive)
+		}
+		spec.Archive = path
+	case spec.Local:
+		if(!s.options.AllowLocal) {
+			return spec, forbidden("local contexts cannot be opened")
+		}
+	default:
+		if(spec.Hostspec == "localhost") {
+			return spec, nil
+		}
+		for _, host := range s.options.AllowedHosts {
+			if(spec.Hostspec == host) {
+				return spec, nil
+			}
+		}
+		return spec, forbidden("host %v is not allowed", spec.Hostspec)
+	}
+	return spec, nil
+}
+
+/* expire drops and returns the contexts whose poll timeout has passed */
+func (s *Server) expire(now time.Time) []*session {
+	expired := []*session{}
+	for id, found := range s.contexts {
+		if(now.After(found.expires)) {
+			delete(s.contexts, id)
+			expired = append(expired, found)
+		}
+	}
+	return expired
+}
+
+/* closeSessions closes the contexts of expired sessions once any request still using them is done */
+func closeSessions(expired []*session) {
+	for _, found := range expired {
+		if closer, ok := found.pmapi.(io.Closer); ok {
+			found.lock.Lock()
+			closer.Close()
+			found.lock.Unlock()
+		}
+	}
+}
+
+func writeError(response http.ResponseWriter, context int, err error) {
+	status := http.StatusBadRequest
+	if request_err, ok := err.(requestError); ok {
+		status = request_err.status
+	}
+	body := map[string]interface{}{"message":err.Error(), "success":false}
+	if(context != 0) {
+		body["context"] = context
+	}
+	writeJSON(response, status, body)
+}
+
+func writeJSON(response http.ResponseWriter, status int, body interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	json.NewEncoder(response).Encode(body)
+}
@@ -0,0 +1,330 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmwebapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var loadInDom = pmapitest.SampleLoadInDom
+var loadPmID = pmapitest.SampleLoadPmID
+
+/* server returns a Server over fresh fake contexts, recording the specs it was asked to open */
+func server(specs *[]ContextSpec) *Server {
+	return NewServer(Options{
+		Connect:func(spec ContextSpec) (pmapi.PMAPI, error) {
+			if(specs != nil) {
+				*specs = append(*specs, spec)
+			}
+			if(spec.Hostspec == "unreachable") {
+				return nil, errors.New("No route to host")
+			}
+			return pmapitest.NewSample(), nil
+		},
+		AllowedHosts:[]string{"web1", "unreachable"},
+		ArchiveDir:"/var/log/pcp/pmlogger",
+	})
+}
+
+/* closingContext records whether it was closed */
+type closingContext struct {
+	*pmapitest.Context
+	closed bool
+}
+
+func (c *closingContext) Close() error {
+	c.closed = true
+	return nil
+}
+
+func get(t *testing.T, s *Server, url string) (int, map[string]interface{}) {
+	response := httptest.NewRecorder()
+	s.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	body := map[string]interface{}{}
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	assert.NoError(t, err, response.Body.String())
+	return response.Code, body
+}
+
+func getJSON(t *testing.T, s *Server, url string) (int, string) {
+	response := httptest.NewRecorder()
+	s.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+	return response.Code, response.Body.String()
+}
+
+func TestServer_context_createsContextsFromTheRequest(t *testing.T) {
+	specs := []ContextSpec{}
+	s := server(&specs)
+
+	_, first := get(t, s, "/pmapi/context?hostspec=web1")
+	_, second := get(t, s, "/pmapi/context?archivefile=/var/log/pcp/pmlogger/web1/20231114")
+	_, third := get(t, s, "/pmapi/context")
+
+	assert.Equal(t, map[string]interface{}{"context":float64(1), "hostspec":"web1"}, first)
+	assert.Equal(t, map[string]interface{}{"context":float64(2), "archivefile":"/var/log/pcp/pmlogger/web1/20231114"}, second)
+	assert.Equal(t, map[string]interface{}{"context":float64(3), "hostspec":"localhost"}, third)
+	assert.Equal(t, []ContextSpec{{Hostspec:"web1"}, {Archive:"/var/log/pcp/pmlogger/web1/20231114"}, {Hostspec:"localhost"}}, specs)
+}
+
+func TestServer_reusesANamedContext(t *testing.T) {
+	specs := []ContextSpec{}
+	s := server(&specs)
+
+	get(t, s, "/pmapi/context?hostspec=web1")
+	status, body := get(t, s, "/pmapi/fetch?context=1&names=kernel.all.load")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["context"])
+	assert.Len(t, specs, 1)
+}
+
+func TestServer_expiresContextsAfterTheirPollTimeout(t *testing.T) {
+	s := server(nil)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	get(t, s, "/pmapi/context?polltimeout=10")
+	now = now.Add(9 * time.Second)
+	status, _ := get(t, s, "/pmapi/fetch?context=1&names=kernel.all.load")
+	assert.Equal(t, http.StatusOK, status)
+	now = now.Add(9 * time.Second)
+	status, _ = get(t, s, "/pmapi/fetch?context=1&names=kernel.all.load")
+	assert.Equal(t, http.StatusOK, status)
+	now = now.Add(11 * time.Second)
+	status, body := get(t, s, "/pmapi/fetch?context=1&names=kernel.all.load")
+
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, map[string]interface{}{"message":"unknown context identifier 1", "success":false}, body)
+}
+
+func TestServer_closesTheContextsOfExpiredSessions(t *testing.T) {
+	opened := []*closingContext{}
+	s := NewServer(Options{Connect:func(spec ContextSpec) (pmapi.PMAPI, error) {
+		context := &closingContext{Context:pmapitest.NewSample()}
+		opened = append(opened, context)
+		return context, nil
+	}})
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	get(t, s, "/pmapi/context?polltimeout=10")
+	get(t, s, "/pmapi/context?polltimeout=20")
+	now = now.Add(11 * time.Second)
+	get(t, s, "/pmapi/fetch?context=2&names=kernel.all.load")
+
+	assert.True(t, opened[0].closed)
+	assert.False(t, opened[1].closed)
+}
+
+func TestServer_onlyOpensContextsTheOptionsAllow(t *testing.T) {
+	specs := []ContextSpec{}
+	s := server(&specs)
+	for _, url := range []string{
+		"/pmapi/context?hostspec=db1",
+		"/pmapi/context?hostname=db1",
+		"/pmapi/context?local=true",
+		"/pmapi/context?archivefile=/etc/passwd",
+		"/pmapi/context?archivefile=../../../../etc/passwd",
+		"/pmapi/context?archivefile=/var/log/pcp/pmlogger/../../../../etc/passwd",
+		"/pmapi/context?archivefile=/var/log/pcp/pmlogger-other/web1/20231114",
+	} {
+		status, body := get(t, s, url)
+		assert.Equal(t, http.StatusForbidden, status, url)
+		assert.Equal(t, false, body["success"], url)
+	}
+	assert.Empty(t, specs)
+}
+
+func TestServer_resolvesArchivesWithinTheArchiveDirectory(t *testing.T) {
+	specs := []ContextSpec{}
+	s := server(&specs)
+
+	get(t, s, "/pmapi/context?archivefile=web1/20231114")
+	get(t, s, "/pmapi/context?archivefile=/var/log/pcp/pmlogger/web1/../web2/20231114")
+
+	assert.Equal(t, []ContextSpec{{Archive:"/var/log/pcp/pmlogger/web1/20231114"}, {Archive:"/var/log/pcp/pmlogger/web2/20231114"}}, specs)
+}
+
+func TestServer_opensOnlyLocalhostByDefault(t *testing.T) {
+	specs := []ContextSpec{}
+	s := NewServer(Options{Connect:func(spec ContextSpec) (pmapi.PMAPI, error) {
+		specs = append(specs, spec)
+		return pmapitest.NewSample(), nil
+	}})
+
+	localhost, _ := get(t, s, "/pmapi/context?hostspec=localhost")
+	other, _ := get(t, s, "/pmapi/context?hostspec=web1")
+	archive, _ := get(t, s, "/pmapi/context?archivefile=/var/log/pcp/pmlogger/web1/20231114")
+	local, _ := get(t, s, "/pmapi/context?local=true")
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden}, []int{localhost, other, archive, local})
+	assert.Equal(t, []ContextSpec{{Hostspec:"localhost"}}, specs)
+}
+
+func TestServer_reportsContextsThatCannotBeCreated(t *testing.T) {
+	status, body := get(t, server(nil), "/pmapi/context?hostspec=unreachable")
+
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Equal(t, "No route to host", body["message"])
+}
+
+func TestServer_metric_describesNamedMetrics(t *testing.T) {
+	status, body := getJSON(t, server(nil), "/pmapi/metric?names=kernel.all.load,kernel.all.pswitch")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"context":1,"metrics":[
+		{"name":"kernel.all.load","pmid":"60.2.0","indom":"60.2","type":"float","sem":"instant","units":"none",
+		 "labels":{"agent":"linux","hostname":"web1"},"text-oneline":"1, 5 and 15 minute load average","text-help":"Load averages over three intervals"},
+		{"name":"kernel.all.pswitch","pmid":"60.0.13","type":"u64","sem":"counter","units":"count","labels":{}}
+	]}`, body)
+}
+
+func TestServer_metric_listsMetricsUnderAPrefix(t *testing.T) {
+	_, body := get(t, server(nil), "/pmapi/metric?prefix=kernel.all")
+
+	metrics := body["metrics"].([]interface{})
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "kernel.all.load", metrics[0].(map[string]interface{})["name"])
+	assert.Equal(t, "kernel.all.pswitch", metrics[1].(map[string]interface{})["name"])
+}
+
+func TestServer_metric_reportsUnknownNames(t *testing.T) {
+	status, body := get(t, server(nil), "/pmapi/metric?names=no.such.metric")
+
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, map[string]interface{}{"context":float64(1), "message":"no.such.metric: Unknown metric name", "success":false}, body)
+}
+
+func TestServer_fetch_returnsValuesByInstance(t *testing.T) {
+	status, body := getJSON(t, server(nil), "/pmapi/fetch?names=kernel.all.load&name=kernel.all.pswitch")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"context":1,"timestamp":1700000000.25,"values":[
+		{"pmid":"60.2.0","name":"kernel.all.load","instances":[
+			{"instance":1,"value":0.5},{"instance":5,"value":0.25},{"instance":15,"value":0.125}]},
+		{"pmid":"60.0.13","name":"kernel.all.pswitch","instances":[{"instance":null,"value":18446744073709551615}]}
+	]}`, body)
+}
+
+func TestServer_fetch_reportsUnknownNamesAsNotFound(t *testing.T) {
+	status, body := get(t, server(nil), "/pmapi/fetch?names=kernel.all.load,no.such.metric")
+
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "no.such.metric: Unknown metric name", body["message"])
+}
+
+/* brokenContext loses its connection to pmcd */
+type brokenContext struct {
+	*pmapitest.Context
+}
+
+func (c brokenContext) PmFetch(pmids ...pmapi.PmID) (*pmapi.PmResult, error) {
+	return nil, pmapi.PmError{Code:pmapi.PmErrIPC, Message:"IPC protocol failure"}
+}
+
+func TestServer_fetch_reportsFailuresOfPmcdAsBadGateway(t *testing.T) {
+	s := NewServer(Options{Connect:func(spec ContextSpec) (pmapi.PMAPI, error) {
+		return brokenContext{pmapitest.NewSample()}, nil
+	}})
+
+	status, body := get(t, s, "/pmapi/fetch?names=kernel.all.load")
+
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Equal(t, "kernel.all.load: IPC protocol failure", body["message"])
+}
+
+func TestServer_servesOtherContextsWhileConnecting(t *testing.T) {
+	connecting, release, connected := make(chan bool), make(chan bool), make(chan bool)
+	s := NewServer(Options{
+		Connect:func(spec ContextSpec) (pmapi.PMAPI, error) {
+			if(spec.Hostspec == "slow") {
+				connecting <- true
+				<-release
+			}
+			return pmapitest.NewSample(), nil
+		},
+		AllowedHosts:[]string{"slow"},
+	})
+	get(t, s, "/pmapi/context")
+
+	go func() {
+		getJSON(t, s, "/pmapi/context?hostspec=slow")
+		close(connected)
+	}()
+	<-connecting
+	status, _ := getJSON(t, s, "/pmapi/fetch?context=1&names=kernel.all.load")
+	close(release)
+	<-connected
+
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestServer_fetch_requiresNames(t *testing.T) {
+	status, body := get(t, server(nil), "/pmapi/fetch")
+
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "no metrics named, use names=", body["message"])
+}
+
+func TestServer_indom_listsInstancesByInDomOrMetric(t *testing.T) {
+	s := server(nil)
+	expected := `{"context":1,"indom":"60.2","instances":[
+		{"instance":1,"name":"1 minute"},{"instance":5,"name":"5 minute"},{"instance":15,"name":"15 minute"}]}`
+
+	_, by_indom := getJSON(t, s, "/pmapi/indom?indom=60.2")
+	_, by_name := getJSON(t, s, "/pmapi/indom?context=1&name=kernel.all.load")
+
+	assert.JSONEq(t, expected, by_indom)
+	assert.JSONEq(t, expected, by_name)
+}
+
+func TestServer_indom_reportsMetricsWithoutAnInDom(t *testing.T) {
+	status, body := get(t, server(nil), "/pmapi/indom?name=kernel.all.pswitch")
+
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "kernel.all.pswitch has no instance domain", body["message"])
+}
+
+func TestServer_indom_reportsUnknownInstanceDomainsAsNotFound(t *testing.T) {
+	status, _ := get(t, server(nil), "/pmapi/indom?indom=99.99")
+
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestParseInDom(t *testing.T) {
+	indom, ok := parseInDom("60.2")
+	assert.True(t, ok)
+	assert.Equal(t, loadInDom, indom)
+	indom, ok = parseInDom("251658242")
+	assert.True(t, ok)
+	assert.Equal(t, loadInDom, indom)
+	_, ok = parseInDom("60.x")
+	assert.False(t, ok)
+}
@@ -0,0 +1,181 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package promcollector exposes PCP metrics fetched through pcpeasy as a Prometheus collector.
+
+PCP names are prefixed with a namespace and have their dots replaced, so kernel.all.load becomes
+pcp_kernel_all_load. Counter semantics map to Prometheus counters (suffixed _total) and instant
+and discrete semantics to gauges. Each instance is labelled with instname and instid, alongside
+the PCP labels of the metric and instance. String metrics are skipped.
+*/
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	InstanceNameLabel = "instname"
+	InstanceIDLabel = "instid"
+)
+
+/* Source is satisfied by the agent returned from pcpeasy.NewAgent */
+type Source interface {
+	MetricsWithLabels(metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+type Collector struct {
+	source Source
+	namespace string
+	metric_names []string
+	error_desc *prometheus.Desc
+}
+
+func New(source Source, namespace string, metric_names ...string) *Collector {
+	return &Collector{
+		source:source,
+		namespace:namespace,
+		metric_names:metric_names,
+		error_desc:prometheus.NewDesc(prometheusName(namespace, "scrape_error", ""), "Error fetching metrics from pmcd", nil, nil),
+	}
+}
+
+/* Describe sends nothing, making this an unchecked collector. The metrics and their labels are
+   only known once pmcd has been asked for them */
+func (c *Collector) Describe(descs chan<- *prometheus.Desc) {
+}
+
+func (c *Collector) Collect(metrics chan<- prometheus.Metric) {
+	pcp_metrics, err := c.source.MetricsWithLabels(c.metric_names...)
+	if(err != nil) {
+		metrics <- prometheus.NewInvalidMetric(c.error_desc, err)
+		return
+	}
+	for _, pcp_metric := range pcp_metrics {
+		c.collectMetric(metrics, pcp_metric)
+	}
+}
+
+func (c *Collector) collectMetric(metrics chan<- prometheus.Metric, pcp_metric pcpeasy.Metric) {
+	if(pcp_metric.Type == reflect.String) {
+		return
+	}
+
+	label_names := labelNames(pcp_metric)
+	desc := prometheus.NewDesc(
+		prometheusName(c.namespace, pcp_metric.Name, pcp_metric.Semantics),
+		helpText(pcp_metric),
+		label_names,
+		nil,
+	)
+	value_type := valueType(pcp_metric.Semantics)
+
+	for _, metric_value := range pcp_metric.Values {
+		value, ok := metric_value.Float()
+		if(!ok) {
+			continue
+		}
+		metric, err := prometheus.NewConstMetric(desc, value_type, value, labelValues(label_names, metric_value)...)
+		if(err != nil) {
+			metric = prometheus.NewInvalidMetric(desc, err)
+		}
+		metrics <- metric
+	}
+}
+
+func valueType(semantics string) prometheus.ValueType {
+	if(semantics == "counter") {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
+
+func helpText(pcp_metric pcpeasy.Metric) string {
+	units := pcp_metric.Units.String()
+	if(units == "") {
+		return fmt.Sprintf("PCP metric %v", pcp_metric.Name)
+	}
+	return fmt.Sprintf("PCP metric %v (%v)", pcp_metric.Name, units)
+}
+
+/* prometheusName joins the namespace and PCP name, replacing anything Prometheus does not allow
+   with underscores, and appends _total to counters */
+func prometheusName(namespace string, pcp_name string, semantics string) string {
+	name := pcpeasy.PrometheusMetricName(pcp_name)
+	if(namespace != "") {
+		name = pcpeasy.PrometheusMetricName(namespace) + "_" + name
+	}
+	if(semantics == "counter" && !strings.HasSuffix(name, "_total")) {
+		name = name + "_total"
+	}
+	return name
+}
+
+/* labelNames returns the sorted union of label names across a metric's values. Prometheus requires
+   every series in a family to carry the same label names, so values lacking one get it empty */
+func labelNames(pcp_metric pcpeasy.Metric) []string {
+	names := make(map[string]bool)
+	for _, metric_value := range pcp_metric.Values {
+		for name := range promLabels(metric_value) {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func labelValues(label_names []string, metric_value pcpeasy.MetricValue) []string {
+	labels := promLabels(metric_value)
+	values := make([]string, len(label_names))
+	for i, name := range label_names {
+		values[i] = labels[name]
+	}
+	return values
+}
+
+/* promLabels converts a value's PCP labels and instance into Prometheus label pairs. The instance
+   labels win over a PCP label that sanitizes to the same name */
+func promLabels(metric_value pcpeasy.MetricValue) map[string]string {
+	labels := make(map[string]string)
+	for name, value := range metric_value.Labels {
+		prom_name := pcpeasy.PrometheusLabelName(name)
+		if(strings.HasPrefix(prom_name, "__")) {
+			/* Reserved for Prometheus' internal use */
+			continue
+		}
+		labels[prom_name] = value
+	}
+	if(metric_value.Instance != "") {
+		labels[InstanceNameLabel] = metric_value.Instance
+		labels[InstanceIDLabel] = strconv.Itoa(metric_value.InstanceID)
+	}
+	return labels
+}
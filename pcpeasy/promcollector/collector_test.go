@@ -0,0 +1,86 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package promcollector
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmapitest"
+	"errors"
+	"strings"
+)
+
+func TestCollector_exposesCountersAndGauges(t *testing.T) {
+	agent := pcpeasy.NewAgentWithPMAPI(pmapitest.NewSample())
+	collector := New(agent, "pcp", "disk.dev.read_bytes", "kernel.all.load", "kernel.uname.release")
+
+	expected := `
+# HELP pcp_disk_dev_read_bytes_total PCP metric disk.dev.read_bytes (Kbyte)
+# TYPE pcp_disk_dev_read_bytes_total counter
+pcp_disk_dev_read_bytes_total{agent="linux",device_type="",hostname="web1",instid="0",instname="sda"} 100
+pcp_disk_dev_read_bytes_total{agent="linux",device_type="ssd",hostname="web1",instid="1",instname="sdb"} 200
+# HELP pcp_kernel_all_load PCP metric kernel.all.load
+# TYPE pcp_kernel_all_load gauge
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="1",instname="1 minute",minutes=""} 0.5
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="15",instname="15 minute",minutes=""} 0.125
+pcp_kernel_all_load{agent="linux",hostname="web1",instid="5",instname="5 minute",minutes="5"} 0.25
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected))
+
+	assert.NoError(t, err)
+}
+
+type failingSource struct{}
+
+func (s failingSource) MetricsWithLabels(metric_names ...string) ([]pcpeasy.Metric, error) {
+	return nil, errors.New("Connection refused")
+}
+
+func TestCollector_reportsFetchErrorsToTheRegistry(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(New(failingSource{}, "pcp", "kernel.all.load"))
+
+	_, err := registry.Gather()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Connection refused")
+}
+
+func TestPrometheusName(t *testing.T) {
+	assert.Equal(t, "pcp_kernel_all_load", prometheusName("pcp", "kernel.all.load", "instant"))
+	assert.Equal(t, "pcp_network_interface_in_bytes_total", prometheusName("pcp", "network.interface.in.bytes", "counter"))
+	assert.Equal(t, "pcp_hinv_ncpu", prometheusName("pcp", "hinv.ncpu", "discrete"))
+	assert.Equal(t, "x_total", prometheusName("", "x_total", "counter"))
+	assert.Equal(t, "_9p_ops", prometheusName("", "9p-ops", "instant"))
+}
+
+func TestPromLabels_dropsReservedNamesAndPrefersInstanceLabels(t *testing.T) {
+	labels := promLabels(pcpeasy.MetricValue{
+		Instance:"sda",
+		InstanceID:0,
+		Labels:map[string]string{"__name__":"x", "instname":"overridden", "user-id":"42"},
+	})
+
+	assert.Equal(t, map[string]string{"instname":"sda", "instid":"0", "user_id":"42"}, labels)
+}
@@ -0,0 +1,50 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+/* PrometheusMetricName maps a string to the [a-zA-Z_:][a-zA-Z0-9_:]* Prometheus and OpenMetrics
+   allow in metric names, replacing anything else with an underscore. A leading digit is kept
+   behind an underscore */
+func PrometheusMetricName(name string) string {
+	return sanitizePrometheusName(name, true)
+}
+
+/* PrometheusLabelName maps a string to the [a-zA-Z_][a-zA-Z0-9_]* allowed in label names */
+func PrometheusLabelName(name string) string {
+	return sanitizePrometheusName(name, false)
+}
+
+func sanitizePrometheusName(name string, allow_colons bool) string {
+	sanitized := []rune{}
+	for i, r := range name {
+		if(i == 0 && r >= '0' && r <= '9') {
+			sanitized = append(sanitized, '_')
+		}
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || (r == ':' && allow_colons)
+		if(valid) {
+			sanitized = append(sanitized, r)
+		} else {
+			sanitized = append(sanitized, '_')
+		}
+	}
+	return string(sanitized)
+}
@@ -0,0 +1,39 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricName_replacesWhatPrometheusDoesNotAllow(t *testing.T) {
+	assert.Equal(t, "kernel_all_load", PrometheusMetricName("kernel.all.load"))
+	assert.Equal(t, "network_interface_in_bytes_eth0_1", PrometheusMetricName("network.interface.in.bytes-eth0/1"))
+	assert.Equal(t, "_9p_io", PrometheusMetricName("9p.io"))
+	assert.Equal(t, "job:rate_5m", PrometheusMetricName("job:rate.5m"))
+}
+
+func TestPrometheusLabelName_replacesColonsToo(t *testing.T) {
+	assert.Equal(t, "device_type", PrometheusLabelName("device.type"))
+	assert.Equal(t, "job_rate", PrometheusLabelName("job:rate"))
+	assert.Equal(t, "_0day", PrometheusLabelName("0day"))
+}
@@ -0,0 +1,122 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"reflect"
+	"time"
+)
+
+/*
+RateConverter turns successive fetches of counters into per-second rates, the way pmval(1) and
+pmrep(1) report them. A counter's units lose a time dimension: a Kbyte counter becomes
+"Kbyte / sec" and a millisec counter becomes a dimensionless utilisation. Metrics that are not
+counters pass through unchanged.
+
+A counter instance has no rate on its first sample, or when it goes backwards (a wrap or pmcd
+restart); the value is left out and becomes the base for the next rate.
+*/
+type RateConverter struct {
+	previous map[string]map[int]rateBase
+}
+
+type rateBase struct {
+	value MetricValue
+	timestamp time.Time
+}
+
+func NewRateConverter() *RateConverter {
+	return &RateConverter{previous:make(map[string]map[int]rateBase)}
+}
+
+func (c *RateConverter) Convert(metrics []Metric) []Metric {
+	converted := make([]Metric, len(metrics))
+	for i, metric := range metrics {
+		converted[i] = c.convert(metric)
+	}
+	return converted
+}
+
+func (c *RateConverter) convert(metric Metric) Metric {
+	if(metric.Semantics != "counter") {
+		return metric
+	}
+	previous := c.previous[metric.Name]
+	current := make(map[int]rateBase)
+	rates := []MetricValue{}
+	for _, value := range metric.Values {
+		current[value.InstanceID] = rateBase{value:value, timestamp:metric.Timestamp}
+		base, found := previous[value.InstanceID]
+		if(!found) {
+			continue
+		}
+		seconds := metric.Timestamp.Sub(base.timestamp).Seconds()
+		delta, ok := counterDelta(base.value.Value, value.Value)
+		if(!ok || seconds <= 0) {
+			continue
+		}
+		rate := value
+		rate.Value = delta / seconds
+		rates = append(rates, rate)
+	}
+	c.previous[metric.Name] = current
+
+	per_second := metric.Units
+	per_second.ScaleTime = TimeSec
+	scale, err := metric.Units.ConvertValue(1, per_second)
+	if(err != nil) {
+		scale = 1
+	}
+	for i := range rates {
+		rates[i].Value = rates[i].Value.(float64) * scale
+	}
+	per_second.DimTime--
+
+	metric.Values = rates
+	metric.Units = per_second
+	metric.Semantics = "instant"
+	metric.Type = reflect.Float64
+	metric.PmType = pmapi.PmTypeDouble
+	return metric
+}
+
+/* counterDelta returns how far a counter moved, computing integer differences exactly before
+   converting them. ok is false when the counter went backwards or is not numeric */
+func counterDelta(previous interface{}, current interface{}) (float64, bool) {
+	switch c := current.(type) {
+	case uint32:
+		p, ok := previous.(uint32)
+		return float64(c - p), ok && c >= p
+	case uint64:
+		p, ok := previous.(uint64)
+		return float64(c - p), ok && c >= p
+	case int32:
+		p, ok := previous.(int32)
+		return float64(int64(c) - int64(p)), ok && c >= p
+	case int64:
+		p, ok := previous.(int64)
+		return float64(c - p), ok && c >= p
+	}
+	p, p_ok := (MetricValue{Value:previous}).Float()
+	f, c_ok := (MetricValue{Value:current}).Float()
+	return f - p, p_ok && c_ok && f >= p
+}
@@ -0,0 +1,91 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"reflect"
+	"time"
+)
+
+var rateStart = time.Unix(1700000000, 0)
+
+func counter(at time.Duration, units Units, values ...MetricValue) []Metric {
+	return []Metric{{Name:"disk.dev.read_bytes", Semantics:"counter", Type:reflect.Uint64, PmType:pmapi.PmTypeU64,
+		Units:units, Timestamp:rateStart.Add(at), Values:values}}
+}
+
+var kbytes = Units{DimSpace:1, ScaleSpace:SpaceKByte}
+
+func TestRateConverter_convertsCountersToRatesPerSecond(t *testing.T) {
+	converter := NewRateConverter()
+
+	first := converter.Convert(counter(0, kbytes, MetricValue{Instance:"sda", InstanceID:0, Value:uint64(100)}))
+	second := converter.Convert(counter(2 * time.Second, kbytes, MetricValue{Instance:"sda", InstanceID:0, Value:uint64(300)}))
+
+	assert.Empty(t, first[0].Values)
+	assert.Equal(t, []MetricValue{{Instance:"sda", InstanceID:0, Value:100.0}}, second[0].Values)
+	assert.Equal(t, "Kbyte / sec", second[0].Units.String())
+	assert.Equal(t, "instant", second[0].Semantics)
+	assert.Equal(t, reflect.Float64, second[0].Type)
+	assert.Equal(t, pmapi.PmTypeDouble, second[0].PmType)
+}
+
+func TestRateConverter_turnsTimeCountersIntoUtilisation(t *testing.T) {
+	converter := NewRateConverter()
+	millisec := Units{DimTime:1, ScaleTime:TimeMSec}
+
+	converter.Convert(counter(0, millisec, MetricValue{Value:uint64(1000)}))
+	converted := converter.Convert(counter(4 * time.Second, millisec, MetricValue{Value:uint64(3000)}))
+
+	assert.Equal(t, 0.5, converted[0].Values[0].Value)
+	assert.True(t, converted[0].Units.IsDimensionless())
+}
+
+func TestRateConverter_rebasesCountersThatGoBackwards(t *testing.T) {
+	converter := NewRateConverter()
+
+	converter.Convert(counter(0, kbytes, MetricValue{Value:uint64(500)}))
+	wrapped := converter.Convert(counter(time.Second, kbytes, MetricValue{Value:uint64(10)}))
+	after := converter.Convert(counter(2 * time.Second, kbytes, MetricValue{Value:uint64(30)}))
+
+	assert.Empty(t, wrapped[0].Values)
+	assert.Equal(t, 20.0, after[0].Values[0].Value)
+}
+
+func TestRateConverter_tracksInstancesSeparately(t *testing.T) {
+	converter := NewRateConverter()
+
+	converter.Convert(counter(0, kbytes, MetricValue{Instance:"sda", InstanceID:0, Value:uint64(0)}))
+	converted := converter.Convert(counter(time.Second, kbytes,
+		MetricValue{Instance:"sda", InstanceID:0, Value:uint64(18446744073709551615)},
+		MetricValue{Instance:"sdb", InstanceID:1, Value:uint64(5)}))
+
+	assert.Equal(t, []MetricValue{{Instance:"sda", InstanceID:0, Value:float64(18446744073709551615)}}, converted[0].Values)
+}
+
+func TestRateConverter_passesOtherMetricsThrough(t *testing.T) {
+	metrics := []Metric{{Name:"kernel.all.load", Semantics:"instant", Values:[]MetricValue{{Value:float32(0.5)}}}}
+
+	assert.Equal(t, metrics, NewRateConverter().Convert(metrics))
+}
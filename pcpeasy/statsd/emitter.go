@@ -0,0 +1,217 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package statsd sends PCP metrics fetched through pcpeasy to a StatsD or DogStatsD server over UDP.
+
+Instant and discrete metrics are sent as gauges. Counter metrics are sent as StatsD counters
+carrying the increase since the previous Emit, so the first Emit only records where each counter
+starts, and a counter that goes backwards (a reset or wrap) is skipped once and measured afresh
+from its new value. Counters missing from an Emit, such as those of exited processes, are
+forgotten and start afresh if they return. String metrics are skipped.
+
+With plain StatsD the instance name is appended to the metric name as another node, encoded as
+in the graphite package. With DogStatsD the instance and PCP labels become tags instead.
+*/
+package statsd
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy/graphite"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const InstanceTag = "instance"
+
+type EmitterOptions struct {
+	/* Prepended to every metric name, such as "pcp" */
+	Prefix string
+	/* Send DogStatsD tags rather than folding instances into the name */
+	DogStatsD bool
+	/* Sent with every DogStatsD metric, as "key:value" or "key" */
+	Tags []string
+	/* Lines are packed into datagrams up to this size. Defaults to 1432, which fits an Ethernet MTU */
+	MaxPacketSize int
+}
+
+type Emitter struct {
+	connection net.Conn
+	options EmitterOptions
+	/* The value of each counter series in the last Emit, keyed by name and instance */
+	previous map[string]interface{}
+}
+
+func NewEmitter(address string, options EmitterOptions) (*Emitter, error) {
+	if(options.MaxPacketSize <= 0) {
+		options.MaxPacketSize = 1432
+	}
+	connection, err := net.Dial("udp", address)
+	if(err != nil) {
+		return nil, err
+	}
+	return &Emitter{connection:connection, options:options, previous:make(map[string]interface{})}, nil
+}
+
+/* Emit sends one sample of metrics. Emit is not safe for concurrent use */
+func (e *Emitter) Emit(metrics []pcpeasy.Metric) error {
+	lines := []string{}
+	current := make(map[string]interface{}, len(e.previous))
+	for _, metric := range metrics {
+		for _, metric_value := range metric.Values {
+			lines = append(lines, e.lines(metric, metric_value, current)...)
+		}
+	}
+	e.previous = current
+	return e.send(lines)
+}
+
+func (e *Emitter) Close() error {
+	return e.connection.Close()
+}
+
+/* lines renders one value, recording counters in current */
+func (e *Emitter) lines(metric pcpeasy.Metric, metric_value pcpeasy.MetricValue, current map[string]interface{}) []string {
+	name := e.name(metric.Name, metric_value.Instance)
+	tags := e.tags(metric_value)
+
+	if(metric.Semantics == "counter") {
+		series := name + "|" + strconv.Itoa(metric_value.InstanceID)
+		previous, seen := e.previous[series]
+		current[series] = metric_value.Value
+		if(!seen) {
+			return nil
+		}
+		delta, ok := counterDelta(previous, metric_value.Value)
+		if(!ok) {
+			return nil
+		}
+		return []string{name + ":" + delta + "|c" + tags}
+	}
+
+	value, ok := metric_value.Float()
+	if(!ok || math.IsNaN(value) || math.IsInf(value, 0)) {
+		return nil
+	}
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if(value < 0 && !e.options.DogStatsD) {
+		/* Plain StatsD reads a signed gauge as a change to the current value, so zero it first */
+		return []string{name + ":0|g" + tags, name + ":" + formatted + "|g" + tags}
+	}
+	return []string{name + ":" + formatted + "|g" + tags}
+}
+
+func (e *Emitter) name(metric_name string, instance string) string {
+	name := metric_name
+	if(e.options.Prefix != "") {
+		name = strings.Trim(e.options.Prefix, ".") + "." + name
+	}
+	if(instance != "" && !e.options.DogStatsD) {
+		name = name + "." + graphite.EncodeInstance(instance)
+	}
+	return nameEscaper.Replace(name)
+}
+
+/* tags renders the DogStatsD tag section, sorted so a series is always written the same way */
+func (e *Emitter) tags(metric_value pcpeasy.MetricValue) string {
+	if(!e.options.DogStatsD) {
+		return ""
+	}
+	tags := []string{}
+	for _, tag := range e.options.Tags {
+		tags = append(tags, tagEscaper.Replace(tag))
+	}
+	label_tags := []string{}
+	for name, value := range metric_value.Labels {
+		label_tags = append(label_tags, tagNameEscaper.Replace(name) + ":" + tagEscaper.Replace(value))
+	}
+	sort.Strings(label_tags)
+	tags = append(tags, label_tags...)
+	if(metric_value.Instance != "") {
+		tags = append(tags, InstanceTag + ":" + tagEscaper.Replace(metric_value.Instance))
+	}
+	if(len(tags) == 0) {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+/* counterDelta subtracts integers as integers so large 64 bit counters keep their precision. ok
+   is false when the counter went backwards or is not numeric */
+func counterDelta(previous interface{}, current interface{}) (string, bool) {
+	switch typed := current.(type) {
+	case uint64:
+		if last, same_type := previous.(uint64); same_type {
+			if(typed < last) {
+				return "", false
+			}
+			return strconv.FormatUint(typed - last, 10), true
+		}
+	case int64:
+		if last, same_type := previous.(int64); same_type {
+			if(typed < last) {
+				return "", false
+			}
+			return strconv.FormatInt(typed - last, 10), true
+		}
+	}
+	last, ok := pcpeasy.MetricValue{Value:previous}.Float()
+	if(!ok) {
+		return "", false
+	}
+	value, ok := pcpeasy.MetricValue{Value:current}.Float()
+	if(!ok || value < last || math.IsNaN(value - last)) {
+		return "", false
+	}
+	return strconv.FormatFloat(value - last, 'f', -1, 64), true
+}
+
+/* send packs lines into as few datagrams as fit MaxPacketSize */
+func (e *Emitter) send(lines []string) error {
+	packet := []byte{}
+	for _, line := range lines {
+		if(len(packet) > 0 && len(packet) + 1 + len(line) > e.options.MaxPacketSize) {
+			_, err := e.connection.Write(packet)
+			if(err != nil) {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if(len(packet) > 0) {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if(len(packet) > 0) {
+		_, err := e.connection.Write(packet)
+		return err
+	}
+	return nil
+}
+
+var (
+	/* Separators in the StatsD line format */
+	nameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_", "#", "_")
+	tagEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+	tagNameEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_", ":", "_")
+)
@@ -0,0 +1,149 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package statsd
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"net"
+	"strings"
+	"time"
+)
+
+/* listen stands in for a StatsD server, returning what each call to read receives */
+func listen(t *testing.T) (string, func() []string) {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if(err != nil) {
+		t.Fatal(err)
+	}
+	read := func() []string {
+		buffer := make([]byte, 65536)
+		connection.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		packets := []string{}
+		for {
+			n, _, err := connection.ReadFrom(buffer)
+			if(err != nil) {
+				return packets
+			}
+			packets = append(packets, string(buffer[:n]))
+		}
+	}
+	return connection.LocalAddr().String(), read
+}
+
+func diskReads(sda uint64, sdb uint64) []pcpeasy.Metric {
+	return []pcpeasy.Metric{{Name:"disk.dev.reads", Semantics:"counter", Values:[]pcpeasy.MetricValue{
+		{Value:sda, Instance:"sda", InstanceID:0, Labels:map[string]string{"hostname":"web1"}},
+		{Value:sdb, Instance:"sdb", InstanceID:1, Labels:map[string]string{"hostname":"web1"}},
+	}}}
+}
+
+func TestEmitter_sendsGauges(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{Prefix:"pcp"})
+	defer emitter.Close()
+
+	emitter.Emit([]pcpeasy.Metric{
+		{Name:"kernel.all.load", Semantics:"instant", Values:[]pcpeasy.MetricValue{{Value:float32(0.5)}}},
+		{Name:"mem.util.free", Semantics:"instant", Values:[]pcpeasy.MetricValue{{Value:uint64(1024)}}},
+		{Name:"kernel.uname.release", Semantics:"discrete", Values:[]pcpeasy.MetricValue{{Value:"6.1.0"}}},
+	})
+
+	assert.Equal(t, []string{"pcp.kernel.all.load:0.5|g\npcp.mem.util.free:1024|g"}, read())
+}
+
+func TestEmitter_sendsCountersAsDeltasFromTheSecondSample(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{})
+	defer emitter.Close()
+
+	emitter.Emit(diskReads(100, 18446744073709551000))
+	assert.Empty(t, read())
+
+	emitter.Emit(diskReads(150, 18446744073709551615))
+	assert.Equal(t, []string{"disk.dev.reads.sda:50|c\ndisk.dev.reads.sdb:615|c"}, read())
+}
+
+func TestEmitter_skipsCountersThatGoBackwards(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{})
+	defer emitter.Close()
+
+	emitter.Emit(diskReads(100, 100))
+	emitter.Emit(diskReads(10, 110))
+	emitter.Emit(diskReads(15, 120))
+
+	assert.Equal(t, []string{"disk.dev.reads.sdb:10|c", "disk.dev.reads.sda:5|c\ndisk.dev.reads.sdb:10|c"}, read())
+}
+
+func TestEmitter_forgetsCountersMissingFromAnEmit(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{})
+	defer emitter.Close()
+	only_sda := diskReads(150, 0)
+	only_sda[0].Values = only_sda[0].Values[:1]
+
+	emitter.Emit(diskReads(100, 100))
+	emitter.Emit(only_sda)
+	assert.Len(t, emitter.previous, 1)
+	emitter.Emit(diskReads(160, 300))
+
+	assert.Equal(t, []string{"disk.dev.reads.sda:50|c", "disk.dev.reads.sda:10|c"}, read())
+}
+
+func TestEmitter_sendsDogStatsDTags(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{DogStatsD:true, Tags:[]string{"env:prod"}})
+	defer emitter.Close()
+
+	emitter.Emit([]pcpeasy.Metric{{Name:"network.interface.up", Semantics:"instant", Values:[]pcpeasy.MetricValue{
+		{Value:int32(-1), Instance:"eth0,1", Labels:map[string]string{"a:b":"c|d", "hostname":"web1"}},
+	}}})
+
+	assert.Equal(t, []string{"network.interface.up:-1|g|#env:prod,a_b:c_d,hostname:web1,instance:eth0_1"}, read())
+}
+
+func TestEmitter_zeroesNegativeGaugesForPlainStatsD(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{})
+	defer emitter.Close()
+
+	emitter.Emit([]pcpeasy.Metric{{Name:"temperature", Semantics:"instant", Values:[]pcpeasy.MetricValue{{Value:int32(-5)}}}})
+
+	assert.Equal(t, []string{"temperature:0|g\ntemperature:-5|g"}, read())
+}
+
+func TestEmitter_splitsPacketsAtMaxPacketSize(t *testing.T) {
+	address, read := listen(t)
+	emitter, _ := NewEmitter(address, EmitterOptions{MaxPacketSize:20})
+	defer emitter.Close()
+
+	emitter.Emit([]pcpeasy.Metric{{Name:"a.metric", Semantics:"instant", Values:[]pcpeasy.MetricValue{
+		{Value:int32(1), Instance:"x"}, {Value:int32(2), Instance:"y"}, {Value:int32(3), Instance:"z"},
+	}}})
+
+	packets := read()
+	assert.Equal(t, []string{"a.metric.x:1|g", "a.metric.y:2|g", "a.metric.z:3|g"}, packets)
+	for _, packet := range packets {
+		assert.True(t, len(packet) <= 20, strings.TrimSpace(packet))
+	}
+}
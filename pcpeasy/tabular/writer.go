@@ -0,0 +1,251 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package tabular writes samples of PCP metrics as CSV or TSV, in the manner of pmdumptext(1): a
+time column, then one column per metric and instance, with one row per sample.
+
+The header row names each column metric[instance], followed by the units in brackets when the
+metric has any, such as "disk.dev.read_bytes[sda] (Kbyte)". Columns are fixed by the first sample
+written; instances that appear later are not added and those that disappear are written as the
+missing marker. A metric without an instance domain always has a column, so one without a value in
+the first sample, as at the start of an archive, is written as the missing marker until it has one.
+WriteArchive holds back samples until every metric has values, so metrics with instances that
+start without any still get their columns, but no more than archiveLookAhead of them.
+*/
+package tabular
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+/* UnixSeconds as the TimeFormat writes times as seconds since the epoch */
+const UnixSeconds = "unix"
+
+type Options struct {
+	/* Defaults to a comma. Use '\t' for TSV */
+	Delimiter rune
+	/* A time.Format layout or UnixSeconds. Defaults to time.RFC3339 */
+	TimeFormat string
+	/* Defaults to the local time zone */
+	Location *time.Location
+	/* Written where a column has no value. Defaults to "?", as pmdumptext does */
+	Missing string
+}
+
+/* Source is satisfied by the agents returned from pcpeasy.NewAgent and pcpeasy.NewArchiveAgent */
+type Source interface {
+	Metrics(metric_names ...string) ([]pcpeasy.Metric, error)
+}
+
+type Writer struct {
+	csv *csv.Writer
+	options Options
+	columns []column
+}
+
+/* column is one metric and instance. instance_id is pmapi.PmInNull for metrics without instances */
+type column struct {
+	metric string
+	instance_id int
+}
+
+func NewWriter(writer io.Writer, options Options) *Writer {
+	if(options.Delimiter == 0) {
+		options.Delimiter = ','
+	}
+	if(options.TimeFormat == "") {
+		options.TimeFormat = time.RFC3339
+	}
+	if(options.Location == nil) {
+		options.Location = time.Local
+	}
+	if(options.Missing == "") {
+		options.Missing = "?"
+	}
+	csv_writer := csv.NewWriter(writer)
+	csv_writer.Comma = options.Delimiter
+	return &Writer{csv:csv_writer, options:options}
+}
+
+/* Write writes one row for a sample, and the header row first if this is the first sample */
+func (w *Writer) Write(metrics []pcpeasy.Metric) error {
+	if(w.columns == nil) {
+		err := w.writeHeader([][]pcpeasy.Metric{metrics})
+		if(err != nil) {
+			return err
+		}
+	}
+
+	values := make(map[column]string)
+	var timestamp time.Time
+	for _, metric := range metrics {
+		if(timestamp.IsZero()) {
+			timestamp = metric.Timestamp
+		}
+		for _, metric_value := range metric.Values {
+			values[column{metric.Name, metric_value.InstanceID}] = formatValue(metric_value.Value)
+		}
+	}
+
+	row := []string{w.formatTime(timestamp)}
+	for _, c := range w.columns {
+		value, found := values[c]
+		if(!found) {
+			value = w.options.Missing
+		}
+		row = append(row, value)
+	}
+	return w.csv.Write(row)
+}
+
+/* Flush writes any buffered rows to the underlying writer */
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+/* archiveLookAhead is the most samples WriteArchive holds back waiting for every metric to have
+   values before it fixes the columns anyway */
+const archiveLookAhead = 100
+
+/* WriteArchive writes a row for every fetch from an archive agent until the end of the archive */
+func (w *Writer) WriteArchive(source Source, metric_names ...string) error {
+	held := [][]pcpeasy.Metric{}
+	for {
+		metrics, err := source.Metrics(metric_names...)
+		if(pcpeasy.IsEndOfArchive(err)) {
+			err = w.writeHeld(held)
+			if(err != nil) {
+				return err
+			}
+			return w.Flush()
+		}
+		if(err != nil) {
+			return err
+		}
+		if(w.columns == nil) {
+			held = append(held, metrics)
+			if(len(held) < archiveLookAhead && !allHaveValues(metrics)) {
+				continue
+			}
+			err = w.writeHeld(held)
+			held = nil
+		} else {
+			err = w.Write(metrics)
+		}
+		if(err != nil) {
+			return err
+		}
+	}
+}
+
+/* writeHeld writes the header for the samples held back by WriteArchive, then their rows */
+func (w *Writer) writeHeld(held [][]pcpeasy.Metric) error {
+	if(len(held) == 0) {
+		return nil
+	}
+	err := w.writeHeader(held)
+	if(err != nil) {
+		return err
+	}
+	for _, metrics := range held {
+		err = w.Write(metrics)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func allHaveValues(metrics []pcpeasy.Metric) bool {
+	for _, metric := range metrics {
+		if(len(metric.Values) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+/* writeHeader names a column for each instance of each metric in the first sample, in the order
+   they first appear across samples */
+func (w *Writer) writeHeader(samples [][]pcpeasy.Metric) error {
+	w.columns = []column{}
+	header := []string{"Time"}
+	for i, metric := range samples[0] {
+		units := metric.Units.String()
+		named := make(map[int]bool)
+		for _, metrics := range samples {
+			if(i >= len(metrics)) {
+				continue
+			}
+			for _, metric_value := range metrics[i].Values {
+				if(named[metric_value.InstanceID]) {
+					continue
+				}
+				named[metric_value.InstanceID] = true
+				w.columns = append(w.columns, column{metric.Name, metric_value.InstanceID})
+				header = append(header, columnName(metric.Name, metric_value.Instance, units))
+			}
+		}
+		if(len(named) == 0 && metric.InDom == pmapi.PmInDomNull) {
+			w.columns = append(w.columns, column{metric.Name, pmapi.PmInNull})
+			header = append(header, columnName(metric.Name, "", units))
+		}
+	}
+	return w.csv.Write(header)
+}
+
+func columnName(metric string, instance string, units string) string {
+	name := metric
+	if(instance != "") {
+		name = fmt.Sprintf("%v[%v]", name, instance)
+	}
+	if(units != "") {
+		name = fmt.Sprintf("%v (%v)", name, units)
+	}
+	return name
+}
+
+func (w *Writer) formatTime(timestamp time.Time) string {
+	if(timestamp.IsZero()) {
+		return w.options.Missing
+	}
+	if(w.options.TimeFormat == UnixSeconds) {
+		return strconv.FormatFloat(float64(timestamp.UnixNano()) / float64(time.Second), 'f', -1, 64)
+	}
+	return timestamp.In(w.options.Location).Format(w.options.TimeFormat)
+}
+
+func formatValue(value interface{}) string {
+	switch typed := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(typed), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(typed, 'g', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,198 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package tabular
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pcpeasy"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+)
+
+var start = time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+func sample(offset time.Duration, sda interface{}, sdb interface{}) []pcpeasy.Metric {
+	disk := pcpeasy.Metric{Name:"disk.dev.read_bytes", Timestamp:start.Add(offset), Units:pcpeasy.Units{DimSpace:1, ScaleSpace:pcpeasy.SpaceKByte}}
+	if(sda != nil) {
+		disk.Values = append(disk.Values, pcpeasy.MetricValue{Instance:"sda", InstanceID:0, Value:sda})
+	}
+	if(sdb != nil) {
+		disk.Values = append(disk.Values, pcpeasy.MetricValue{Instance:"sdb", InstanceID:1, Value:sdb})
+	}
+	return []pcpeasy.Metric{
+		disk,
+		{Name:"kernel.all.load", Timestamp:start.Add(offset), Values:[]pcpeasy.MetricValue{{InstanceID:pmapi.PmInNull, Value:float32(0.1)}}},
+	}
+}
+
+func TestWriter_writesAHeaderAndARowPerSample(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, Options{Location:time.UTC})
+
+	writer.Write(sample(0, uint64(100), uint64(200)))
+	writer.Write(sample(time.Second, uint64(150), nil))
+	writer.Write(sample(2 * time.Second, uint64(175), uint64(250)))
+	err := writer.Flush()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Time,disk.dev.read_bytes[sda] (Kbyte),disk.dev.read_bytes[sdb] (Kbyte),kernel.all.load
+2023-11-14T22:13:20Z,100,200,0.1
+2023-11-14T22:13:21Z,150,?,0.1
+2023-11-14T22:13:22Z,175,250,0.1
+`, out.String())
+}
+
+func TestWriter_ignoresInstancesThatAppearAfterTheHeader(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, Options{Location:time.UTC})
+
+	writer.Write(sample(0, uint64(100), nil))
+	writer.Write(sample(time.Second, uint64(150), uint64(200)))
+	writer.Flush()
+
+	assert.Equal(t, `Time,disk.dev.read_bytes[sda] (Kbyte),kernel.all.load
+2023-11-14T22:13:20Z,100,0.1
+2023-11-14T22:13:21Z,150,0.1
+`, out.String())
+}
+
+func TestWriter_supportsOtherDelimitersTimeFormatsAndMissingMarkers(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, Options{Delimiter:'\t', TimeFormat:UnixSeconds, Missing:"-"})
+
+	writer.Write(sample(0, uint64(100), uint64(200)))
+	writer.Write(sample(1500 * time.Millisecond, nil, uint64(250)))
+	writer.Flush()
+
+	assert.Equal(t, "Time\tdisk.dev.read_bytes[sda] (Kbyte)\tdisk.dev.read_bytes[sdb] (Kbyte)\tkernel.all.load\n" +
+		"1700000000\t100\t200\t0.1\n" +
+		"1700000001.5\t-\t250\t0.1\n", out.String())
+}
+
+func TestWriter_quotesValuesContainingTheDelimiter(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, Options{Location:time.UTC})
+
+	writer.Write([]pcpeasy.Metric{{Name:"kernel.uname.version", Timestamp:start, Values:[]pcpeasy.MetricValue{
+		{InstanceID:pmapi.PmInNull, Value:"#1 SMP, PREEMPT"},
+	}}})
+	writer.Flush()
+
+	assert.Equal(t, "Time,kernel.uname.version\n2023-11-14T22:13:20Z,\"#1 SMP, PREEMPT\"\n", out.String())
+}
+
+/* archive replays scripted samples, then reports the end of the archive */
+type archive struct {
+	samples [][]pcpeasy.Metric
+	err error
+}
+
+func (a *archive) Metrics(metric_names ...string) ([]pcpeasy.Metric, error) {
+	if(len(a.samples) == 0) {
+		if(a.err != nil) {
+			return nil, a.err
+		}
+		return nil, pmapi.PmError{Code:pmapi.PmErrEOL, Message:"End of PCP archive log"}
+	}
+	metrics := a.samples[0]
+	a.samples = a.samples[1:]
+	return metrics, nil
+}
+
+func TestWriter_WriteArchive_writesEverySampleUntilTheEndOfTheArchive(t *testing.T) {
+	var out bytes.Buffer
+	source := &archive{samples:[][]pcpeasy.Metric{sample(0, uint64(1), uint64(2)), sample(time.Minute, uint64(3), uint64(4))}}
+
+	err := NewWriter(&out, Options{Location:time.UTC}).WriteArchive(source, "disk.dev.read_bytes", "kernel.all.load")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Time,disk.dev.read_bytes[sda] (Kbyte),disk.dev.read_bytes[sdb] (Kbyte),kernel.all.load
+2023-11-14T22:13:20Z,1,2,0.1
+2023-11-14T22:14:20Z,3,4,0.1
+`, out.String())
+}
+
+func TestWriter_WriteArchive_writesTheMissingMarkerForMetricsNotYetInTheArchive(t *testing.T) {
+	var out bytes.Buffer
+	empty := func(offset time.Duration) []pcpeasy.Metric {
+		metrics := sample(offset, nil, nil)
+		metrics[1].Values, metrics[1].InDom = []pcpeasy.MetricValue{}, pmapi.PmInDomNull
+		return metrics
+	}
+	counter := func(offset time.Duration, sda interface{}) []pcpeasy.Metric {
+		metrics := sample(offset, sda, nil)
+		metrics[1].InDom = pmapi.PmInDomNull
+		return metrics
+	}
+	source := &archive{samples:[][]pcpeasy.Metric{empty(0), counter(time.Minute, uint64(3)), counter(2 * time.Minute, uint64(5))}}
+
+	err := NewWriter(&out, Options{Location:time.UTC}).WriteArchive(source, "disk.dev.read_bytes", "kernel.all.load")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Time,disk.dev.read_bytes[sda] (Kbyte),kernel.all.load
+2023-11-14T22:13:20Z,?,?
+2023-11-14T22:14:20Z,3,0.1
+2023-11-14T22:15:20Z,5,0.1
+`, out.String())
+}
+
+func TestWriter_WriteArchive_writesMetricsWithoutValuesUntilTheEndOfTheArchive(t *testing.T) {
+	var out bytes.Buffer
+	metrics := []pcpeasy.Metric{{Name:"kernel.all.pswitch", Timestamp:start, InDom:pmapi.PmInDomNull, Values:[]pcpeasy.MetricValue{}}}
+	source := &archive{samples:[][]pcpeasy.Metric{metrics}}
+
+	err := NewWriter(&out, Options{Location:time.UTC}).WriteArchive(source, "kernel.all.pswitch")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Time,kernel.all.pswitch\n2023-11-14T22:13:20Z,?\n", out.String())
+}
+
+func TestWriter_WriteArchive_stopsHoldingSamplesAfterTheLookAhead(t *testing.T) {
+	var out bytes.Buffer
+	source := &archive{err:errors.New("corrupt archive")}
+	for i := 0; i < archiveLookAhead; i++ {
+		metrics := sample(time.Duration(i) * time.Minute, uint64(i), nil)
+		metrics[1].Values = []pcpeasy.MetricValue{}
+		source.samples = append(source.samples, metrics)
+	}
+	writer := NewWriter(&out, Options{Location:time.UTC})
+
+	err := writer.WriteArchive(source, "disk.dev.read_bytes", "kernel.all.load")
+	writer.Flush()
+
+	assert.EqualError(t, err, "corrupt archive")
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, archiveLookAhead + 1)
+	assert.Equal(t, "Time,disk.dev.read_bytes[sda] (Kbyte)", lines[0])
+	assert.Equal(t, "2023-11-14T22:13:20Z,0", lines[1])
+}
+
+func TestWriter_WriteArchive_returnsOtherErrors(t *testing.T) {
+	var out bytes.Buffer
+
+	err := NewWriter(&out, Options{}).WriteArchive(&archive{err:errors.New("corrupt archive")})
+
+	assert.EqualError(t, err, "corrupt archive")
+}
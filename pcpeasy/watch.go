@@ -0,0 +1,171 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"context"
+	"time"
+	"errors"
+	"syscall"
+)
+
+/* A Sample is one fetch made by Watch. Either Metrics or Err is set. */
+type Sample struct {
+	/* When the fetch was scheduled. Metric.Timestamp holds pmcd's own timestamp */
+	Timestamp time.Time
+	Metrics []Metric
+	Err error
+	/* Samples discarded since the previous delivered sample because the consumer was not ready */
+	Dropped int
+}
+
+type SlowConsumerPolicy int
+
+const (
+	/* Discard samples the consumer is not ready for, keeping the sampling interval intact */
+	DropSamples SlowConsumerPolicy = iota
+	/* Wait for the consumer. Intervals missed while waiting are skipped, not caught up */
+	BlockSampling
+)
+
+type WatchOptions struct {
+	Interval time.Duration
+	Policy SlowConsumerPolicy
+	/* Capacity of the returned channel. Zero gives 1, so a consumer that is busy handling one
+	   sample does not miss the next */
+	Buffer int
+}
+
+/*
+Watch fetches the named metrics every interval and delivers them on the returned channel until
+ctx is done, at which point the channel is closed. Samples the consumer is not ready for are
+dropped; use WatchWithOptions to block instead.
+*/
+func (a *agent) Watch(ctx context.Context, interval time.Duration, metric_names ...string) <-chan Sample {
+	return a.WatchWithOptions(ctx, WatchOptions{Interval:interval}, metric_names...)
+}
+
+/*
+WatchWithOptions is Watch with control over the slow consumer policy and channel buffer.
+
+Fetches are scheduled against fixed deadlines from the first sample so time spent fetching does
+not accumulate as drift. A failed fetch is delivered as a Sample with Err set and fetching carries
+on; when the connection to pmcd failed, an agent made by NewAgent reconnects to its host before the
+next fetch. Watching an archive agent ends, closing the channel, at the end of the archive.
+*/
+func (a *agent) WatchWithOptions(ctx context.Context, options WatchOptions, metric_names ...string) <-chan Sample {
+	if(options.Interval <= 0) {
+		failed := make(chan Sample, 1)
+		failed <- Sample{Timestamp:time.Now(), Err:errors.New("watch interval must be positive")}
+		close(failed)
+		return failed
+	}
+	if(options.Buffer == 0) {
+		options.Buffer = 1
+	}
+	samples := make(chan Sample, options.Buffer)
+	go a.watch(ctx, options, metric_names, samples)
+	return samples
+}
+
+func (a *agent) watch(ctx context.Context, options WatchOptions, metric_names []string, samples chan<- Sample) {
+	defer close(samples)
+
+	start := time.Now()
+	deadline := start
+	dropped := 0
+	needs_reconnect := false
+
+	for {
+		sample := Sample{Timestamp:deadline}
+		if(needs_reconnect) {
+			sample.Err = a.reconnect()
+			needs_reconnect = sample.Err != nil
+		}
+		if(sample.Err == nil) {
+			sample.Metrics, sample.Err = a.Metrics(metric_names...)
+			if(IsEndOfArchive(sample.Err)) {
+				return
+			}
+			needs_reconnect = a.canReconnectFrom(sample.Err)
+		}
+
+		sample.Dropped = dropped
+		if(deliverSample(ctx, options.Policy, samples, sample)) {
+			dropped = 0
+		} else {
+			dropped++
+		}
+
+		deadline = nextDeadline(start, deadline, options.Interval, time.Now())
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+/* canReconnectFrom reports whether a fetch failed for the connection to pmcd, which a new context
+   may restore. Other errors, such as a metric without values, would fail again the same way */
+func (a *agent) canReconnectFrom(err error) bool {
+	if(a.connect == nil) {
+		return false
+	}
+	for _, code := range []int{pmapi.PmErrIPC, pmapi.PmErrEOF, pmapi.PmErrTimeout, -int(syscall.ECONNRESET)} {
+		if(pmapi.IsPmError(err, code)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* deliverSample sends the sample according to policy, returning false if it was dropped */
+func deliverSample(ctx context.Context, policy SlowConsumerPolicy, samples chan<- Sample, sample Sample) bool {
+	if(policy == BlockSampling) {
+		select {
+		case samples <- sample:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case samples <- sample:
+		return true
+	default:
+		return false
+	}
+}
+
+/* nextDeadline returns the first deadline on the start + n*interval grid that is after both the
+   previous deadline and now, so a slow fetch or consumer skips intervals rather than bunching them */
+func nextDeadline(start time.Time, previous time.Time, interval time.Duration, now time.Time) time.Time {
+	next := previous.Add(interval)
+	if(next.After(now)) {
+		return next
+	}
+	elapsed_intervals := now.Sub(start) / interval
+	return start.Add((elapsed_intervals + 1) * interval)
+}
@@ -0,0 +1,207 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pcpeasy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"context"
+	"time"
+)
+
+func mockSingularMetric(mock_pmapi *MockPMAPI, name string, pmid pmapi.PmID, value int32) {
+	pm_value := &pmapi.PmValue{Inst:pmapi.PmInNull}
+	pm_desc := pmapi.PmDesc{Type:pmapi.PmType32, InDom:pmapi.PmInDomNull, PmID:pmid}
+	pm_result := &pmapi.PmResult{
+		NumPmID:1,
+		VSet:[]*pmapi.PmValueSet{{NumVal:1, PmID:pmid, ValFmt:pmapi.PmValInsitu, VList:[]*pmapi.PmValue{pm_value}}},
+	}
+	mock_pmapi.On("PmLookupName", []string{name}).Return([]pmapi.PmID{pmid}, nil)
+	mock_pmapi.On("PmFetch", []pmapi.PmID{pmid}).Return(pm_result, nil)
+	mock_pmapi.On("PmLookupDesc", pmid).Return(pm_desc, nil)
+	mock_pmapi.On("PmExtractValue", pmapi.PmValInsitu, pmapi.PmType32, pm_value).Return(pmapi.PmAtomValue{Int32:value}, nil)
+}
+
+func TestAgent_Watch_deliversSamplesUntilTheContextIsDone(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := &agent{pmapi:mock_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:mock_pmapi}}
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	samples := agent.Watch(ctx, time.Millisecond, "my.metric")
+	first := <-samples
+	second := <-samples
+	cancel()
+	for range samples {
+	}
+
+	assert.NoError(t, first.Err)
+	assert.Equal(t, int32(42), first.Metrics[0].Values[0].Value)
+	assert.True(t, second.Timestamp.After(first.Timestamp))
+}
+
+func TestAgent_Watch_reportsFetchErrorsAndReconnects(t *testing.T) {
+	failing_pmapi := &MockPMAPI{}
+	working_pmapi := &MockPMAPI{}
+	connections := 0
+	connect := func() (pmapi.PMAPI, error) {
+		connections++
+		return working_pmapi, nil
+	}
+	agent := &agent{pmapi:failing_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:failing_pmapi}, connect:connect}
+	failing_pmapi.On("PmLookupName", []string{"my.metric"}).Return(nil, pmapi.PmError{Code:pmapi.PmErrIPC, Message:"IPC protocol failure"})
+	mockSingularMetric(working_pmapi, "my.metric", pmapi.PmID(123), 42)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := agent.WatchWithOptions(ctx, WatchOptions{Interval:time.Millisecond, Policy:BlockSampling}, "my.metric")
+	failed := <-samples
+	recovered := <-samples
+
+	assert.EqualError(t, failed.Err, "IPC protocol failure")
+	assert.NoError(t, recovered.Err)
+	assert.Equal(t, int32(42), recovered.Metrics[0].Values[0].Value)
+	assert.Equal(t, 1, connections)
+}
+
+/* closingPMAPI records whether the agent closed it */
+type closingPMAPI struct {
+	*MockPMAPI
+	closed bool
+}
+
+func (c *closingPMAPI) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestAgent_Watch_closesTheContextItReconnectsFrom(t *testing.T) {
+	failing_pmapi := &closingPMAPI{MockPMAPI:&MockPMAPI{}}
+	working_pmapi := &MockPMAPI{}
+	connect := func() (pmapi.PMAPI, error) {
+		return working_pmapi, nil
+	}
+	agent := &agent{pmapi:failing_pmapi, pmDescAdapter:pmDescAdapterImpl{}, pmValueAdapter:pmValueAdapterImpl{pmapi:failing_pmapi}, connect:connect}
+	failing_pmapi.On("PmLookupName", []string{"my.metric"}).Return(nil, pmapi.PmError{Code:pmapi.PmErrEOF, Message:"IPC channel closed"})
+	mockSingularMetric(working_pmapi, "my.metric", pmapi.PmID(123), 42)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := agent.WatchWithOptions(ctx, WatchOptions{Interval:time.Millisecond, Policy:BlockSampling}, "my.metric")
+	<-samples
+	recovered := <-samples
+
+	assert.NoError(t, recovered.Err)
+	assert.True(t, failing_pmapi.closed)
+}
+
+func TestAgent_Watch_keepsFetchingAfterAnErrorWithoutReconnecting(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := NewAgentWithPMAPI(mock_pmapi)
+	pm_value := &pmapi.PmValue{Inst:pmapi.PmInNull}
+	mock_pmapi.On("PmLookupName", []string{"my.metric"}).Return([]pmapi.PmID{123}, nil)
+	mock_pmapi.On("PmFetch", []pmapi.PmID{123}).Return(nil, pmapi.PmError{Code:pmapi.PmErrValue, Message:"Missing metric value(s)"}).Once()
+	mock_pmapi.On("PmFetch", []pmapi.PmID{123}).Return(&pmapi.PmResult{NumPmID:1, VSet:[]*pmapi.PmValueSet{
+		{NumVal:1, PmID:123, ValFmt:pmapi.PmValInsitu, VList:[]*pmapi.PmValue{pm_value}},
+	}}, nil)
+	mock_pmapi.On("PmLookupDesc", pmapi.PmID(123)).Return(pmapi.PmDesc{Type:pmapi.PmType32, InDom:pmapi.PmInDomNull, PmID:123}, nil)
+	mock_pmapi.On("PmExtractValue", pmapi.PmValInsitu, pmapi.PmType32, pm_value).Return(pmapi.PmAtomValue{Int32:42}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := agent.WatchWithOptions(ctx, WatchOptions{Interval:time.Millisecond, Policy:BlockSampling}, "my.metric")
+	failed := <-samples
+	next := <-samples
+
+	assert.EqualError(t, failed.Err, "Missing metric value(s)")
+	assert.NoError(t, next.Err)
+	assert.Equal(t, int32(42), next.Metrics[0].Values[0].Value)
+}
+
+func TestAgent_Watch_endsAtTheEndOfAnArchive(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := NewAgentWithPMAPI(mock_pmapi)
+	mock_pmapi.On("PmLookupName", []string{"my.metric"}).Return(nil, pmapi.PmError{Code:pmapi.PmErrEOL, Message:"End of PCP archive log"})
+
+	samples := agent.Watch(context.Background(), time.Millisecond, "my.metric")
+	_, open := <-samples
+
+	assert.False(t, open)
+}
+
+func TestAgent_Watch_buffersOneSampleByDefault(t *testing.T) {
+	mock_pmapi := &MockPMAPI{}
+	agent := NewAgentWithPMAPI(mock_pmapi)
+	mockSingularMetric(mock_pmapi, "my.metric", pmapi.PmID(123), 42)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := agent.Watch(ctx, time.Hour, "my.metric")
+
+	assert.Equal(t, 1, cap(samples))
+	assert.NoError(t, (<-samples).Err)
+}
+
+func TestAgent_Watch_rejectsANonPositiveInterval(t *testing.T) {
+	agent := &agent{}
+
+	samples := agent.Watch(context.Background(), 0, "my.metric")
+
+	assert.EqualError(t, (<-samples).Err, "watch interval must be positive")
+	_, open := <-samples
+	assert.False(t, open)
+}
+
+func TestDeliverSample_dropsSamplesWhenTheConsumerIsNotReady(t *testing.T) {
+	samples := make(chan Sample, 1)
+	samples <- Sample{}
+
+	delivered := deliverSample(context.Background(), DropSamples, samples, Sample{})
+
+	assert.False(t, delivered)
+}
+
+func TestDeliverSample_blockingGivesUpWhenTheContextIsDone(t *testing.T) {
+	samples := make(chan Sample)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	delivered := deliverSample(ctx, BlockSampling, samples, Sample{})
+
+	assert.False(t, delivered)
+}
+
+func TestNextDeadline_followsTheIntervalWhenOnTime(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	next := nextDeadline(start, start, time.Second, start.Add(300 * time.Millisecond))
+
+	assert.Equal(t, start.Add(time.Second), next)
+}
+
+func TestNextDeadline_skipsMissedIntervalsWithoutDrifting(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	next := nextDeadline(start, start.Add(time.Second), time.Second, start.Add(3500 * time.Millisecond))
+
+	assert.Equal(t, start.Add(4 * time.Second), next)
+}
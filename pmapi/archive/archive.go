@@ -0,0 +1,329 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package archive reads PCP archives, as pmlogger(1) writes them, without libpcp. Versions 2 and 3 of
+the format are understood.
+
+An archive is a set of files sharing a base name: the metadata in .meta, a temporal index in .index
+and the values in data volumes .0, .1 and so on. Open reads the metadata and index, giving the
+descriptors, metric names, instance domains and labels as they changed over time. Records are then
+read in time order from any point with Seek and Next.
+
+	a, err := archive.Open("/var/log/pcp/pmlogger/web1/20240101")
+	defer a.Close()
+	a.Seek(a.Label.Start.Add(time.Hour))
+	result, err := a.Next()
+
+All integers in the files are big endian. Values are held as in the PCP protocol, so records decode
+to a pdu.Result.
+*/
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Every file of an archive starts with a label holding magic | version */
+const (
+	magic = 0x50052600
+	Version2 = 2
+	Version3 = 3
+)
+
+/* The volume numbers in the labels of the .meta and .index files */
+const (
+	VolumeMeta = -1
+	VolumeIndex = -2
+)
+
+/* Sizes of the labels, including their leading and trailing lengths */
+const (
+	labelSizeV2 = 132
+	labelSizeV3 = 808
+)
+
+/* Label is what every file of an archive starts with. The labels of an archive's files differ only
+   in Volume */
+type Label struct {
+	Version int
+	/* The process that wrote the archive */
+	PID int
+	/* When the archive was started */
+	Start time.Time
+	Volume int
+	Hostname string
+	/* The $TZ of the host logged */
+	Timezone string
+	/* Version 3 only: the host's zoneinfo name, such as :Australia/Melbourne */
+	Zoneinfo string
+	/* Version 3 only: bits for features the reader must understand */
+	Features uint32
+}
+
+/* Archive is an open archive. It is not safe for concurrent use as it reads from one place at a
+   time */
+type Archive struct {
+	/* The label of the .meta file */
+	Label Label
+	base string
+	meta *metadata
+	index []IndexEntry
+	/* The data volume numbers, in order */
+	volumes []int
+
+	/* Where Next reads from: an open volume, or nil for the start of the first */
+	volume int
+	file *os.File
+	reader *bufio.Reader
+	/* A record read ahead by Seek, returned by the next call to Next */
+	pending *pdu.Result
+}
+
+/* Open opens the archive of any of its files or its base name */
+func Open(name string) (*Archive, error) {
+	base := baseName(name)
+	a := &Archive{base:base}
+
+	meta_file, err := os.Open(base + ".meta")
+	if(err != nil) {
+		if _, xz_err := os.Stat(base + ".meta.xz"); xz_err == nil {
+			return nil, errors.New(fmt.Sprintf("%v is compressed, decompress it with xz -d first", base))
+		}
+		return nil, err
+	}
+	defer meta_file.Close()
+	reader := bufio.NewReader(meta_file)
+	a.Label, err = readLabel(reader, VolumeMeta)
+	if(err != nil) {
+		return nil, errors.New(fmt.Sprintf("%v.meta: %v", base, err))
+	}
+	a.meta, err = readMetadata(reader, a.Label.Version)
+	if(err != nil) {
+		return nil, errors.New(fmt.Sprintf("%v.meta: %v", base, err))
+	}
+
+	a.volumes, err = findVolumes(base)
+	if(err != nil) {
+		return nil, err
+	}
+	a.index, err = readIndex(base + ".index", a.Label.Version)
+	if(err != nil) {
+		return nil, errors.New(fmt.Sprintf("%v.index: %v", base, err))
+	}
+	return a, nil
+}
+
+/* baseName strips the suffix of one of an archive's files */
+func baseName(name string) string {
+	extension := filepath.Ext(name)
+	if(extension == ".meta" || extension == ".index") {
+		return strings.TrimSuffix(name, extension)
+	}
+	if _, err := strconv.Atoi(strings.TrimPrefix(extension, ".")); err == nil && len(extension) > 1 {
+		return strings.TrimSuffix(name, extension)
+	}
+	return name
+}
+
+/* findVolumes lists the numbers of the data volumes present */
+func findVolumes(base string) ([]int, error) {
+	paths, err := filepath.Glob(base + ".[0-9]*")
+	if(err != nil) {
+		return nil, err
+	}
+	volumes := []int{}
+	for _, path := range paths {
+		volume, err := strconv.Atoi(strings.TrimPrefix(path, base + "."))
+		if(err == nil) {
+			volumes = append(volumes, volume)
+		}
+	}
+	if(len(volumes) == 0) {
+		return nil, errors.New(fmt.Sprintf("%v has no data volumes", base))
+	}
+	sort.Ints(volumes)
+	return volumes, nil
+}
+
+/* Close closes the volume being read */
+func (a *Archive) Close() error {
+	if(a.file == nil) {
+		return nil
+	}
+	err := a.file.Close()
+	a.file, a.reader = nil, nil
+	return err
+}
+
+/* readLabel reads the label a file starts with, checking it is of the volume expected */
+func readLabel(reader io.Reader, volume int) (Label, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(reader, header)
+	if(err != nil) {
+		return Label{}, errors.New(fmt.Sprintf("reading label: %v", err))
+	}
+	length := int(binary.BigEndian.Uint32(header))
+	label_magic := binary.BigEndian.Uint32(header[4:])
+	if(label_magic &^ 0xff != magic) {
+		return Label{}, errors.New(fmt.Sprintf("not a PCP archive, bad magic %#x", label_magic))
+	}
+	version := int(label_magic & 0xff)
+	size := map[int]int{Version2:labelSizeV2, Version3:labelSizeV3}[version]
+	if(size == 0) {
+		return Label{}, errors.New(fmt.Sprintf("unsupported archive version %v", version))
+	}
+	if(length != size) {
+		return Label{}, errors.New(fmt.Sprintf("bad label length %v for version %v", length, version))
+	}
+	body := make([]byte, size - 8)
+	_, err = io.ReadFull(reader, body)
+	if(err != nil) {
+		return Label{}, errors.New(fmt.Sprintf("reading label: %v", err))
+	}
+
+	f := &fields{data:body}
+	label := Label{Version:version, PID:f.int()}
+	if(version == Version2) {
+		label.Start = f.timestamp(Version2)
+		label.Volume = f.int()
+		label.Hostname = f.text(64)
+		label.Timezone = f.text(40)
+	} else {
+		label.Start = f.timestamp(Version3)
+		label.Volume = f.int()
+		label.Features = f.uint()
+		f.uint()
+		label.Hostname = f.text(256)
+		label.Timezone = f.text(256)
+		label.Zoneinfo = f.text(256)
+	}
+	if(f.int() != length) {
+		return Label{}, errors.New("label trailer does not match its length")
+	}
+	if(label.Volume != volume) {
+		return Label{}, errors.New(fmt.Sprintf("label is of volume %v, expected %v", label.Volume, volume))
+	}
+	return label, f.err
+}
+
+/* readRecord reads a record framed by its length, returning what lies between the lengths */
+func readRecord(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(reader, header)
+	if(err != nil) {
+		return nil, err
+	}
+	length := int(int32(binary.BigEndian.Uint32(header)))
+	if(length < 8 || length > pdu.MaxSize) {
+		return nil, errors.New(fmt.Sprintf("bad record length %v", length))
+	}
+	record := make([]byte, length - 4)
+	_, err = io.ReadFull(reader, record)
+	if(err != nil) {
+		if(err == io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if(int(int32(binary.BigEndian.Uint32(record[length - 8:]))) != length) {
+		return nil, errors.New("record trailer does not match its length")
+	}
+	return record[:length - 8], nil
+}
+
+/* fields reads the fields of a label or record. The first read past the end records an error and
+   every read after it returns zero, so callers check err once */
+type fields struct {
+	data []byte
+	offset int
+	err error
+}
+
+func (f *fields) take(length int) []byte {
+	if(f.err != nil) {
+		return nil
+	}
+	if(length < 0 || f.offset + length > len(f.data)) {
+		f.err = errors.New("record too short")
+		return nil
+	}
+	taken := f.data[f.offset:f.offset + length]
+	f.offset += length
+	return taken
+}
+
+func (f *fields) uint() uint32 {
+	taken := f.take(4)
+	if(taken == nil) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(taken)
+}
+
+func (f *fields) int() int {
+	return int(int32(f.uint()))
+}
+
+/* count reads a number of following items, each at least item_size bytes long */
+func (f *fields) count(item_size int) int {
+	count := f.int()
+	if(f.err == nil && (count < 0 || count * item_size > len(f.data) - f.offset)) {
+		f.err = errors.New(fmt.Sprintf("bad count %v in record", count))
+		return 0
+	}
+	return count
+}
+
+/* timestamp reads seconds and microseconds for version 2, or 64 bit seconds and nanoseconds for
+   version 3 */
+func (f *fields) timestamp(version int) time.Time {
+	if(version == Version2) {
+		seconds, microseconds := f.int(), f.int()
+		return time.Unix(int64(seconds), int64(microseconds) * 1000)
+	}
+	high, low, nanoseconds := f.uint(), f.uint(), f.int()
+	return time.Unix(int64(uint64(high) << 32 | uint64(low)), int64(nanoseconds))
+}
+
+/* text reads a fixed size field holding a NUL terminated string */
+func (f *fields) text(size int) string {
+	field := f.take(size)
+	end := 0
+	for end < len(field) && field[end] != 0 {
+		end++
+	}
+	return string(field[:end])
+}
+
+func (f *fields) rest() []byte {
+	return f.take(len(f.data) - f.offset)
+}
@@ -0,0 +1,378 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	testLong = uint32(10 << 22 | 1)
+	testBin = uint32(10 << 22 | 2 << 10 | 3)
+	testInDom = uint32(10 << 22 | 1)
+)
+
+var testStart = time.Unix(1700000000, 250000000)
+
+/* record builds a record word by word, as libpcp lays them out */
+type record []byte
+
+func (r record) int(values ...int) record {
+	for _, value := range values {
+		r = binary.BigEndian.AppendUint32(r, uint32(value))
+	}
+	return r
+}
+
+func (r record) text(text string, size int) record {
+	field := make([]byte, size)
+	copy(field, text)
+	return append(r, field...)
+}
+
+func (r record) timestamp(version int, at time.Time) record {
+	if(version == Version2) {
+		return r.int(int(at.Unix()), at.Nanosecond() / 1000)
+	}
+	return r.int(int(at.Unix() >> 32), int(at.Unix()), at.Nanosecond())
+}
+
+/* framed puts the length before and after a record */
+func (r record) framed() []byte {
+	length := binary.BigEndian.AppendUint32(nil, uint32(len(r) + 8))
+	return append(append(append([]byte{}, length...), r...), length...)
+}
+
+func testLabel(version int, volume int) []byte {
+	label := record{}.int(magic | version, 4321).timestamp(version, testStart).int(volume)
+	if(version == Version2) {
+		return label.text("web1", 64).text("AEST-10", 40).framed()
+	}
+	return label.int(0, 0).text("web1", 256).text("AEST-10", 256).text(":Australia/Melbourne", 256).framed()
+}
+
+func testInDomRecord(version int, at time.Time, record_type int, instances []int, names []string) []byte {
+	offsets, strings := []int{}, []byte{}
+	for _, name := range names {
+		if(name == "") {
+			offsets = append(offsets, -1)
+			continue
+		}
+		offsets = append(offsets, len(strings))
+		strings = append(append(strings, name...), 0)
+	}
+	indom := record{}.int(record_type).timestamp(version, at).int(int(testInDom), len(instances))
+	return append(indom.int(instances...).int(offsets...), strings...).framed()
+}
+
+func testLabelRecord(version int, at time.Time, level int, ident uint32, inst int, json string) []byte {
+	record_type := recordLabelV2
+	if(version == Version3) {
+		record_type = recordLabel
+	}
+	labels := record{}.int(record_type).timestamp(version, at).int(level, int(ident), 1, inst, len(json))
+	return append(labels, json...).int(1, 0x00000800, 0x0c000005).framed()
+}
+
+func testResult(version int, result pdu.Result) []byte {
+	if(version == Version2) {
+		return record(pdu.EncodeResult(result).Body).framed()
+	}
+	return record(pdu.EncodeHighResResult(result).Body).framed()
+}
+
+func testValues(t *testing.T, at time.Time, long int, bins map[int]uint64) pdu.Result {
+	result := pdu.Result{Timestamp:at, ValueSets:[]pdu.ValueSet{
+		{PmID:testLong, NumVal:1, ValFmt:pdu.ValInsitu, Values:[]pdu.Value{{Inst:pdu.InNull, Insitu:uint32(long)}}},
+		{PmID:testBin, ValFmt:pdu.ValDptr},
+	}}
+	for _, inst := range []int{100, 200, 300} {
+		if value, found := bins[inst]; found {
+			bin, err := pdu.NewValue(inst, pdu.TypeU64, value)
+			assert.NoError(t, err)
+			result.ValueSets[1].Values = append(result.ValueSets[1].Values, bin)
+			result.ValueSets[1].NumVal++
+		}
+	}
+	return result
+}
+
+/* writeTestArchive writes an archive of two volumes, each of two records ten seconds apart, and
+   returns its base name. Instance 100 of the instance domain goes and 300 comes at the third */
+func writeTestArchive(t *testing.T, version int) string {
+	base := filepath.Join(t.TempDir(), "20231115")
+	at := func(seconds int) time.Time {
+		return testStart.Add(time.Duration(seconds) * time.Second)
+	}
+
+	long_desc := pdu.EncodeDesc(pdu.Desc{PmID:testLong, Type:pdu.Type32, InDom:pdu.InDomNull, Sem:pdu.SemInstant, Units:pdu.Units{DimCount:1}}).Body
+	bin_desc := pdu.EncodeDesc(pdu.Desc{PmID:testBin, Type:pdu.TypeU64, InDom:testInDom, Sem:pdu.SemCounter, Units:pdu.Units{DimSpace:1, ScaleSpace:1}}).Body
+	meta := testLabel(version, VolumeMeta)
+	meta = append(meta, append(record{}.int(recordDesc), long_desc...).int(1, 15).text("sample.long.one", 15).framed()...)
+	meta = append(meta, append(record{}.int(recordDesc), bin_desc...).int(2, 10).text("sample.bin", 10).int(11).text("sample.bins", 11).framed()...)
+	meta = append(meta, record{}.int(recordText, pdu.TextOneline | pdu.TextPmID, int(testLong)).text("1 as a 32-bit integer", 22).framed()...)
+	meta = append(meta, testLabelRecord(version, at(0), pdu.LabelContext, pdu.InDomNull, pdu.InNull, `{"hostname":"web1"}`)...)
+	meta = append(meta, testLabelRecord(version, at(20), pdu.LabelContext, pdu.InDomNull, pdu.InNull, `{"hostname":"web2"}`)...)
+	if(version == Version2) {
+		meta = append(meta, testInDomRecord(version, at(0), recordInDomV2, []int{100, 200}, []string{"bin-100", "bin-200"})...)
+		meta = append(meta, testInDomRecord(version, at(20), recordInDomV2, []int{200, 300}, []string{"bin-200", "bin-300"})...)
+	} else {
+		meta = append(meta, testInDomRecord(version, at(0), recordInDom, []int{100, 200}, []string{"bin-100", "bin-200"})...)
+		meta = append(meta, testInDomRecord(version, at(20), recordInDomDelta, []int{100, 300}, []string{"", "bin-300"})...)
+	}
+	assert.NoError(t, os.WriteFile(base + ".meta", meta, 0644))
+
+	index := testLabel(version, VolumeIndex)
+	for volume := 0; volume < 2; volume++ {
+		data := testLabel(version, volume)
+		first := at(20 * volume)
+		entry := record{}.timestamp(version, first).int(volume)
+		if(version == Version2) {
+			entry = entry.int(0, len(data))
+		} else {
+			entry = entry.int(0, 0, 0, len(data))
+		}
+		index = append(index, entry...)
+		if(volume == 0) {
+			data = append(data, testResult(version, testValues(t, at(0), 1, map[int]uint64{100:1000, 200:2000}))...)
+			data = append(data, testResult(version, testValues(t, at(10), 2, map[int]uint64{100:1100, 200:2200}))...)
+		} else {
+			data = append(data, testResult(version, testValues(t, at(20), 3, map[int]uint64{200:2400, 300:3000}))...)
+			data = append(data, testResult(version, pdu.Result{Timestamp:at(30)})...)
+		}
+		assert.NoError(t, os.WriteFile(base + "." + string(rune('0' + volume)), data, 0644))
+	}
+	assert.NoError(t, os.WriteFile(base + ".index", index, 0644))
+	return base
+}
+
+func openTestArchive(t *testing.T, version int) *Archive {
+	a, err := Open(writeTestArchive(t, version))
+	assert.NoError(t, err)
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func TestOpen_readsTheLabel(t *testing.T) {
+	v2 := openTestArchive(t, Version2)
+	v3 := openTestArchive(t, Version3)
+
+	assert.Equal(t, Label{Version:2, PID:4321, Start:time.Unix(1700000000, 250000000), Volume:-1, Hostname:"web1", Timezone:"AEST-10"}, v2.Label)
+	assert.Equal(t, Label{Version:3, PID:4321, Start:time.Unix(1700000000, 250000000), Volume:-1, Hostname:"web1", Timezone:"AEST-10", Zoneinfo:":Australia/Melbourne"}, v3.Label)
+}
+
+func TestOpen_acceptsTheNameOfAnyFileOfTheArchive(t *testing.T) {
+	base := writeTestArchive(t, Version3)
+
+	for _, name := range []string{base, base + ".meta", base + ".index", base + ".1"} {
+		a, err := Open(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "web1", a.Label.Hostname, name)
+	}
+}
+
+func TestOpen_rejectsAFileThatIsNotAnArchive(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "notes")
+	os.WriteFile(base + ".meta", record{}.int(0x12345678).framed(), 0644)
+
+	_, err := Open(base)
+
+	assert.EqualError(t, err, base + ".meta: not a PCP archive, bad magic 0x12345678")
+}
+
+func TestOpen_explainsCompressedArchivesAreNotRead(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "20231115")
+	os.WriteFile(base + ".meta.xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0}, 0644)
+
+	_, err := Open(base)
+
+	assert.EqualError(t, err, base + " is compressed, decompress it with xz -d first")
+}
+
+func TestOpen_needsADataVolume(t *testing.T) {
+	base := writeTestArchive(t, Version2)
+	os.Remove(base + ".0")
+	os.Remove(base + ".1")
+
+	_, err := Open(base)
+
+	assert.EqualError(t, err, base + " has no data volumes")
+}
+
+func TestArchive_readsDescriptorsAndNames(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+
+		desc, found := a.Desc(testBin)
+		assert.True(t, found)
+		assert.Equal(t, pdu.Desc{PmID:testBin, Type:pdu.TypeU64, InDom:testInDom, Sem:pdu.SemCounter, Units:pdu.Units{DimSpace:1, ScaleSpace:1}}, desc)
+		pmid, found := a.LookupName("sample.bins")
+		assert.True(t, found)
+		assert.Equal(t, testBin, pmid)
+		assert.Equal(t, []string{"sample.bin", "sample.bins"}, a.Names(testBin))
+		assert.Equal(t, []string{"sample.bin", "sample.bins", "sample.long.one"}, a.Metrics())
+		_, found = a.LookupName("sample.missing")
+		assert.False(t, found)
+	}
+}
+
+func TestInDom_givesTheInstancesAsTheyWereAtATime(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+
+		_, found := a.InDom(testInDom, testStart.Add(-time.Second))
+		assert.False(t, found)
+		first, _ := a.InDom(testInDom, testStart.Add(19 * time.Second))
+		assert.Equal(t, map[int]string{100:"bin-100", 200:"bin-200"}, first.Instances)
+		second, _ := a.InDom(testInDom, testStart.Add(time.Hour))
+		assert.Equal(t, InDom{Time:testStart.Add(20 * time.Second), Instances:map[int]string{200:"bin-200", 300:"bin-300"}}, second)
+		assert.Len(t, a.InDomHistory(testInDom), 2)
+	}
+}
+
+func TestLabels_givesTheSetsAsTheyWereAtATime(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+
+		sets, found := a.Labels(pdu.LabelContext, pdu.InDomNull, testStart.Add(time.Second))
+		assert.True(t, found)
+		assert.Equal(t, []pdu.LabelSet{{Inst:pdu.InNull, Labels:map[string]string{"hostname":"web1"}}}, sets)
+		sets, _ = a.Labels(pdu.LabelContext, pdu.InDomNull, testStart.Add(time.Minute))
+		assert.Equal(t, "web2", sets[0].Labels["hostname"])
+		_, found = a.Labels(pdu.LabelItem, testBin, testStart)
+		assert.False(t, found)
+	}
+}
+
+func TestText_givesLoggedHelpText(t *testing.T) {
+	a := openTestArchive(t, Version2)
+
+	text, found := a.Text(pdu.TextOneline | pdu.TextPmID, testLong)
+
+	assert.True(t, found)
+	assert.Equal(t, "1 as a 32-bit integer", text)
+	_, found = a.Text(pdu.TextHelp | pdu.TextPmID, testLong)
+	assert.False(t, found)
+}
+
+func TestNext_readsEveryRecordAcrossTheVolumes(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+
+		timestamps := []time.Time{}
+		var results []pdu.Result
+		for {
+			result, err := a.Next()
+			if(err == io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+			timestamps = append(timestamps, result.Timestamp)
+			results = append(results, result)
+		}
+
+		assert.Equal(t, []time.Time{testStart, testStart.Add(10 * time.Second), testStart.Add(20 * time.Second), testStart.Add(30 * time.Second)}, timestamps)
+		assert.Equal(t, testValues(t, testStart.Add(20 * time.Second), 3, map[int]uint64{200:2400, 300:3000}).ValueSets, results[2].ValueSets)
+		assert.Empty(t, results[3].ValueSets, "a mark record")
+	}
+}
+
+func TestIndex_givesTheEntriesOfTheIndex(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+		offset := int64(labelSizeV2)
+		if(version == Version3) {
+			offset = labelSizeV3
+		}
+
+		assert.Equal(t, []IndexEntry{
+			{Time:testStart, Volume:0, VolumeOffset:offset},
+			{Time:testStart.Add(20 * time.Second), Volume:1, VolumeOffset:offset},
+		}, a.Index())
+	}
+}
+
+func TestSeek_findsTheFirstRecordAtOrAfterATime(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openTestArchive(t, version)
+
+		assert.NoError(t, a.Seek(testStart.Add(15 * time.Second)))
+		result, err := a.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, testStart.Add(20 * time.Second), result.Timestamp)
+
+		assert.NoError(t, a.Seek(testStart.Add(10 * time.Second)))
+		result, _ = a.Next()
+		assert.Equal(t, testStart.Add(10 * time.Second), result.Timestamp)
+
+		assert.NoError(t, a.Seek(testStart.Add(-time.Hour)))
+		result, _ = a.Next()
+		assert.Equal(t, testStart, result.Timestamp)
+
+		assert.NoError(t, a.Seek(testStart.Add(time.Hour)))
+		_, err = a.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestSeek_readsFromTheStartWithoutAnIndex(t *testing.T) {
+	base := writeTestArchive(t, Version3)
+	os.Remove(base + ".index")
+	a, err := Open(base)
+	assert.NoError(t, err)
+	defer a.Close()
+
+	assert.NoError(t, a.Seek(testStart.Add(25 * time.Second)))
+	result, err := a.Next()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testStart.Add(30 * time.Second), result.Timestamp)
+	assert.Empty(t, a.Index())
+}
+
+func TestEnd_givesTheTimeOfTheLastRecord(t *testing.T) {
+	a := openTestArchive(t, Version2)
+
+	end, err := a.End()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testStart.Add(30 * time.Second), end)
+}
+
+func TestNext_reportsATruncatedVolume(t *testing.T) {
+	base := writeTestArchive(t, Version2)
+	data, _ := os.ReadFile(base + ".0")
+	os.WriteFile(base + ".0", data[:len(data) - 6], 0644)
+	a, _ := Open(base)
+	defer a.Close()
+
+	a.Next()
+	_, err := a.Next()
+
+	assert.EqualError(t, err, base + ".0: unexpected EOF")
+}
@@ -0,0 +1,196 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+/* The golden archives are written by pmlogger and described by the PCP tools, see
+   testdata/mkgolden.sh */
+
+/* goldenArchive gives the base name of the pmlogger archive of a version, failing the test when it
+   has not been recorded */
+func goldenArchive(t *testing.T, version int) string {
+	base := filepath.Join("testdata", fmt.Sprintf("v%v", version), "golden")
+	if _, err := os.Stat(base + ".meta"); err != nil {
+		t.Fatalf("no pmlogger archive at %v, record one with testdata/mkgolden.sh", base)
+	}
+	return base
+}
+
+/* goldenReport gives the lines of what a PCP tool reported for a golden archive */
+func goldenReport(t *testing.T, version int, name string) []string {
+	file, err := os.Open(filepath.Join("testdata", fmt.Sprintf("v%v", version), name))
+	if(err != nil) {
+		t.Fatalf("golden archive without its report: %v", err)
+	}
+	defer file.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func openGoldenArchive(t *testing.T, version int) *Archive {
+	a, err := Open(goldenArchive(t, version))
+	if(err != nil) {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+/* The names pmdumplog gives types and semantics */
+var dumpedTypes = map[int]string{
+	pdu.Type32:"32-bit int", pdu.TypeU32:"32-bit unsigned int", pdu.Type64:"64-bit int",
+	pdu.TypeU64:"64-bit unsigned int", pdu.TypeFloat:"float", pdu.TypeDouble:"double", pdu.TypeString:"string",
+}
+var dumpedSemantics = map[int]string{pdu.SemCounter:"counter", pdu.SemInstant:"instant", pdu.SemDiscrete:"discrete"}
+
+func indomString(indom uint32) string {
+	if(indom == pdu.InDomNull) {
+		return "PM_INDOM_NULL"
+	}
+	return fmt.Sprintf("%v.%v", indom >> 22, indom & 0x3fffff)
+}
+
+func TestGolden_readsTheDescriptorsPmdumplogReports(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		assertDescriptorsReported(t, openGoldenArchive(t, version), version)
+	}
+}
+
+/* assertDescriptorsReported checks an archive holds the descriptors pmdumplog -d reported for the
+   golden archive of a version */
+func assertDescriptorsReported(t *testing.T, a *Archive, version int) {
+	pmid_line := regexp.MustCompile(`^PMID: (\S+) \((.*)\)$`)
+	type_line := regexp.MustCompile(`^\s+Data Type: (.+?)\s+InDom: (\S+)`)
+	semantics_line := regexp.MustCompile(`^\s+Semantics: (\S+)`)
+	reported := 0
+	var desc pdu.Desc
+	for _, line := range goldenReport(t, version, "pmdumplog-d.txt") {
+		if match := pmid_line.FindStringSubmatch(line); match != nil {
+			pmid, found := a.LookupName(match[2])
+			assert.True(t, found, match[2])
+			assert.Equal(t, match[1], pmidString(pmid), match[2])
+			desc, _ = a.Desc(pmid)
+			reported++
+		} else if match := type_line.FindStringSubmatch(line); match != nil {
+			assert.Equal(t, match[1], dumpedTypes[desc.Type], pmidString(desc.PmID))
+			assert.Equal(t, match[2], indomString(desc.InDom), pmidString(desc.PmID))
+		} else if match := semantics_line.FindStringSubmatch(line); match != nil {
+			assert.Equal(t, match[1], dumpedSemantics[desc.Sem], pmidString(desc.PmID))
+		}
+	}
+	assert.NotZero(t, reported)
+	assert.Len(t, a.Metrics(), reported)
+}
+
+func TestGolden_readsTheInstanceDomainsPmdumplogReports(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		assertInDomsReported(t, openGoldenArchive(t, version), version)
+	}
+}
+
+/* assertInDomsReported checks the first instances an archive holds for each instance domain are
+   those pmdumplog -i reported for the golden archive of a version */
+func assertInDomsReported(t *testing.T, a *Archive, version int) {
+	indom_line := regexp.MustCompile(`^InDom: (\S+)`)
+	instance_line := regexp.MustCompile(`^\s+(-?\d+) or "(.*)"$`)
+	/* The first instances logged for each domain, which are never a delta */
+	reported := map[string]map[int]string{}
+	current := ""
+	for _, line := range goldenReport(t, version, "pmdumplog-i.txt") {
+		if match := indom_line.FindStringSubmatch(line); match != nil {
+			current = match[1]
+			if _, seen := reported[current]; seen {
+				current = ""
+			} else {
+				reported[current] = map[int]string{}
+			}
+		} else if match := instance_line.FindStringSubmatch(line); match != nil && current != "" {
+			inst, _ := strconv.Atoi(match[1])
+			reported[current][inst] = match[2]
+		}
+	}
+	assert.NotEmpty(t, reported)
+
+	read := map[string]map[int]string{}
+	for _, name := range a.Metrics() {
+		pmid, _ := a.LookupName(name)
+		desc, _ := a.Desc(pmid)
+		if history := a.InDomHistory(desc.InDom); len(history) > 0 {
+			read[indomString(desc.InDom)] = history[0].Instances
+		}
+	}
+	assert.Equal(t, reported, read)
+}
+
+func TestGolden_readsTheIndexPmdumplogReports(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		assertIndexReported(t, openGoldenArchive(t, version), version)
+	}
+}
+
+/* assertIndexReported checks an archive's temporal index is the one pmdumplog -t reported for the
+   golden archive of a version */
+func assertIndexReported(t *testing.T, a *Archive, version int) {
+	entry_line := regexp.MustCompile(`^\d.*\s(\d+)\s+(\d+)\s+(\d+)\s*$`)
+	reported := [][]int64{}
+	for _, line := range goldenReport(t, version, "pmdumplog-t.txt") {
+		if match := entry_line.FindStringSubmatch(line); match != nil {
+			volume, _ := strconv.ParseInt(match[1], 10, 64)
+			meta, _ := strconv.ParseInt(match[2], 10, 64)
+			log, _ := strconv.ParseInt(match[3], 10, 64)
+			reported = append(reported, []int64{volume, meta, log})
+		}
+	}
+
+	read := [][]int64{}
+	for _, entry := range a.Index() {
+		read = append(read, []int64{int64(entry.Volume), entry.MetaOffset, entry.VolumeOffset})
+	}
+	assert.NotEmpty(t, reported)
+	assert.Equal(t, reported, read)
+}
+
+func TestGolden_seeksToEachIndexedRecord(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		a := openGoldenArchive(t, version)
+		for _, entry := range a.Index() {
+			assert.NoError(t, a.Seek(entry.Time))
+			result, err := a.Next()
+			assert.NoError(t, err)
+			assert.False(t, result.Timestamp.Before(entry.Time), "%v", entry.Time)
+		}
+	}
+}
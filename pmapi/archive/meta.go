@@ -0,0 +1,296 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+/* The types of the records in a .meta file */
+const (
+	recordDesc = 1
+	recordInDomV2 = 2
+	recordLabelV2 = 3
+	recordText = 4
+	recordInDom = 5
+	recordInDomDelta = 6
+	recordLabel = 7
+)
+
+/* InDom is the instances of an instance domain from Time until the next InDom logged for it */
+type InDom struct {
+	Time time.Time
+	Instances map[int]string
+}
+
+/* Labels is the label sets of one level of the hierarchy from Time until the next logged for it.
+   Only sets of the LabelInstances level name an instance, the rest have an Inst of pdu.InNull */
+type Labels struct {
+	Time time.Time
+	Sets []pdu.LabelSet
+}
+
+/* metadataKey identifies the labels or help text of a level or kind and its identifier */
+type metadataKey struct {
+	kind int
+	ident uint32
+}
+
+type metadata struct {
+	descs map[uint32]pdu.Desc
+	pmids map[string]uint32
+	names map[uint32][]string
+	indoms map[uint32][]InDom
+	labels map[metadataKey][]Labels
+	text map[metadataKey]string
+}
+
+/* readMetadata reads every record of a .meta file after its label */
+func readMetadata(reader *bufio.Reader, version int) (*metadata, error) {
+	meta := &metadata{
+		descs:make(map[uint32]pdu.Desc),
+		pmids:make(map[string]uint32),
+		names:make(map[uint32][]string),
+		indoms:make(map[uint32][]InDom),
+		labels:make(map[metadataKey][]Labels),
+		text:make(map[metadataKey]string),
+	}
+	for {
+		record, err := readRecord(reader)
+		if(err == io.EOF) {
+			break
+		}
+		if(err != nil) {
+			return nil, err
+		}
+		f := &fields{data:record}
+		record_type := f.int()
+		switch record_type {
+		case recordDesc:
+			meta.readDesc(f)
+		case recordInDomV2:
+			meta.readInDom(f, Version2, false)
+		case recordInDom:
+			meta.readInDom(f, Version3, false)
+		case recordInDomDelta:
+			meta.readInDom(f, Version3, true)
+		case recordLabelV2:
+			meta.readLabels(f, Version2)
+		case recordLabel:
+			meta.readLabels(f, Version3)
+		case recordText:
+			meta.readText(f)
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown metadata record type %v", record_type))
+		}
+		if(f.err != nil) {
+			return nil, errors.New(fmt.Sprintf("metadata record of type %v: %v", record_type, f.err))
+		}
+		if(version == Version2 && record_type > recordText) {
+			return nil, errors.New(fmt.Sprintf("metadata record of type %v in a version 2 archive", record_type))
+		}
+	}
+	for indom := range meta.indoms {
+		history := meta.indoms[indom]
+		sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+	}
+	for key := range meta.labels {
+		history := meta.labels[key]
+		sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+	}
+	return meta, nil
+}
+
+/* readDesc reads a descriptor and the names of its metric */
+func (m *metadata) readDesc(f *fields) {
+	desc, err := pdu.DecodeDesc(pdu.PDU{Type:pdu.TypeDesc, Body:f.take(20)})
+	if(f.err != nil) {
+		return
+	}
+	if(err != nil) {
+		f.err = err
+		return
+	}
+	m.descs[desc.PmID] = desc
+	names := f.count(4)
+	for i := 0; i < names; i++ {
+		name := string(f.take(f.int()))
+		if(f.err != nil) {
+			return
+		}
+		if _, found := m.pmids[name]; !found {
+			m.names[desc.PmID] = append(m.names[desc.PmID], name)
+		}
+		m.pmids[name] = desc.PmID
+	}
+}
+
+/* readInDom reads the instances of an instance domain. A delta holds only the instances added
+   since the last record, and those removed with a string index of -1 */
+func (m *metadata) readInDom(f *fields, version int, delta bool) {
+	timestamp := f.timestamp(version)
+	indom := f.uint()
+	count := f.count(8)
+	instances := make([]int, count)
+	for i := range instances {
+		instances[i] = f.int()
+	}
+	offsets := make([]int, count)
+	for i := range offsets {
+		offsets[i] = f.int()
+	}
+	strings := f.rest()
+	if(f.err != nil) {
+		return
+	}
+
+	set := make(map[int]string)
+	history := m.indoms[indom]
+	if(delta && len(history) > 0) {
+		for inst, name := range history[len(history) - 1].Instances {
+			set[inst] = name
+		}
+	}
+	for i, inst := range instances {
+		if(delta && offsets[i] == -1) {
+			delete(set, inst)
+			continue
+		}
+		if(offsets[i] < 0 || offsets[i] >= len(strings)) {
+			f.err = errors.New(fmt.Sprintf("bad name offset %v for instance %v", offsets[i], inst))
+			return
+		}
+		end := offsets[i]
+		for end < len(strings) && strings[end] != 0 {
+			end++
+		}
+		set[inst] = string(strings[offsets[i]:end])
+	}
+	m.indoms[indom] = append(history, InDom{Time:timestamp, Instances:set})
+}
+
+/* readLabels reads the label sets of a level. Each set's JSON is followed by libpcp's parse of
+   it, which is skipped as the JSON is parsed again */
+func (m *metadata) readLabels(f *fields, version int) {
+	timestamp := f.timestamp(version)
+	key := metadataKey{kind:f.int()}
+	key.ident = f.uint()
+	sets := make([]pdu.LabelSet, f.count(12))
+	for i := range sets {
+		sets[i].Inst = f.int()
+		text := f.take(f.int())
+		parsed := f.int()
+		if(parsed > 0) {
+			f.take(parsed * 8)
+		}
+		if(f.err != nil) {
+			return
+		}
+		var err error
+		sets[i].Labels, err = pdu.LabelsFromJSON(text)
+		if(err != nil) {
+			f.err = errors.New(fmt.Sprintf("labels %q: %v", text, err))
+			return
+		}
+	}
+	m.labels[key] = append(m.labels[key], Labels{Time:timestamp, Sets:sets})
+}
+
+/* readText reads help text, whose kind combines pdu.TextOneline or pdu.TextHelp with pdu.TextPmID
+   or pdu.TextInDom */
+func (m *metadata) readText(f *fields) {
+	key := metadataKey{kind:f.int()}
+	key.ident = f.uint()
+	text := f.rest()
+	end := 0
+	for end < len(text) && text[end] != 0 {
+		end++
+	}
+	if(f.err == nil) {
+		m.text[key] = string(text[:end])
+	}
+}
+
+/* Desc gives the descriptor of a metric */
+func (a *Archive) Desc(pmid uint32) (pdu.Desc, bool) {
+	desc, found := a.meta.descs[pmid]
+	return desc, found
+}
+
+/* LookupName gives the PMID of a metric name */
+func (a *Archive) LookupName(name string) (uint32, bool) {
+	pmid, found := a.meta.pmids[name]
+	return pmid, found
+}
+
+/* Names gives the names of a metric, in the order they were logged */
+func (a *Archive) Names(pmid uint32) []string {
+	return a.meta.names[pmid]
+}
+
+/* Metrics gives the name of every metric in the archive, sorted */
+func (a *Archive) Metrics() []string {
+	names := make([]string, 0, len(a.meta.pmids))
+	for name := range a.meta.pmids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* InDom gives the instances of an instance domain as they were at a time, false if none had been
+   logged by then */
+func (a *Archive) InDom(indom uint32, at time.Time) (InDom, bool) {
+	history := a.meta.indoms[indom]
+	i := sort.Search(len(history), func(i int) bool { return history[i].Time.After(at) })
+	if(i == 0) {
+		return InDom{}, false
+	}
+	return history[i - 1], true
+}
+
+/* InDomHistory gives every logged state of an instance domain in time order */
+func (a *Archive) InDomHistory(indom uint32) []InDom {
+	return a.meta.indoms[indom]
+}
+
+/* Labels gives the label sets of a level as they were at a time. ident is as for pdu.LabelReq:
+   pdu.InDomNull for the context, then the domain number, instance domain, or cluster or item PMID */
+func (a *Archive) Labels(level int, ident uint32, at time.Time) ([]pdu.LabelSet, bool) {
+	history := a.meta.labels[metadataKey{kind:level, ident:ident}]
+	i := sort.Search(len(history), func(i int) bool { return history[i].Time.After(at) })
+	if(i == 0) {
+		return nil, false
+	}
+	return history[i - 1].Sets, true
+}
+
+/* Text gives help text, kind being as for pdu.TextReq */
+func (a *Archive) Text(kind int, ident uint32) (string, bool) {
+	text, found := a.meta.text[metadataKey{kind:kind, ident:ident}]
+	return text, found
+}
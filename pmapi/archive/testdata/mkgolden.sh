@@ -0,0 +1,36 @@
+#!/bin/sh
+# Records the pmlogger archives the golden tests of pmapi/archive and pmapi read, version 2 in v2/
+# and version 3 in v3/, along with what pmdumplog, pminfo and pmval report for them. Run it from
+# this directory on a host with PCP 6 or later and pmcd running, then check the results in.
+#
+# The tests compare the reader against the reports and the writer's records against pmlogger's,
+# and fail while the archives are missing.
+
+set -e
+
+metrics="kernel.all.load kernel.all.pswitch hinv.ncpu mem.util.free"
+config=$(mktemp)
+trap 'rm -f "$config"' EXIT
+{
+	echo "log mandatory on 1 sec {"
+	for metric in $metrics; do
+		echo "	$metric"
+	done
+	echo "}"
+} > "$config"
+
+for version in 2 3; do
+	mkdir -p v$version
+	rm -f v$version/golden.* v$version/*.txt
+	base=v$version/golden
+	pmlogger -V $version -c "$config" -s 5 -l v$version/pmlogger.log $base
+	rm -f v$version/pmlogger.log
+
+	pmdumplog -d $base > v$version/pmdumplog-d.txt
+	pmdumplog -i $base > v$version/pmdumplog-i.txt
+	pmdumplog -t $base > v$version/pmdumplog-t.txt
+	pminfo -a $base -l $metrics > v$version/pminfo-l.txt
+	for metric in kernel.all.pswitch mem.util.free; do
+		pmval -r -f 6 -t 1sec -a $base $metric > v$version/pmval-$metric.txt
+	done
+done
@@ -0,0 +1,214 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+/* IndexEntry is an entry of the temporal index: where in the metadata and which data volume
+   records logged from Time start */
+type IndexEntry struct {
+	Time time.Time
+	Volume int
+	MetaOffset int64
+	VolumeOffset int64
+}
+
+/* readIndex reads a .index file. An archive missing its index is read from the start of its first
+   volume instead */
+func readIndex(path string, version int) ([]IndexEntry, error) {
+	file, err := os.Open(path)
+	if(os.IsNotExist(err)) {
+		return nil, nil
+	}
+	if(err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	_, err = readLabel(reader, VolumeIndex)
+	if(err != nil) {
+		return nil, err
+	}
+
+	size := 20
+	if(version == Version3) {
+		size = 32
+	}
+	entries := []IndexEntry{}
+	for {
+		entry := make([]byte, size)
+		_, err = io.ReadFull(reader, entry)
+		if(err == io.EOF) {
+			return entries, nil
+		}
+		if(err != nil) {
+			return nil, err
+		}
+		f := &fields{data:entry}
+		index_entry := IndexEntry{Time:f.timestamp(version), Volume:f.int()}
+		if(version == Version2) {
+			index_entry.MetaOffset = int64(f.uint())
+			index_entry.VolumeOffset = int64(f.uint())
+		} else {
+			index_entry.MetaOffset = int64(uint64(f.uint()) << 32 | uint64(f.uint()))
+			index_entry.VolumeOffset = int64(uint64(f.uint()) << 32 | uint64(f.uint()))
+		}
+		entries = append(entries, index_entry)
+	}
+}
+
+/* Index gives the entries of the temporal index in time order */
+func (a *Archive) Index() []IndexEntry {
+	return a.index
+}
+
+/* Seek positions the archive so that Next returns the first record logged at or after a time */
+func (a *Archive) Seek(at time.Time) error {
+	a.pending = nil
+	i := sort.Search(len(a.index), func(i int) bool { return a.index[i].Time.After(at) })
+	if(i == 0) {
+		err := a.openVolume(a.volumes[0], -1)
+		if(err != nil) {
+			return err
+		}
+	} else {
+		entry := a.index[i - 1]
+		err := a.openVolume(entry.Volume, entry.VolumeOffset)
+		if(err != nil) {
+			return err
+		}
+	}
+	for {
+		result, err := a.Next()
+		if(err == io.EOF) {
+			return nil
+		}
+		if(err != nil) {
+			return err
+		}
+		if(!result.Timestamp.Before(at)) {
+			a.pending = &result
+			return nil
+		}
+	}
+}
+
+/* Next reads the next record, moving on through the volumes and returning io.EOF after the last.
+   Records with no value sets are marks, logged where pmlogger stopped or lost data */
+func (a *Archive) Next() (pdu.Result, error) {
+	if(a.pending != nil) {
+		result := *a.pending
+		a.pending = nil
+		return result, nil
+	}
+	if(a.reader == nil) {
+		err := a.openVolume(a.volumes[0], -1)
+		if(err != nil) {
+			return pdu.Result{}, err
+		}
+	}
+	for {
+		record, err := readRecord(a.reader)
+		if(err == io.EOF) {
+			next := sort.SearchInts(a.volumes, a.volume + 1)
+			if(next == len(a.volumes)) {
+				return pdu.Result{}, io.EOF
+			}
+			err = a.openVolume(a.volumes[next], -1)
+			if(err != nil) {
+				return pdu.Result{}, err
+			}
+			continue
+		}
+		if(err != nil) {
+			return pdu.Result{}, errors.New(fmt.Sprintf("%v.%v: %v", a.base, a.volume, err))
+		}
+		var result pdu.Result
+		if(a.Label.Version == Version2) {
+			result, err = pdu.DecodeResult(pdu.PDU{Type:pdu.TypeResult, Body:record})
+		} else {
+			result, err = pdu.DecodeHighResResult(pdu.PDU{Type:pdu.TypeHighResResult, Body:record})
+		}
+		if(err != nil) {
+			return pdu.Result{}, errors.New(fmt.Sprintf("%v.%v: %v", a.base, a.volume, err))
+		}
+		return result, nil
+	}
+}
+
+/* End gives the time of the last record. It reads through the last volume, so Seek afterwards to
+   read records again */
+func (a *Archive) End() (time.Time, error) {
+	start := a.Label.Start
+	if(len(a.index) > 0) {
+		start = a.index[len(a.index) - 1].Time
+	}
+	err := a.Seek(start)
+	if(err != nil) {
+		return time.Time{}, err
+	}
+	end := a.Label.Start
+	for {
+		result, err := a.Next()
+		if(err == io.EOF) {
+			return end, nil
+		}
+		if(err != nil) {
+			return time.Time{}, err
+		}
+		end = result.Timestamp
+	}
+}
+
+/* openVolume opens a data volume to read from offset, or from just after its label when offset is
+   negative */
+func (a *Archive) openVolume(volume int, offset int64) error {
+	a.Close()
+	file, err := os.Open(fmt.Sprintf("%v.%v", a.base, volume))
+	if(err != nil) {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	_, err = readLabel(reader, volume)
+	if(err != nil) {
+		file.Close()
+		return errors.New(fmt.Sprintf("%v.%v: %v", a.base, volume, err))
+	}
+	if(offset >= 0) {
+		_, err = file.Seek(offset, io.SeekStart)
+		if(err != nil) {
+			file.Close()
+			return err
+		}
+		reader.Reset(file)
+	}
+	a.volume, a.file, a.reader = volume, file, reader
+	return nil
+}
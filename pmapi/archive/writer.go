@@ -0,0 +1,538 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/* indexInterval is how many records pmlogger-like writers put between temporal index entries, on top
+   of an entry at the start of each volume */
+const indexInterval = 100
+
+var errClosed = errors.New("archive writer is closed")
+
+/* Writer writes an archive as pmlogger does. Descriptors and instance domains must be put before
+   the results that need them, and results in time order. Nothing is complete until Close */
+type Writer struct {
+	/* When non-zero, a new data volume is started before a record that would take the current
+	   one past this many bytes */
+	VolumeSize int64
+	base string
+	label Label
+	meta *output
+	index *output
+	volume *output
+	volume_number int
+	descs map[uint32]pdu.Desc
+	pmids map[string]uint32
+	indoms map[uint32]map[int]string
+	/* Whether a result has been written, after which the label's start is settled */
+	started bool
+	/* The time and offset of the last record, and how many records have been written since the
+	   last index entry */
+	last time.Time
+	last_offset int64
+	unindexed int
+}
+
+/* output is a file of the archive being written and how many bytes it holds */
+type output struct {
+	file *os.File
+	writer *bufio.Writer
+	size int64
+}
+
+/* Create starts an archive of base name base, refusing to replace one. Version defaults to
+   Version3, PID to this process, Hostname to this host's and Timezone to the local zone's. A zero
+   Start becomes the time of the first result. Volume is ignored, each file being labelled with its
+   own */
+func Create(base string, label Label) (*Writer, error) {
+	if(label.Version == 0) {
+		label.Version = Version3
+	}
+	if(label.Version != Version2 && label.Version != Version3) {
+		return nil, errors.New(fmt.Sprintf("unsupported archive version %v", label.Version))
+	}
+	if(label.PID == 0) {
+		label.PID = os.Getpid()
+	}
+	if(label.Hostname == "") {
+		label.Hostname, _ = os.Hostname()
+	}
+	if(label.Timezone == "") {
+		label.Timezone = posixTimezone(time.Now())
+	}
+	w := &Writer{
+		base:base,
+		label:label,
+		descs:make(map[uint32]pdu.Desc),
+		pmids:make(map[string]uint32),
+		indoms:make(map[uint32]map[int]string),
+	}
+	var err error
+	w.meta, err = w.createOutput(".meta", VolumeMeta)
+	if(err != nil) {
+		return nil, err
+	}
+	w.index, err = w.createOutput(".index", VolumeIndex)
+	if(err == nil) {
+		w.volume, err = w.createOutput(".0", 0)
+	}
+	if(err != nil) {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+/* posixTimezone gives the local zone as $TZ would set it, such as AEST-10 */
+func posixTimezone(at time.Time) string {
+	name, offset := at.Zone()
+	sign := "-"
+	if(offset <= 0) {
+		sign, offset = "", -offset
+	}
+	if(offset == 0) {
+		return name + "0"
+	}
+	if(offset % 3600 != 0) {
+		return fmt.Sprintf("%v%v%v:%02d", name, sign, offset / 3600, offset % 3600 / 60)
+	}
+	return fmt.Sprintf("%v%v%v", name, sign, offset / 3600)
+}
+
+/* createOutput creates a file of the archive, starting it with its label */
+func (w *Writer) createOutput(suffix string, volume int) (*output, error) {
+	file, err := os.OpenFile(w.base + suffix, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+	if(err != nil) {
+		return nil, err
+	}
+	o := &output{file:file, writer:bufio.NewWriter(file)}
+	err = o.write(w.encodeLabel(volume))
+	if(err != nil) {
+		o.close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *output) write(data []byte) error {
+	_, err := o.writer.Write(data)
+	o.size += int64(len(data))
+	return err
+}
+
+func (o *output) close() error {
+	err := o.writer.Flush()
+	close_err := o.file.Close()
+	if(err == nil) {
+		err = close_err
+	}
+	return err
+}
+
+func (w *Writer) encodeLabel(volume int) []byte {
+	e := &encoder{}
+	e.uint(uint32(magic | w.label.Version))
+	e.int(w.label.PID)
+	e.timestamp(w.label.Version, w.label.Start)
+	e.int(volume)
+	if(w.label.Version == Version2) {
+		e.text(w.label.Hostname, 64)
+		e.text(w.label.Timezone, 40)
+	} else {
+		e.uint(w.label.Features)
+		e.int(0)
+		e.text(w.label.Hostname, 256)
+		e.text(w.label.Timezone, 256)
+		e.text(w.label.Zoneinfo, 256)
+	}
+	return e.framed()
+}
+
+/* PutDesc puts the descriptor of a metric and the names it goes by */
+func (w *Writer) PutDesc(desc pdu.Desc, names ...string) error {
+	if(len(names) == 0) {
+		return errors.New(fmt.Sprintf("PMID %v needs a name", pmidString(desc.PmID)))
+	}
+	if known, found := w.descs[desc.PmID]; found && known != desc {
+		return errors.New(fmt.Sprintf("PMID %v is already described differently", pmidString(desc.PmID)))
+	}
+	for _, name := range names {
+		if pmid, found := w.pmids[name]; found && pmid != desc.PmID {
+			return errors.New(fmt.Sprintf("%v already names PMID %v", name, pmidString(pmid)))
+		}
+	}
+	e := &encoder{}
+	e.int(recordDesc)
+	e.bytes(pdu.EncodeDesc(desc).Body)
+	e.int(len(names))
+	for _, name := range names {
+		e.int(len(name))
+		e.bytes([]byte(name))
+	}
+	err := w.writeMeta(e)
+	if(err != nil) {
+		return err
+	}
+	w.descs[desc.PmID] = desc
+	for _, name := range names {
+		w.pmids[name] = desc.PmID
+	}
+	return nil
+}
+
+func (w *Writer) writeMeta(e *encoder) error {
+	if(w.meta == nil) {
+		return errClosed
+	}
+	return w.meta.write(e.framed())
+}
+
+/* PutInDom puts the instances of an instance domain from a time on. Nothing is written when they
+   have not changed */
+func (w *Writer) PutInDom(indom uint32, at time.Time, instances map[int]string) error {
+	if(w.indoms[indom] != nil && reflect.DeepEqual(w.indoms[indom], instances)) {
+		return nil
+	}
+	insts := make([]int, 0, len(instances))
+	for inst := range instances {
+		insts = append(insts, inst)
+	}
+	sort.Ints(insts)
+
+	e := &encoder{}
+	if(w.label.Version == Version2) {
+		e.int(recordInDomV2)
+	} else {
+		e.int(recordInDom)
+	}
+	e.timestamp(w.label.Version, at)
+	e.uint(indom)
+	e.int(len(insts))
+	for _, inst := range insts {
+		e.int(inst)
+	}
+	offset := 0
+	for _, inst := range insts {
+		e.int(offset)
+		offset += len(instances[inst]) + 1
+	}
+	for _, inst := range insts {
+		e.bytes(append([]byte(instances[inst]), 0))
+	}
+	err := w.writeMeta(e)
+	if(err != nil) {
+		return err
+	}
+	copied := make(map[int]string)
+	for inst, name := range instances {
+		copied[inst] = name
+	}
+	w.indoms[indom] = copied
+	return nil
+}
+
+/* PutLabels puts the label sets of a level of the hierarchy from a time on. level and ident are as
+   for pdu.LabelReq. Values that are JSON numbers, booleans, null, arrays or objects are written as
+   such and anything else as a string, the reverse of how pdu.LabelsFromJSON reads them */
+func (w *Writer) PutLabels(level int, ident uint32, at time.Time, sets []pdu.LabelSet) error {
+	e := &encoder{}
+	if(w.label.Version == Version2) {
+		e.int(recordLabelV2)
+	} else {
+		e.int(recordLabel)
+	}
+	e.timestamp(w.label.Version, at)
+	e.int(level)
+	e.uint(ident)
+	e.int(len(sets))
+	for _, set := range sets {
+		text, labels, err := encodeLabels(set.Labels, level)
+		if(err != nil) {
+			return err
+		}
+		e.int(set.Inst)
+		e.int(len(text))
+		e.bytes(text)
+		e.int(len(labels) / 8)
+		e.bytes(labels)
+	}
+	return w.writeMeta(e)
+}
+
+/* encodeLabels gives the JSON of a label set, with its names sorted as libpcp keeps them, and the
+   pmLabel of each label: where in the JSON its name and value are and its flags */
+func encodeLabels(labels map[string]string, level int) ([]byte, []byte, error) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text := []byte{'{'}
+	parsed := &encoder{}
+	for i, name := range names {
+		if(i > 0) {
+			text = append(text, ',')
+		}
+		value := []byte(labels[name])
+		if(!json.Valid(value) || len(value) == 0 || value[0] == '"') {
+			value, _ = json.Marshal(labels[name])
+		}
+		name_offset := len(text) + 1
+		text = append(text, '"')
+		text = append(text, name...)
+		text = append(text, '"', ':')
+		value_offset := len(text)
+		text = append(text, value...)
+		if(len(name) > 0xff || value_offset + len(value) > 0xffff) {
+			return nil, nil, errors.New(fmt.Sprintf("label %v is too long", name))
+		}
+		parsed.uint(uint32(name_offset) << 16 | uint32(len(name)) << 8 | uint32(level & 0x7f))
+		parsed.uint(uint32(value_offset) << 16 | uint32(len(value)))
+	}
+	return append(text, '}'), parsed.buffer, nil
+}
+
+/* PutText puts help text, kind being as for pdu.TextReq */
+func (w *Writer) PutText(kind int, ident uint32, text string) error {
+	e := &encoder{}
+	e.int(recordText)
+	e.int(kind)
+	e.uint(ident)
+	e.bytes(append([]byte(text), 0))
+	return w.writeMeta(e)
+}
+
+/* PutResult appends a record of values. Every metric must have been described, along with the
+   instances of its instance domain, and the values must be in the format of its type */
+func (w *Writer) PutResult(result pdu.Result) error {
+	if(len(result.ValueSets) == 0) {
+		return errors.New("a result needs values, put a mark to record a gap")
+	}
+	for _, value_set := range result.ValueSets {
+		err := w.checkValues(value_set)
+		if(err != nil) {
+			return err
+		}
+	}
+	return w.putRecord(result)
+}
+
+/* PutMark appends a mark, which tells readers values were not logged up to the next record, as
+   pmlogger does when it stops */
+func (w *Writer) PutMark(at time.Time) error {
+	return w.putRecord(pdu.Result{Timestamp:at})
+}
+
+func (w *Writer) checkValues(value_set pdu.ValueSet) error {
+	desc, found := w.descs[value_set.PmID]
+	if(!found) {
+		return errors.New(fmt.Sprintf("PMID %v has not been described", pmidString(value_set.PmID)))
+	}
+	if(value_set.NumVal < 0 || len(value_set.Values) == 0) {
+		return nil
+	}
+	if(desc.InDom != pdu.InDomNull && w.indoms[desc.InDom] == nil) {
+		return errors.New(fmt.Sprintf("instance domain of PMID %v has no instances", pmidString(value_set.PmID)))
+	}
+	if(value_set.ValFmt != pdu.ValueFormat(desc.Type)) {
+		return errors.New(fmt.Sprintf("PMID %v has values of format %v for type %v", pmidString(value_set.PmID), value_set.ValFmt, desc.Type))
+	}
+	for _, value := range value_set.Values {
+		if(value_set.ValFmt != pdu.ValInsitu && value.Type != desc.Type) {
+			return errors.New(fmt.Sprintf("PMID %v has a value of type %v for type %v", pmidString(value_set.PmID), value.Type, desc.Type))
+		}
+	}
+	return nil
+}
+
+func (w *Writer) putRecord(result pdu.Result) error {
+	if(w.meta == nil || w.volume == nil) {
+		return errClosed
+	}
+	if(result.Timestamp.Before(w.last)) {
+		return errors.New(fmt.Sprintf("result at %v is before the last, at %v", result.Timestamp, w.last))
+	}
+	if(!w.started) {
+		err := w.start(result.Timestamp)
+		if(err != nil) {
+			return err
+		}
+	}
+
+	var record pdu.PDU
+	if(w.label.Version == Version2) {
+		record = pdu.EncodeResult(result)
+	} else {
+		record = pdu.EncodeHighResResult(result)
+	}
+	e := &encoder{buffer:record.Body}
+	framed := e.framed()
+	label_size := int64(labelSizeV2)
+	if(w.label.Version == Version3) {
+		label_size = labelSizeV3
+	}
+	if(w.VolumeSize > 0 && w.volume.size > label_size && w.volume.size + int64(len(framed)) > w.VolumeSize) {
+		err := w.nextVolume()
+		if(err != nil) {
+			return err
+		}
+	}
+	if(w.volume.size == label_size || w.unindexed >= indexInterval) {
+		err := w.putIndex(result.Timestamp, w.volume.size)
+		if(err != nil) {
+			return err
+		}
+	}
+	w.last, w.last_offset = result.Timestamp, w.volume.size
+	w.unindexed++
+	return w.volume.write(framed)
+}
+
+/* start settles the label's start at the first record, rewriting the labels already written when
+   it was left zero */
+func (w *Writer) start(first time.Time) error {
+	w.started = true
+	if(!w.label.Start.IsZero()) {
+		return nil
+	}
+	w.label.Start = first
+	for _, o := range []*output{w.meta, w.index, w.volume} {
+		err := o.writer.Flush()
+		if(err != nil) {
+			return err
+		}
+	}
+	volumes := map[*output]int{w.meta:VolumeMeta, w.index:VolumeIndex, w.volume:0}
+	for o, volume := range volumes {
+		_, err := o.file.WriteAt(w.encodeLabel(volume), 0)
+		if(err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) nextVolume() error {
+	err := w.volume.close()
+	w.volume = nil
+	if(err != nil) {
+		return err
+	}
+	w.volume_number++
+	w.volume, err = w.createOutput(fmt.Sprintf(".%v", w.volume_number), w.volume_number)
+	return err
+}
+
+/* putIndex puts an entry in the temporal index for a record about to be written */
+func (w *Writer) putIndex(at time.Time, offset int64) error {
+	e := &encoder{}
+	e.timestamp(w.label.Version, at)
+	e.int(w.volume_number)
+	if(w.label.Version == Version2) {
+		e.uint(uint32(w.meta.size))
+		e.uint(uint32(offset))
+	} else {
+		e.uint(uint32(uint64(w.meta.size) >> 32))
+		e.uint(uint32(w.meta.size))
+		e.uint(uint32(uint64(offset) >> 32))
+		e.uint(uint32(offset))
+	}
+	w.unindexed = 0
+	return w.index.write(e.buffer)
+}
+
+/* Close indexes the last record, so readers find the end of the archive quickly, then writes out
+   and closes every file */
+func (w *Writer) Close() error {
+	var err error
+	if(w.unindexed > 1 && w.index != nil && w.volume != nil) {
+		err = w.putIndex(w.last, w.last_offset)
+	}
+	for _, o := range []*output{w.meta, w.index, w.volume} {
+		if(o == nil) {
+			continue
+		}
+		close_err := o.close()
+		if(err == nil) {
+			err = close_err
+		}
+	}
+	w.meta, w.index, w.volume = nil, nil, nil
+	return err
+}
+
+func pmidString(pmid uint32) string {
+	return fmt.Sprintf("%v.%v.%v", pmid >> 22 & 0x1ff, pmid >> 10 & 0xfff, pmid & 0x3ff)
+}
+
+/* encoder lays out the words of a record */
+type encoder struct {
+	buffer []byte
+}
+
+func (e *encoder) uint(value uint32) {
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, value)
+}
+
+func (e *encoder) int(value int) {
+	e.uint(uint32(int32(value)))
+}
+
+func (e *encoder) bytes(data []byte) {
+	e.buffer = append(e.buffer, data...)
+}
+
+func (e *encoder) timestamp(version int, at time.Time) {
+	if(version == Version2) {
+		e.int(int(at.Unix()))
+		e.int(at.Nanosecond() / 1000)
+		return
+	}
+	e.uint(uint32(uint64(at.Unix()) >> 32))
+	e.uint(uint32(at.Unix()))
+	e.int(at.Nanosecond())
+}
+
+/* text puts a string in a field of size bytes, truncated to leave a terminating NUL */
+func (e *encoder) text(text string, size int) {
+	field := make([]byte, size)
+	copy(field[:size - 1], text)
+	e.bytes(field)
+}
+
+/* framed gives the record with its length before and after it */
+func (e *encoder) framed() []byte {
+	length := binary.BigEndian.AppendUint32(nil, uint32(len(e.buffer) + 8))
+	return append(append(append(make([]byte, 0, len(e.buffer) + 8), length...), e.buffer...), length...)
+}
@@ -0,0 +1,361 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package archive
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/* createTestWriter creates an archive describing the metrics of writeTestArchive */
+func createTestWriter(t *testing.T, label Label) (*Writer, string) {
+	base := filepath.Join(t.TempDir(), "written")
+	w, err := Create(base, label)
+	assert.NoError(t, err)
+	assert.NoError(t, w.PutDesc(pdu.Desc{PmID:testLong, Type:pdu.Type32, InDom:pdu.InDomNull, Sem:pdu.SemInstant, Units:pdu.Units{DimCount:1}}, "sample.long.one"))
+	assert.NoError(t, w.PutDesc(pdu.Desc{PmID:testBin, Type:pdu.TypeU64, InDom:testInDom, Sem:pdu.SemCounter}, "sample.bin", "sample.bins"))
+	assert.NoError(t, w.PutInDom(testInDom, testStart, map[int]string{100:"bin-100", 200:"bin-200", 300:"bin-300"}))
+	return w, base
+}
+
+func TestWriter_writesWhatTheReaderReadsBack(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		label := Label{Version:version, PID:77, Start:testStart, Hostname:"web1", Timezone:"AEST-10"}
+		w, base := createTestWriter(t, label)
+		assert.NoError(t, w.PutText(pdu.TextOneline | pdu.TextPmID, testLong, "1 as a 32-bit integer"))
+		assert.NoError(t, w.PutLabels(pdu.LabelContext, pdu.InDomNull, testStart, []pdu.LabelSet{{Inst:pdu.InNull, Labels:map[string]string{"hostname":"web1", "cores":"4"}}}))
+		assert.NoError(t, w.PutInDom(testInDom, testStart.Add(time.Minute), map[int]string{300:"bin-300"}))
+		first := testValues(t, testStart, 1, map[int]uint64{100:1000, 200:2000})
+		assert.NoError(t, w.PutResult(first))
+		assert.NoError(t, w.PutMark(testStart.Add(time.Second)))
+		assert.NoError(t, w.PutResult(testValues(t, testStart.Add(time.Minute), 2, map[int]uint64{300:3000})))
+		assert.NoError(t, w.Close())
+
+		a, err := Open(base)
+		assert.NoError(t, err)
+		defer a.Close()
+		label.Volume = VolumeMeta
+		assert.Equal(t, label, a.Label)
+		desc, _ := a.Desc(testLong)
+		assert.Equal(t, pdu.Units{DimCount:1}, desc.Units)
+		assert.Equal(t, []string{"sample.bin", "sample.bins"}, a.Names(testBin))
+		text, _ := a.Text(pdu.TextOneline | pdu.TextPmID, testLong)
+		assert.Equal(t, "1 as a 32-bit integer", text)
+		sets, _ := a.Labels(pdu.LabelContext, pdu.InDomNull, testStart)
+		assert.Equal(t, []pdu.LabelSet{{Inst:pdu.InNull, Labels:map[string]string{"hostname":"web1", "cores":"4"}}}, sets)
+		assert.Len(t, a.InDomHistory(testInDom), 2)
+
+		result, err := a.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, first.ValueSets, result.ValueSets)
+		mark, _ := a.Next()
+		assert.Empty(t, mark.ValueSets)
+		last, _ := a.Next()
+		assert.Equal(t, testStart.Add(time.Minute), last.Timestamp)
+		_, err = a.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestWriter_indexesTheFirstAndLastRecords(t *testing.T) {
+	w, base := createTestWriter(t, Label{Start:testStart})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, w.PutResult(testValues(t, testStart.Add(time.Duration(i) * time.Second), i, nil)))
+	}
+	assert.NoError(t, w.Close())
+
+	a, _ := Open(base)
+	defer a.Close()
+	index := a.Index()
+	assert.Len(t, index, 2)
+	assert.Equal(t, testStart, index[0].Time)
+	assert.Equal(t, int64(labelSizeV3), index[0].VolumeOffset)
+	assert.Equal(t, testStart.Add(2 * time.Second), index[1].Time)
+	end, err := a.End()
+	assert.NoError(t, err)
+	assert.Equal(t, testStart.Add(2 * time.Second), end)
+}
+
+func TestWriter_startsTheArchiveAtTheFirstResultWhenNoStartIsGiven(t *testing.T) {
+	w, base := createTestWriter(t, Label{Hostname:"web1"})
+	assert.NoError(t, w.PutResult(testValues(t, testStart, 1, nil)))
+	assert.NoError(t, w.Close())
+
+	a, err := Open(base)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testStart, a.Label.Start)
+	assert.Equal(t, os.Getpid(), a.Label.PID)
+	assert.NotEmpty(t, a.Label.Timezone)
+	_, err = a.Next()
+	assert.NoError(t, err, "the volume's label was rewritten too")
+}
+
+func TestWriter_startsANewVolumeAtTheVolumeSize(t *testing.T) {
+	w, base := createTestWriter(t, Label{Version:Version2, Start:testStart})
+	w.VolumeSize = labelSizeV2 + 100
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, w.PutResult(testValues(t, testStart.Add(time.Duration(i) * time.Second), i, map[int]uint64{100:1})))
+	}
+	assert.NoError(t, w.Close())
+
+	a, _ := Open(base)
+	defer a.Close()
+	assert.Equal(t, []int{0, 1, 2, 3}, a.volumes)
+	assert.NoError(t, a.Seek(testStart.Add(2 * time.Second)))
+	result, err := a.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, testStart.Add(2 * time.Second), result.Timestamp)
+	assert.Equal(t, 2, a.volume)
+}
+
+func TestWriter_refusesToReplaceAnArchive(t *testing.T) {
+	w, base := createTestWriter(t, Label{})
+	w.Close()
+
+	_, err := Create(base, Label{})
+
+	assert.True(t, os.IsExist(err))
+}
+
+func TestWriter_writesAnUnchangedInstanceDomainOnce(t *testing.T) {
+	w, base := createTestWriter(t, Label{Start:testStart})
+	assert.NoError(t, w.PutInDom(testInDom, testStart.Add(time.Second), map[int]string{100:"bin-100", 200:"bin-200", 300:"bin-300"}))
+	w.Close()
+
+	a, _ := Open(base)
+
+	assert.Len(t, a.InDomHistory(testInDom), 1)
+}
+
+func TestWriter_refusesResultsItCannotDescribe(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "written")
+	w, _ := Create(base, Label{})
+	defer w.Close()
+	assert.NoError(t, w.PutDesc(pdu.Desc{PmID:testBin, Type:pdu.TypeU64, InDom:testInDom, Sem:pdu.SemCounter}, "sample.bin"))
+	value, _ := pdu.NewValue(100, pdu.TypeU64, 5)
+	bins := pdu.ValueSet{PmID:testBin, NumVal:1, ValFmt:pdu.ValDptr, Values:[]pdu.Value{value}}
+
+	assert.EqualError(t, w.PutResult(pdu.Result{Timestamp:testStart, ValueSets:[]pdu.ValueSet{{PmID:testLong}}}), "PMID 10.0.1 has not been described")
+	assert.EqualError(t, w.PutResult(pdu.Result{Timestamp:testStart, ValueSets:[]pdu.ValueSet{bins}}), "instance domain of PMID 10.2.3 has no instances")
+	w.PutInDom(testInDom, testStart, map[int]string{100:"bin-100"})
+	bins.ValFmt = pdu.ValInsitu
+	assert.EqualError(t, w.PutResult(pdu.Result{Timestamp:testStart, ValueSets:[]pdu.ValueSet{bins}}), "PMID 10.2.3 has values of format 0 for type 3")
+	bins.ValFmt = pdu.ValDptr
+	assert.NoError(t, w.PutResult(pdu.Result{Timestamp:testStart, ValueSets:[]pdu.ValueSet{bins}}))
+	assert.Error(t, w.PutResult(pdu.Result{Timestamp:testStart.Add(-time.Second), ValueSets:[]pdu.ValueSet{bins}}))
+	assert.EqualError(t, w.PutDesc(pdu.Desc{PmID:testBin, Type:pdu.Type32}, "sample.bin"), "PMID 10.2.3 is already described differently")
+	assert.EqualError(t, w.PutDesc(pdu.Desc{PmID:testLong}, "sample.bin"), "sample.bin already names PMID 10.2.3")
+}
+
+func TestWriter_failsOnceClosed(t *testing.T) {
+	w, _ := createTestWriter(t, Label{})
+	w.Close()
+
+	assert.Equal(t, errClosed, w.PutMark(testStart))
+	assert.Equal(t, errClosed, w.PutText(pdu.TextHelp | pdu.TextPmID, testLong, "help"))
+}
+
+/* framedRecords splits a file into its label and records, each with its leading and trailing lengths */
+func framedRecords(t *testing.T, path string) [][]byte {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	records := [][]byte{}
+	for len(data) >= 4 {
+		length := int(binary.BigEndian.Uint32(data))
+		if(length < 8 || length > len(data)) {
+			t.Fatalf("%v: bad record length %v", path, length)
+		}
+		records = append(records, data[:length])
+		data = data[length:]
+	}
+	assert.Empty(t, data, path)
+	return records
+}
+
+/* withoutLabelFlags clears the flags of each label in a labels record, which pmlogger keeps as
+   pmcd sent them and the writer does not know */
+func withoutLabelFlags(record []byte, version int) []byte {
+	cleared := append([]byte{}, record...)
+	offset := 8 + 8 + 8
+	if(version == Version3) {
+		offset += 4
+	}
+	sets := int(binary.BigEndian.Uint32(cleared[offset:]))
+	offset += 4
+	for i := 0; i < sets && offset + 8 <= len(cleared); i++ {
+		offset += 4
+		offset += 4 + int(binary.BigEndian.Uint32(cleared[offset:]))
+		labels := int(binary.BigEndian.Uint32(cleared[offset:]))
+		offset += 4
+		for j := 0; j < labels && offset + 8 <= len(cleared); j++ {
+			cleared[offset + 3] = 0
+			offset += 8
+		}
+	}
+	return cleared
+}
+
+func TestWriter_writesTheRecordsPmloggerWrote(t *testing.T) {
+	for _, version := range []int{Version2, Version3} {
+		golden := openGoldenArchive(t, version)
+		golden_base := goldenArchive(t, version)
+		base := filepath.Join(t.TempDir(), "copy")
+		w, err := Create(base, golden.Label)
+		assert.NoError(t, err)
+
+		/* Replay each metadata record, expecting the same bytes back unless the writer had nothing
+		   to write, as for an unchanged instance domain. The writer puts a delta as the whole
+		   instance domain, so what it writes for one is not compared */
+		golden_records := framedRecords(t, golden_base + ".meta")
+		expected := [][]byte{golden_records[0]}
+		for _, golden_record := range golden_records[1:] {
+			f := &fields{data:golden_record[4:]}
+			record_type := f.int()
+			size := w.meta.size
+			switch record_type {
+			case recordDesc:
+				desc, err := pdu.DecodeDesc(pdu.PDU{Type:pdu.TypeDesc, Body:f.take(20)})
+				assert.NoError(t, err)
+				names := make([]string, f.int())
+				for i := range names {
+					names[i] = string(f.take(f.int()))
+				}
+				assert.NoError(t, w.PutDesc(desc, names...))
+			case recordInDomV2, recordInDom, recordInDomDelta:
+				at := f.timestamp(version)
+				indom := f.uint()
+				instances, _ := golden.InDom(indom, at)
+				assert.NoError(t, w.PutInDom(indom, at, instances.Instances))
+				if(record_type == recordInDomDelta) {
+					golden_record = nil
+				}
+			case recordLabelV2, recordLabel:
+				at := f.timestamp(version)
+				level := f.int()
+				ident := f.uint()
+				sets, _ := golden.Labels(level, ident, at)
+				assert.NoError(t, w.PutLabels(level, ident, at, sets))
+				golden_record = withoutLabelFlags(golden_record, version)
+			case recordText:
+				kind := f.int()
+				ident := f.uint()
+				text, _ := golden.Text(kind, ident)
+				assert.NoError(t, w.PutText(kind, ident, text))
+			}
+			assert.NoError(t, f.err)
+			if(w.meta.size != size) {
+				expected = append(expected, golden_record)
+			}
+		}
+		for {
+			result, err := golden.Next()
+			if(err == io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+			if(len(result.ValueSets) == 0) {
+				assert.NoError(t, w.PutMark(result.Timestamp))
+			} else {
+				assert.NoError(t, w.PutResult(result))
+			}
+		}
+		assert.NoError(t, w.Close())
+
+		written := framedRecords(t, base + ".meta")
+		assert.Equal(t, len(expected), len(written), "v%v metadata records", version)
+		for i := 0; i < len(expected) && i < len(written); i++ {
+			if(expected[i] == nil) {
+				continue
+			}
+			record := written[i]
+			if(i > 0 && (int(binary.BigEndian.Uint32(record[4:])) == recordLabel || int(binary.BigEndian.Uint32(record[4:])) == recordLabelV2)) {
+				record = withoutLabelFlags(record, version)
+			}
+			assert.Equal(t, expected[i], record, "v%v metadata record %v", version, i)
+		}
+
+		/* The labels of the other files, and the index's entries of the same size pointing at
+		   records of the volume */
+		golden_index, err := os.ReadFile(golden_base + ".index")
+		assert.NoError(t, err)
+		index, err := os.ReadFile(base + ".index")
+		assert.NoError(t, err)
+		label_size := len(golden_records[0])
+		assert.Equal(t, golden_index[:label_size], index[:label_size], "v%v index label", version)
+		entry_size := map[int]int{Version2:20, Version3:32}[version]
+		assert.Zero(t, (len(golden_index) - label_size) % entry_size, "v%v index entries", version)
+		assert.Zero(t, (len(index) - label_size) % entry_size, "v%v index entries", version)
+		assert.Equal(t, golden.Index()[0].VolumeOffset, int64(label_size), "v%v first index entry", version)
+		volume := framedRecords(t, base + ".0")
+		assert.Equal(t, framedRecords(t, golden_base + ".0")[0], volume[0], "v%v volume label", version)
+
+		/* What pmdumplog reported of pmlogger's archive holds for the copy, which replays the same
+		   records */
+		written_archive, err := Open(base)
+		if(err != nil) {
+			t.Fatal(err)
+		}
+		assertDescriptorsReported(t, written_archive, version)
+		assertInDomsReported(t, written_archive, version)
+		assert.Equal(t, allResults(t, openGoldenArchive(t, version)), allResults(t, written_archive), "v%v records", version)
+		written_archive.Close()
+	}
+}
+
+func allResults(t *testing.T, a *Archive) []pdu.Result {
+	results := []pdu.Result{}
+	for {
+		result, err := a.Next()
+		if(err == io.EOF) {
+			return results
+		}
+		if(err != nil) {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+}
+
+func TestEncodeLabels_pointsAtEachNameAndValueInTheJSON(t *testing.T) {
+	text, labels, err := encodeLabels(map[string]string{"hostname":"web1", "cores":"4", "quoted":`"x"`}, pdu.LabelContext)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"cores":4,"hostname":"web1","quoted":"\"x\""}`, string(text))
+	assert.Equal(t, []byte{
+		0, 2, 5, 1, 0, 9, 0, 1,
+		0, 12, 8, 1, 0, 22, 0, 6,
+		0, 30, 6, 1, 0, 38, 0, 7,
+	}, labels)
+}
+
+func TestPosixTimezone_invertsTheOffsetAsTZDoes(t *testing.T) {
+	assert.Equal(t, "AEST-10", posixTimezone(time.Date(2024, 6, 1, 0, 0, 0, 0, time.FixedZone("AEST", 10 * 3600))))
+	assert.Equal(t, "EST5", posixTimezone(time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("EST", -5 * 3600))))
+	assert.Equal(t, "IST-5:30", posixTimezone(time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("IST", 19800))))
+	assert.Equal(t, "UTC0", posixTimezone(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,599 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/archive"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* ArchiveContext is an archive context read by package archive, so needs neither cgo nor libpcp.
+   It is what PmNewContext gives for archives when the package is built without them */
+type ArchiveContext struct {
+	archive *archive.Archive
+	/* When the last record was logged */
+	end time.Time
+	/* Guards everything below, as the archive is read from one place at a time */
+	lock sync.Mutex
+	profile pdu.Profile
+	mode int
+	/* The time of the next fetch in PmModeInterp, otherwise of the record last fetched */
+	position time.Time
+	delta time.Duration
+	/* Whether the archive is read to just after position, so PmModeForw reads on from there */
+	sequential bool
+}
+
+/* archiveSample is the values of a metric in the record logged at time */
+type archiveSample struct {
+	time time.Time
+	values pdu.ValueSet
+}
+
+/* NewArchiveContext opens an archive by the name of any of its files or its base name. Fetches
+   start at the first record, in PmModeForw */
+func NewArchiveContext(path string) (*ArchiveContext, error) {
+	opened, err := archive.Open(path)
+	if(err != nil) {
+		return nil, archiveError(err)
+	}
+	end, err := opened.End()
+	if(err != nil) {
+		opened.Close()
+		return nil, archiveError(err)
+	}
+	return &ArchiveContext{archive:opened, end:end, mode:PmModeForw, position:opened.Label.Start}, nil
+}
+
+/* archiveError gives the PmError libpcp would for an archive that cannot be read */
+func archiveError(err error) error {
+	if path_err, ok := err.(*os.PathError); ok {
+		if errno, ok := path_err.Err.(syscall.Errno); ok {
+			return PmError{Code:-int(errno), Message:err.Error()}
+		}
+	}
+	return PmError{Code:pdu.ErrNotArchive, Message:err.Error()}
+}
+
+func (c *ArchiveContext) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.archive.Close()
+}
+
+/* PmGetContextHostname returns the host the archive was logged from */
+func (c *ArchiveContext) PmGetContextHostname() (string, error) {
+	return c.archive.Label.Hostname, nil
+}
+
+/* PmSetMode positions the context at when. PmModeForw and PmModeBack then fetch the records logged
+   from when on or before it in turn. PmModeInterp fetches values interpolated at when, then each
+   delta after */
+func (c *ArchiveContext) PmSetMode(mode int, when time.Time, delta time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch mode {
+	case PmModeForw, PmModeInterp:
+		c.position = when
+	case PmModeBack:
+		/* Back fetches records before the position, so the first can be at when */
+		c.position = when.Add(time.Nanosecond)
+	default:
+		return wirePmError(pdu.ErrMode)
+	}
+	c.mode, c.delta, c.sequential = mode, delta, false
+	return nil
+}
+
+func (c *ArchiveContext) PmGetArchiveLabel() (PmLogLabel, error) {
+	return PmLogLabel{Hostname:c.archive.Label.Hostname, Start:c.archive.Label.Start}, nil
+}
+
+/* PmGetArchiveEnd returns the time of the archive's last record */
+func (c *ArchiveContext) PmGetArchiveEnd() (time.Time, error) {
+	return c.end, nil
+}
+
+/* PmLookupName gives names the archive does not hold PM_ID_NULL, failing only when it holds none
+   of them */
+func (c *ArchiveContext) PmLookupName(names ...string) ([]PmID, error) {
+	pmids := make([]PmID, len(names))
+	known := 0
+	for i, name := range names {
+		pmid, found := c.archive.LookupName(name)
+		if(!found) {
+			pmids[i] = PmID(pdu.IDNull)
+			continue
+		}
+		pmids[i] = PmID(pmid)
+		known++
+	}
+	if(known == 0 && len(names) > 0) {
+		return nil, wirePmError(PmErrName)
+	}
+	return pmids, nil
+}
+
+func (c *ArchiveContext) PmLookupDesc(pmid PmID) (PmDesc, error) {
+	desc, found := c.archive.Desc(uint32(pmid))
+	if(!found) {
+		return PmDesc{}, wirePmError(PmErrPmID)
+	}
+	return PmDesc{
+		PmID:PmID(desc.PmID),
+		Type:desc.Type,
+		InDom:PmInDom(desc.InDom),
+		Sem:desc.Sem,
+		Units:PmUnits{
+			DimSpace:desc.Units.DimSpace,
+			DimTime:desc.Units.DimTime,
+			DimCount:desc.Units.DimCount,
+			ScaleSpace:desc.Units.ScaleSpace,
+			ScaleTime:desc.Units.ScaleTime,
+			ScaleCount:desc.Units.ScaleCount,
+		}}, nil
+}
+
+/* PmGetInDom returns the instances as they were at the context's position, or as first logged
+   when the position is before that */
+func (c *ArchiveContext) PmGetInDom(indom PmInDom) (map[int]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	instances, found := c.archive.InDom(uint32(indom), c.position)
+	if(!found) {
+		history := c.archive.InDomHistory(uint32(indom))
+		if(len(history) == 0) {
+			return nil, wirePmError(PmErrInDom)
+		}
+		instances = history[0]
+	}
+	indom_map := make(map[int]string)
+	for inst, name := range instances.Instances {
+		indom_map[inst] = name
+	}
+	return indom_map, nil
+}
+
+/* PmLookupInDom finds an instance by name at the context's position, or failing that in any
+   state of the instance domain the archive holds, latest first, as pmLookupInDomArchive does */
+func (c *ArchiveContext) PmLookupInDom(indom PmInDom, name string) (int, error) {
+	for _, instances := range c.inDomStates(indom) {
+		for inst, inst_name := range instances {
+			if(inst_name == name) {
+				return inst, nil
+			}
+		}
+	}
+	return 0, wirePmError(PmErrInst)
+}
+
+/* PmNameInDom names an instance as PmLookupInDom finds one */
+func (c *ArchiveContext) PmNameInDom(indom PmInDom, instance int) (string, error) {
+	for _, instances := range c.inDomStates(indom) {
+		if name, found := instances[instance]; found {
+			return name, nil
+		}
+	}
+	return "", wirePmError(PmErrInst)
+}
+
+/* inDomStates gives the instances at the context's position, then every logged state latest first */
+func (c *ArchiveContext) inDomStates(indom PmInDom) []map[int]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	states := []map[int]string{}
+	current, found := c.archive.InDom(uint32(indom), c.position)
+	if(found) {
+		states = append(states, current.Instances)
+	}
+	history := c.archive.InDomHistory(uint32(indom))
+	for i := len(history) - 1; i >= 0; i-- {
+		states = append(states, history[i].Instances)
+	}
+	return states
+}
+
+/* PmAddProfile adds instances to the context's fetch profile for indom, as for a host */
+func (c *ArchiveContext) PmAddProfile(indom PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return changeProfile(&c.profile, indom, pdu.ProfileInclude, instances)
+}
+
+/* PmDelProfile removes instances from the context's fetch profile for indom, as for a host */
+func (c *ArchiveContext) PmDelProfile(indom PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return changeProfile(&c.profile, indom, pdu.ProfileExclude, instances)
+}
+
+/* PmFetch returns, by the mode set, the next or previous record holding any of pmids or marking a
+   gap in the archive, or values interpolated at the position. Past either end of the archive it
+   returns PmErrEOL */
+func (c *ArchiveContext) PmFetch(pmids ...PmID) (*PmResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	wanted := make([]uint32, len(pmids))
+	for i, pmid := range pmids {
+		wanted[i] = uint32(pmid)
+	}
+	switch c.mode {
+	case PmModeBack:
+		return c.fetchBack(wanted)
+	case PmModeInterp:
+		return c.fetchInterp(wanted)
+	}
+	return c.fetchForw(wanted)
+}
+
+func (c *ArchiveContext) fetchForw(pmids []uint32) (*PmResult, error) {
+	if(!c.sequential) {
+		err := c.archive.Seek(c.position)
+		if(err != nil) {
+			return nil, archiveError(err)
+		}
+		c.sequential = true
+	}
+	for {
+		result, err := c.archive.Next()
+		if(err == io.EOF) {
+			return nil, wirePmError(PmErrEOL)
+		}
+		if(err != nil) {
+			return nil, archiveError(err)
+		}
+		c.position = result.Timestamp
+		if(len(result.ValueSets) == 0 || holdsAny(result, pmids)) {
+			return newPmResult(c.selectValues(result, pmids)), nil
+		}
+	}
+}
+
+func (c *ArchiveContext) fetchBack(pmids []uint32) (*PmResult, error) {
+	c.sequential = false
+	until := c.position
+	for _, from := range c.startsBefore(c.position) {
+		err := c.archive.Seek(from)
+		if(err != nil) {
+			return nil, archiveError(err)
+		}
+		var found *pdu.Result
+		for {
+			result, err := c.archive.Next()
+			if(err == io.EOF || (err == nil && !result.Timestamp.Before(until))) {
+				break
+			}
+			if(err != nil) {
+				return nil, archiveError(err)
+			}
+			if(len(result.ValueSets) == 0 || holdsAny(result, pmids)) {
+				found = &result
+			}
+		}
+		if(found != nil) {
+			c.position = found.Timestamp
+			return newPmResult(c.selectValues(*found, pmids)), nil
+		}
+		until = from
+	}
+	return nil, wirePmError(PmErrEOL)
+}
+
+/* startsBefore gives the times of the index entries before a time, latest first, ending with a
+   time before the archive so the last read starts at the first record */
+func (c *ArchiveContext) startsBefore(at time.Time) []time.Time {
+	starts := []time.Time{}
+	index := c.archive.Index()
+	for i := len(index) - 1; i >= 0; i-- {
+		if(index[i].Time.Before(at) && index[i].Time.After(c.archive.Label.Start)) {
+			starts = append(starts, index[i].Time)
+		}
+	}
+	return append(starts, time.Time{})
+}
+
+func holdsAny(result pdu.Result, pmids []uint32) bool {
+	for _, value_set := range result.ValueSets {
+		for _, pmid := range pmids {
+			if(value_set.PmID == pmid) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/* selectValues gives a record's values of pmids in the profile, with no values for those it does
+   not hold. A mark record is returned as it is */
+func (c *ArchiveContext) selectValues(result pdu.Result, pmids []uint32) pdu.Result {
+	if(len(result.ValueSets) == 0) {
+		return result
+	}
+	selected := pdu.Result{Timestamp:result.Timestamp, ValueSets:make([]pdu.ValueSet, len(pmids))}
+	for i, pmid := range pmids {
+		selected.ValueSets[i] = pdu.ValueSet{PmID:pmid}
+		for _, value_set := range result.ValueSets {
+			if(value_set.PmID == pmid) {
+				selected.ValueSets[i] = c.inProfile(value_set)
+			}
+		}
+	}
+	return selected
+}
+
+/* inProfile drops the values of instances the profile excludes */
+func (c *ArchiveContext) inProfile(value_set pdu.ValueSet) pdu.ValueSet {
+	desc, found := c.archive.Desc(value_set.PmID)
+	if(!found || desc.InDom == pdu.InDomNull || value_set.NumVal < 0) {
+		return value_set
+	}
+	values := []pdu.Value{}
+	for _, value := range value_set.Values {
+		if(c.profile.Includes(desc.InDom, value.Inst)) {
+			values = append(values, value)
+		}
+	}
+	return pdu.ValueSet{PmID:value_set.PmID, NumVal:len(values), ValFmt:value_set.ValFmt, Values:values}
+}
+
+/* fetchInterp gives the values at the position, then moves it on by delta. Counters and instant
+   values are interpolated linearly between the records either side, and discrete values and
+   strings are those of the record before. No value is given across a mark, nor for a counter that
+   went backwards */
+func (c *ArchiveContext) fetchInterp(pmids []uint32) (*PmResult, error) {
+	c.sequential = false
+	at := c.position
+	if(at.After(c.end)) {
+		return nil, wirePmError(PmErrEOL)
+	}
+	before, err := c.samplesBefore(at, pmids)
+	if(err != nil) {
+		return nil, err
+	}
+	after, err := c.samplesAfter(at, pmids)
+	if(err != nil) {
+		return nil, err
+	}
+
+	result := pdu.Result{Timestamp:at, ValueSets:make([]pdu.ValueSet, len(pmids))}
+	for i, pmid := range pmids {
+		result.ValueSets[i] = pdu.ValueSet{PmID:pmid}
+		desc, known := c.archive.Desc(pmid)
+		if(!known) {
+			result.ValueSets[i].NumVal = PmErrPmID
+			continue
+		}
+		previous, found := before[pmid]
+		if(!found) {
+			continue
+		}
+		if(previous.values.NumVal < 0) {
+			result.ValueSets[i] = previous.values
+			continue
+		}
+		values := []pdu.Value{}
+		for _, value := range c.inProfile(previous.values).Values {
+			interpolated, ok := interpolate(desc, previous, value, after[pmid], at)
+			if(ok) {
+				values = append(values, interpolated)
+			}
+		}
+		result.ValueSets[i] = pdu.ValueSet{PmID:pmid, NumVal:len(values), ValFmt:pdu.ValueFormat(desc.Type), Values:values}
+	}
+	c.position = at.Add(c.delta)
+	return newPmResult(result), nil
+}
+
+/* interpolate gives an instance's value at a time from its values in the records before and after */
+func interpolate(desc pdu.Desc, previous archiveSample, value pdu.Value, next archiveSample, at time.Time) (pdu.Value, bool) {
+	numeric := desc.Type >= pdu.Type32 && desc.Type <= pdu.TypeDouble
+	if(previous.time.Equal(at) || desc.Sem == pdu.SemDiscrete || !numeric) {
+		return value, true
+	}
+	var next_value *pdu.Value
+	for i := range next.values.Values {
+		if(next.values.Values[i].Inst == value.Inst) {
+			next_value = &next.values.Values[i]
+		}
+	}
+	if(next_value == nil) {
+		return pdu.Value{}, false
+	}
+	from, from_err := atomFloat(value, desc.Type)
+	to, to_err := atomFloat(*next_value, desc.Type)
+	if(from_err != nil || to_err != nil || (desc.Sem == pdu.SemCounter && to < from)) {
+		return pdu.Value{}, false
+	}
+	interpolated := from + (to - from) * float64(at.Sub(previous.time)) / float64(next.time.Sub(previous.time))
+	if(desc.Type != pdu.TypeFloat && desc.Type != pdu.TypeDouble) {
+		interpolated = math.Round(interpolated)
+	}
+	new_value, err := pdu.NewValue(value.Inst, desc.Type, interpolated)
+	return new_value, err == nil
+}
+
+func atomFloat(value pdu.Value, pm_type int) (float64, error) {
+	atom, err := value.Atom(pm_type, pdu.ValueFormat(pm_type))
+	if(err != nil) {
+		return 0, err
+	}
+	switch typed := atom.(type) {
+	case int32:
+		return float64(typed), nil
+	case uint32:
+		return float64(typed), nil
+	case int64:
+		return float64(typed), nil
+	case uint64:
+		return float64(typed), nil
+	case float32:
+		return float64(typed), nil
+	}
+	return atom.(float64), nil
+}
+
+/* samplesBefore finds the last record at or before a time holding each of pmids, stopping at a
+   mark. It reads back an index entry at a time until it has them all */
+func (c *ArchiveContext) samplesBefore(at time.Time, pmids []uint32) (map[uint32]archiveSample, error) {
+	samples := make(map[uint32]archiveSample)
+	until := at.Add(time.Nanosecond)
+	for _, from := range c.startsBefore(until) {
+		err := c.archive.Seek(from)
+		if(err != nil) {
+			return nil, archiveError(err)
+		}
+		found := make(map[uint32]archiveSample)
+		marked := false
+		for {
+			result, err := c.archive.Next()
+			if(err == io.EOF || (err == nil && !result.Timestamp.Before(until))) {
+				break
+			}
+			if(err != nil) {
+				return nil, archiveError(err)
+			}
+			if(len(result.ValueSets) == 0) {
+				found, marked = make(map[uint32]archiveSample), true
+				continue
+			}
+			for _, value_set := range result.ValueSets {
+				found[value_set.PmID] = archiveSample{time:result.Timestamp, values:value_set}
+			}
+		}
+		for _, pmid := range pmids {
+			if _, have := samples[pmid]; !have {
+				if sample, ok := found[pmid]; ok {
+					samples[pmid] = sample
+				}
+			}
+		}
+		if(marked || len(samples) == len(pmids)) {
+			break
+		}
+		until = from
+	}
+	return samples, nil
+}
+
+/* samplesAfter finds the first record at or after a time holding each of pmids, stopping at a mark */
+func (c *ArchiveContext) samplesAfter(at time.Time, pmids []uint32) (map[uint32]archiveSample, error) {
+	samples := make(map[uint32]archiveSample)
+	wanted := make(map[uint32]bool)
+	for _, pmid := range pmids {
+		wanted[pmid] = true
+	}
+	err := c.archive.Seek(at)
+	if(err != nil) {
+		return nil, archiveError(err)
+	}
+	for len(samples) < len(wanted) {
+		result, err := c.archive.Next()
+		if(err == io.EOF || (err == nil && len(result.ValueSets) == 0)) {
+			break
+		}
+		if(err != nil) {
+			return nil, archiveError(err)
+		}
+		for _, value_set := range result.ValueSets {
+			if _, have := samples[value_set.PmID]; !have && wanted[value_set.PmID] {
+				samples[value_set.PmID] = archiveSample{time:result.Timestamp, values:value_set}
+			}
+		}
+	}
+	return samples, nil
+}
+
+func (c *ArchiveContext) PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error) {
+	return PmExtractValue(value_format, pm_type, pm_value)
+}
+
+/* PmLookupText returns the PmTextOneline or PmTextHelp text logged for a metric */
+func (c *ArchiveContext) PmLookupText(pmid PmID, level int) (string, error) {
+	text, found := c.archive.Text(pdu.TextPmID | level, uint32(pmid))
+	if(!found) {
+		return "", wirePmError(PmErrText)
+	}
+	return text, nil
+}
+
+/* PmLookupLabels returns each level of a metric's label hierarchy as it was at the context's
+   position: the context, domain, instance domain, cluster and item, then the metric's instances */
+func (c *ArchiveContext) PmLookupLabels(pmid PmID) ([]PmLabelSet, error) {
+	desc, err := c.PmLookupDesc(pmid)
+	if(err != nil) {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	requests := []pdu.LabelReq{
+		{Ident:pdu.IDNull, Type:pdu.LabelContext},
+		{Ident:uint32(pmid) >> 22 & 0x1ff, Type:pdu.LabelDomain},
+	}
+	if(desc.InDom != PmInDomNull) {
+		requests = append(requests, pdu.LabelReq{Ident:uint32(desc.InDom), Type:pdu.LabelInDom})
+	}
+	/* pmlogger logs cluster labels against the PMID with its item zeroed */
+	requests = append(requests, pdu.LabelReq{Ident:uint32(pmid) &^ 0x3ff, Type:pdu.LabelCluster}, pdu.LabelReq{Ident:uint32(pmid), Type:pdu.LabelItem})
+	if(desc.InDom != PmInDomNull) {
+		requests = append(requests, pdu.LabelReq{Ident:uint32(desc.InDom), Type:pdu.LabelInstances})
+	}
+
+	label_sets := []PmLabelSet{}
+	for _, label_req := range requests {
+		sets, _ := c.archive.Labels(label_req.Type, label_req.Ident, c.position)
+		for _, set := range sets {
+			inst := set.Inst
+			if(label_req.Type != pdu.LabelInstances) {
+				inst = PmInNull
+			}
+			label_sets = append(label_sets, PmLabelSet{Inst:inst, Labels:set.Labels})
+		}
+	}
+	return label_sets, nil
+}
+
+/* PmGetChildrenStatus returns the names one level below name in the PMNS the archive's metric
+   names make up, each mapped to whether it is a leaf (a metric) */
+func (c *ArchiveContext) PmGetChildrenStatus(name string) (map[string]bool, error) {
+	prefix := ""
+	if(name != "") {
+		prefix = name + "."
+	}
+	children := make(map[string]bool)
+	metrics := c.archive.Metrics()
+	for i := sort.SearchStrings(metrics, prefix); i < len(metrics) && strings.HasPrefix(metrics[i], prefix); i++ {
+		child, _, nested := strings.Cut(metrics[i][len(prefix):], ".")
+		children[child] = !nested
+	}
+	if _, leaf := c.archive.LookupName(name); len(children) == 0 && !leaf {
+		return nil, wirePmError(PmErrName)
+	}
+	return children, nil
+}
@@ -0,0 +1,322 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	archiveCounterPmID = PmID(29 << 22 | 1)
+	archiveStringPmID = PmID(29 << 22 | 2)
+	archiveBinPmID = PmID(29 << 22 | 1 << 10 | 3)
+	archiveBinInDom = PmInDom(29 << 22 | 1)
+)
+
+var archiveStart = time.Unix(1600000000, 0)
+
+/* archiveWords lays out the words of a version 3 archive record */
+func archiveWords(values ...int) []byte {
+	words := []byte{}
+	for _, value := range values {
+		words = binary.BigEndian.AppendUint32(words, uint32(value))
+	}
+	return words
+}
+
+func archiveRecord(parts ...[]byte) []byte {
+	body := []byte{}
+	for _, part := range parts {
+		body = append(body, part...)
+	}
+	length := archiveWords(len(body) + 8)
+	return append(append(length, body...), length...)
+}
+
+func archiveStamp(seconds int) []byte {
+	return archiveWords(0, int(archiveStart.Unix()) + seconds, 0)
+}
+
+func archiveLabel(volume int) []byte {
+	return archiveRecord(archiveWords(0x50052603, 99), archiveStamp(0), archiveWords(volume, 0, 0),
+		append([]byte("logged.example.com"), make([]byte, 256 * 3 - 18)...))
+}
+
+func archiveValues(t *testing.T, seconds int, counter uint64, text string, bins map[int]uint32) []byte {
+	counter_value, err := pdu.NewValue(PmInNull, pdu.TypeU64, counter)
+	assert.NoError(t, err)
+	string_value, err := pdu.NewValue(PmInNull, pdu.TypeString, text)
+	assert.NoError(t, err)
+	bin_set := pdu.ValueSet{PmID:uint32(archiveBinPmID), NumVal:len(bins), ValFmt:pdu.ValInsitu}
+	for _, inst := range []int{1, 2} {
+		if value, found := bins[inst]; found {
+			bin_set.Values = append(bin_set.Values, pdu.Value{Inst:inst, Insitu:value})
+		}
+	}
+	result := pdu.Result{Timestamp:archiveStart.Add(time.Duration(seconds) * time.Second), ValueSets:[]pdu.ValueSet{
+		{PmID:uint32(archiveCounterPmID), NumVal:1, ValFmt:pdu.ValDptr, Values:[]pdu.Value{counter_value}},
+		{PmID:uint32(archiveStringPmID), NumVal:1, ValFmt:pdu.ValDptr, Values:[]pdu.Value{string_value}},
+		bin_set,
+	}}
+	return archiveRecord(pdu.EncodeHighResResult(result).Body)
+}
+
+/* archiveContext writes and opens an archive of records every 10 seconds for 40 seconds, with a
+   mark at 30 and the counter going backwards at 20 */
+func archiveContext(t *testing.T) *ArchiveContext {
+	base := filepath.Join(t.TempDir(), "20200913")
+	desc := func(pmid PmID, pm_type int, indom PmInDom, sem int, name string) []byte {
+		body := pdu.EncodeDesc(pdu.Desc{PmID:uint32(pmid), Type:pm_type, InDom:uint32(indom), Sem:sem}).Body
+		return archiveRecord(archiveWords(1), body, archiveWords(1, len(name)), []byte(name))
+	}
+	meta := archiveLabel(-1)
+	meta = append(meta, desc(archiveCounterPmID, PmTypeU64, PmInDomNull, PmSemCounter, "sample.counter")...)
+	meta = append(meta, desc(archiveStringPmID, PmTypeString, PmInDomNull, PmSemDiscrete, "sample.string")...)
+	meta = append(meta, desc(archiveBinPmID, PmTypeU32, archiveBinInDom, PmSemInstant, "sample.bins.one")...)
+	meta = append(meta, archiveRecord(archiveWords(5), archiveStamp(0), archiveWords(int(archiveBinInDom), 2, 1, 2, 0, 6), []byte("bin-1\x00bin-2\x00"))...)
+	meta = append(meta, archiveRecord(archiveWords(6), archiveStamp(40), archiveWords(int(archiveBinInDom), 1, 1, -1))...)
+	meta = append(meta, archiveRecord(archiveWords(4, PmTextOneline | pdu.TextPmID, int(archiveCounterPmID)), []byte("A counter\x00"))...)
+	json := `{"agent":"sample"}`
+	meta = append(meta, archiveRecord(archiveWords(7), archiveStamp(0), archiveWords(pdu.LabelDomain, 29, 1, PmInNull, len(json)), []byte(json), archiveWords(0))...)
+	json = `{"bin":"one"}`
+	meta = append(meta, archiveRecord(archiveWords(7), archiveStamp(0), archiveWords(pdu.LabelInstances, int(archiveBinInDom), 1, 1, len(json)), []byte(json), archiveWords(0))...)
+	assert.NoError(t, os.WriteFile(base + ".meta", meta, 0644))
+
+	data := archiveLabel(0)
+	data = append(data, archiveValues(t, 0, 100, "a", map[int]uint32{1:10, 2:20})...)
+	data = append(data, archiveValues(t, 10, 200, "b", map[int]uint32{1:20, 2:40})...)
+	data = append(data, archiveValues(t, 20, 150, "c", map[int]uint32{1:30})...)
+	data = append(data, archiveRecord(archiveStamp(30), archiveWords(0))...)
+	data = append(data, archiveValues(t, 40, 1000, "d", map[int]uint32{2:50})...)
+	assert.NoError(t, os.WriteFile(base + ".0", data, 0644))
+
+	c, err := NewArchiveContext(base)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func archiveTime(seconds int) time.Time {
+	return archiveStart.Add(time.Duration(seconds) * time.Second)
+}
+
+func extractedU64(t *testing.T, vset *PmValueSet) []uint64 {
+	values := []uint64{}
+	for _, value := range vset.VList {
+		atom, err := PmExtractValue(vset.ValFmt, PmTypeU64, value)
+		assert.NoError(t, err)
+		values = append(values, atom.UInt64)
+	}
+	return values
+}
+
+func TestNewArchiveContext_returnsTheErrnoOfAMissingArchive(t *testing.T) {
+	_, err := NewArchiveContext(filepath.Join(t.TempDir(), "missing"))
+
+	assert.True(t, IsPmError(err, -int(syscall.ENOENT)))
+}
+
+func TestArchiveContext_describesTheArchive(t *testing.T) {
+	c := archiveContext(t)
+
+	label, err := c.PmGetArchiveLabel()
+	assert.NoError(t, err)
+	assert.Equal(t, PmLogLabel{Hostname:"logged.example.com", Start:archiveStart}, label)
+	end, err := c.PmGetArchiveEnd()
+	assert.NoError(t, err)
+	assert.Equal(t, archiveTime(40), end)
+	hostname, _ := c.PmGetContextHostname()
+	assert.Equal(t, "logged.example.com", hostname)
+}
+
+func TestArchiveContext_looksUpNamesAndDescriptors(t *testing.T) {
+	c := archiveContext(t)
+
+	pmids, err := c.PmLookupName("sample.string", "not.a.name")
+	assert.NoError(t, err)
+	assert.Equal(t, []PmID{archiveStringPmID, PmID(pdu.IDNull)}, pmids)
+	_, err = c.PmLookupName("not.a.name")
+	assert.True(t, IsPmError(err, PmErrName))
+	desc, err := c.PmLookupDesc(archiveBinPmID)
+	assert.NoError(t, err)
+	assert.Equal(t, PmDesc{PmID:archiveBinPmID, Type:PmTypeU32, InDom:archiveBinInDom, Sem:PmSemInstant}, desc)
+	_, err = c.PmLookupDesc(PmID(1))
+	assert.True(t, IsPmError(err, PmErrPmID))
+}
+
+func TestArchiveContext_walksThePMNSOfTheArchivesNames(t *testing.T) {
+	c := archiveContext(t)
+
+	root, err := c.PmGetChildrenStatus("")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"sample":false}, root)
+	sample, _ := c.PmGetChildrenStatus("sample")
+	assert.Equal(t, map[string]bool{"counter":true, "string":true, "bins":false}, sample)
+	_, err = c.PmGetChildrenStatus("sample.missing")
+	assert.True(t, IsPmError(err, PmErrName))
+}
+
+func TestArchiveContext_givesInstancesAsTheyWereAtItsPosition(t *testing.T) {
+	c := archiveContext(t)
+
+	instances, err := c.PmGetInDom(archiveBinInDom)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{1:"bin-1", 2:"bin-2"}, instances)
+	c.PmSetMode(PmModeForw, archiveTime(40), 0)
+	instances, _ = c.PmGetInDom(archiveBinInDom)
+	assert.Equal(t, map[int]string{2:"bin-2"}, instances)
+
+	inst, err := c.PmLookupInDom(archiveBinInDom, "bin-1")
+	assert.NoError(t, err, "found in the archive's history")
+	assert.Equal(t, 1, inst)
+	name, _ := c.PmNameInDom(archiveBinInDom, 1)
+	assert.Equal(t, "bin-1", name)
+	_, err = c.PmNameInDom(archiveBinInDom, 3)
+	assert.True(t, IsPmError(err, PmErrInst))
+}
+
+func TestArchiveContext_looksUpLoggedTextAndLabels(t *testing.T) {
+	c := archiveContext(t)
+
+	text, err := c.PmLookupText(archiveCounterPmID, PmTextOneline)
+	assert.NoError(t, err)
+	assert.Equal(t, "A counter", text)
+	_, err = c.PmLookupText(archiveCounterPmID, PmTextHelp)
+	assert.True(t, IsPmError(err, PmErrText))
+
+	labels, err := c.PmLookupLabels(archiveBinPmID)
+	assert.NoError(t, err)
+	assert.Equal(t, []PmLabelSet{
+		{Inst:PmInNull, Labels:map[string]string{"agent":"sample"}},
+		{Inst:1, Labels:map[string]string{"bin":"one"}},
+	}, labels)
+}
+
+func TestArchiveContext_fetchesRecordsForwardsThenReturnsEndOfLog(t *testing.T) {
+	c := archiveContext(t)
+
+	timestamps := []time.Time{}
+	for {
+		pm_result, err := c.PmFetch(archiveCounterPmID)
+		if(IsPmError(err, PmErrEOL)) {
+			break
+		}
+		assert.NoError(t, err)
+		timestamps = append(timestamps, pm_result.Timestamp)
+		if(pm_result.Timestamp.Equal(archiveTime(30))) {
+			assert.Equal(t, 0, pm_result.NumPmID, "a mark")
+			continue
+		}
+		assert.Equal(t, 1, pm_result.NumPmID)
+	}
+
+	assert.Equal(t, []time.Time{archiveTime(0), archiveTime(10), archiveTime(20), archiveTime(30), archiveTime(40)}, timestamps)
+}
+
+func TestArchiveContext_fetchesOnlyTheInstancesInTheProfile(t *testing.T) {
+	c := archiveContext(t)
+	assert.NoError(t, c.PmDelProfile(archiveBinInDom))
+	assert.NoError(t, c.PmAddProfile(archiveBinInDom, 2))
+
+	pm_result, err := c.PmFetch(archiveBinPmID, archiveCounterPmID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pm_result.VSet[0].NumVal)
+	assert.Equal(t, 2, pm_result.VSet[0].VList[0].Inst)
+	assert.Equal(t, []uint64{100}, extractedU64(t, pm_result.VSet[1]))
+}
+
+func TestArchiveContext_fetchesRecordsBackwards(t *testing.T) {
+	c := archiveContext(t)
+	assert.NoError(t, c.PmSetMode(PmModeBack, archiveTime(20), 0))
+
+	first, err := c.PmFetch(archiveCounterPmID)
+	assert.NoError(t, err)
+	second, _ := c.PmFetch(archiveCounterPmID)
+	third, _ := c.PmFetch(archiveCounterPmID)
+	_, err = c.PmFetch(archiveCounterPmID)
+
+	assert.Equal(t, []time.Time{archiveTime(20), archiveTime(10), archiveTime(0)}, []time.Time{first.Timestamp, second.Timestamp, third.Timestamp})
+	assert.Equal(t, []uint64{200}, extractedU64(t, second.VSet[0]))
+	assert.True(t, IsPmError(err, PmErrEOL))
+}
+
+func TestArchiveContext_interpolatesCountersAndInstantValues(t *testing.T) {
+	c := archiveContext(t)
+	assert.NoError(t, c.PmSetMode(PmModeInterp, archiveTime(5), 10 * time.Second))
+
+	pm_result, err := c.PmFetch(archiveCounterPmID, archiveBinPmID, archiveStringPmID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, archiveTime(5), pm_result.Timestamp)
+	assert.Equal(t, []uint64{150}, extractedU64(t, pm_result.VSet[0]))
+	bins := pm_result.VSet[1]
+	assert.Equal(t, 2, bins.NumVal)
+	atom, _ := PmExtractValue(bins.ValFmt, PmTypeU32, bins.VList[1])
+	assert.Equal(t, uint32(30), atom.UInt32)
+	atom, _ = PmExtractValue(pm_result.VSet[2].ValFmt, PmTypeString, pm_result.VSet[2].VList[0])
+	assert.Equal(t, "a", atom.String, "discrete values are not interpolated")
+}
+
+func TestArchiveContext_interpolatesNoValueWhereACounterWentBackOrAcrossAMark(t *testing.T) {
+	c := archiveContext(t)
+	assert.NoError(t, c.PmSetMode(PmModeInterp, archiveTime(15), 10 * time.Second))
+
+	went_back, err := c.PmFetch(archiveCounterPmID, archiveBinPmID)
+	assert.NoError(t, err)
+	before_mark, err := c.PmFetch(archiveCounterPmID)
+	assert.NoError(t, err)
+	after_mark, err := c.PmFetch(archiveCounterPmID)
+	assert.NoError(t, err)
+	_, err = c.PmFetch(archiveCounterPmID)
+
+	assert.Equal(t, 0, went_back.VSet[0].NumVal)
+	assert.Equal(t, 1, went_back.VSet[1].NumVal, "instance 2 is not in the next record")
+	assert.Equal(t, archiveTime(25), before_mark.Timestamp)
+	assert.Equal(t, 0, before_mark.VSet[0].NumVal)
+	assert.Equal(t, 0, after_mark.VSet[0].NumVal)
+	assert.True(t, IsPmError(err, PmErrEOL))
+}
+
+func TestArchiveContext_interpolatesTheValueOfARecordAtItsTime(t *testing.T) {
+	c := archiveContext(t)
+	assert.NoError(t, c.PmSetMode(PmModeInterp, archiveTime(40), time.Second))
+
+	pm_result, err := c.PmFetch(archiveCounterPmID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1000}, extractedU64(t, pm_result.VSet[0]))
+}
+
+func TestArchiveContext_refusesLiveMode(t *testing.T) {
+	err := archiveContext(t).PmSetMode(PmModeLive, time.Now(), 0)
+
+	assert.True(t, IsPmError(err, pdu.ErrMode))
+}
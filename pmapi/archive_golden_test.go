@@ -0,0 +1,184 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+/* The pmlogger archives recorded by archive/testdata/mkgolden.sh, with what pminfo and pmval
+   report for them */
+
+func goldenArchiveContext(t *testing.T, version int) (*ArchiveContext, string) {
+	dir := filepath.Join("archive", "testdata", fmt.Sprintf("v%v", version))
+	if _, err := os.Stat(filepath.Join(dir, "golden.meta")); err != nil {
+		t.Fatalf("no pmlogger archive in %v, record one with archive/testdata/mkgolden.sh", dir)
+	}
+	c, err := NewArchiveContext(filepath.Join(dir, "golden"))
+	if(err != nil) {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c, dir
+}
+
+func goldenLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	if(err != nil) {
+		t.Fatalf("golden archive without its report: %v", err)
+	}
+	defer file.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestGolden_mergesTheLabelsPminfoReports(t *testing.T) {
+	metric_line := regexp.MustCompile(`^(\S+)$`)
+	labels_line := regexp.MustCompile(`^\s+labels (\{.*\})$`)
+	instance_line := regexp.MustCompile(`^\s+inst \[(-?\d+) or ".*"\] labels (\{.*\})$`)
+	for _, version := range []int{2, 3} {
+		c, dir := goldenArchiveContext(t, version)
+		end, err := c.PmGetArchiveEnd()
+		assert.NoError(t, err)
+		assert.NoError(t, c.PmSetMode(PmModeInterp, end, time.Second))
+
+		reported := 0
+		var metric_labels map[string]string
+		var sets []PmLabelSet
+		for _, line := range goldenLines(t, filepath.Join(dir, "pminfo-l.txt")) {
+			if match := metric_line.FindStringSubmatch(line); match != nil {
+				pmids, err := c.PmLookupName(match[1])
+				assert.NoError(t, err)
+				sets, err = c.PmLookupLabels(pmids[0])
+				assert.NoError(t, err)
+				metric_labels = map[string]string{}
+				for _, set := range sets {
+					if(set.Inst == PmInNull) {
+						mergeLabels(metric_labels, set.Labels)
+					}
+				}
+			} else if match := labels_line.FindStringSubmatch(line); match != nil {
+				expected, err := pdu.LabelsFromJSON([]byte(match[1]))
+				assert.NoError(t, err)
+				assert.Equal(t, expected, metric_labels, line)
+				reported++
+			} else if match := instance_line.FindStringSubmatch(line); match != nil {
+				inst, _ := strconv.Atoi(match[1])
+				expected, err := pdu.LabelsFromJSON([]byte(match[2]))
+				assert.NoError(t, err)
+				instance_labels := map[string]string{}
+				mergeLabels(instance_labels, metric_labels)
+				for _, set := range sets {
+					if(set.Inst == inst) {
+						mergeLabels(instance_labels, set.Labels)
+					}
+				}
+				assert.Equal(t, expected, instance_labels, line)
+			}
+		}
+		assert.NotZero(t, reported)
+	}
+}
+
+func mergeLabels(into map[string]string, labels map[string]string) {
+	for name, value := range labels {
+		into[name] = value
+	}
+}
+
+func TestGolden_interpolatesTheValuesPmvalReports(t *testing.T) {
+	value_line := regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d+\s+(.+?)\s*$`)
+	for _, version := range []int{2, 3} {
+		for _, metric := range []string{"kernel.all.pswitch", "mem.util.free"} {
+			c, dir := goldenArchiveContext(t, version)
+			label, err := c.PmGetArchiveLabel()
+			assert.NoError(t, err)
+			assert.NoError(t, c.PmSetMode(PmModeInterp, label.Start, time.Second))
+			pmids, err := c.PmLookupName(metric)
+			assert.NoError(t, err)
+			desc, err := c.PmLookupDesc(pmids[0])
+			assert.NoError(t, err)
+
+			rows := 0
+			for _, line := range goldenLines(t, filepath.Join(dir, fmt.Sprintf("pmval-%v.txt", metric))) {
+				match := value_line.FindStringSubmatch(line)
+				if(match == nil) {
+					continue
+				}
+				rows++
+				result, err := c.PmFetch(pmids[0])
+				if(!assert.NoError(t, err, "%v v%v %v", metric, version, line)) {
+					break
+				}
+				reported, parse_err := strconv.ParseFloat(match[1], 64)
+				if(parse_err != nil) {
+					assert.Equal(t, 0, result.VSet[0].NumVal, "%v v%v %v", metric, version, line)
+					continue
+				}
+				if(!assert.Equal(t, 1, result.VSet[0].NumVal, "%v v%v %v", metric, version, line)) {
+					continue
+				}
+				atom, err := PmExtractValue(result.VSet[0].ValFmt, desc.Type, result.VSet[0].VList[0])
+				assert.NoError(t, err)
+				read := goldenFloat(desc.Type, atom)
+				/* pmval prints six decimal places, and integers interpolate to integers */
+				tolerance := 1e-6 + math.Abs(reported) * 1e-9
+				if(desc.Type != PmTypeFloat && desc.Type != PmTypeDouble) {
+					tolerance = 1
+				}
+				assert.InDelta(t, reported, read, tolerance, "%v v%v %v", metric, version, line)
+			}
+			assert.NotZero(t, rows, "%v v%v", metric, version)
+		}
+	}
+}
+
+func goldenFloat(pm_type int, atom PmAtomValue) float64 {
+	switch pm_type {
+	case PmType32:
+		return float64(atom.Int32)
+	case PmTypeU32:
+		return float64(atom.UInt32)
+	case PmType64:
+		return float64(atom.Int64)
+	case PmTypeU64:
+		return float64(atom.UInt64)
+	case PmTypeFloat:
+		return float64(atom.Float)
+	}
+	return atom.Double
+}
@@ -0,0 +1,141 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/archive"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/* ArchiveWriter writes a version 3 archive of PmResults, whether fetched from any context or
+   built with NewPmValue, so they can be replayed with an archive context or the PCP tools. Use
+   package archive directly for labels, help text or version 2 archives */
+type ArchiveWriter struct {
+	writer *archive.Writer
+	descs map[PmID]PmDesc
+}
+
+/* NewArchiveWriter creates an archive of the base name path. The label's Start may be left zero
+   for the time of the first result, and its Hostname empty for this host's */
+func NewArchiveWriter(path string, label PmLogLabel) (*ArchiveWriter, error) {
+	writer, err := archive.Create(path, archive.Label{Version:archive.Version3, Start:label.Start, Hostname:label.Hostname})
+	if(err != nil) {
+		return nil, err
+	}
+	return &ArchiveWriter{writer:writer, descs:make(map[PmID]PmDesc)}, nil
+}
+
+/* AddMetric declares a metric, which must be done before results hold its values */
+func (w *ArchiveWriter) AddMetric(name string, desc PmDesc) error {
+	err := w.writer.PutDesc(pdu.Desc{
+		PmID:uint32(desc.PmID),
+		Type:desc.Type,
+		InDom:uint32(desc.InDom),
+		Sem:desc.Sem,
+		Units:pdu.Units{
+			DimSpace:desc.Units.DimSpace,
+			DimTime:desc.Units.DimTime,
+			DimCount:desc.Units.DimCount,
+			ScaleSpace:desc.Units.ScaleSpace,
+			ScaleTime:desc.Units.ScaleTime,
+			ScaleCount:desc.Units.ScaleCount,
+		}}, name)
+	if(err != nil) {
+		return err
+	}
+	w.descs[desc.PmID] = desc
+	return nil
+}
+
+/* AddInDom records the instances of an instance domain from a time on. It must be done before
+   results hold values of its metrics, and again whenever the instances change */
+func (w *ArchiveWriter) AddInDom(indom PmInDom, at time.Time, instances map[int]string) error {
+	return w.writer.PutInDom(uint32(indom), at, instances)
+}
+
+/* PutResult appends a result, after any already put */
+func (w *ArchiveWriter) PutResult(result *PmResult) error {
+	record := pdu.Result{Timestamp:result.Timestamp, ValueSets:make([]pdu.ValueSet, len(result.VSet))}
+	for i, vset := range result.VSet {
+		desc, found := w.descs[vset.PmID]
+		if(!found) {
+			return errors.New(fmt.Sprintf("PMID %v has not been added", PmIDStr(vset.PmID)))
+		}
+		value_set := pdu.ValueSet{PmID:uint32(vset.PmID), NumVal:vset.NumVal, ValFmt:pdu.ValueFormat(desc.Type)}
+		for _, pm_value := range vset.VList {
+			value, err := archiveValue(vset.ValFmt, desc.Type, pm_value)
+			if(err != nil) {
+				return errors.New(fmt.Sprintf("PMID %v: %v", PmIDStr(vset.PmID), err))
+			}
+			value_set.Values = append(value_set.Values, value)
+		}
+		if(value_set.NumVal >= 0) {
+			value_set.NumVal = len(value_set.Values)
+		}
+		record.ValueSets[i] = value_set
+	}
+	return w.writer.PutResult(record)
+}
+
+/* archiveValue gives a value as an archive holds it, re-encoding those copied from libpcp */
+func archiveValue(value_format int, pm_type int, pm_value *PmValue) (pdu.Value, error) {
+	if value, ok := pm_value.value.(pduValue); ok {
+		return pdu.Value(value), nil
+	}
+	atom, err := PmExtractValue(value_format, pm_type, pm_value)
+	if(err != nil) {
+		return pdu.Value{}, err
+	}
+	values := map[int]interface{}{
+		PmType32:atom.Int32,
+		PmTypeU32:atom.UInt32,
+		PmType64:atom.Int64,
+		PmTypeU64:atom.UInt64,
+		PmTypeFloat:atom.Float,
+		PmTypeDouble:atom.Double,
+		PmTypeString:atom.String,
+	}
+	return pdu.NewValue(pm_value.Inst, pm_type, values[pm_type])
+}
+
+/* PutMark records that nothing was collected from a time until the next result, as pmlogger does
+   when it stops */
+func (w *ArchiveWriter) PutMark(at time.Time) error {
+	return w.writer.PutMark(at)
+}
+
+/* Close finishes the archive. It is incomplete until then */
+func (w *ArchiveWriter) Close() error {
+	return w.writer.Close()
+}
+
+/* NewPmValue makes the value of an instance, for a result to put in an archive. value is any Go
+   integer, floating point number or string, held as a metric of type pm_type would be */
+func NewPmValue(inst int, pm_type int, value interface{}) (*PmValue, error) {
+	pdu_value, err := pdu.NewValue(inst, pm_type, value)
+	if(err != nil) {
+		return nil, err
+	}
+	return &PmValue{Inst:inst, value:pduValue(pdu_value)}, nil
+}
@@ -0,0 +1,109 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmcdtest"
+	"path/filepath"
+	"time"
+)
+
+func TestArchiveWriter_writesResultsAnArchiveContextReadsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry")
+	w, err := NewArchiveWriter(path, PmLogLabel{Hostname:"telemetry.example.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, w.AddMetric("app.requests", PmDesc{PmID:archiveCounterPmID, Type:PmTypeU64, InDom:PmInDomNull, Sem:PmSemCounter, Units:PmUnits{DimCount:1}}))
+	assert.NoError(t, w.AddMetric("app.queue", PmDesc{PmID:archiveBinPmID, Type:PmTypeU32, InDom:archiveBinInDom, Sem:PmSemInstant}))
+	assert.NoError(t, w.AddInDom(archiveBinInDom, archiveStart, map[int]string{1:"fast", 2:"slow"}))
+	for i := 0; i < 3; i++ {
+		requests, _ := NewPmValue(PmInNull, PmTypeU64, 100 * i)
+		fast, _ := NewPmValue(1, PmTypeU32, i)
+		slow, _ := NewPmValue(2, PmTypeU32, 10 + i)
+		assert.NoError(t, w.PutResult(&PmResult{Timestamp:archiveTime(10 * i), VSet:[]*PmValueSet{
+			{PmID:archiveCounterPmID, NumVal:1, ValFmt:PmValDptr, VList:[]*PmValue{requests}},
+			{PmID:archiveBinPmID, NumVal:2, ValFmt:PmValInsitu, VList:[]*PmValue{fast, slow}},
+		}}))
+	}
+	assert.NoError(t, w.Close())
+
+	c, err := NewArchiveContext(path)
+	assert.NoError(t, err)
+	defer c.Close()
+	label, _ := c.PmGetArchiveLabel()
+	assert.Equal(t, PmLogLabel{Hostname:"telemetry.example.com", Start:archiveStart}, label)
+	pmids, _ := c.PmLookupName("app.requests", "app.queue")
+	assert.Equal(t, []PmID{archiveCounterPmID, archiveBinPmID}, pmids)
+	instances, _ := c.PmGetInDom(archiveBinInDom)
+	assert.Equal(t, map[int]string{1:"fast", 2:"slow"}, instances)
+	assert.NoError(t, c.PmSetMode(PmModeInterp, archiveTime(15), 0))
+	pm_result, err := c.PmFetch(pmids...)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{150}, extractedU64(t, pm_result.VSet[0]))
+	atom, _ := PmExtractValue(pm_result.VSet[1].ValFmt, PmTypeU32, pm_result.VSet[1].VList[1])
+	assert.Equal(t, uint32(12), atom.UInt32, "11.5 rounded")
+}
+
+func TestArchiveWriter_recordsWhatAHostContextFetches(t *testing.T) {
+	server := pmcdtest.Sample()
+	server.Timestamp = time.Unix(1500000000, 0)
+	host := wireContext(t, server)
+	desc, _ := host.PmLookupDesc(sampleColourPmID)
+	instances, _ := host.PmGetInDom(desc.InDom)
+	fetched, _ := host.PmFetch(sampleColourPmID)
+	path := filepath.Join(t.TempDir(), "recorded")
+
+	w, _ := NewArchiveWriter(path, PmLogLabel{})
+	assert.NoError(t, w.AddMetric("sample.colour", desc))
+	assert.NoError(t, w.AddInDom(desc.InDom, fetched.Timestamp, instances))
+	assert.NoError(t, w.PutResult(fetched))
+	assert.NoError(t, w.Close())
+
+	c, err := NewArchiveContext(path)
+	assert.NoError(t, err)
+	defer c.Close()
+	replayed, err := c.PmFetch(sampleColourPmID)
+	assert.NoError(t, err)
+	assert.Equal(t, fetched.Timestamp, replayed.Timestamp)
+	assert.Equal(t, fetched.VSet[0].NumVal, replayed.VSet[0].NumVal)
+	for i, value := range fetched.VSet[0].VList {
+		want, _ := PmExtractValue(fetched.VSet[0].ValFmt, PmType32, value)
+		got, _ := PmExtractValue(replayed.VSet[0].ValFmt, PmType32, replayed.VSet[0].VList[i])
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestArchiveWriter_refusesValuesOfMetricsNotAdded(t *testing.T) {
+	w, _ := NewArchiveWriter(filepath.Join(t.TempDir(), "telemetry"), PmLogLabel{})
+	defer w.Close()
+	value, _ := NewPmValue(PmInNull, PmType32, 1)
+
+	err := w.PutResult(&PmResult{Timestamp:archiveStart, VSet:[]*PmValueSet{{PmID:archiveStringPmID, NumVal:1, VList:[]*PmValue{value}}}})
+
+	assert.EqualError(t, err, "PMID 29.0.2 has not been added")
+}
+
+func TestNewPmValue_refusesAValueOfTheWrongKind(t *testing.T) {
+	_, err := NewPmValue(PmInNull, PmTypeString, 1)
+
+	assert.EqualError(t, err, "1 is not a string")
+}
@@ -0,0 +1,41 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"errors"
+)
+
+func TestPmError_ErrorIsTheMessage(t *testing.T) {
+	err := PmError{Code:PmErrName, Message:"Unknown metric name"}
+
+	assert.EqualError(t, err, "Unknown metric name")
+}
+
+func TestIsPmError(t *testing.T) {
+	err := PmError{Code:PmErrText, Message:"One-line or help text is not available"}
+
+	assert.True(t, IsPmError(err, PmErrText))
+	assert.False(t, IsPmError(err, PmErrName))
+	assert.False(t, IsPmError(errors.New("One-line or help text is not available"), PmErrText))
+}
@@ -0,0 +1,51 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"fmt"
+)
+
+/* PmIDStr formats a PMID as domain.cluster.item, as pmIDStr(3) does */
+func PmIDStr(pmid PmID) string {
+	if(pmid == PmIDNull) {
+		return "PM_ID_NULL"
+	}
+	return fmt.Sprintf("%d.%d.%d", (pmid >> 22) & 0x1ff, (pmid >> 10) & 0xfff, pmid & 0x3ff)
+}
+
+/* PmInDomStr formats an instance domain as domain.serial, as pmInDomStr(3) does */
+func PmInDomStr(indom PmInDom) string {
+	if(indom == PmInDomNull) {
+		return "PM_INDOM_NULL"
+	}
+	return fmt.Sprintf("%d.%d", (indom >> 22) & 0x1ff, indom & 0x3fffff)
+}
+
+/* PmIDFromParts builds a PMID from its parts, the inverse of PmIDStr */
+func PmIDFromParts(domain int, cluster int, item int) PmID {
+	return PmID((domain & 0x1ff) << 22 | (cluster & 0xfff) << 10 | (item & 0x3ff))
+}
+
+/* PmInDomFromParts builds an instance domain from its parts, the inverse of PmInDomStr */
+func PmInDomFromParts(domain int, serial int) PmInDom {
+	return PmInDom((domain & 0x1ff) << 22 | (serial & 0x3fffff))
+}
@@ -0,0 +1,39 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPmIDStr(t *testing.T) {
+	assert.Equal(t, "29.0.5", PmIDStr(PmID(121634821)))
+	assert.Equal(t, "60.2.0", PmIDStr(PmIDFromParts(60, 2, 0)))
+	assert.Equal(t, "511.4095.1023", PmIDStr(PmIDFromParts(511, 4095, 1023)))
+	assert.Equal(t, "PM_ID_NULL", PmIDStr(PmID(0xffffffff)))
+}
+
+func TestPmInDomStr(t *testing.T) {
+	assert.Equal(t, "29.1", PmInDomStr(PmInDom(121634817)))
+	assert.Equal(t, "60.2", PmInDomStr(PmInDomFromParts(60, 2)))
+	assert.Equal(t, "PM_INDOM_NULL", PmInDomStr(PmInDomNull))
+}
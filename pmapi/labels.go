@@ -0,0 +1,56 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"encoding/json"
+)
+
+/* PmLabelSet is one level of the label hierarchy for a metric. Inst is PmInNull for the
+   context, domain, indom, cluster and item levels, which are returned in that order. Non-string
+   label values are kept as their JSON text */
+type PmLabelSet struct {
+	Inst int
+	Labels map[string]string
+}
+
+func labelsFromJSON(label_json string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if(label_json == "") {
+		return labels, nil
+	}
+	raw_labels := make(map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(label_json), &raw_labels)
+	if(err != nil) {
+		return nil, err
+	}
+	for name, raw_value := range raw_labels {
+		value := string(raw_value)
+		if(len(raw_value) > 0 && raw_value[0] == '"') {
+			err = json.Unmarshal(raw_value, &value)
+			if(err != nil) {
+				return nil, err
+			}
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
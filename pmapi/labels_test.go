@@ -0,0 +1,50 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsFromJSON_KeepsStringValues(t *testing.T) {
+	labels, _ := labelsFromJSON(`{"agent":"sample","hostname":"web \"1\""}`)
+
+	assert.Equal(t, map[string]string{"agent":"sample", "hostname":`web "1"`}, labels)
+}
+
+func TestLabelsFromJSON_KeepsNonStringValuesAsJSON(t *testing.T) {
+	labels, _ := labelsFromJSON(`{"cluster":0,"role":null,"sites":["a","b"]}`)
+
+	assert.Equal(t, map[string]string{"cluster":"0", "role":"null", "sites":`["a","b"]`}, labels)
+}
+
+func TestLabelsFromJSON_ReturnsAnEmptyMapForNoLabels(t *testing.T) {
+	labels, _ := labelsFromJSON("")
+
+	assert.Equal(t, map[string]string{}, labels)
+}
+
+func TestLabelsFromJSON_ReturnsAnErrorForInvalidJSON(t *testing.T) {
+	_, err := labelsFromJSON(`{"agent":`)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+//go:build cgo && !purego
+
+package pmapi
+// #include <pcp/pmapi.h>
+import "C"
+
+/* libpcpConstant is a constant of types.go alongside the value libpcp gives it */
+type libpcpConstant struct {
+	name string
+	ours int
+	libpcp int
+}
+
+/* libpcpConstants lets libpcp_test.go check types.go against the installed pmapi.h */
+var libpcpConstants = []libpcpConstant{
+	{"PmContextHost", int(PmContextHost), int(C.PM_CONTEXT_HOST)},
+	{"PmContextArchive", int(PmContextArchive), int(C.PM_CONTEXT_ARCHIVE)},
+	{"PmContextLocal", int(PmContextLocal), int(C.PM_CONTEXT_LOCAL)},
+	{"PmContextUndef", int(PmContextUndef), int(C.PM_CONTEXT_UNDEF)},
+	{"PmInDomNull", int(PmInDomNull), int(C.PM_INDOM_NULL)},
+	{"PmInNull", int(PmInNull), int(C.PM_IN_NULL)},
+	{"PmIDNull", int(PmIDNull), int(C.PM_ID_NULL)},
+	{"PmSpaceByte", int(PmSpaceByte), int(C.PM_SPACE_BYTE)},
+	{"PmSpaceKByte", int(PmSpaceKByte), int(C.PM_SPACE_KBYTE)},
+	{"PmSpaceMByte", int(PmSpaceMByte), int(C.PM_SPACE_MBYTE)},
+	{"PmSpaceGByte", int(PmSpaceGByte), int(C.PM_SPACE_GBYTE)},
+	{"PmSpaceTByte", int(PmSpaceTByte), int(C.PM_SPACE_TBYTE)},
+	{"PmSpacePByte", int(PmSpacePByte), int(C.PM_SPACE_PBYTE)},
+	{"PmSpaceEByte", int(PmSpaceEByte), int(C.PM_SPACE_EBYTE)},
+	{"PmTimeNSec", int(PmTimeNSec), int(C.PM_TIME_NSEC)},
+	{"PmTimeUSec", int(PmTimeUSec), int(C.PM_TIME_USEC)},
+	{"PmTimeMSec", int(PmTimeMSec), int(C.PM_TIME_MSEC)},
+	{"PmTimeSec", int(PmTimeSec), int(C.PM_TIME_SEC)},
+	{"PmTimeMin", int(PmTimeMin), int(C.PM_TIME_MIN)},
+	{"PmTimeHour", int(PmTimeHour), int(C.PM_TIME_HOUR)},
+	{"PmTypeNoSupport", int(PmTypeNoSupport), int(C.PM_TYPE_NOSUPPORT)},
+	{"PmType32", int(PmType32), int(C.PM_TYPE_32)},
+	{"PmTypeU32", int(PmTypeU32), int(C.PM_TYPE_U32)},
+	{"PmType64", int(PmType64), int(C.PM_TYPE_64)},
+	{"PmTypeU64", int(PmTypeU64), int(C.PM_TYPE_U64)},
+	{"PmTypeFloat", int(PmTypeFloat), int(C.PM_TYPE_FLOAT)},
+	{"PmTypeDouble", int(PmTypeDouble), int(C.PM_TYPE_DOUBLE)},
+	{"PmTypeString", int(PmTypeString), int(C.PM_TYPE_STRING)},
+	{"PmTypeAggregate", int(PmTypeAggregate), int(C.PM_TYPE_AGGREGATE)},
+	{"PmTypeAggregateStatic", int(PmTypeAggregateStatic), int(C.PM_TYPE_AGGREGATE_STATIC)},
+	{"PmTypeEvent", int(PmTypeEvent), int(C.PM_TYPE_EVENT)},
+	{"PmTypeHighResEvent", int(PmTypeHighResEvent), int(C.PM_TYPE_HIGHRES_EVENT)},
+	{"PmTypeUnknown", int(PmTypeUnknown), int(C.PM_TYPE_UNKNOWN)},
+	{"PmSemCounter", int(PmSemCounter), int(C.PM_SEM_COUNTER)},
+	{"PmSemInstant", int(PmSemInstant), int(C.PM_SEM_INSTANT)},
+	{"PmSemDiscrete", int(PmSemDiscrete), int(C.PM_SEM_DISCRETE)},
+	{"PmValInsitu", int(PmValInsitu), int(C.PM_VAL_INSITU)},
+	{"PmValDptr", int(PmValDptr), int(C.PM_VAL_DPTR)},
+	{"PmValSptr", int(PmValSptr), int(C.PM_VAL_SPTR)},
+	{"PmModeLive", int(PmModeLive), int(C.PM_MODE_LIVE)},
+	{"PmModeInterp", int(PmModeInterp), int(C.PM_MODE_INTERP)},
+	{"PmModeForw", int(PmModeForw), int(C.PM_MODE_FORW)},
+	{"PmModeBack", int(PmModeBack), int(C.PM_MODE_BACK)},
+	{"PmTextOneline", int(PmTextOneline), int(C.PM_TEXT_ONELINE)},
+	{"PmTextHelp", int(PmTextHelp), int(C.PM_TEXT_HELP)},
+	{"PmErrText", int(PmErrText), int(C.PM_ERR_TEXT)},
+	{"PmErrValue", int(PmErrValue), int(C.PM_ERR_VALUE)},
+	{"PmErrTimeout", int(PmErrTimeout), int(C.PM_ERR_TIMEOUT)},
+	{"PmErrName", int(PmErrName), int(C.PM_ERR_NAME)},
+	{"PmErrPmID", int(PmErrPmID), int(C.PM_ERR_PMID)},
+	{"PmErrInDom", int(PmErrInDom), int(C.PM_ERR_INDOM)},
+	{"PmErrInst", int(PmErrInst), int(C.PM_ERR_INST)},
+	{"PmErrIPC", int(PmErrIPC), int(C.PM_ERR_IPC)},
+	{"PmErrEOF", int(PmErrEOF), int(C.PM_ERR_EOF)},
+	{"PmErrEOL", int(PmErrEOL), int(C.PM_ERR_EOL)},
+	{"PmErrNoLabels", int(PmErrNoLabels), int(C.PM_ERR_NOLABELS)},
+}
@@ -0,0 +1,128 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pdu
+
+import (
+	"fmt"
+)
+
+/* PM_ERR_* codes carried by error PDUs and failed value sets */
+const (
+	ErrGeneric = -12345
+	ErrPMNS = -12346
+	ErrNoPMNS = -12347
+	ErrText = -12349
+	ErrValue = -12351
+	ErrTimeout = -12353
+	ErrReset = -12355
+	ErrName = -12357
+	ErrPmID = -12358
+	ErrInDom = -12359
+	ErrInst = -12360
+	ErrConv = -12362
+	ErrProfile = -12365
+	ErrIPC = -12366
+	ErrEOF = -12368
+	ErrNotHost = -12369
+	ErrEOL = -12370
+	ErrMode = -12371
+	ErrNotArchive = -12374
+	ErrNoContext = -12376
+	ErrProfileSpec = -12377
+	ErrNoAgent = -12386
+	ErrPermission = -12387
+	ErrAgain = -12389
+	ErrNotConn = -12391
+	ErrNonLeaf = -12394
+	ErrType = -12397
+	ErrNoLabels = -12409
+	ErrNYI = -21344
+)
+
+type errorInfo struct {
+	name string
+	message string
+}
+
+var errorInfos = map[int]errorInfo{
+	ErrGeneric:{"PM_ERR_GENERIC", "Generic error, already reported above"},
+	ErrPMNS:{"PM_ERR_PMNS", "Problems parsing PMNS definitions"},
+	ErrNoPMNS:{"PM_ERR_NOPMNS", "PMNS not accessible"},
+	ErrText:{"PM_ERR_TEXT", "One-line or help text is not available"},
+	ErrValue:{"PM_ERR_VALUE", "Missing metric value(s)"},
+	ErrTimeout:{"PM_ERR_TIMEOUT", "Timeout waiting for a response from PMCD"},
+	ErrReset:{"PM_ERR_RESET", "PMCD reset or configuration change"},
+	ErrName:{"PM_ERR_NAME", "Unknown metric name"},
+	ErrPmID:{"PM_ERR_PMID", "Unknown or illegal metric identifier"},
+	ErrInDom:{"PM_ERR_INDOM", "Unknown or illegal instance domain identifier"},
+	ErrInst:{"PM_ERR_INST", "Unknown or illegal instance identifier"},
+	ErrConv:{"PM_ERR_CONV", "Impossible value or scale conversion"},
+	ErrProfile:{"PM_ERR_PROFILE", "Explicit instance identifier(s) required"},
+	ErrIPC:{"PM_ERR_IPC", "IPC protocol failure"},
+	ErrEOF:{"PM_ERR_EOF", "IPC channel closed"},
+	ErrNotHost:{"PM_ERR_NOTHOST", "Operation requires context with host source of metrics"},
+	ErrEOL:{"PM_ERR_EOL", "End of PCP archive log"},
+	ErrMode:{"PM_ERR_MODE", "Illegal mode specification"},
+	ErrNotArchive:{"PM_ERR_NOTARCHIVE", "Operation requires context with archive source of metrics"},
+	ErrNoContext:{"PM_ERR_NOCONTEXT", "Attempt to use an illegal context"},
+	ErrProfileSpec:{"PM_ERR_PROFILESPEC", "NULL pmInDom with non-NULL instlist"},
+	ErrNoAgent:{"PM_ERR_NOAGENT", "No PMCD agent for domain of request"},
+	ErrPermission:{"PM_ERR_PERMISSION", "No permission to perform requested operation"},
+	ErrAgain:{"PM_ERR_AGAIN", "Try again. Information not currently available"},
+	ErrNotConn:{"PM_ERR_NOTCONN", "Not connected to PMCD"},
+	ErrNonLeaf:{"PM_ERR_NONLEAF", "Metric name is not a leaf in PMNS"},
+	ErrType:{"PM_ERR_TYPE", "Unknown or illegal metric type"},
+	ErrNoLabels:{"PM_ERR_NOLABELS", "No support for label metadata"},
+	ErrNYI:{"PM_ERR_NYI", "Functionality not yet implemented"},
+}
+
+/* Error is a PM_ERR_* or negated errno code received from, or to be sent to, a peer */
+type Error struct {
+	Code int
+}
+
+/* Error gives the message pmErrStr(3) would */
+func (e Error) Error() string {
+	info, found := errorInfos[e.Code]
+	if(found) {
+		return info.message
+	}
+	return fmt.Sprintf("Unknown error code %v", e.Code)
+}
+
+/* ErrorCode looks up a code by its PM_ERR_* name */
+func ErrorCode(name string) (int, bool) {
+	for code, info := range errorInfos {
+		if(info.name == name) {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+/* ErrorName gives the PM_ERR_* name of a code, or the code as a number if it has none */
+func ErrorName(code int) string {
+	info, found := errorInfos[code]
+	if(found) {
+		return info.name
+	}
+	return fmt.Sprint(code)
+}
@@ -0,0 +1,183 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pdu
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+/* Label hierarchy levels, which a label request asks for one of */
+const (
+	LabelContext = 1 << 0
+	LabelDomain = 1 << 1
+	LabelInDom = 1 << 2
+	LabelCluster = 1 << 3
+	LabelItem = 1 << 4
+	LabelInstances = 1 << 5
+)
+
+/* LabelReq asks for the labels of one level. Ident is the domain number, cluster or item PMID or
+   instance domain the level belongs to, and unused for the context */
+type LabelReq struct {
+	Ident uint32
+	Type int
+}
+
+/* LabelSet is the labels of a level, or of one instance for LabelInstances. Values that are not
+   JSON strings are kept as their JSON text */
+type LabelSet struct {
+	Inst int
+	Labels map[string]string
+}
+
+type LabelList struct {
+	Ident uint32
+	Type int
+	Sets []LabelSet
+}
+
+func EncodeLabelReq(request LabelReq) PDU {
+	e := &encoder{}
+	e.uint(request.Ident)
+	e.int(request.Type)
+	return e.pdu(TypeLabelReq, FromAnon)
+}
+
+func DecodeLabelReq(pdu PDU) (LabelReq, error) {
+	d := newDecoder(pdu, TypeLabelReq)
+	request := LabelReq{Ident:d.uint(), Type:d.int()}
+	return request, d.err
+}
+
+/* label indexes a name and value in a label set's JSON, as pmLabel does */
+type label struct {
+	name int
+	name_length int
+	value int
+	value_length int
+}
+
+/* labelJSON builds the JSON object of a label set, sorted by name as libpcp keeps it, with the
+   offsets of each name and value in it */
+func labelJSON(labels map[string]string) (string, []label) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text, indexes := "{", make([]label, len(names))
+	for i, name := range names {
+		if(i > 0) {
+			text += ","
+		}
+		quoted_name, _ := json.Marshal(name)
+		value := labels[name]
+		if(!json.Valid([]byte(value)) || value == "" || value[0] == '"') {
+			quoted_value, _ := json.Marshal(value)
+			value = string(quoted_value)
+		}
+		indexes[i].name = len(text) + 1
+		indexes[i].name_length = len(quoted_name) - 2
+		text += string(quoted_name) + ":"
+		indexes[i].value = len(text)
+		indexes[i].value_length = len(value)
+		text += value
+	}
+	return text + "}", indexes
+}
+
+/* EncodeLabelList gives each set its label indexes and the offset of its JSON in the strings
+   following the sets */
+func EncodeLabelList(list LabelList) PDU {
+	e, strings := &encoder{}, []byte{}
+	e.uint(list.Ident)
+	e.int(list.Type)
+	e.int(0)
+	e.int(len(list.Sets))
+	for _, set := range list.Sets {
+		text, indexes := labelJSON(set.Labels)
+		e.int(set.Inst)
+		e.int(len(indexes))
+		e.int(len(strings))
+		e.int(len(text))
+		for _, index := range indexes {
+			e.uint(uint32(index.name & 0xffff) << 16 | uint32(index.name_length & 0xff) << 8 | uint32(list.Type & 0xff))
+			e.uint(uint32(index.value & 0xffff) << 16 | uint32(index.value_length & 0xffff))
+		}
+		strings = append(strings, text...)
+	}
+	e.bytes(strings)
+	return e.pdu(TypeLabel, FromAnon)
+}
+
+func DecodeLabelList(pdu PDU) (LabelList, error) {
+	d := newDecoder(pdu, TypeLabel)
+	list := LabelList{Ident:d.uint(), Type:d.int()}
+	d.int()
+	list.Sets = make([]LabelSet, d.count(16))
+	offsets, lengths := make([]int, len(list.Sets)), make([]int, len(list.Sets))
+	for i := range list.Sets {
+		list.Sets[i].Inst = d.int()
+		count := d.int()
+		offsets[i], lengths[i] = d.int(), d.int()
+		for words := d.fits(count, 8) * 2; words > 0; words-- {
+			d.uint()
+		}
+	}
+	strings := d.body[min(d.offset, len(d.body)):]
+	for i := range list.Sets {
+		if(d.err != nil) {
+			break
+		}
+		if(offsets[i] < 0 || lengths[i] < 0 || offsets[i] + lengths[i] > len(strings)) {
+			d.err = Error{Code:ErrIPC}
+			break
+		}
+		list.Sets[i].Labels, d.err = LabelsFromJSON(strings[offsets[i]:offsets[i] + lengths[i]])
+	}
+	return list, d.err
+}
+
+/* LabelsFromJSON reads the JSON object of a label set. Values that are not strings are kept as
+   their JSON text */
+func LabelsFromJSON(text []byte) (map[string]string, error) {
+	labels := make(map[string]string)
+	if(len(text) == 0) {
+		return labels, nil
+	}
+	raw_labels := make(map[string]json.RawMessage)
+	err := json.Unmarshal(text, &raw_labels)
+	if(err != nil) {
+		return nil, err
+	}
+	for name, raw_value := range raw_labels {
+		value := string(raw_value)
+		if(len(raw_value) > 0 && raw_value[0] == '"') {
+			err = json.Unmarshal(raw_value, &value)
+			if(err != nil) {
+				return nil, err
+			}
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
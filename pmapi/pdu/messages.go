@@ -0,0 +1,513 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pdu
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* Version is the protocol version pmcd offers in its challenge */
+const Version = 2
+
+/* Features pmcd advertises in its challenge */
+const (
+	FeatureSecure = 1 << 0
+	FeatureCompress = 1 << 1
+	FeatureAuth = 1 << 2
+	FeatureCredsRequired = 1 << 3
+	FeatureSecureAck = 1 << 4
+	FeatureContainer = 1 << 6
+	FeatureLabels = 1 << 9
+)
+
+/* credVersion is the type of the credential a client announces its version and flags with */
+const credVersion = 1
+
+/* EncodeError builds the PDU pmcd answers a failed request with */
+func EncodeError(code int) PDU {
+	e := &encoder{}
+	e.int(code)
+	return e.pdu(TypeError, FromAnon)
+}
+
+/* DecodeError returns the code of an error PDU */
+func DecodeError(pdu PDU) (int, error) {
+	d := newDecoder(pdu, TypeError)
+	code := d.int()
+	return code, d.err
+}
+
+/* Challenge is what pmcd sends a client on connection, as the datum of an extended error PDU */
+type Challenge struct {
+	Version int
+	Licensed int
+	Features int
+}
+
+func EncodeChallenge(challenge Challenge) PDU {
+	e := &encoder{}
+	e.int(0)
+	e.uint(uint32(challenge.Version & 0x7f) << 24 | uint32(challenge.Licensed & 0xff) << 16 | uint32(challenge.Features & 0xffff))
+	return e.pdu(TypeError, FromAnon)
+}
+
+/* DecodeChallenge reads pmcd's challenge. pmcd refusing the connection gives an Error */
+func DecodeChallenge(pdu PDU) (Challenge, error) {
+	d := newDecoder(pdu, TypeError)
+	code := d.int()
+	datum := d.uint()
+	if(d.err != nil) {
+		return Challenge{}, d.err
+	}
+	if(code < 0) {
+		return Challenge{}, Error{Code:code}
+	}
+	return Challenge{Version:int(datum >> 24 & 0x7f), Licensed:int(datum >> 16 & 0xff), Features:int(datum & 0xffff)}, nil
+}
+
+/* Creds is the version credential a client answers the challenge with. Flags asks for the
+   features it wants to use */
+type Creds struct {
+	Version int
+	Flags int
+}
+
+func EncodeCreds(creds Creds) PDU {
+	e := &encoder{}
+	e.int(1)
+	e.uint(credVersion << 24 | uint32(creds.Version & 0xff) << 16 | uint32(creds.Flags & 0xffff))
+	return e.pdu(TypeCreds, FromAnon)
+}
+
+/* DecodeCreds finds the version credential among those a client sent */
+func DecodeCreds(pdu PDU) (Creds, error) {
+	d := newDecoder(pdu, TypeCreds)
+	count := d.count(4)
+	creds := Creds{}
+	for i := 0; i < count; i++ {
+		cred := d.uint()
+		if(cred >> 24 == credVersion) {
+			creds = Creds{Version:int(cred >> 16 & 0xff), Flags:int(cred & 0xffff)}
+		}
+	}
+	return creds, d.err
+}
+
+/* Profile states, for the context as a whole and for each instance domain */
+const (
+	ProfileInclude = 0
+	ProfileExclude = 1
+)
+
+/* InDomProfile selects the instances fetched for an instance domain. Instances are the
+   exceptions to State */
+type InDomProfile struct {
+	InDom uint32
+	State int
+	Instances []int
+}
+
+/* Profile is the instance profile a client sends before fetching after changing it */
+type Profile struct {
+	Context int
+	State int
+	InDoms []InDomProfile
+}
+
+/* Includes reports whether the profile selects an instance, as __pmInProfile does */
+func (p Profile) Includes(indom uint32, inst int) bool {
+	for _, indom_profile := range p.InDoms {
+		if(indom_profile.InDom != indom) {
+			continue
+		}
+		for _, listed := range indom_profile.Instances {
+			if(listed == inst) {
+				return indom_profile.State == ProfileExclude
+			}
+		}
+		return indom_profile.State == ProfileInclude
+	}
+	return p.State == ProfileInclude
+}
+
+func EncodeProfile(profile Profile) PDU {
+	e := &encoder{}
+	e.int(profile.Context)
+	e.int(profile.State)
+	e.int(len(profile.InDoms))
+	e.int(0)
+	for _, indom_profile := range profile.InDoms {
+		e.uint(indom_profile.InDom)
+		e.int(indom_profile.State)
+		e.int(len(indom_profile.Instances))
+		e.int(0)
+	}
+	for _, indom_profile := range profile.InDoms {
+		for _, inst := range indom_profile.Instances {
+			e.int(inst)
+		}
+	}
+	return e.pdu(TypeProfile, FromAnon)
+}
+
+func DecodeProfile(pdu PDU) (Profile, error) {
+	d := newDecoder(pdu, TypeProfile)
+	profile := Profile{Context:d.int(), State:d.int()}
+	count := d.count(16)
+	d.int()
+	profile.InDoms = make([]InDomProfile, count)
+	for i := range profile.InDoms {
+		profile.InDoms[i] = InDomProfile{InDom:d.uint(), State:d.int()}
+		profile.InDoms[i].Instances = make([]int, d.count(4))
+		d.int()
+	}
+	for _, indom_profile := range profile.InDoms {
+		for i := range indom_profile.Instances {
+			indom_profile.Instances[i] = d.int()
+		}
+	}
+	return profile, d.err
+}
+
+type Fetch struct {
+	Context int
+	PmIDs []uint32
+}
+
+func EncodeFetch(fetch Fetch) PDU {
+	e := &encoder{}
+	e.int(fetch.Context)
+	/* The time wanted, which only archives use */
+	e.int(0)
+	e.int(0)
+	e.int(len(fetch.PmIDs))
+	for _, pmid := range fetch.PmIDs {
+		e.uint(pmid)
+	}
+	return e.pdu(TypeFetch, FromAnon)
+}
+
+func DecodeFetch(pdu PDU) (Fetch, error) {
+	d := newDecoder(pdu, TypeFetch)
+	fetch := Fetch{Context:d.int()}
+	d.int()
+	d.int()
+	fetch.PmIDs = make([]uint32, d.count(4))
+	for i := range fetch.PmIDs {
+		fetch.PmIDs[i] = d.uint()
+	}
+	return fetch, d.err
+}
+
+/* Units packs into a word as pmUnits does, with signed dimensions and count scale */
+type Units struct {
+	DimSpace int
+	DimTime int
+	DimCount int
+	ScaleSpace uint
+	ScaleTime uint
+	ScaleCount int
+}
+
+func (u Units) word() uint32 {
+	return uint32(u.DimSpace & 0xf) << 28 | uint32(u.DimTime & 0xf) << 24 | uint32(u.DimCount & 0xf) << 20 |
+		uint32(u.ScaleSpace & 0xf) << 16 | uint32(u.ScaleTime & 0xf) << 12 | uint32(u.ScaleCount & 0xf) << 8
+}
+
+func unitsFromWord(word uint32) Units {
+	signed := func(shift uint) int {
+		return int(int32(word << (28 - shift)) >> 28)
+	}
+	return Units{
+		DimSpace:signed(28),
+		DimTime:signed(24),
+		DimCount:signed(20),
+		ScaleSpace:uint(word >> 16 & 0xf),
+		ScaleTime:uint(word >> 12 & 0xf),
+		ScaleCount:signed(8),
+	}
+}
+
+type Desc struct {
+	PmID uint32
+	Type int
+	InDom uint32
+	Sem int
+	Units Units
+}
+
+func EncodeDescReq(pmid uint32) PDU {
+	e := &encoder{}
+	e.uint(pmid)
+	return e.pdu(TypeDescReq, FromAnon)
+}
+
+func DecodeDescReq(pdu PDU) (uint32, error) {
+	d := newDecoder(pdu, TypeDescReq)
+	pmid := d.uint()
+	return pmid, d.err
+}
+
+func EncodeDesc(desc Desc) PDU {
+	e := &encoder{}
+	e.uint(desc.PmID)
+	e.int(desc.Type)
+	e.uint(desc.InDom)
+	e.int(desc.Sem)
+	e.uint(desc.Units.word())
+	return e.pdu(TypeDesc, FromAnon)
+}
+
+func DecodeDesc(pdu PDU) (Desc, error) {
+	d := newDecoder(pdu, TypeDesc)
+	desc := Desc{PmID:d.uint(), Type:d.int(), InDom:d.uint(), Sem:d.int()}
+	desc.Units = unitsFromWord(d.uint())
+	return desc, d.err
+}
+
+/* InstanceReq asks for an instance domain: the whole of it when Inst is InNull and Name is empty,
+   otherwise the instance with that identifier or name */
+type InstanceReq struct {
+	InDom uint32
+	Inst int
+	Name string
+}
+
+type Instance struct {
+	Inst int
+	Name string
+}
+
+type InstanceList struct {
+	InDom uint32
+	Instances []Instance
+}
+
+func EncodeInstanceReq(request InstanceReq) PDU {
+	e := &encoder{}
+	e.uint(request.InDom)
+	/* The time wanted, which only archives use */
+	e.int(0)
+	e.int(0)
+	e.int(request.Inst)
+	e.int(len(request.Name))
+	e.bytes([]byte(request.Name))
+	return e.pdu(TypeInstanceReq, FromAnon)
+}
+
+func DecodeInstanceReq(pdu PDU) (InstanceReq, error) {
+	d := newDecoder(pdu, TypeInstanceReq)
+	request := InstanceReq{InDom:d.uint()}
+	d.int()
+	d.int()
+	request.Inst = d.int()
+	request.Name = d.string(d.int())
+	return request, d.err
+}
+
+func EncodeInstanceList(list InstanceList) PDU {
+	e := &encoder{}
+	e.uint(list.InDom)
+	e.int(len(list.Instances))
+	for _, instance := range list.Instances {
+		e.int(instance.Inst)
+		e.int(len(instance.Name))
+		e.bytes([]byte(instance.Name))
+	}
+	return e.pdu(TypeInstance, FromAnon)
+}
+
+func DecodeInstanceList(pdu PDU) (InstanceList, error) {
+	d := newDecoder(pdu, TypeInstance)
+	list := InstanceList{InDom:d.uint()}
+	list.Instances = make([]Instance, d.count(8))
+	for i := range list.Instances {
+		list.Instances[i].Inst = d.int()
+		length := d.int()
+		if(length > 0) {
+			list.Instances[i].Name = d.string(length)
+		}
+	}
+	return list, d.err
+}
+
+/* Help text kinds, combined with TextPmID or TextInDom in a request */
+const (
+	TextOneline = 1
+	TextHelp = 2
+	TextPmID = 4
+	TextInDom = 8
+)
+
+type TextReq struct {
+	Ident uint32
+	Type int
+}
+
+func EncodeTextReq(request TextReq) PDU {
+	e := &encoder{}
+	e.uint(request.Ident)
+	e.int(request.Type)
+	return e.pdu(TypeTextReq, FromAnon)
+}
+
+func DecodeTextReq(pdu PDU) (TextReq, error) {
+	d := newDecoder(pdu, TypeTextReq)
+	request := TextReq{Ident:d.uint(), Type:d.int()}
+	return request, d.err
+}
+
+func EncodeText(ident uint32, text string) PDU {
+	e := &encoder{}
+	e.uint(ident)
+	e.int(len(text))
+	e.bytes([]byte(text))
+	return e.pdu(TypeText, FromAnon)
+}
+
+func DecodeText(pdu PDU) (string, error) {
+	d := newDecoder(pdu, TypeText)
+	d.uint()
+	text := d.string(d.int())
+	return text, d.err
+}
+
+/* Statuses of the children in a name list answering a PMNS child request */
+const (
+	LeafStatus = 0
+	NonLeafStatus = 1
+)
+
+/* NameList carries metric names: a client's names to look up, or pmcd's answer to a child or
+   traverse request. Statuses, when present, hold one status per name */
+type NameList struct {
+	Names []string
+	Statuses []int
+}
+
+func EncodeNameList(list NameList) PDU {
+	string_bytes := 0
+	for _, name := range list.Names {
+		string_bytes += len(name) + 1
+	}
+	e := &encoder{}
+	e.int(string_bytes)
+	e.int(len(list.Statuses))
+	e.int(len(list.Names))
+	for i, name := range list.Names {
+		if(len(list.Statuses) > 0) {
+			e.int(list.Statuses[i])
+		}
+		e.int(len(name))
+		e.bytes([]byte(name))
+	}
+	return e.pdu(TypePMNSNames, FromAnon)
+}
+
+func DecodeNameList(pdu PDU) (NameList, error) {
+	d := newDecoder(pdu, TypePMNSNames)
+	d.int()
+	statuses := d.int()
+	list := NameList{Names:make([]string, d.count(4))}
+	if(d.err == nil && statuses != 0 && statuses != len(list.Names)) {
+		return NameList{}, errors.New(fmt.Sprintf("%v statuses for %v names", statuses, len(list.Names)))
+	}
+	if(statuses > 0) {
+		list.Statuses = make([]int, statuses)
+	}
+	for i := range list.Names {
+		if(statuses > 0) {
+			list.Statuses[i] = d.int()
+		}
+		list.Names[i] = d.string(d.int())
+	}
+	return list, d.err
+}
+
+/* IDList carries PMIDs: pmcd's answer to a name lookup, where Status is the number of names found
+   and unknown names have IDNull, or a client's PMIDs to find the names of */
+type IDList struct {
+	Status int
+	PmIDs []uint32
+}
+
+/* IDNull is PM_ID_NULL */
+const IDNull = 0xffffffff
+
+func EncodeIDList(list IDList) PDU {
+	e := &encoder{}
+	e.int(list.Status)
+	e.int(len(list.PmIDs))
+	for _, pmid := range list.PmIDs {
+		e.uint(pmid)
+	}
+	return e.pdu(TypePMNSIDs, FromAnon)
+}
+
+func DecodeIDList(pdu PDU) (IDList, error) {
+	d := newDecoder(pdu, TypePMNSIDs)
+	list := IDList{Status:d.int()}
+	list.PmIDs = make([]uint32, d.count(4))
+	for i := range list.PmIDs {
+		list.PmIDs[i] = d.uint()
+	}
+	return list, d.err
+}
+
+/* NameReq asks for the children of a PMNS node, with their leaf statuses when Status is set, or
+   for every metric name under it */
+type NameReq struct {
+	Status bool
+	Name string
+}
+
+func EncodeChildReq(request NameReq) PDU {
+	return encodeNameReq(request, TypePMNSChild)
+}
+
+func EncodeTraverseReq(request NameReq) PDU {
+	return encodeNameReq(request, TypePMNSTraverse)
+}
+
+func encodeNameReq(request NameReq, pdu_type int) PDU {
+	e := &encoder{}
+	if(request.Status) {
+		e.int(1)
+	} else {
+		e.int(0)
+	}
+	e.int(len(request.Name))
+	e.bytes([]byte(request.Name))
+	return e.pdu(pdu_type, FromAnon)
+}
+
+/* DecodeNameReq decodes either a child or a traverse request */
+func DecodeNameReq(pdu PDU) (NameReq, error) {
+	expected := TypePMNSChild
+	if(pdu.Type == TypePMNSTraverse) {
+		expected = TypePMNSTraverse
+	}
+	d := newDecoder(pdu, expected)
+	request := NameReq{Status:d.int() != 0}
+	request.Name = d.string(d.int())
+	return request, d.err
+}
@@ -0,0 +1,242 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package pdu encodes and decodes the protocol data units (PDUs) pmcd(1) and its clients exchange
+over TCP, as libpcp does. It covers what a client of a live pmcd needs: the connection handshake,
+profiles, fetches, descriptors, instance domains, help text, the PMNS and labels.
+
+Every PDU starts with a header of its length, type and sender, and all integers are big endian
+32 bit words. Unlike package pmapi, this package does not need libpcp.
+*/
+package pdu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	TypeError = 0x7000
+	TypeResult = 0x7001
+	TypeProfile = 0x7002
+	TypeFetch = 0x7003
+	TypeDescReq = 0x7004
+	TypeDesc = 0x7005
+	TypeInstanceReq = 0x7006
+	TypeInstance = 0x7007
+	TypeTextReq = 0x7008
+	TypeText = 0x7009
+	TypeCreds = 0x700c
+	TypePMNSIDs = 0x700d
+	TypePMNSNames = 0x700e
+	TypePMNSChild = 0x700f
+	TypePMNSTraverse = 0x7010
+	TypeAttr = 0x7011
+	TypeLabelReq = 0x7012
+	TypeLabel = 0x7013
+	TypeHighResResult = 0x7015
+)
+
+const (
+	/* HeaderSize is the size of the length, type and from words starting every PDU */
+	HeaderSize = 12
+	/* MaxSize bounds the PDUs Read accepts, so a corrupt length cannot exhaust memory */
+	MaxSize = 64 * 1024 * 1024
+	/* FromAnon is the sender pmcd uses for its replies */
+	FromAnon = 0
+)
+
+/* PDU is one protocol data unit. Body excludes the header and is a whole number of words */
+type PDU struct {
+	Type int
+	From int
+	Body []byte
+}
+
+var typeNames = map[int]string{
+	TypeError:"ERROR",
+	TypeResult:"RESULT",
+	TypeProfile:"PROFILE",
+	TypeFetch:"FETCH",
+	TypeDescReq:"DESC_REQ",
+	TypeDesc:"DESC",
+	TypeInstanceReq:"INSTANCE_REQ",
+	TypeInstance:"INSTANCE",
+	TypeTextReq:"TEXT_REQ",
+	TypeText:"TEXT",
+	TypeCreds:"CREDS",
+	TypePMNSIDs:"PMNS_IDS",
+	TypePMNSNames:"PMNS_NAMES",
+	TypePMNSChild:"PMNS_CHILD",
+	TypePMNSTraverse:"PMNS_TRAVERSE",
+	TypeAttr:"ATTR",
+	TypeLabelReq:"LABEL_REQ",
+	TypeLabel:"LABEL",
+	TypeHighResResult:"HIGHRES_RESULT",
+}
+
+/* TypeName gives the name libpcp uses for a PDU type, as in pmcd's debug output */
+func TypeName(pdu_type int) string {
+	name, found := typeNames[pdu_type]
+	if(!found) {
+		return fmt.Sprintf("TYPE-%#x", pdu_type)
+	}
+	return name
+}
+
+/* Read reads one PDU */
+func Read(reader io.Reader) (PDU, error) {
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if(err != nil) {
+		return PDU{}, err
+	}
+	length := int(int32(binary.BigEndian.Uint32(header)))
+	if(length < HeaderSize || length > MaxSize) {
+		return PDU{}, errors.New(fmt.Sprintf("bad PDU length %v", length))
+	}
+	body := make([]byte, length - HeaderSize)
+	_, err = io.ReadFull(reader, body)
+	if(err != nil) {
+		if(err == io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return PDU{}, err
+	}
+	return PDU{
+		Type:int(int32(binary.BigEndian.Uint32(header[4:]))),
+		From:int(int32(binary.BigEndian.Uint32(header[8:]))),
+		Body:body,
+	}, nil
+}
+
+/* Write writes one PDU, padding its body to a whole number of words */
+func Write(writer io.Writer, pdu PDU) error {
+	body := pad(pdu.Body)
+	buffer := make([]byte, HeaderSize, HeaderSize + len(body))
+	binary.BigEndian.PutUint32(buffer, uint32(HeaderSize + len(body)))
+	binary.BigEndian.PutUint32(buffer[4:], uint32(int32(pdu.Type)))
+	binary.BigEndian.PutUint32(buffer[8:], uint32(int32(pdu.From)))
+	_, err := writer.Write(append(buffer, body...))
+	return err
+}
+
+func pad(data []byte) []byte {
+	for len(data) % 4 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+/* encoder builds a PDU body a word at a time */
+type encoder struct {
+	buffer []byte
+}
+
+func (e *encoder) int(value int) {
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, uint32(int32(value)))
+}
+
+func (e *encoder) uint(value uint32) {
+	e.buffer = binary.BigEndian.AppendUint32(e.buffer, value)
+}
+
+/* bytes appends data padded to a whole number of words */
+func (e *encoder) bytes(data []byte) {
+	e.buffer = pad(append(e.buffer, data...))
+}
+
+func (e *encoder) pdu(pdu_type int, from int) PDU {
+	return PDU{Type:pdu_type, From:from, Body:e.buffer}
+}
+
+/* decoder reads a PDU body a word at a time. The first read past the end records an error and
+   every read after it returns zero, so callers check err once */
+type decoder struct {
+	body []byte
+	offset int
+	err error
+}
+
+/* newDecoder starts decoding a PDU of the expected type. An error PDU in its place, pmcd's answer
+   to a failed request, becomes an Error */
+func newDecoder(pdu PDU, expected int) *decoder {
+	d := &decoder{body:pdu.Body}
+	if(pdu.Type == TypeError && expected != TypeError && len(pdu.Body) >= 4) {
+		d.err = Error{Code:int(int32(binary.BigEndian.Uint32(pdu.Body)))}
+	} else if(pdu.Type != expected) {
+		d.err = errors.New(fmt.Sprintf("expected a %v PDU, got %v", TypeName(expected), TypeName(pdu.Type)))
+	}
+	return d
+}
+
+func (d *decoder) uint() uint32 {
+	if(d.err != nil) {
+		return 0
+	}
+	if(d.offset + 4 > len(d.body)) {
+		d.err = errors.New("PDU too short")
+		return 0
+	}
+	value := binary.BigEndian.Uint32(d.body[d.offset:])
+	d.offset += 4
+	return value
+}
+
+func (d *decoder) int() int {
+	return int(int32(d.uint()))
+}
+
+/* count reads a number of following items, each at least item_size bytes long */
+func (d *decoder) count(item_size int) int {
+	return d.fits(d.int(), item_size)
+}
+
+/* fits checks count items of at least item_size bytes can follow, so a corrupt count cannot
+   allocate more than the PDU holds */
+func (d *decoder) fits(count int, item_size int) int {
+	if(d.err == nil && (count < 0 || count * item_size > len(d.body) - d.offset)) {
+		d.err = errors.New(fmt.Sprintf("bad count %v in PDU", count))
+		return 0
+	}
+	return count
+}
+
+/* bytes reads length bytes and skips their padding */
+func (d *decoder) bytes(length int) []byte {
+	if(d.err != nil) {
+		return nil
+	}
+	padded := (length + 3) &^ 3
+	if(length < 0 || d.offset + padded > len(d.body)) {
+		d.err = errors.New("PDU too short")
+		return nil
+	}
+	data := d.body[d.offset:d.offset + length]
+	d.offset += padded
+	return data
+}
+
+func (d *decoder) string(length int) string {
+	return string(d.bytes(length))
+}
@@ -0,0 +1,292 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pdu
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"bytes"
+	"time"
+)
+
+/* transmit writes a PDU and reads it back, as the peer would see it */
+func transmit(t *testing.T, pdu PDU) PDU {
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, Write(buffer, pdu))
+	read, err := Read(buffer)
+	assert.NoError(t, err)
+	return read
+}
+
+func TestWrite_padsTheBodyAndWritesABigEndianHeader(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	Write(buffer, PDU{Type:TypeText, From:7, Body:[]byte{1, 2, 3, 4, 5}})
+
+	assert.Equal(t, []byte{
+		0, 0, 0, 20,
+		0, 0, 0x70, 0x09,
+		0, 0, 0, 7,
+		1, 2, 3, 4, 5, 0, 0, 0,
+	}, buffer.Bytes())
+}
+
+func TestRead_rejectsBadLengths(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte{0, 0, 0, 4, 0, 0, 0x70, 0, 0, 0, 0, 0}))
+
+	assert.EqualError(t, err, "bad PDU length 4")
+}
+
+func TestChallenge_packsTheVersionLicenseAndFeatures(t *testing.T) {
+	pdu := EncodeChallenge(Challenge{Version:Version, Licensed:1, Features:FeatureLabels})
+
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x02, 0x01, 0x02, 0x00}, pdu.Body)
+	challenge, err := DecodeChallenge(transmit(t, pdu))
+	assert.NoError(t, err)
+	assert.Equal(t, Challenge{Version:2, Licensed:1, Features:FeatureLabels}, challenge)
+}
+
+func TestDecodeChallenge_returnsTheErrorOfARefusal(t *testing.T) {
+	_, err := DecodeChallenge(PDU{Type:TypeError, Body:[]byte{0xff, 0xff, 0xcf, 0x9d, 0, 0, 0, 0}})
+
+	assert.Equal(t, Error{Code:ErrPermission}, err)
+}
+
+func TestDecoders_returnAnErrorPDUsCode(t *testing.T) {
+	_, err := DecodeDesc(EncodeError(ErrPmID))
+
+	assert.Equal(t, Error{Code:ErrPmID}, err)
+	assert.EqualError(t, err, "Unknown or illegal metric identifier")
+}
+
+func TestDecoders_rejectTheWrongPDUType(t *testing.T) {
+	_, err := DecodeDesc(EncodeText(1, "text"))
+
+	assert.EqualError(t, err, "expected a DESC PDU, got TEXT")
+}
+
+func TestDecoders_rejectTruncatedPDUs(t *testing.T) {
+	pdu := EncodeFetch(Fetch{PmIDs:[]uint32{1, 2, 3}})
+	pdu.Body = pdu.Body[:len(pdu.Body) - 4]
+
+	_, err := DecodeFetch(pdu)
+
+	assert.Error(t, err)
+}
+
+func TestCreds_roundTrip(t *testing.T) {
+	creds, err := DecodeCreds(transmit(t, EncodeCreds(Creds{Version:Version, Flags:FeatureSecure})))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Creds{Version:Version, Flags:FeatureSecure}, creds)
+}
+
+func TestProfile_roundTrip(t *testing.T) {
+	profile := Profile{Context:3, State:ProfileInclude, InDoms:[]InDomProfile{
+		{InDom:10, State:ProfileExclude, Instances:[]int{1, 2}},
+		{InDom:11, State:ProfileInclude, Instances:[]int{}},
+	}}
+
+	decoded, err := DecodeProfile(transmit(t, EncodeProfile(profile)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, profile, decoded)
+}
+
+func TestProfile_Includes(t *testing.T) {
+	profile := Profile{State:ProfileInclude, InDoms:[]InDomProfile{
+		{InDom:10, State:ProfileExclude, Instances:[]int{1}},
+		{InDom:11, State:ProfileInclude, Instances:[]int{1}},
+	}}
+
+	assert.True(t, profile.Includes(10, 1))
+	assert.False(t, profile.Includes(10, 2))
+	assert.False(t, profile.Includes(11, 1))
+	assert.True(t, profile.Includes(11, 2))
+	assert.True(t, profile.Includes(12, 1))
+}
+
+func TestFetch_roundTrip(t *testing.T) {
+	fetch, err := DecodeFetch(transmit(t, EncodeFetch(Fetch{Context:1, PmIDs:[]uint32{121634844, 121634847}})))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Fetch{Context:1, PmIDs:[]uint32{121634844, 121634847}}, fetch)
+}
+
+func TestDesc_packsSignedUnits(t *testing.T) {
+	desc := Desc{PmID:121634819, Type:TypeDouble, InDom:InDomNull, Sem:SemCounter,
+		Units:Units{DimSpace:1, DimTime:-1, ScaleSpace:2, ScaleTime:3, ScaleCount:-2}}
+
+	pdu := EncodeDesc(desc)
+
+	assert.Equal(t, []byte{0x1f, 0x02, 0x3e, 0x00}, pdu.Body[16:])
+	decoded, err := DecodeDesc(transmit(t, pdu))
+	assert.NoError(t, err)
+	assert.Equal(t, desc, decoded)
+}
+
+func TestInstances_roundTrip(t *testing.T) {
+	request, err := DecodeInstanceReq(transmit(t, EncodeInstanceReq(InstanceReq{InDom:121634817, Inst:InNull, Name:"green"})))
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceReq{InDom:121634817, Inst:InNull, Name:"green"}, request)
+
+	list := InstanceList{InDom:121634817, Instances:[]Instance{{Inst:0, Name:"red"}, {Inst:1, Name:""}, {Inst:2, Name:"blue"}}}
+	decoded, err := DecodeInstanceList(transmit(t, EncodeInstanceList(list)))
+	assert.NoError(t, err)
+	assert.Equal(t, list, decoded)
+}
+
+func TestText_roundTrip(t *testing.T) {
+	request, err := DecodeTextReq(transmit(t, EncodeTextReq(TextReq{Ident:5, Type:TextPmID | TextOneline})))
+	assert.NoError(t, err)
+	assert.Equal(t, TextReq{Ident:5, Type:TextPmID | TextOneline}, request)
+
+	text, err := DecodeText(transmit(t, EncodeText(5, "one line")))
+	assert.NoError(t, err)
+	assert.Equal(t, "one line", text)
+}
+
+func TestNames_roundTrip(t *testing.T) {
+	list := NameList{Names:[]string{"colour", "double"}, Statuses:[]int{LeafStatus, NonLeafStatus}}
+	decoded, err := DecodeNameList(transmit(t, EncodeNameList(list)))
+	assert.NoError(t, err)
+	assert.Equal(t, list, decoded)
+
+	decoded, err = DecodeNameList(transmit(t, EncodeNameList(NameList{Names:[]string{"sample.colour"}})))
+	assert.NoError(t, err)
+	assert.Equal(t, NameList{Names:[]string{"sample.colour"}}, decoded)
+
+	ids, err := DecodeIDList(transmit(t, EncodeIDList(IDList{Status:1, PmIDs:[]uint32{5, IDNull}})))
+	assert.NoError(t, err)
+	assert.Equal(t, IDList{Status:1, PmIDs:[]uint32{5, IDNull}}, ids)
+
+	request, err := DecodeNameReq(transmit(t, EncodeTraverseReq(NameReq{Name:"sample"})))
+	assert.NoError(t, err)
+	assert.Equal(t, NameReq{Name:"sample"}, request)
+	request, err = DecodeNameReq(transmit(t, EncodeChildReq(NameReq{Status:true, Name:"sample"})))
+	assert.NoError(t, err)
+	assert.Equal(t, NameReq{Status:true, Name:"sample"}, request)
+}
+
+func TestResult_roundTrip(t *testing.T) {
+	million, _ := NewValue(InNull, TypeDouble, 1000000)
+	hullo, _ := NewValue(InNull, TypeString, "hullo world!")
+	red, _ := NewValue(0, Type32, 101)
+	green, _ := NewValue(1, Type32, -5)
+	result := Result{Timestamp:time.Unix(1500000000, 250000000), ValueSets:[]ValueSet{
+		{PmID:1, NumVal:1, ValFmt:ValDptr, Values:[]Value{million}},
+		{PmID:2, NumVal:ErrPmID},
+		{PmID:3, NumVal:2, ValFmt:ValInsitu, Values:[]Value{red, green}},
+		{PmID:4, NumVal:1, ValFmt:ValDptr, Values:[]Value{hullo}},
+		{PmID:5, NumVal:0},
+	}}
+
+	decoded, err := DecodeResult(transmit(t, EncodeResult(result)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, result.Timestamp, decoded.Timestamp)
+	assert.Equal(t, ErrPmID, decoded.ValueSets[1].NumVal)
+	assert.Equal(t, 0, decoded.ValueSets[4].NumVal)
+	atom, _ := decoded.ValueSets[0].Values[0].Atom(TypeDouble, ValDptr)
+	assert.Equal(t, 1000000.0, atom)
+	atom, _ = decoded.ValueSets[2].Values[1].Atom(Type32, ValInsitu)
+	assert.Equal(t, int32(-5), atom)
+	assert.Equal(t, 1, decoded.ValueSets[2].Values[1].Inst)
+	atom, _ = decoded.ValueSets[3].Values[0].Atom(TypeString, ValDptr)
+	assert.Equal(t, "hullo world!", atom)
+}
+
+func TestResult_pointsValueListsAtTrailingBlocks(t *testing.T) {
+	value, _ := NewValue(InNull, TypeU64, uint64(0x0102030405060708))
+
+	pdu := EncodeResult(Result{Timestamp:time.Unix(0, 0), ValueSets:[]ValueSet{{PmID:1, ValFmt:ValDptr, Values:[]Value{value}}}})
+
+	/* header, timestamp and count, then pmid, numval, valfmt, inst and an offset of 11 words */
+	assert.Equal(t, []byte{0, 0, 0, 11}, pdu.Body[28:32])
+	assert.Equal(t, []byte{TypeU64, 0, 0, 12, 1, 2, 3, 4, 5, 6, 7, 8}, pdu.Body[32:])
+}
+
+func TestHighResResult_keepsNanosecondsAndLaterSeconds(t *testing.T) {
+	value, _ := NewValue(InNull, TypeU64, uint64(7))
+	result := Result{Timestamp:time.Unix(1 << 33, 123456789), ValueSets:[]ValueSet{{PmID:1, NumVal:1, ValFmt:ValDptr, Values:[]Value{value}}}}
+
+	pdu := EncodeHighResResult(result)
+	decoded, err := DecodeHighResResult(transmit(t, pdu))
+
+	/* header, 64 bit seconds, nanoseconds and count, then pmid, numval, valfmt, inst and an offset
+	   of 12 words */
+	assert.Equal(t, []byte{0, 0, 0, 12}, pdu.Body[32:36])
+	assert.NoError(t, err)
+	assert.Equal(t, result.Timestamp, decoded.Timestamp)
+	atom, _ := decoded.ValueSets[0].Values[0].Atom(TypeU64, ValDptr)
+	assert.Equal(t, uint64(7), atom)
+}
+
+func TestValue_Atom_checksTheBlockType(t *testing.T) {
+	value, _ := NewValue(InNull, TypeString, "text")
+
+	_, err := value.Atom(Type64, ValDptr)
+
+	assert.EqualError(t, err, "value block of type 6 for a metric of type 2")
+}
+
+func TestNewValue_rejectsValuesOfTheWrongKind(t *testing.T) {
+	_, err := NewValue(InNull, TypeString, 5)
+	assert.EqualError(t, err, "5 is not a string")
+
+	_, err = NewValue(InNull, TypeDouble, "five")
+	assert.EqualError(t, err, "five is not a number")
+}
+
+func TestLabels_roundTrip(t *testing.T) {
+	list := LabelList{Ident:InDomNull, Type:LabelInstances, Sets:[]LabelSet{
+		{Inst:0, Labels:map[string]string{"colour":"red", "rgb":"[255,0,0]"}},
+		{Inst:1, Labels:map[string]string{}},
+	}}
+
+	decoded, err := DecodeLabelList(transmit(t, EncodeLabelList(list)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, list, decoded)
+
+	request, err := DecodeLabelReq(transmit(t, EncodeLabelReq(LabelReq{Ident:29, Type:LabelDomain})))
+	assert.NoError(t, err)
+	assert.Equal(t, LabelReq{Ident:29, Type:LabelDomain}, request)
+}
+
+func TestLabelJSON_indexesNamesAndValues(t *testing.T) {
+	text, indexes := labelJSON(map[string]string{"agent":"sample", "role":"testing", "cpus":"4"})
+
+	assert.Equal(t, `{"agent":"sample","cpus":4,"role":"testing"}`, text)
+	assert.Equal(t, "agent", text[indexes[0].name:indexes[0].name + indexes[0].name_length])
+	assert.Equal(t, "4", text[indexes[1].value:indexes[1].value + indexes[1].value_length])
+	assert.Equal(t, `"testing"`, text[indexes[2].value:indexes[2].value + indexes[2].value_length])
+}
+
+func TestErrorCode_and_ErrorName(t *testing.T) {
+	code, found := ErrorCode("PM_ERR_NAME")
+
+	assert.True(t, found)
+	assert.Equal(t, ErrName, code)
+	assert.Equal(t, "PM_ERR_NAME", ErrorName(ErrName))
+	assert.Equal(t, "-5", ErrorName(-5))
+	assert.EqualError(t, Error{Code:-5}, "Unknown error code -5")
+}
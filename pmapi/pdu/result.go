@@ -0,0 +1,277 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pdu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+/* Metric types, semantics and value formats, as in pmapi.h */
+const (
+	Type32 = 0
+	TypeU32 = 1
+	Type64 = 2
+	TypeU64 = 3
+	TypeFloat = 4
+	TypeDouble = 5
+	TypeString = 6
+
+	SemCounter = 1
+	SemInstant = 3
+	SemDiscrete = 4
+
+	ValInsitu = 0
+	ValDptr = 1
+)
+
+const (
+	/* InNull is the instance of a metric without an instance domain */
+	InNull = -1
+	InDomNull = 0xffffffff
+)
+
+/* Result answers a fetch with one value set per PMID asked for */
+type Result struct {
+	Timestamp time.Time
+	ValueSets []ValueSet
+}
+
+/* ValueSet holds the values of one metric. A negative NumVal is the error fetching it, otherwise
+   NumVal is ignored when encoding and the number of Values used */
+type ValueSet struct {
+	PmID uint32
+	NumVal int
+	ValFmt int
+	Values []Value
+}
+
+/* Value is one instance's value. 32 bit integers are held in Insitu, everything else in Data as
+   the big endian body of a value block of type Type */
+type Value struct {
+	Inst int
+	Insitu uint32
+	Type int
+	Data []byte
+}
+
+/* ValueFormat gives how values of a metric type are sent */
+func ValueFormat(pm_type int) int {
+	if(pm_type == Type32 || pm_type == TypeU32) {
+		return ValInsitu
+	}
+	return ValDptr
+}
+
+/* NewValue encodes value, any Go integer, floating point number or string, as pm_type */
+func NewValue(inst int, pm_type int, value interface{}) (Value, error) {
+	reflected := reflect.ValueOf(value)
+	kind := reflected.Kind()
+	is_int := kind >= reflect.Int && kind <= reflect.Int64
+	is_uint := kind >= reflect.Uint && kind <= reflect.Uintptr
+	is_float := kind == reflect.Float32 || kind == reflect.Float64
+	if(pm_type == TypeString) {
+		if(kind != reflect.String) {
+			return Value{}, errors.New(fmt.Sprintf("%v is not a string", value))
+		}
+		return Value{Inst:inst, Type:pm_type, Data:append([]byte(reflected.String()), 0)}, nil
+	}
+	if(!is_int && !is_uint && !is_float) {
+		return Value{}, errors.New(fmt.Sprintf("%v is not a number", value))
+	}
+	var integer uint64
+	var float float64
+	switch {
+	case is_int:
+		integer, float = uint64(reflected.Int()), float64(reflected.Int())
+	case is_uint:
+		integer, float = reflected.Uint(), float64(reflected.Uint())
+	default:
+		integer, float = uint64(int64(reflected.Float())), reflected.Float()
+	}
+
+	switch pm_type {
+	case Type32, TypeU32:
+		return Value{Inst:inst, Insitu:uint32(integer)}, nil
+	case Type64, TypeU64:
+		return Value{Inst:inst, Type:pm_type, Data:binary.BigEndian.AppendUint64(nil, integer)}, nil
+	case TypeFloat:
+		return Value{Inst:inst, Type:pm_type, Data:binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(float)))}, nil
+	case TypeDouble:
+		return Value{Inst:inst, Type:pm_type, Data:binary.BigEndian.AppendUint64(nil, math.Float64bits(float))}, nil
+	}
+	return Value{}, errors.New(fmt.Sprintf("unsupported metric type %v", pm_type))
+}
+
+/* Atom decodes a value of a metric of type pm_type as an int32, uint32, int64, uint64, float32,
+   float64 or string */
+func (v Value) Atom(pm_type int, value_format int) (interface{}, error) {
+	if(value_format == ValInsitu) {
+		switch pm_type {
+		case Type32:
+			return int32(v.Insitu), nil
+		case TypeU32:
+			return v.Insitu, nil
+		}
+		return nil, errors.New(fmt.Sprintf("metric type %v cannot be in situ", pm_type))
+	}
+	if(v.Type != pm_type) {
+		return nil, errors.New(fmt.Sprintf("value block of type %v for a metric of type %v", v.Type, pm_type))
+	}
+	size := map[int]int{Type64:8, TypeU64:8, TypeFloat:4, TypeDouble:8}[pm_type]
+	if(pm_type != TypeString && len(v.Data) < size) {
+		return nil, errors.New(fmt.Sprintf("value block of %v bytes is too short", len(v.Data)))
+	}
+	switch pm_type {
+	case Type64:
+		return int64(binary.BigEndian.Uint64(v.Data)), nil
+	case TypeU64:
+		return binary.BigEndian.Uint64(v.Data), nil
+	case TypeFloat:
+		return math.Float32frombits(binary.BigEndian.Uint32(v.Data)), nil
+	case TypeDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(v.Data)), nil
+	case TypeString:
+		data := v.Data
+		for len(data) > 0 && data[len(data) - 1] == 0 {
+			data = data[:len(data) - 1]
+		}
+		return string(data), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported metric type %v", pm_type))
+}
+
+/* EncodeResult lays the value sets out as libpcp does: the value lists first, then the value
+   blocks they point to, with offsets in words from the start of the PDU */
+func EncodeResult(result Result) PDU {
+	return encodeResult(result, TypeResult)
+}
+
+/* EncodeHighResResult is EncodeResult with a nanosecond timestamp of 64 bit seconds, as PCP 6
+   sends results and version 3 archives hold them */
+func EncodeHighResResult(result Result) PDU {
+	return encodeResult(result, TypeHighResResult)
+}
+
+func encodeResult(result Result, pdu_type int) PDU {
+	timestamp := &encoder{}
+	if(pdu_type == TypeHighResResult) {
+		timestamp.uint(uint32(uint64(result.Timestamp.Unix()) >> 32))
+		timestamp.uint(uint32(result.Timestamp.Unix()))
+		timestamp.int(result.Timestamp.Nanosecond())
+	} else {
+		timestamp.int(int(result.Timestamp.Unix()))
+		timestamp.int(result.Timestamp.Nanosecond() / 1000)
+	}
+	list_words := len(timestamp.buffer) / 4 + 1
+	for _, value_set := range result.ValueSets {
+		list_words += 2
+		if(value_set.NumVal >= 0 && len(value_set.Values) > 0) {
+			list_words += 1 + 2 * len(value_set.Values)
+		}
+	}
+	block_offset := HeaderSize / 4 + list_words
+
+	e, blocks := &encoder{buffer:timestamp.buffer}, &encoder{}
+	e.int(len(result.ValueSets))
+	for _, value_set := range result.ValueSets {
+		e.uint(value_set.PmID)
+		if(value_set.NumVal < 0 || len(value_set.Values) == 0) {
+			e.int(min(value_set.NumVal, 0))
+			continue
+		}
+		e.int(len(value_set.Values))
+		e.int(value_set.ValFmt)
+		for _, value := range value_set.Values {
+			e.int(value.Inst)
+			if(value_set.ValFmt == ValInsitu) {
+				e.uint(value.Insitu)
+				continue
+			}
+			e.int(block_offset + len(blocks.buffer) / 4)
+			blocks.uint(uint32(value.Type & 0xff) << 24 | uint32(4 + len(value.Data)) & 0xffffff)
+			blocks.bytes(value.Data)
+		}
+	}
+	e.buffer = append(e.buffer, blocks.buffer...)
+	return e.pdu(pdu_type, FromAnon)
+}
+
+func DecodeResult(pdu PDU) (Result, error) {
+	d := newDecoder(pdu, TypeResult)
+	seconds, microseconds := d.int(), d.int()
+	return decodeValueSets(d, time.Unix(int64(seconds), int64(microseconds) * 1000))
+}
+
+func DecodeHighResResult(pdu PDU) (Result, error) {
+	d := newDecoder(pdu, TypeHighResResult)
+	high, low, nanoseconds := d.uint(), d.uint(), d.int()
+	return decodeValueSets(d, time.Unix(int64(uint64(high) << 32 | uint64(low)), int64(nanoseconds)))
+}
+
+/* decodeValueSets reads what follows the timestamp of a result */
+func decodeValueSets(d *decoder, timestamp time.Time) (Result, error) {
+	result := Result{Timestamp:timestamp}
+	result.ValueSets = make([]ValueSet, d.count(8))
+	for i := range result.ValueSets {
+		value_set := &result.ValueSets[i]
+		value_set.PmID = d.uint()
+		value_set.NumVal = d.int()
+		if(value_set.NumVal <= 0) {
+			continue
+		}
+		value_set.ValFmt = d.int()
+		value_set.Values = make([]Value, d.fits(value_set.NumVal, 8))
+		for j := range value_set.Values {
+			value := &value_set.Values[j]
+			value.Inst = d.int()
+			if(value_set.ValFmt == ValInsitu) {
+				value.Insitu = d.uint()
+				continue
+			}
+			value.Type, value.Data = d.block(d.int())
+		}
+	}
+	return result, d.err
+}
+
+/* block reads the value block offset words from the start of the PDU */
+func (d *decoder) block(offset int) (int, []byte) {
+	start := offset * 4 - HeaderSize
+	if(d.err != nil) {
+		return 0, nil
+	}
+	if(start < 0 || start + 4 > len(d.body)) {
+		d.err = errors.New(fmt.Sprintf("bad value block offset %v", offset))
+		return 0, nil
+	}
+	header := binary.BigEndian.Uint32(d.body[start:])
+	length := int(header & 0xffffff)
+	if(length < 4 || start + length > len(d.body)) {
+		d.err = errors.New(fmt.Sprintf("bad value block length %v", length))
+		return 0, nil
+	}
+	return int(header >> 24), d.body[start + 4:start + length]
+}
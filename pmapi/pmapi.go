@@ -18,6 +18,8 @@
 //OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 //SOFTWARE.
 
+//go:build cgo && !purego
+
 package pmapi
 // #cgo LDFLAGS: -lpcp
 // #include <pcp/pmapi.h>
@@ -104,6 +106,22 @@ void freePmValueBlockFromPmAtomValue(pmAtomValue atom) {
 	free(atom.vbp);
 }
 
+// cgo cannot read the jsonlen bitfield of pmLabelSet either
+pmLabelSet* getPmLabelSet(int index, pmLabelSet *sets) {
+	return &sets[index];
+}
+int getPmLabelSetJsonLen(pmLabelSet *set) {
+	return set->jsonlen;
+}
+
+// Saves building a struct timeval from Go, whose field types vary by platform
+int pmSetModeAt(int mode, long sec, long usec, int delta) {
+	struct timeval when;
+	when.tv_sec = sec;
+	when.tv_usec = usec;
+	return pmSetMode(mode, &when, delta);
+}
+
 */
 import "C"
 import (
@@ -113,115 +131,10 @@ import (
 	"time"
 )
 
-type PMAPI interface {
-	PmLookupName(names ...string) ([]PmID, error)
-	PmFetch(pmids ...PmID) (*PmResult, error)
-	PmLookupDesc(pmid PmID) (PmDesc, error)
-	PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error)
-	PmGetInDom(indom PmInDom) (map[int]string, error)
-}
-
 type PmapiContext struct {
 	context int
 }
 
-type PmDesc struct {
-	PmID PmID
-	Type int
-	InDom PmInDom
-	Sem int
-	Units PmUnits
-}
-
-type PmUnits struct {
-	DimSpace int
-	DimTime int
-	DimCount int
-	ScaleSpace uint
-	ScaleTime uint
-	ScaleCount int
-}
-
-type PmResult struct {
-	Timestamp time.Time
-	NumPmID	int
-	VSet []*PmValueSet
-}
-
-type PmValueSet struct {
-	PmID	PmID
-	NumVal	int
-	ValFmt	int
-	VList	[]*PmValue
-}
-
-type PmValue struct {
-	Inst int
-	cPmValue C.pmValue
-	valfmt C.int
-}
-
-type PmAtomValue struct {
-	Int32 int32
-	UInt32 uint32
-	Int64 int64
-	UInt64 uint64
-	Float float32
-	Double float64
-	String string
-}
-
-type PmContextType int
-type PmID uint32
-type PmInDom uint32
-
-
-const (
-	PmContextHost = PmContextType(int(C.PM_CONTEXT_HOST))
-	PmContextArchive = PmContextType(int(C.PM_CONTEXT_ARCHIVE))
-	PmContextLocal = PmContextType(int(C.PM_CONTEXT_LOCAL))
-	PmContextUndef = PmContextType(int(C.PM_CONTEXT_UNDEF))
-	PmInDomNull = PmInDom(C.PM_INDOM_NULL)
-	PmInNull = int(C.PM_IN_NULL)
-
-	PmSpaceByte = uint(C.PM_SPACE_BYTE)
-	PmSpaceKByte = uint(C.PM_SPACE_KBYTE)
-	PmSpaceMByte = uint(C.PM_SPACE_MBYTE)
-	PmSpaceGByte = uint(C.PM_SPACE_GBYTE)
-	PmSpaceTByte = uint(C.PM_SPACE_TBYTE)
-	PmSpacePByte = uint(C.PM_SPACE_PBYTE)
-	PmSpaceEByte = uint(C.PM_SPACE_EBYTE)
-
-	PmTimeNSec = uint(C.PM_TIME_NSEC)
-	PmTimeUSec = uint(C.PM_TIME_USEC)
-	PmTimeMSec = uint(C.PM_TIME_MSEC)
-	PmTimeSec = uint(C.PM_TIME_SEC)
-	PmTimeMin = uint(C.PM_TIME_MIN)
-	PmTimeHour = uint(C.PM_TIME_HOUR)
-
-	PmTypeNoSupport = int(C.PM_TYPE_NOSUPPORT)
-	PmType32 = int(C.PM_TYPE_32)
-	PmTypeU32 = int(C.PM_TYPE_U32)
-	PmType64 = int(C.PM_TYPE_64)
-	PmTypeU64 = int(C.PM_TYPE_U64)
-	PmTypeFloat = int(C.PM_TYPE_FLOAT)
-	PmTypeDouble = int(C.PM_TYPE_DOUBLE)
-	PmTypeString = int(C.PM_TYPE_STRING)
-	PmTypeAggregate= int(C.PM_TYPE_AGGREGATE)
-	PmTypeAggregateStatic = int(C.PM_TYPE_AGGREGATE_STATIC)
-	PmTypeEvent = int(C.PM_TYPE_EVENT)
-	PmTypeHighResEvent = int(C.PM_TYPE_HIGHRES_EVENT)
-	PmTypeUnknown = int(C.PM_TYPE_UNKNOWN)
-
-	PmSemCounter = int(C.PM_SEM_COUNTER)
-	PmSemInstant = int(C.PM_SEM_INSTANT)
-	PmSemDiscrete = int(C.PM_SEM_DISCRETE)
-
-	PmValInsitu = int(C.PM_VAL_INSITU)
-	PmValDptr = int(C.PM_VAL_DPTR)
-	PmValSptr = int(C.PM_VAL_SPTR)
-)
-
 func PmNewContext(context_type PmContextType, host_or_archive string) (*PmapiContext, error) {
 	host_or_archive_ptr := C.CString(host_or_archive)
 	defer C.free(unsafe.Pointer(host_or_archive_ptr))
@@ -235,13 +148,26 @@ func PmNewContext(context_type PmContextType, host_or_archive string) (*PmapiCon
 		context: context_id,
 	}
 
-	runtime.SetFinalizer(context, func(c *PmapiContext) {
-		C.pmDestroyContext(C.int(c.context))
-	})
+	runtime.SetFinalizer(context, (*PmapiContext).Close)
 
 	return context, nil
 }
 
+/* Close destroys the context, as the garbage collector otherwise does. It cannot be used
+   afterwards */
+func (c *PmapiContext) Close() error {
+	runtime.SetFinalizer(c, nil)
+	if(c.context < 0) {
+		return nil
+	}
+	err := int(C.pmDestroyContext(C.int(c.context)))
+	c.context = -1
+	if(err < 0) {
+		return newPmError(err)
+	}
+	return nil
+}
+
 func (c *PmapiContext) PmGetContextHostname() (string, error) {
 	err := c.pmUseContext()
 	if(err != nil) {
@@ -255,6 +181,55 @@ func (c *PmapiContext) PmGetContextHostname() (string, error) {
 	return C.GoString(raw_char_ptr), nil
 }
 
+/* PmSetMode positions an archive context at when. In PmModeInterp each fetch then returns values
+   interpolated delta after the last, with delta rounded to milliseconds */
+func (c *PmapiContext) PmSetMode(mode int, when time.Time, delta time.Duration) error {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return context_err
+	}
+
+	err := int(C.pmSetModeAt(C.int(mode), C.long(when.Unix()), C.long(when.Nanosecond() / 1000), C.int(delta / time.Millisecond)))
+	if(err < 0) {
+		return newPmError(err)
+	}
+	return nil
+}
+
+func (c *PmapiContext) PmGetArchiveLabel() (PmLogLabel, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return PmLogLabel{}, context_err
+	}
+
+	var c_log_label C.pmLogLabel
+	err := int(C.pmGetArchiveLabel(&c_log_label))
+	if(err < 0) {
+		return PmLogLabel{}, newPmError(err)
+	}
+
+	return PmLogLabel{
+		Hostname:C.GoString(&c_log_label.ll_hostname[0]),
+		Start:time.Unix(int64(c_log_label.ll_start.tv_sec), int64(c_log_label.ll_start.tv_usec) * 1000),
+	}, nil
+}
+
+/* PmGetArchiveEnd returns the time of the archive's last record */
+func (c *PmapiContext) PmGetArchiveEnd() (time.Time, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return time.Time{}, context_err
+	}
+
+	var c_end C.struct_timeval
+	err := int(C.pmGetArchiveEnd(&c_end))
+	if(err < 0) {
+		return time.Time{}, newPmError(err)
+	}
+
+	return time.Unix(int64(c_end.tv_sec), int64(c_end.tv_usec) * 1000), nil
+}
+
 func (c *PmapiContext) PmLookupName(names ...string) ([]PmID, error) {
 	context_err := c.pmUseContext()
 	if(context_err != nil) {
@@ -346,6 +321,156 @@ func (c *PmapiContext) PmGetInDom(indom PmInDom) (map[int]string, error) {
 	return indom_map, nil
 }
 
+/* PmGetChildrenStatus returns the names one level below name in the PMNS, each mapped to whether
+   it is a leaf (a metric). An empty name is the root of the PMNS and a leaf has no children */
+func (c *PmapiContext) PmGetChildrenStatus(name string) (map[string]bool, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return nil, context_err
+	}
+
+	name_ptr := C.CString(name)
+	defer C.free(unsafe.Pointer(name_ptr))
+	var c_children **C.char
+	var c_statuses *C.int
+
+	err_or_number_of_children := int(C.pmGetChildrenStatus(name_ptr, &c_children, &c_statuses))
+	if(err_or_number_of_children < 0) {
+		return nil, newPmError(err_or_number_of_children)
+	}
+	children := make(map[string]bool)
+	if(err_or_number_of_children == 0) {
+		return children, nil
+	}
+	defer C.free(unsafe.Pointer(c_children))
+	defer C.free(unsafe.Pointer(c_statuses))
+
+	c_children_slice := (*[1 << 30]*C.char)(unsafe.Pointer(c_children))
+	c_statuses_slice := (*[1 << 30]C.int)(unsafe.Pointer(c_statuses))
+	for i := 0; i < err_or_number_of_children; i++ {
+		children[C.GoString(c_children_slice[i])] = c_statuses_slice[i] == C.PMNS_LEAF_STATUS
+	}
+
+	return children, nil
+}
+
+func (c *PmapiContext) PmLookupInDom(indom PmInDom, name string) (int, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return 0, context_err
+	}
+
+	name_ptr := C.CString(name)
+	defer C.free(unsafe.Pointer(name_ptr))
+
+	err_or_instance := int(C.pmLookupInDom(C.pmInDom(indom), name_ptr))
+	if(err_or_instance < 0) {
+		return 0, newPmError(err_or_instance)
+	}
+	return err_or_instance, nil
+}
+
+func (c *PmapiContext) PmNameInDom(indom PmInDom, instance int) (string, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return "", context_err
+	}
+
+	var c_name *C.char
+	err := int(C.pmNameInDom(C.pmInDom(indom), C.int(instance), &c_name))
+	if(err < 0) {
+		return "", newPmError(err)
+	}
+	defer C.free(unsafe.Pointer(c_name))
+
+	return C.GoString(c_name), nil
+}
+
+/* PmAddProfile adds instances to the context's fetch profile for indom. With no instances,
+   every instance of indom is included */
+func (c *PmapiContext) PmAddProfile(indom PmInDom, instances ...int) error {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return context_err
+	}
+
+	c_instances := cInstanceList(instances)
+	err := int(C.pmAddProfile(C.pmInDom(indom), C.int(len(instances)), c_instances))
+	if(err < 0) {
+		return newPmError(err)
+	}
+	return nil
+}
+
+/* PmDelProfile removes instances from the context's fetch profile for indom. With no instances,
+   every instance of indom is excluded */
+func (c *PmapiContext) PmDelProfile(indom PmInDom, instances ...int) error {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return context_err
+	}
+
+	c_instances := cInstanceList(instances)
+	err := int(C.pmDelProfile(C.pmInDom(indom), C.int(len(instances)), c_instances))
+	if(err < 0) {
+		return newPmError(err)
+	}
+	return nil
+}
+
+func cInstanceList(instances []int) *C.int {
+	if(len(instances) == 0) {
+		return nil
+	}
+	c_instances := make([]C.int, len(instances))
+	for i, instance := range instances {
+		c_instances[i] = C.int(instance)
+	}
+	return &c_instances[0]
+}
+
+func (c *PmapiContext) PmLookupLabels(pmid PmID) ([]PmLabelSet, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return nil, context_err
+	}
+
+	var c_label_sets *C.pmLabelSet
+	err_or_number_of_sets := int(C.pmLookupLabels(C.pmID(pmid), &c_label_sets))
+	if(err_or_number_of_sets < 0) {
+		return nil, newPmError(err_or_number_of_sets)
+	}
+	defer C.pmFreeLabelSets(c_label_sets, C.int(err_or_number_of_sets))
+
+	label_sets := make([]PmLabelSet, err_or_number_of_sets)
+	for i := 0; i < err_or_number_of_sets; i++ {
+		c_label_set := C.getPmLabelSet(C.int(i), c_label_sets)
+		labels, err := labelsFromJSON(C.GoStringN(c_label_set.json, C.getPmLabelSetJsonLen(c_label_set)))
+		if(err != nil) {
+			return nil, err
+		}
+		label_sets[i] = PmLabelSet{Inst:int(int32(c_label_set.inst)), Labels:labels}
+	}
+	return label_sets, nil
+}
+
+/* PmLookupText returns the PmTextOneline or PmTextHelp text of a metric */
+func (c *PmapiContext) PmLookupText(pmid PmID, level int) (string, error) {
+	context_err := c.pmUseContext()
+	if(context_err != nil) {
+		return "", context_err
+	}
+
+	var c_text *C.char
+	err := int(C.pmLookupText(C.pmID(pmid), C.int(level), &c_text))
+	if(err < 0) {
+		return "", newPmError(err)
+	}
+	defer C.free(unsafe.Pointer(c_text))
+
+	return C.GoString(c_text), nil
+}
+
 func (c *PmapiContext) PmFetch(pmids ...PmID) (*PmResult, error) {
 	context_err := c.pmUseContext()
 	if(context_err != nil) {
@@ -414,25 +539,30 @@ func vlistFromPmValueSet(c_vset *C.pmValueSet) []*PmValue {
 func newPmValue(index int, c_vset *C.pmValueSet) *PmValue {
 	/* See comment in PmFetch() for an explanation */
 	c_pm_value := C.getDuplicatedPmValueFromPmValueSet(C.int(index), c_vset)
-	pm_value := &PmValue{
-		Inst:int(c_pm_value.inst),
-		cPmValue:c_pm_value,
+	value := &cPmValue{
+		pm_value:c_pm_value,
 		valfmt:c_vset.valfmt,
 	}
-	runtime.SetFinalizer(pm_value, func(pm_value *PmValue){
-		C.freePmValue(pm_value.cPmValue, pm_value.valfmt)
+	runtime.SetFinalizer(value, func(value *cPmValue){
+		C.freePmValue(value.pm_value, value.valfmt)
 	})
-	return pm_value
+	return &PmValue{Inst:int(c_pm_value.inst), value:value}
+}
+
+/* cPmValue is a pmValue copied out of a pmResult, decoded by libpcp */
+type cPmValue struct {
+	pm_value C.pmValue
+	valfmt C.int
 }
 
 func (c *PmapiContext) PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error) {
 	return PmExtractValue(value_format, pm_type, pm_value)
 }
 
-func PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error) {
+func (v *cPmValue) extract(value_format int, pm_type int) (PmAtomValue, error) {
 	var c_pm_atom_value C.pmAtomValue
 
-	err := int(C.pmExtractValue(C.int(value_format), &pm_value.cPmValue, C.int(pm_type), &c_pm_atom_value, C.int(pm_type)))
+	err := int(C.pmExtractValue(C.int(value_format), &v.pm_value, C.int(pm_type), &c_pm_atom_value, C.int(pm_type)))
 	if(err < 0) {
 		return PmAtomValue{}, newPmError(err)
 	}
@@ -472,7 +602,7 @@ func (c *PmapiContext) pmUseContext() error {
 }
 
 func newPmError(err int) error {
-	return errors.New(pmErrStr(err))
+	return PmError{Code:err, Message:pmErrStr(err)}
 }
 
 func pmErrStr(error_no int) string {
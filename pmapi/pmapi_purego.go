@@ -0,0 +1,74 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+//go:build !cgo || purego
+
+package pmapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+/* Built without libpcp, a context to a host is a WireContext and one to an archive an
+   ArchiveContext. Local contexts cannot be opened */
+type PmapiContext struct {
+	pureGoContext
+	context int
+}
+
+/* pureGoContext is what both WireContext and ArchiveContext offer */
+type pureGoContext interface {
+	PMAPI
+	PmGetContextHostname() (string, error)
+	PmSetMode(mode int, when time.Time, delta time.Duration) error
+	PmGetArchiveLabel() (PmLogLabel, error)
+	PmGetArchiveEnd() (time.Time, error)
+	Close() error
+}
+
+/* The number given to the next context, counting from 0 as libpcp does */
+var next_context int32
+
+func PmNewContext(context_type PmContextType, host_or_archive string) (*PmapiContext, error) {
+	var opened pureGoContext
+	var err error
+	switch context_type {
+	case PmContextHost:
+		opened, err = NewWireContext(host_or_archive)
+	case PmContextArchive:
+		opened, err = NewArchiveContext(host_or_archive)
+	default:
+		return nil, PmError{Code:pdu.ErrNYI, Message:"Local contexts are not available without libpcp"}
+	}
+	if(err != nil) {
+		return nil, err
+	}
+	context := &PmapiContext{pureGoContext:opened, context:int(atomic.AddInt32(&next_context, 1) - 1)}
+	/* Like libpcp contexts, those dropped without being closed are closed when collected */
+	runtime.SetFinalizer(context, (*PmapiContext).Close)
+	return context, nil
+}
+
+func (c *PmapiContext) GetContextId() int {
+	return c.context
+}
@@ -0,0 +1,56 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+//go:build !cgo || purego
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmcdtest"
+	"runtime"
+	"time"
+)
+
+/* connected reports whether a WireContext still has its connection to pmcd */
+func connected(wire *WireContext) bool {
+	wire.lock.Lock()
+	defer wire.lock.Unlock()
+	return wire.connection != nil
+}
+
+func TestPmNewContext_closesAContextDroppedWithoutClosing(t *testing.T) {
+	server := pmcdtest.Sample()
+	address, err := server.Start("localhost:0")
+	assert.NoError(t, err)
+	defer server.Close()
+	context, err := PmNewContext(PmContextHost, address)
+	assert.NoError(t, err)
+	wire := context.pureGoContext.(*WireContext)
+
+	context = nil
+	for i := 0; i < 100 && connected(wire); i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.False(t, connected(wire))
+}
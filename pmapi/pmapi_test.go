@@ -30,6 +30,7 @@ var sampleDoubleMillionPmID PmID = 121634844
 var sampleMillisecondsPmID PmID = 121634819
 var sampleColourInDom PmInDom = 121634817
 var sampleStringHulloPmID PmID = 121634847
+var sampleColourPmID PmID = 121634821
 
 func TestPmapiContext_PmGetContextHostname(t *testing.T) {
 	c, _ := PmNewContext(PmContextHost, "localhost")
@@ -114,6 +115,114 @@ func TestPmapiContext_PmGetInDom_ReturnsAnErrorForIncorrectInDoms(t *testing.T)
 	assert.Error(t, err)
 }
 
+func TestPmapiContext_PmLookupInDom_ReturnsTheInstanceForAName(t *testing.T) {
+	instance, _ := localContext().PmLookupInDom(sampleColourInDom, "green")
+
+	assert.Equal(t, 1, instance)
+}
+
+func TestPmapiContext_PmLookupInDom_ReturnsAnErrorForUnknownNames(t *testing.T) {
+	_, err := localContext().PmLookupInDom(sampleColourInDom, "purple")
+
+	assert.Error(t, err)
+}
+
+func TestPmapiContext_PmNameInDom_ReturnsTheNameForAnInstance(t *testing.T) {
+	name, _ := localContext().PmNameInDom(sampleColourInDom, 2)
+
+	assert.Equal(t, "blue", name)
+}
+
+func TestPmapiContext_PmDelProfile_ExcludesInstancesFromFetches(t *testing.T) {
+	c := localContext()
+	c.PmDelProfile(sampleColourInDom)
+	c.PmAddProfile(sampleColourInDom, 1)
+
+	pm_result, _ := c.PmFetch(sampleColourPmID)
+
+	assert.Len(t, pm_result.VSet[0].VList, 1)
+	assert.Equal(t, 1, pm_result.VSet[0].VList[0].Inst)
+}
+
+func TestPmapiContext_PmAddProfile_WithNoInstancesIncludesTheWholeInDom(t *testing.T) {
+	c := localContext()
+	c.PmDelProfile(sampleColourInDom)
+	c.PmAddProfile(sampleColourInDom)
+
+	pm_result, _ := c.PmFetch(sampleColourPmID)
+
+	assert.Len(t, pm_result.VSet[0].VList, 3)
+}
+
+func TestPmapiContext_PmLookupLabels_IncludesTheDomainLabels(t *testing.T) {
+	label_sets, _ := localContext().PmLookupLabels(sampleDoubleMillionPmID)
+
+	found := false
+	for _, label_set := range label_sets {
+		if(label_set.Labels["agent"] == "sample") {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestPmapiContext_PmLookupLabels_ReturnsInstanceLabelSets(t *testing.T) {
+	label_sets, _ := localContext().PmLookupLabels(sampleColourPmID)
+
+	instances := []int{}
+	for _, label_set := range label_sets {
+		if(label_set.Inst != PmInNull) {
+			instances = append(instances, label_set.Inst)
+		}
+	}
+	assert.Len(t, instances, 3)
+}
+
+func TestPmapiContext_PmLookupText_ReturnsTheOnelineText(t *testing.T) {
+	text, _ := localContext().PmLookupText(sampleDoubleMillionPmID, PmTextOneline)
+
+	assert.Equal(t, "1000000.0 as a 64-bit floating point value", text)
+}
+
+func TestPmapiContext_PmLookupName_ReturnsAPmErrorWithTheErrorCode(t *testing.T) {
+	_, err := localContext().PmLookupName("not.a.name")
+
+	assert.Equal(t, PmErrName, err.(PmError).Code)
+}
+
+func TestPmapiContext_PmGetChildrenStatus_ReturnsLeavesAndNonLeaves(t *testing.T) {
+	children, err := localContext().PmGetChildrenStatus("sample")
+
+	assert.NoError(t, err)
+	assert.Equal(t, true, children["colour"])
+	assert.Equal(t, false, children["double"])
+}
+
+func TestPmapiContext_PmGetChildrenStatus_ReturnsNothingForALeaf(t *testing.T) {
+	children, err := localContext().PmGetChildrenStatus("sample.colour")
+
+	assert.NoError(t, err)
+	assert.Empty(t, children)
+}
+
+func TestPmapiContext_PmSetMode_ReturnsAnErrorForALiveContext(t *testing.T) {
+	err := localContext().PmSetMode(PmModeInterp, time.Now(), time.Second)
+
+	assert.Error(t, err)
+}
+
+func TestPmapiContext_PmGetArchiveLabel_ReturnsAnErrorForALiveContext(t *testing.T) {
+	_, err := localContext().PmGetArchiveLabel()
+
+	assert.Error(t, err)
+}
+
+func TestPmNewContext_withAMissingArchiveHasAnError(t *testing.T) {
+	_, err := PmNewContext(PmContextArchive, "/does/not/exist")
+
+	assert.Error(t, err)
+}
+
 func TestPmapiContext_PmFetch_returnsAPmResultWithATimestamp(t *testing.T) {
 	pm_result, _ := localContext().PmFetch(sampleDoubleMillionPmID)
 
@@ -211,12 +320,6 @@ func TestPmNewContext_hasANilError(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestPmNewContext_supportsALocalContext(t *testing.T) {
-	c, _ := PmNewContext(PmContextLocal, "")
-
-	assert.NotNil(t, c)
-}
-
 func assertWithinDuration(t *testing.T, time1 time.Time, time2 time.Time, duration time.Duration) {
 	rounded1 := time1.Round(duration)
 	rounded2 := time2.Round(duration)
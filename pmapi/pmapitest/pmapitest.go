@@ -0,0 +1,360 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package pmapitest provides an in-memory pmapi.PMAPI for testing code built on pmapi or pcpeasy
+without a running pmcd.
+*/
+package pmapitest
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metric struct {
+	desc pmapi.PmDesc
+	values map[int]pmapi.PmAtomValue
+	text map[int]string
+	labels []pmapi.PmLabelSet
+}
+
+/* profile tracks pmAddProfile/pmDelProfile for one indom: either every instance except those
+   listed, or only those listed */
+type profile struct {
+	include_all bool
+	listed map[int]bool
+}
+
+type Context struct {
+	/* Timestamp of each PmResult. The current time is used when zero */
+	Timestamp time.Time
+
+	lock sync.Mutex
+	names map[string]pmapi.PmID
+	metrics map[pmapi.PmID]*metric
+	indoms map[pmapi.PmInDom]map[int]string
+	profiles map[pmapi.PmInDom]*profile
+}
+
+func New() *Context {
+	return &Context{
+		names:make(map[string]pmapi.PmID),
+		metrics:make(map[pmapi.PmID]*metric),
+		indoms:make(map[pmapi.PmInDom]map[int]string),
+		profiles:make(map[pmapi.PmInDom]*profile),
+	}
+}
+
+/* AddMetric registers a metric. values are keyed by instance, using pmapi.PmInNull for metrics
+   without an instance domain */
+func (c *Context) AddMetric(name string, desc pmapi.PmDesc, values map[int]pmapi.PmAtomValue) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.names[name] = desc.PmID
+	c.metrics[desc.PmID] = &metric{desc:desc, values:values, text:make(map[int]string)}
+}
+
+/* SetValues replaces the values returned by subsequent fetches of a metric */
+func (c *Context) SetValues(name string, values map[int]pmapi.PmAtomValue) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics[c.names[name]].values = values
+}
+
+/* SetHelpText sets the pmapi.PmTextOneline and pmapi.PmTextHelp text of a metric */
+func (c *Context) SetHelpText(name string, oneline string, help string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	text := c.metrics[c.names[name]].text
+	text[pmapi.PmTextOneline] = oneline
+	text[pmapi.PmTextHelp] = help
+}
+
+func (c *Context) SetLabels(name string, label_sets []pmapi.PmLabelSet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics[c.names[name]].labels = label_sets
+}
+
+func (c *Context) AddInDom(indom pmapi.PmInDom, instances map[int]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.indoms[indom] = instances
+}
+
+/* PmLookupName gives names it does not know PM_ID_NULL, failing only when it knows none of them, as
+   pmLookupName does */
+func (c *Context) PmLookupName(names ...string) ([]pmapi.PmID, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pmids := make([]pmapi.PmID, len(names))
+	known := 0
+	for i, name := range names {
+		pmid, found := c.names[name]
+		if(!found) {
+			pmid = pmapi.PmIDNull
+		} else {
+			known++
+		}
+		pmids[i] = pmid
+	}
+	if(known == 0) {
+		return nil, pmError(pmapi.PmErrName)
+	}
+	return pmids, nil
+}
+
+/* PmGetChildrenStatus derives the PMNS from the names of the metrics added */
+func (c *Context) PmGetChildrenStatus(name string) (map[string]bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	prefix := name + "."
+	if(name == "") {
+		prefix = ""
+	}
+	children := make(map[string]bool)
+	found := false
+	for metric_name := range c.names {
+		if(metric_name == name) {
+			found = true
+			continue
+		}
+		if(!strings.HasPrefix(metric_name, prefix)) {
+			continue
+		}
+		found = true
+		child := strings.SplitN(strings.TrimPrefix(metric_name, prefix), ".", 2)
+		children[child[0]] = len(child) == 1
+	}
+	if(!found) {
+		return nil, pmError(pmapi.PmErrName)
+	}
+	return children, nil
+}
+
+func (c *Context) PmLookupDesc(pmid pmapi.PmID) (pmapi.PmDesc, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	metric, found := c.metrics[pmid]
+	if(!found) {
+		return pmapi.PmDesc{}, pmError(pmapi.PmErrPmID)
+	}
+	return metric.desc, nil
+}
+
+func (c *Context) PmLookupText(pmid pmapi.PmID, level int) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	metric, found := c.metrics[pmid]
+	if(!found) {
+		return "", pmError(pmapi.PmErrPmID)
+	}
+	text := metric.text[level]
+	if(text == "") {
+		return "", pmError(pmapi.PmErrText)
+	}
+	return text, nil
+}
+
+func (c *Context) PmLookupLabels(pmid pmapi.PmID) ([]pmapi.PmLabelSet, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	metric, found := c.metrics[pmid]
+	if(!found) {
+		return nil, pmError(pmapi.PmErrPmID)
+	}
+	return metric.labels, nil
+}
+
+func (c *Context) PmGetInDom(indom pmapi.PmInDom) (map[int]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	instances, found := c.indoms[indom]
+	if(!found) {
+		return nil, pmError(pmapi.PmErrInDom)
+	}
+	copied := make(map[int]string)
+	for instance, name := range instances {
+		copied[instance] = name
+	}
+	return copied, nil
+}
+
+func (c *Context) PmLookupInDom(indom pmapi.PmInDom, name string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for instance, instance_name := range c.indoms[indom] {
+		if(instance_name == name) {
+			return instance, nil
+		}
+	}
+	return 0, pmError(pmapi.PmErrInst)
+}
+
+func (c *Context) PmNameInDom(indom pmapi.PmInDom, instance int) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	name, found := c.indoms[indom][instance]
+	if(!found) {
+		return "", pmError(pmapi.PmErrInst)
+	}
+	return name, nil
+}
+
+func (c *Context) PmAddProfile(indom pmapi.PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if(len(instances) == 0) {
+		delete(c.profiles, indom)
+		return nil
+	}
+	p := c.profileFor(indom)
+	for _, instance := range instances {
+		if(p.include_all) {
+			delete(p.listed, instance)
+		} else {
+			p.listed[instance] = true
+		}
+	}
+	return nil
+}
+
+func (c *Context) PmDelProfile(indom pmapi.PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if(len(instances) == 0) {
+		c.profiles[indom] = &profile{include_all:false, listed:make(map[int]bool)}
+		return nil
+	}
+	p := c.profileFor(indom)
+	for _, instance := range instances {
+		if(p.include_all) {
+			p.listed[instance] = true
+		} else {
+			delete(p.listed, instance)
+		}
+	}
+	return nil
+}
+
+func (c *Context) profileFor(indom pmapi.PmInDom) *profile {
+	p, found := c.profiles[indom]
+	if(!found) {
+		p = &profile{include_all:true, listed:make(map[int]bool)}
+		c.profiles[indom] = p
+	}
+	return p
+}
+
+func (c *Context) included(indom pmapi.PmInDom, instance int) bool {
+	p, found := c.profiles[indom]
+	if(!found || indom == pmapi.PmInDomNull) {
+		return true
+	}
+	return p.include_all != p.listed[instance]
+}
+
+func (c *Context) PmFetch(pmids ...pmapi.PmID) (*pmapi.PmResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if(len(pmids) == 0) {
+		return nil, errors.New("Invalid argument")
+	}
+
+	timestamp := c.Timestamp
+	if(timestamp.IsZero()) {
+		timestamp = time.Now()
+	}
+	vset := make([]*pmapi.PmValueSet, len(pmids))
+	for i, pmid := range pmids {
+		vset[i] = c.valueSet(pmid)
+	}
+	return &pmapi.PmResult{Timestamp:timestamp, NumPmID:len(pmids), VSet:vset}, nil
+}
+
+func (c *Context) valueSet(pmid pmapi.PmID) *pmapi.PmValueSet {
+	metric, found := c.metrics[pmid]
+	if(!found) {
+		return &pmapi.PmValueSet{PmID:pmid, NumVal:pmapi.PmErrPmID, VList:[]*pmapi.PmValue{}}
+	}
+
+	instances := []int{}
+	for instance := range metric.values {
+		if(c.included(metric.desc.InDom, instance)) {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Ints(instances)
+
+	/* Each value carries its atom, as those of the other contexts do */
+	vlist := make([]*pmapi.PmValue, 0, len(instances))
+	for _, instance := range instances {
+		pm_value, err := pmapi.NewPmValue(instance, metric.desc.Type, atomValue(metric.desc.Type, metric.values[instance]))
+		if(err != nil) {
+			return &pmapi.PmValueSet{PmID:pmid, NumVal:pmapi.PmErrValue, VList:[]*pmapi.PmValue{}}
+		}
+		vlist = append(vlist, pm_value)
+	}
+	value_format := pmapi.PmValDptr
+	if(metric.desc.Type == pmapi.PmType32 || metric.desc.Type == pmapi.PmTypeU32) {
+		value_format = pmapi.PmValInsitu
+	}
+	return &pmapi.PmValueSet{PmID:pmid, NumVal:len(vlist), ValFmt:value_format, VList:vlist}
+}
+
+func (c *Context) PmExtractValue(value_format int, pm_type int, pm_value *pmapi.PmValue) (pmapi.PmAtomValue, error) {
+	return pmapi.PmExtractValue(value_format, pm_type, pm_value)
+}
+
+/* atomValue gives the field of atom a metric of type pm_type holds */
+func atomValue(pm_type int, atom pmapi.PmAtomValue) interface{} {
+	switch pm_type {
+	case pmapi.PmType32:
+		return atom.Int32
+	case pmapi.PmTypeU32:
+		return atom.UInt32
+	case pmapi.PmType64:
+		return atom.Int64
+	case pmapi.PmTypeU64:
+		return atom.UInt64
+	case pmapi.PmTypeFloat:
+		return atom.Float
+	case pmapi.PmTypeDouble:
+		return atom.Double
+	}
+	return atom.String
+}
+
+/* pmError returns the error libpcp would for code */
+func pmError(code int) error {
+	messages := map[int]string{
+		pmapi.PmErrName:"Unknown metric name",
+		pmapi.PmErrPmID:"Unknown or illegal metric identifier",
+		pmapi.PmErrInDom:"Unknown or illegal instance domain identifier",
+		pmapi.PmErrInst:"Unknown or illegal instance identifier",
+		pmapi.PmErrText:"One-line or help text is not available",
+	}
+	return pmapi.PmError{Code:code, Message:messages[code]}
+}
@@ -0,0 +1,165 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapitest
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"time"
+)
+
+var colourInDom = pmapi.PmInDom(7)
+var colourDesc = pmapi.PmDesc{PmID:pmapi.PmID(5), Type:pmapi.PmType32, InDom:colourInDom, Sem:pmapi.PmSemInstant}
+
+func colourContext() *Context {
+	c := New()
+	c.AddInDom(colourInDom, map[int]string{0:"red", 1:"green", 2:"blue"})
+	c.AddMetric("sample.colour", colourDesc, map[int]pmapi.PmAtomValue{0:{Int32:100}, 1:{Int32:200}, 2:{Int32:300}})
+	return c
+}
+
+func instancesOf(vset *pmapi.PmValueSet) []int {
+	instances := []int{}
+	for _, pm_value := range vset.VList {
+		instances = append(instances, pm_value.Inst)
+	}
+	return instances
+}
+
+func TestContext_ImplementsPMAPI(t *testing.T) {
+	var _ pmapi.PMAPI = New()
+}
+
+func TestContext_PmLookupName_givesUnknownNamesPmIDNull(t *testing.T) {
+	c := colourContext()
+	colour, _ := c.PmLookupName("sample.colour")
+
+	pmids, err := c.PmLookupName("sample.colour", "not.a.name")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []pmapi.PmID{colour[0], pmapi.PmIDNull}, pmids)
+}
+
+func TestContext_PmLookupName_returnsAnErrorWhenNoNameIsKnown(t *testing.T) {
+	_, err := colourContext().PmLookupName("not.a.name", "nor.this")
+
+	assert.EqualError(t, err, "Unknown metric name")
+	assert.True(t, pmapi.IsPmError(err, pmapi.PmErrName))
+}
+
+func TestContext_PmFetch_returnsValuesInInstanceOrder(t *testing.T) {
+	c := colourContext()
+	c.Timestamp = time.Unix(100, 0)
+
+	pm_result, _ := c.PmFetch(colourDesc.PmID)
+	atom, _ := c.PmExtractValue(pm_result.VSet[0].ValFmt, pmapi.PmType32, pm_result.VSet[0].VList[2])
+
+	assert.Equal(t, time.Unix(100, 0), pm_result.Timestamp)
+	assert.Equal(t, []int{0, 1, 2}, instancesOf(pm_result.VSet[0]))
+	assert.Equal(t, pmapi.PmValInsitu, pm_result.VSet[0].ValFmt)
+	assert.Equal(t, int32(300), atom.Int32)
+}
+
+func TestContext_PmFetch_givesValuesThatCarryTheirAtoms(t *testing.T) {
+	c := NewSample()
+
+	pm_result, _ := c.PmFetch(SamplePswitchPmID, SampleReleasePmID)
+	pswitch, err := pmapi.PmExtractValue(pm_result.VSet[0].ValFmt, pmapi.PmTypeU64, pm_result.VSet[0].VList[0])
+	assert.NoError(t, err)
+	release, err := pmapi.PmExtractValue(pm_result.VSet[1].ValFmt, pmapi.PmTypeString, pm_result.VSet[1].VList[0])
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(18446744073709551615), pswitch.UInt64)
+	assert.Equal(t, "6.1.0", release.String)
+}
+
+func TestContext_PmFetch_encodesUnknownPMIDsInNumVal(t *testing.T) {
+	pm_result, _ := colourContext().PmFetch(pmapi.PmID(999))
+
+	assert.Equal(t, pmapi.PmErrPmID, pm_result.VSet[0].NumVal)
+}
+
+func TestContext_PmFetch_appliesTheProfile(t *testing.T) {
+	c := colourContext()
+
+	c.PmDelProfile(colourInDom)
+	c.PmAddProfile(colourInDom, 2, 0)
+	restricted, _ := c.PmFetch(colourDesc.PmID)
+	c.PmDelProfile(colourInDom, 0)
+	narrowed, _ := c.PmFetch(colourDesc.PmID)
+	c.PmAddProfile(colourInDom)
+	c.PmDelProfile(colourInDom, 1)
+	excluded, _ := c.PmFetch(colourDesc.PmID)
+
+	assert.Equal(t, []int{0, 2}, instancesOf(restricted.VSet[0]))
+	assert.Equal(t, []int{2}, instancesOf(narrowed.VSet[0]))
+	assert.Equal(t, []int{0, 2}, instancesOf(excluded.VSet[0]))
+}
+
+func TestContext_SetValues_changesSubsequentFetches(t *testing.T) {
+	c := colourContext()
+
+	c.SetValues("sample.colour", map[int]pmapi.PmAtomValue{1:{Int32:201}})
+	pm_result, _ := c.PmFetch(colourDesc.PmID)
+	atom, _ := c.PmExtractValue(pm_result.VSet[0].ValFmt, pmapi.PmType32, pm_result.VSet[0].VList[0])
+
+	assert.Equal(t, []int{1}, instancesOf(pm_result.VSet[0]))
+	assert.Equal(t, int32(201), atom.Int32)
+}
+
+func TestContext_PmLookupInDom_and_PmNameInDom(t *testing.T) {
+	c := colourContext()
+
+	instance, _ := c.PmLookupInDom(colourInDom, "green")
+	name, _ := c.PmNameInDom(colourInDom, 2)
+	_, err := c.PmNameInDom(colourInDom, 9)
+
+	assert.Equal(t, 1, instance)
+	assert.Equal(t, "blue", name)
+	assert.EqualError(t, err, "Unknown or illegal instance identifier")
+}
+
+func TestContext_PmLookupText(t *testing.T) {
+	c := colourContext()
+	c.SetHelpText("sample.colour", "Metrics with a \"saw-tooth\" trend over time", "")
+
+	oneline, _ := c.PmLookupText(colourDesc.PmID, pmapi.PmTextOneline)
+	_, err := c.PmLookupText(colourDesc.PmID, pmapi.PmTextHelp)
+
+	assert.Equal(t, "Metrics with a \"saw-tooth\" trend over time", oneline)
+	assert.True(t, pmapi.IsPmError(err, pmapi.PmErrText))
+}
+
+func TestContext_PmGetChildrenStatus(t *testing.T) {
+	c := colourContext()
+	c.AddMetric("sample.long.one", pmapi.PmDesc{PmID:pmapi.PmID(6), InDom:pmapi.PmInDomNull}, nil)
+
+	root, _ := c.PmGetChildrenStatus("")
+	sample, _ := c.PmGetChildrenStatus("sample")
+	leaf, _ := c.PmGetChildrenStatus("sample.colour")
+	_, err := c.PmGetChildrenStatus("sample.col")
+
+	assert.Equal(t, map[string]bool{"sample":false}, root)
+	assert.Equal(t, map[string]bool{"colour":true, "long":false}, sample)
+	assert.Empty(t, leaf)
+	assert.True(t, pmapi.IsPmError(err, pmapi.PmErrName))
+}
@@ -0,0 +1,80 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapitest
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi"
+	"time"
+)
+
+/* The PMIDs, instance domains and fetch time of the metrics NewSample serves */
+var (
+	SampleLoadPmID = pmapi.PmIDFromParts(60, 2, 0)
+	SampleLoadInDom = pmapi.PmInDomFromParts(60, 2)
+	SamplePswitchPmID = pmapi.PmIDFromParts(60, 0, 13)
+	SampleReadBytesPmID = pmapi.PmIDFromParts(60, 0, 38)
+	SampleDiskInDom = pmapi.PmInDomFromParts(60, 1)
+	SampleReleasePmID = pmapi.PmIDFromParts(60, 12, 0)
+	SampleTimestamp = time.Unix(1700000000, 250000000)
+)
+
+/* NewSample gives a Context serving a few metrics of the Linux PMDA, one of each kind exporters
+   and tools need to handle:
+
+	kernel.all.load       float instant over 1, 5 and 15 minute instances, with help text, labels
+	                      and labels on the 5 minute instance
+	kernel.all.pswitch    u64 counter without an instance domain, at the largest u64
+	disk.dev.read_bytes   u64 counter in Kbyte over sda and sdb, with one line help text, labels
+	                      and labels on sdb
+	kernel.uname.release  discrete string
+
+   Tests change values with SetValues and add metrics of their own as they need */
+func NewSample() *Context {
+	c := New()
+	c.Timestamp = SampleTimestamp
+	c.AddInDom(SampleLoadInDom, map[int]string{1:"1 minute", 5:"5 minute", 15:"15 minute"})
+	c.AddMetric("kernel.all.load",
+		pmapi.PmDesc{PmID:SampleLoadPmID, Type:pmapi.PmTypeFloat, InDom:SampleLoadInDom, Sem:pmapi.PmSemInstant},
+		map[int]pmapi.PmAtomValue{1:{Float:0.5}, 5:{Float:0.25}, 15:{Float:0.125}})
+	c.SetHelpText("kernel.all.load", "1, 5 and 15 minute load average", "Load averages over three intervals")
+	c.SetLabels("kernel.all.load", []pmapi.PmLabelSet{
+		{Inst:pmapi.PmInNull, Labels:map[string]string{"hostname":"web1", "agent":"linux"}},
+		{Inst:5, Labels:map[string]string{"minutes":"5"}},
+	})
+	c.AddMetric("kernel.all.pswitch",
+		pmapi.PmDesc{PmID:SamplePswitchPmID, Type:pmapi.PmTypeU64, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemCounter,
+			Units:pmapi.PmUnits{DimCount:1}},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{UInt64:18446744073709551615}})
+	c.AddInDom(SampleDiskInDom, map[int]string{0:"sda", 1:"sdb"})
+	c.AddMetric("disk.dev.read_bytes",
+		pmapi.PmDesc{PmID:SampleReadBytesPmID, Type:pmapi.PmTypeU64, InDom:SampleDiskInDom, Sem:pmapi.PmSemCounter,
+			Units:pmapi.PmUnits{DimSpace:1, ScaleSpace:pmapi.PmSpaceKByte}},
+		map[int]pmapi.PmAtomValue{0:{UInt64:100}, 1:{UInt64:200}})
+	c.SetHelpText("disk.dev.read_bytes", "per-disk read bytes", "")
+	c.SetLabels("disk.dev.read_bytes", []pmapi.PmLabelSet{
+		{Inst:pmapi.PmInNull, Labels:map[string]string{"hostname":"web1", "agent":"linux"}},
+		{Inst:1, Labels:map[string]string{"device.type":"ssd"}},
+	})
+	c.AddMetric("kernel.uname.release",
+		pmapi.PmDesc{PmID:SampleReleasePmID, Type:pmapi.PmTypeString, InDom:pmapi.PmInDomNull, Sem:pmapi.PmSemDiscrete},
+		map[int]pmapi.PmAtomValue{pmapi.PmInNull:{String:"6.1.0"}})
+	return c
+}
@@ -0,0 +1,63 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmcdtest
+
+import (
+	"strings"
+)
+
+/* sampleScript mirrors the metrics of pmcd's sample PMDA that the pmapi and pcpeasy tests use,
+   with their real PMIDs, plus pmcd.hostname which libpcp fetches for pmGetContextHostName */
+const sampleScript = `{
+  "labels": {"hostname": "localhost"},
+  "domains": {"29": {"agent": "sample", "role": "testing"}},
+  "indoms": {
+    "29.1": {
+      "instances": {"0": "red", "1": "green", "2": "blue"},
+      "instance_labels": {"0": {"model": "RGB"}, "1": {"model": "RGB"}, "2": {"model": "RGB"}}
+    }
+  },
+  "metrics": {
+    "pmcd.hostname": {"pmid": "2.7.3", "type": "string", "sem": "discrete", "value": "localhost",
+      "oneline": "local hostname"},
+    "sample.colour": {"pmid": "29.0.5", "type": "32", "sem": "instant", "indom": "29.1",
+      "values": {"0": 101, "1": 202, "2": 303},
+      "oneline": "Metrics with a \"saw-tooth\" trend over time"},
+    "sample.milliseconds": {"pmid": "29.0.3", "type": "double", "sem": "counter",
+      "units": {"DimTime": 1, "ScaleTime": 2}, "value": 1000,
+      "oneline": "Time since PMDA started, in milliseconds"},
+    "sample.double.million": {"pmid": "29.0.28", "type": "double", "sem": "instant", "value": 1000000,
+      "oneline": "1000000.0 as a 64-bit floating point value",
+      "help": "A 64-bit floating point value with the value 1000000.0."},
+    "sample.string.hullo": {"pmid": "29.0.31", "type": "string", "sem": "instant", "value": "hullo world!",
+      "oneline": "K&R have a lot to answer for"}
+  }
+}`
+
+/* Sample returns a server with the part of the sample PMDA the tests of this project use */
+func Sample() *Server {
+	s := New()
+	err := s.LoadScript(strings.NewReader(sampleScript))
+	if(err != nil) {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,331 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmcdtest
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* script is the JSON form of a server's contents read by LoadScript */
+type script struct {
+	Labels map[string]string `json:"labels"`
+	Domains map[string]map[string]string `json:"domains"`
+	InDoms map[string]indomScript `json:"indoms"`
+	Metrics map[string]metricScript `json:"metrics"`
+	Failures map[string]string `json:"failures"`
+}
+
+type indomScript struct {
+	Instances map[string]string `json:"instances"`
+	Labels map[string]string `json:"labels"`
+	InstanceLabels map[string]map[string]string `json:"instance_labels"`
+}
+
+type metricScript struct {
+	PmID string `json:"pmid"`
+	Type string `json:"type"`
+	Sem string `json:"sem"`
+	InDom string `json:"indom"`
+	Units pdu.Units `json:"units"`
+	Value interface{} `json:"value"`
+	Values map[string]interface{} `json:"values"`
+	Oneline string `json:"oneline"`
+	Help string `json:"help"`
+	Labels map[string]string `json:"labels"`
+	Error string `json:"error"`
+}
+
+var typeNames = map[string]int{
+	"32":pdu.Type32,
+	"u32":pdu.TypeU32,
+	"64":pdu.Type64,
+	"u64":pdu.TypeU64,
+	"float":pdu.TypeFloat,
+	"double":pdu.TypeDouble,
+	"string":pdu.TypeString,
+}
+
+var semanticsNames = map[string]int{
+	"counter":pdu.SemCounter,
+	"instant":pdu.SemInstant,
+	"discrete":pdu.SemDiscrete,
+}
+
+/* failureNames are the requests a script can make fail */
+var failureNames = map[string]int{
+	"fetch":pdu.TypeFetch,
+	"desc":pdu.TypeDescReq,
+	"instance":pdu.TypeInstanceReq,
+	"text":pdu.TypeTextReq,
+	"names":pdu.TypePMNSNames,
+	"ids":pdu.TypePMNSIDs,
+	"children":pdu.TypePMNSChild,
+	"traverse":pdu.TypePMNSTraverse,
+	"labels":pdu.TypeLabelReq,
+}
+
+/*
+LoadScript adds the metrics, instance domains, labels and failures of a JSON script:
+
+	{
+	  "labels": {"hostname": "mock"},
+	  "domains": {"29": {"agent": "sample"}},
+	  "indoms": {
+	    "29.1": {"instances": {"0": "red", "1": "green"}, "instance_labels": {"0": {"model": "RGB"}}}
+	  },
+	  "metrics": {
+	    "sample.colour": {"pmid": "29.0.5", "type": "32", "sem": "instant", "indom": "29.1",
+	      "values": {"0": 101, "1": 202}, "oneline": "Metrics with a colour instance domain"},
+	    "sample.milliseconds": {"pmid": "29.0.3", "type": "double", "sem": "counter",
+	      "units": {"DimTime": 1, "ScaleTime": 2}, "value": 1500},
+	    "sample.broken": {"pmid": "29.0.99", "type": "u64", "error": "PM_ERR_AGAIN"}
+	  },
+	  "failures": {"text": "PM_ERR_PERMISSION"}
+	}
+
+Top level labels are the context's and domains, indoms and metrics can have their own. Types and
+semantics are named as pminfo(1) does, with a metric of type 32 and instant semantics by default.
+Errors, a metric's fetch error or the failure of every request of a kind (fetch, desc, instance,
+text, names, ids, children, traverse or labels), are PM_ERR_* names or numbers.
+*/
+func (s *Server) LoadScript(reader io.Reader) error {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	loaded := script{}
+	err := decoder.Decode(&loaded)
+	if(err != nil) {
+		return err
+	}
+
+	if(loaded.Labels != nil) {
+		s.SetLabels(pdu.LabelContext, 0, loaded.Labels)
+	}
+	for domain_text, labels := range loaded.Domains {
+		domain, err := strconv.ParseUint(domain_text, 10, 9)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("invalid domain \"%v\"", domain_text))
+		}
+		s.SetLabels(pdu.LabelDomain, uint32(domain), labels)
+	}
+	for indom_text, indom_script := range loaded.InDoms {
+		err = s.loadInDom(indom_text, indom_script)
+		if(err != nil) {
+			return err
+		}
+	}
+	names := []string{}
+	for name := range loaded.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = s.loadMetric(name, loaded.Metrics[name])
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("%v: %v", name, err))
+		}
+	}
+	for request, code_text := range loaded.Failures {
+		request_type, found := failureNames[request]
+		if(!found) {
+			return errors.New(fmt.Sprintf("unknown request \"%v\" in failures", request))
+		}
+		code, err := errorCode(code_text)
+		if(err != nil) {
+			return err
+		}
+		s.Fail(request_type, code)
+	}
+	return nil
+}
+
+func (s *Server) loadInDom(indom_text string, indom_script indomScript) error {
+	indom, err := parseInDom(indom_text)
+	if(err != nil) {
+		return err
+	}
+	instances := make(map[int]string)
+	for instance_text, name := range indom_script.Instances {
+		instance, err := strconv.Atoi(instance_text)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("%v: invalid instance \"%v\"", indom_text, instance_text))
+		}
+		instances[instance] = name
+	}
+	s.AddInDom(indom, instances)
+	if(indom_script.Labels != nil) {
+		s.SetLabels(pdu.LabelInDom, indom, indom_script.Labels)
+	}
+	instance_labels := make(map[int]map[string]string)
+	for instance_text, labels := range indom_script.InstanceLabels {
+		instance, err := strconv.Atoi(instance_text)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("%v: invalid instance \"%v\"", indom_text, instance_text))
+		}
+		instance_labels[instance] = labels
+	}
+	if(len(instance_labels) > 0) {
+		s.SetInstanceLabels(indom, instance_labels)
+	}
+	return nil
+}
+
+func (s *Server) loadMetric(name string, metric_script metricScript) error {
+	desc := pdu.Desc{Type:pdu.Type32, InDom:pdu.InDomNull, Sem:pdu.SemInstant, Units:metric_script.Units}
+	var err error
+	desc.PmID, err = parsePmID(metric_script.PmID)
+	if(err != nil) {
+		return err
+	}
+	if(metric_script.InDom != "") {
+		desc.InDom, err = parseInDom(metric_script.InDom)
+		if(err != nil) {
+			return err
+		}
+	}
+	if(metric_script.Type != "") {
+		var found bool
+		desc.Type, found = typeNames[metric_script.Type]
+		if(!found) {
+			return errors.New(fmt.Sprintf("unknown type \"%v\"", metric_script.Type))
+		}
+	}
+	if(metric_script.Sem != "") {
+		var found bool
+		desc.Sem, found = semanticsNames[metric_script.Sem]
+		if(!found) {
+			return errors.New(fmt.Sprintf("unknown semantics \"%v\"", metric_script.Sem))
+		}
+	}
+
+	raw_values := metric_script.Values
+	if(metric_script.Value != nil) {
+		raw_values = map[string]interface{}{strconv.Itoa(pdu.InNull):metric_script.Value}
+	}
+	values := make(map[int]interface{})
+	for instance_text, raw_value := range raw_values {
+		instance, err := strconv.Atoi(instance_text)
+		if(err != nil) {
+			return errors.New(fmt.Sprintf("invalid instance \"%v\"", instance_text))
+		}
+		values[instance], err = scriptValue(desc.Type, raw_value)
+		if(err != nil) {
+			return err
+		}
+	}
+
+	s.AddMetric(name, desc, values)
+	if(metric_script.Oneline != "" || metric_script.Help != "") {
+		s.SetHelpText(name, metric_script.Oneline, metric_script.Help)
+	}
+	if(metric_script.Labels != nil) {
+		s.SetLabels(pdu.LabelItem, desc.PmID, metric_script.Labels)
+	}
+	if(metric_script.Error != "") {
+		code, err := errorCode(metric_script.Error)
+		if(err != nil) {
+			return err
+		}
+		s.SetFetchError(name, code)
+	}
+	return nil
+}
+
+/* scriptValue converts a JSON value to the Go type of a metric type, keeping 64 bit integers
+   exact */
+func scriptValue(pm_type int, raw_value interface{}) (interface{}, error) {
+	number, is_number := raw_value.(json.Number)
+	text, is_string := raw_value.(string)
+	if(pm_type == pdu.TypeString) {
+		if(!is_string) {
+			return nil, errors.New(fmt.Sprintf("%v is not a string", raw_value))
+		}
+		return text, nil
+	}
+	if(!is_number) {
+		return nil, errors.New(fmt.Sprintf("%v is not a number", raw_value))
+	}
+	var value interface{}
+	var err error
+	switch pm_type {
+	case pdu.Type32, pdu.Type64:
+		value, err = strconv.ParseInt(number.String(), 10, 64)
+	case pdu.TypeU32, pdu.TypeU64:
+		value, err = strconv.ParseUint(number.String(), 10, 64)
+	default:
+		value, err = number.Float64()
+	}
+	if(err != nil) {
+		return nil, errors.New(fmt.Sprintf("invalid value %v", number))
+	}
+	return value, nil
+}
+
+func errorCode(text string) (int, error) {
+	code, found := pdu.ErrorCode(text)
+	if(found) {
+		return code, nil
+	}
+	code, err := strconv.Atoi(text)
+	if(err != nil || code >= 0) {
+		return 0, errors.New(fmt.Sprintf("unknown error \"%v\"", text))
+	}
+	return code, nil
+}
+
+func parsePmID(text string) (uint32, error) {
+	parts, err := parseParts(text, 3)
+	if(err != nil) {
+		return 0, errors.New(fmt.Sprintf("invalid pmid \"%v\"", text))
+	}
+	return uint32((parts[0] & 0x1ff) << 22 | (parts[1] & 0xfff) << 10 | (parts[2] & 0x3ff)), nil
+}
+
+func parseInDom(text string) (uint32, error) {
+	parts, err := parseParts(text, 2)
+	if(err != nil) {
+		return 0, errors.New(fmt.Sprintf("invalid indom \"%v\"", text))
+	}
+	return uint32((parts[0] & 0x1ff) << 22 | (parts[1] & 0x3fffff)), nil
+}
+
+func parseParts(text string, count int) ([]int, error) {
+	fields := strings.Split(text, ".")
+	if(len(fields) != count) {
+		return nil, errors.New("wrong number of parts")
+	}
+	parts := make([]int, count)
+	for i, field := range fields {
+		part, err := strconv.ParseUint(field, 10, 22)
+		if(err != nil) {
+			return nil, err
+		}
+		parts[i] = int(part)
+	}
+	return parts, nil
+}
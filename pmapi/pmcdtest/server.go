@@ -0,0 +1,537 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+/*
+Package pmcdtest is a scripted pmcd(1) for tests. It speaks the PCP protocol on a TCP port, so
+PCP clients, libpcp included, can connect to it as a host (localhost:PORT, or localhost with
+PMCD_PORT set) and are served the metrics, instance domains, help text, labels and errors it has
+been given. Tests using it run without PCP installed or a pmcd running.
+
+	server := pmcdtest.New()
+	server.AddInDom(colour, map[int]string{0:"red", 1:"green"})
+	server.AddMetric("sample.colour", pdu.Desc{PmID:pmid, Type:pdu.Type32, InDom:colour, Sem:pdu.SemInstant},
+		map[int]interface{}{0:101, 1:202})
+	address, err := server.Start("localhost:0")
+	defer server.Close()
+
+Sample returns a server with the part of pmcd's sample PMDA this project's tests use.
+*/
+package pmcdtest
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metric struct {
+	desc pdu.Desc
+	values map[int]interface{}
+	text map[int]string
+	fetch_error int
+}
+
+type labelKey struct {
+	level int
+	ident uint32
+}
+
+type Server struct {
+	/* Timestamp of each result. The current time is used when zero */
+	Timestamp time.Time
+	/* Where each PDU received is logged, if set */
+	Log io.Writer
+
+	lock sync.Mutex
+	names map[string]uint32
+	metrics map[uint32]*metric
+	indoms map[uint32]map[int]string
+	labels map[labelKey]map[string]string
+	instance_labels map[uint32]map[int]map[string]string
+	failures map[int]int
+
+	listener net.Listener
+	connections map[net.Conn]bool
+	serving sync.WaitGroup
+}
+
+func New() *Server {
+	return &Server{
+		names:make(map[string]uint32),
+		metrics:make(map[uint32]*metric),
+		indoms:make(map[uint32]map[int]string),
+		labels:make(map[labelKey]map[string]string),
+		instance_labels:make(map[uint32]map[int]map[string]string),
+		failures:make(map[int]int),
+		connections:make(map[net.Conn]bool),
+	}
+}
+
+/* AddMetric registers a metric. values are keyed by instance, using pdu.InNull for metrics without
+   an instance domain, and may be any Go number, or a string for pdu.TypeString */
+func (s *Server) AddMetric(name string, desc pdu.Desc, values map[int]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.names[name] = desc.PmID
+	s.metrics[desc.PmID] = &metric{desc:desc, values:values, text:make(map[int]string)}
+}
+
+/* SetValues replaces the values served by subsequent fetches of a metric */
+func (s *Server) SetValues(name string, values map[int]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics[s.names[name]].values = values
+	s.metrics[s.names[name]].fetch_error = 0
+}
+
+/* SetFetchError makes fetches of a metric return code in place of its values, as pmcd does when a
+   PMDA cannot supply them. A code of zero serves the values again */
+func (s *Server) SetFetchError(name string, code int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics[s.names[name]].fetch_error = code
+}
+
+/* SetHelpText sets the pdu.TextOneline and pdu.TextHelp text of a metric */
+func (s *Server) SetHelpText(name string, oneline string, help string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	text := s.metrics[s.names[name]].text
+	text[pdu.TextOneline] = oneline
+	text[pdu.TextHelp] = help
+}
+
+func (s *Server) AddInDom(indom uint32, instances map[int]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.indoms[indom] = instances
+}
+
+/* SetLabels sets the labels of one level of the hierarchy: pdu.LabelContext (whose ident is
+   ignored), pdu.LabelDomain with a domain number, pdu.LabelCluster or pdu.LabelItem with a PMID, or
+   pdu.LabelInDom with an instance domain */
+func (s *Server) SetLabels(level int, ident uint32, labels map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if(level == pdu.LabelContext) {
+		ident = 0
+	}
+	s.labels[labelKey{level:level, ident:ident}] = labels
+}
+
+/* SetInstanceLabels sets the labels of instances in an instance domain */
+func (s *Server) SetInstanceLabels(indom uint32, labels map[int]map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.instance_labels[indom] = labels
+}
+
+/* Fail answers every request of a PDU type, such as pdu.TypeDescReq, with an error PDU of code.
+   A code of zero answers them normally again */
+func (s *Server) Fail(request_type int, code int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if(code == 0) {
+		delete(s.failures, request_type)
+		return
+	}
+	s.failures[request_type] = code
+}
+
+/* Start listens on address, such as "localhost:0" for any free port, and serves clients in the
+   background. It returns the address listened on */
+func (s *Server) Start(address string) (string, error) {
+	listener, err := net.Listen("tcp", address)
+	if(err != nil) {
+		return "", err
+	}
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+	s.serving.Add(1)
+	go func() {
+		defer s.serving.Done()
+		s.Serve(listener)
+	}()
+	return listener.Addr().String(), nil
+}
+
+/* Serve accepts clients until the listener is closed */
+func (s *Server) Serve(listener net.Listener) error {
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+	for {
+		connection, err := listener.Accept()
+		if(err != nil) {
+			return err
+		}
+		s.lock.Lock()
+		s.connections[connection] = true
+		s.lock.Unlock()
+		s.serving.Add(1)
+		go func() {
+			defer s.serving.Done()
+			s.serveClient(connection)
+		}()
+	}
+}
+
+/* Close stops listening, disconnects every client and waits for them to finish */
+func (s *Server) Close() error {
+	s.lock.Lock()
+	var err error
+	if(s.listener != nil) {
+		err = s.listener.Close()
+	}
+	for connection := range s.connections {
+		connection.Close()
+	}
+	s.lock.Unlock()
+	s.serving.Wait()
+	return err
+}
+
+func (s *Server) serveClient(connection net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.connections, connection)
+		s.lock.Unlock()
+		connection.Close()
+	}()
+	client := &client{server:s, profiles:make(map[int]pdu.Profile)}
+	err := pdu.Write(connection, pdu.EncodeChallenge(pdu.Challenge{Version:pdu.Version, Licensed:1, Features:pdu.FeatureLabels}))
+	for err == nil {
+		var request pdu.PDU
+		request, err = pdu.Read(connection)
+		if(err != nil) {
+			return
+		}
+		if(s.Log != nil) {
+			fmt.Fprintf(s.Log, "%v: %v\n", connection.RemoteAddr(), pdu.TypeName(request.Type))
+		}
+		reply, ok := client.answer(request)
+		if(ok) {
+			err = pdu.Write(connection, reply)
+		}
+	}
+}
+
+/* client is the state pmcd keeps for a connection: the profile of each of its contexts */
+type client struct {
+	server *Server
+	profiles map[int]pdu.Profile
+}
+
+/* answer handles a request, returning the reply if the request has one */
+func (c *client) answer(request pdu.PDU) (pdu.PDU, bool) {
+	s := c.server
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch request.Type {
+	case pdu.TypeCreds, pdu.TypeAttr:
+		return pdu.PDU{}, false
+	case pdu.TypeProfile:
+		profile, err := pdu.DecodeProfile(request)
+		if(err == nil) {
+			c.profiles[profile.Context] = profile
+		}
+		return pdu.PDU{}, false
+	}
+	if code, failing := s.failures[request.Type]; failing {
+		return pdu.EncodeError(code), true
+	}
+
+	var reply pdu.PDU
+	var err error
+	switch request.Type {
+	case pdu.TypeFetch:
+		reply, err = c.fetch(request)
+	case pdu.TypeDescReq:
+		reply, err = s.desc(request)
+	case pdu.TypeInstanceReq:
+		reply, err = s.instances(request)
+	case pdu.TypeTextReq:
+		reply, err = s.text(request)
+	case pdu.TypePMNSNames:
+		reply, err = s.lookupNames(request)
+	case pdu.TypePMNSIDs:
+		reply, err = s.nameIDs(request)
+	case pdu.TypePMNSChild:
+		reply, err = s.children(request)
+	case pdu.TypePMNSTraverse:
+		reply, err = s.traverse(request)
+	case pdu.TypeLabelReq:
+		reply, err = s.labelSets(request)
+	default:
+		err = pdu.Error{Code:pdu.ErrNYI}
+	}
+	if(err != nil) {
+		code := pdu.ErrIPC
+		if pdu_err, ok := err.(pdu.Error); ok {
+			code = pdu_err.Code
+		}
+		return pdu.EncodeError(code), true
+	}
+	return reply, true
+}
+
+func (c *client) fetch(request pdu.PDU) (pdu.PDU, error) {
+	fetch, err := pdu.DecodeFetch(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	timestamp := c.server.Timestamp
+	if(timestamp.IsZero()) {
+		timestamp = time.Now()
+	}
+	result := pdu.Result{Timestamp:timestamp, ValueSets:make([]pdu.ValueSet, len(fetch.PmIDs))}
+	for i, pmid := range fetch.PmIDs {
+		result.ValueSets[i] = c.server.valueSet(pmid, c.profiles[fetch.Context])
+	}
+	return pdu.EncodeResult(result), nil
+}
+
+func (s *Server) valueSet(pmid uint32, profile pdu.Profile) pdu.ValueSet {
+	metric, found := s.metrics[pmid]
+	if(!found) {
+		return pdu.ValueSet{PmID:pmid, NumVal:pdu.ErrPmID}
+	}
+	if(metric.fetch_error != 0) {
+		return pdu.ValueSet{PmID:pmid, NumVal:metric.fetch_error}
+	}
+
+	instances := []int{}
+	for instance := range metric.values {
+		if(metric.desc.InDom == pdu.InDomNull || profile.Includes(metric.desc.InDom, instance)) {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Ints(instances)
+	values := make([]pdu.Value, len(instances))
+	for i, instance := range instances {
+		value, err := pdu.NewValue(instance, metric.desc.Type, metric.values[instance])
+		if(err != nil) {
+			return pdu.ValueSet{PmID:pmid, NumVal:pdu.ErrConv}
+		}
+		values[i] = value
+	}
+	return pdu.ValueSet{PmID:pmid, NumVal:len(values), ValFmt:pdu.ValueFormat(metric.desc.Type), Values:values}
+}
+
+func (s *Server) desc(request pdu.PDU) (pdu.PDU, error) {
+	pmid, err := pdu.DecodeDescReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	metric, found := s.metrics[pmid]
+	if(!found) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrPmID}
+	}
+	return pdu.EncodeDesc(metric.desc), nil
+}
+
+func (s *Server) instances(request pdu.PDU) (pdu.PDU, error) {
+	instance_req, err := pdu.DecodeInstanceReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	names, found := s.indoms[instance_req.InDom]
+	if(!found) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrInDom}
+	}
+	list := pdu.InstanceList{InDom:instance_req.InDom, Instances:[]pdu.Instance{}}
+	for instance, name := range names {
+		if(instance_req.Inst != pdu.InNull && instance != instance_req.Inst) {
+			continue
+		}
+		if(instance_req.Name != "" && name != instance_req.Name) {
+			continue
+		}
+		list.Instances = append(list.Instances, pdu.Instance{Inst:instance, Name:name})
+	}
+	if(len(list.Instances) == 0 && (instance_req.Inst != pdu.InNull || instance_req.Name != "")) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrInst}
+	}
+	sort.Slice(list.Instances, func(i, j int) bool {
+		return list.Instances[i].Inst < list.Instances[j].Inst
+	})
+	return pdu.EncodeInstanceList(list), nil
+}
+
+func (s *Server) text(request pdu.PDU) (pdu.PDU, error) {
+	text_req, err := pdu.DecodeTextReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	if(text_req.Type & pdu.TextPmID == 0) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrText}
+	}
+	metric, found := s.metrics[text_req.Ident]
+	if(!found) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrPmID}
+	}
+	text := metric.text[text_req.Type & (pdu.TextOneline | pdu.TextHelp)]
+	if(text == "") {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrText}
+	}
+	return pdu.EncodeText(text_req.Ident, text), nil
+}
+
+/* lookupNames answers pmLookupName: the PMID of each name, or pdu.IDNull for those unknown */
+func (s *Server) lookupNames(request pdu.PDU) (pdu.PDU, error) {
+	list, err := pdu.DecodeNameList(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	ids := pdu.IDList{PmIDs:make([]uint32, len(list.Names))}
+	for i, name := range list.Names {
+		pmid, found := s.names[name]
+		if(!found) {
+			ids.PmIDs[i] = pdu.IDNull
+			continue
+		}
+		ids.PmIDs[i] = pmid
+		ids.Status++
+	}
+	if(ids.Status == 0) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrName}
+	}
+	return pdu.EncodeIDList(ids), nil
+}
+
+/* nameIDs answers pmNameAll: the names of a PMID */
+func (s *Server) nameIDs(request pdu.PDU) (pdu.PDU, error) {
+	ids, err := pdu.DecodeIDList(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	list := pdu.NameList{Names:[]string{}}
+	for name, pmid := range s.names {
+		for _, wanted := range ids.PmIDs {
+			if(pmid == wanted) {
+				list.Names = append(list.Names, name)
+			}
+		}
+	}
+	if(len(list.Names) == 0) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrPmID}
+	}
+	sort.Strings(list.Names)
+	return pdu.EncodeNameList(list), nil
+}
+
+/* children derives the PMNS from the metric names, as pmapitest does */
+func (s *Server) children(request pdu.PDU) (pdu.PDU, error) {
+	name_req, err := pdu.DecodeNameReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	prefix := name_req.Name + "."
+	if(name_req.Name == "") {
+		prefix = ""
+	}
+	leaves := make(map[string]bool)
+	found := false
+	for metric_name := range s.names {
+		if(metric_name == name_req.Name) {
+			found = true
+			continue
+		}
+		if(!strings.HasPrefix(metric_name, prefix)) {
+			continue
+		}
+		found = true
+		child := strings.SplitN(strings.TrimPrefix(metric_name, prefix), ".", 2)
+		leaves[child[0]] = leaves[child[0]] || len(child) == 1
+	}
+	if(!found) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrName}
+	}
+
+	list := pdu.NameList{Names:[]string{}}
+	for child := range leaves {
+		list.Names = append(list.Names, child)
+	}
+	sort.Strings(list.Names)
+	if(name_req.Status) {
+		list.Statuses = make([]int, len(list.Names))
+		for i, child := range list.Names {
+			if(!leaves[child]) {
+				list.Statuses[i] = pdu.NonLeafStatus
+			}
+		}
+	}
+	return pdu.EncodeNameList(list), nil
+}
+
+/* traverse answers pmTraversePMNS: every metric name under a node, or the node if it is one */
+func (s *Server) traverse(request pdu.PDU) (pdu.PDU, error) {
+	name_req, err := pdu.DecodeNameReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	list := pdu.NameList{Names:[]string{}}
+	for metric_name := range s.names {
+		if(name_req.Name == "" || metric_name == name_req.Name || strings.HasPrefix(metric_name, name_req.Name + ".")) {
+			list.Names = append(list.Names, metric_name)
+		}
+	}
+	if(len(list.Names) == 0) {
+		return pdu.PDU{}, pdu.Error{Code:pdu.ErrName}
+	}
+	sort.Strings(list.Names)
+	return pdu.EncodeNameList(list), nil
+}
+
+func (s *Server) labelSets(request pdu.PDU) (pdu.PDU, error) {
+	label_req, err := pdu.DecodeLabelReq(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	list := pdu.LabelList{Ident:label_req.Ident, Type:label_req.Type, Sets:[]pdu.LabelSet{}}
+	if(label_req.Type == pdu.LabelInstances) {
+		by_instance := s.instance_labels[label_req.Ident]
+		instances := []int{}
+		for instance := range by_instance {
+			instances = append(instances, instance)
+		}
+		sort.Ints(instances)
+		for _, instance := range instances {
+			list.Sets = append(list.Sets, pdu.LabelSet{Inst:instance, Labels:by_instance[instance]})
+		}
+		return pdu.EncodeLabelList(list), nil
+	}
+
+	key := labelKey{level:label_req.Type, ident:label_req.Ident}
+	if(label_req.Type == pdu.LabelContext) {
+		key.ident = 0
+	}
+	if labels, found := s.labels[key]; found {
+		list.Sets = append(list.Sets, pdu.LabelSet{Inst:pdu.InNull, Labels:labels})
+	}
+	return pdu.EncodeLabelList(list), nil
+}
@@ -0,0 +1,291 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmcdtest
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"net"
+	"strings"
+	"time"
+)
+
+var colour = uint32(29 << 22 | 1)
+
+/* connect starts a sample server and completes the handshake with it as libpcp would */
+func connect(t *testing.T, server *Server) net.Conn {
+	address, err := server.Start("localhost:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	connection, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+
+	challenge_pdu, err := pdu.Read(connection)
+	assert.NoError(t, err)
+	challenge, err := pdu.DecodeChallenge(challenge_pdu)
+	assert.NoError(t, err)
+	assert.Equal(t, pdu.Version, challenge.Version)
+	assert.NoError(t, pdu.Write(connection, pdu.EncodeCreds(pdu.Creds{Version:pdu.Version})))
+	return connection
+}
+
+func request(t *testing.T, connection net.Conn, request pdu.PDU) pdu.PDU {
+	assert.NoError(t, pdu.Write(connection, request))
+	reply, err := pdu.Read(connection)
+	assert.NoError(t, err)
+	return reply
+}
+
+func TestServer_advertisesLabels(t *testing.T) {
+	server := Sample()
+	address, _ := server.Start("localhost:0")
+	defer server.Close()
+	connection, _ := net.Dial("tcp", address)
+	defer connection.Close()
+
+	challenge_pdu, _ := pdu.Read(connection)
+	challenge, _ := pdu.DecodeChallenge(challenge_pdu)
+
+	assert.Equal(t, pdu.FeatureLabels, challenge.Features & pdu.FeatureLabels)
+}
+
+func TestServer_looksUpNames(t *testing.T) {
+	connection := connect(t, Sample())
+
+	ids, err := pdu.DecodeIDList(request(t, connection, pdu.EncodeNameList(pdu.NameList{Names:[]string{"sample.double.million", "not.a.name"}})))
+
+	assert.NoError(t, err)
+	assert.Equal(t, pdu.IDList{Status:1, PmIDs:[]uint32{121634844, pdu.IDNull}}, ids)
+}
+
+func TestServer_failsToLookUpOnlyUnknownNames(t *testing.T) {
+	connection := connect(t, Sample())
+
+	_, err := pdu.DecodeIDList(request(t, connection, pdu.EncodeNameList(pdu.NameList{Names:[]string{"not.a.name"}})))
+
+	assert.Equal(t, pdu.Error{Code:pdu.ErrName}, err)
+}
+
+func TestServer_findsTheNamesOfPmIDs(t *testing.T) {
+	connection := connect(t, Sample())
+
+	names, err := pdu.DecodeNameList(request(t, connection, pdu.EncodeIDList(pdu.IDList{PmIDs:[]uint32{121634844}})))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sample.double.million"}, names.Names)
+}
+
+func TestServer_describesMetrics(t *testing.T) {
+	connection := connect(t, Sample())
+
+	desc, err := pdu.DecodeDesc(request(t, connection, pdu.EncodeDescReq(121634819)))
+	assert.NoError(t, err)
+	assert.Equal(t, pdu.Desc{PmID:121634819, Type:pdu.TypeDouble, InDom:pdu.InDomNull, Sem:pdu.SemCounter,
+		Units:pdu.Units{DimTime:1, ScaleTime:2}}, desc)
+
+	_, err = pdu.DecodeDesc(request(t, connection, pdu.EncodeDescReq(123)))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrPmID}, err)
+}
+
+func TestServer_fetchesValues(t *testing.T) {
+	server := Sample()
+	server.Timestamp = time.Unix(1500000000, 0)
+	connection := connect(t, server)
+
+	result, err := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{PmIDs:[]uint32{121634844, 121634821, 1}})))
+
+	assert.NoError(t, err)
+	assert.Equal(t, server.Timestamp, result.Timestamp)
+	million, _ := result.ValueSets[0].Values[0].Atom(pdu.TypeDouble, result.ValueSets[0].ValFmt)
+	assert.Equal(t, 1000000.0, million)
+	assert.Equal(t, pdu.InNull, result.ValueSets[0].Values[0].Inst)
+	assert.Len(t, result.ValueSets[1].Values, 3)
+	blue, _ := result.ValueSets[1].Values[2].Atom(pdu.Type32, result.ValueSets[1].ValFmt)
+	assert.Equal(t, int32(303), blue)
+	assert.Equal(t, pdu.ErrPmID, result.ValueSets[2].NumVal)
+}
+
+func TestServer_appliesTheProfileOfTheFetchingContext(t *testing.T) {
+	connection := connect(t, Sample())
+	pdu.Write(connection, pdu.EncodeProfile(pdu.Profile{Context:2, State:pdu.ProfileInclude, InDoms:[]pdu.InDomProfile{
+		{InDom:colour, State:pdu.ProfileExclude, Instances:[]int{1}},
+	}}))
+
+	profiled, _ := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{Context:2, PmIDs:[]uint32{121634821}})))
+	unprofiled, _ := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{Context:0, PmIDs:[]uint32{121634821}})))
+
+	assert.Len(t, profiled.ValueSets[0].Values, 1)
+	assert.Equal(t, 1, profiled.ValueSets[0].Values[0].Inst)
+	assert.Len(t, unprofiled.ValueSets[0].Values, 3)
+}
+
+func TestServer_servesScriptedFetchErrors(t *testing.T) {
+	server := Sample()
+	server.SetFetchError("sample.colour", pdu.ErrAgain)
+	connection := connect(t, server)
+
+	result, _ := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{PmIDs:[]uint32{121634821}})))
+
+	assert.Equal(t, pdu.ErrAgain, result.ValueSets[0].NumVal)
+}
+
+func TestServer_servesChangedValues(t *testing.T) {
+	server := Sample()
+	connection := connect(t, server)
+	server.SetValues("sample.double.million", map[int]interface{}{pdu.InNull:2000000.0})
+
+	result, _ := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{PmIDs:[]uint32{121634844}})))
+
+	value, _ := result.ValueSets[0].Values[0].Atom(pdu.TypeDouble, pdu.ValDptr)
+	assert.Equal(t, 2000000.0, value)
+}
+
+func TestServer_servesInstanceDomains(t *testing.T) {
+	connection := connect(t, Sample())
+
+	all, err := pdu.DecodeInstanceList(request(t, connection, pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:colour, Inst:pdu.InNull})))
+	assert.NoError(t, err)
+	assert.Equal(t, []pdu.Instance{{Inst:0, Name:"red"}, {Inst:1, Name:"green"}, {Inst:2, Name:"blue"}}, all.Instances)
+
+	by_name, _ := pdu.DecodeInstanceList(request(t, connection, pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:colour, Inst:pdu.InNull, Name:"green"})))
+	assert.Equal(t, []pdu.Instance{{Inst:1, Name:"green"}}, by_name.Instances)
+
+	by_id, _ := pdu.DecodeInstanceList(request(t, connection, pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:colour, Inst:2})))
+	assert.Equal(t, []pdu.Instance{{Inst:2, Name:"blue"}}, by_id.Instances)
+
+	_, err = pdu.DecodeInstanceList(request(t, connection, pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:colour, Inst:pdu.InNull, Name:"purple"})))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrInst}, err)
+
+	_, err = pdu.DecodeInstanceList(request(t, connection, pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:123, Inst:pdu.InNull})))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrInDom}, err)
+}
+
+func TestServer_servesHelpText(t *testing.T) {
+	connection := connect(t, Sample())
+
+	oneline, err := pdu.DecodeText(request(t, connection, pdu.EncodeTextReq(pdu.TextReq{Ident:121634844, Type:pdu.TextPmID | pdu.TextOneline})))
+	assert.NoError(t, err)
+	assert.Equal(t, "1000000.0 as a 64-bit floating point value", oneline)
+
+	_, err = pdu.DecodeText(request(t, connection, pdu.EncodeTextReq(pdu.TextReq{Ident:121634847, Type:pdu.TextPmID | pdu.TextHelp})))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrText}, err)
+}
+
+func TestServer_servesThePMNS(t *testing.T) {
+	connection := connect(t, Sample())
+
+	children, err := pdu.DecodeNameList(request(t, connection, pdu.EncodeChildReq(pdu.NameReq{Status:true, Name:"sample"})))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"colour", "double", "milliseconds", "string"}, children.Names)
+	assert.Equal(t, []int{pdu.LeafStatus, pdu.NonLeafStatus, pdu.LeafStatus, pdu.NonLeafStatus}, children.Statuses)
+
+	leaf, err := pdu.DecodeNameList(request(t, connection, pdu.EncodeChildReq(pdu.NameReq{Name:"sample.colour"})))
+	assert.NoError(t, err)
+	assert.Empty(t, leaf.Names)
+
+	all, _ := pdu.DecodeNameList(request(t, connection, pdu.EncodeTraverseReq(pdu.NameReq{Name:"sample"})))
+	assert.Equal(t, []string{"sample.colour", "sample.double.million", "sample.milliseconds", "sample.string.hullo"}, all.Names)
+
+	_, err = pdu.DecodeNameList(request(t, connection, pdu.EncodeChildReq(pdu.NameReq{Name:"not"})))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrName}, err)
+}
+
+func TestServer_servesLabels(t *testing.T) {
+	connection := connect(t, Sample())
+
+	domain, err := pdu.DecodeLabelList(request(t, connection, pdu.EncodeLabelReq(pdu.LabelReq{Ident:29, Type:pdu.LabelDomain})))
+	assert.NoError(t, err)
+	assert.Equal(t, []pdu.LabelSet{{Inst:pdu.InNull, Labels:map[string]string{"agent":"sample", "role":"testing"}}}, domain.Sets)
+
+	instances, _ := pdu.DecodeLabelList(request(t, connection, pdu.EncodeLabelReq(pdu.LabelReq{Ident:colour, Type:pdu.LabelInstances})))
+	assert.Len(t, instances.Sets, 3)
+	assert.Equal(t, 2, instances.Sets[2].Inst)
+
+	item, _ := pdu.DecodeLabelList(request(t, connection, pdu.EncodeLabelReq(pdu.LabelReq{Ident:121634844, Type:pdu.LabelItem})))
+	assert.Empty(t, item.Sets)
+}
+
+func TestServer_Fail_answersRequestsWithAnError(t *testing.T) {
+	server := Sample()
+	server.Fail(pdu.TypeDescReq, pdu.ErrPermission)
+	connection := connect(t, server)
+
+	_, err := pdu.DecodeDesc(request(t, connection, pdu.EncodeDescReq(121634844)))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrPermission}, err)
+
+	server.Fail(pdu.TypeDescReq, 0)
+	_, err = pdu.DecodeDesc(request(t, connection, pdu.EncodeDescReq(121634844)))
+	assert.NoError(t, err)
+}
+
+func TestServer_answersUnsupportedRequestsWithAnError(t *testing.T) {
+	connection := connect(t, Sample())
+
+	code, _ := pdu.DecodeError(request(t, connection, pdu.PDU{Type:0x700a}))
+
+	assert.Equal(t, pdu.ErrNYI, code)
+}
+
+func TestServer_Close_disconnectsClients(t *testing.T) {
+	server := Sample()
+	connection := connect(t, server)
+
+	server.Close()
+
+	_, err := pdu.Read(connection)
+	assert.Error(t, err)
+}
+
+func TestLoadScript_loadsFailuresAndFetchErrors(t *testing.T) {
+	server := New()
+	err := server.LoadScript(strings.NewReader(`{
+		"metrics": {"disk.all.read": {"pmid": "60.0.24", "type": "u64", "sem": "counter", "value": 18446744073709551615},
+		            "disk.broken": {"pmid": "60.0.99", "error": "-5"}},
+		"failures": {"text": "PM_ERR_PERMISSION"}
+	}`))
+	assert.NoError(t, err)
+	connection := connect(t, server)
+
+	result, _ := pdu.DecodeResult(request(t, connection, pdu.EncodeFetch(pdu.Fetch{PmIDs:[]uint32{251658264, 251658339}})))
+	value, _ := result.ValueSets[0].Values[0].Atom(pdu.TypeU64, pdu.ValDptr)
+	assert.Equal(t, uint64(18446744073709551615), value)
+	assert.Equal(t, -5, result.ValueSets[1].NumVal)
+
+	_, err = pdu.DecodeText(request(t, connection, pdu.EncodeTextReq(pdu.TextReq{Ident:251658264, Type:pdu.TextPmID | pdu.TextOneline})))
+	assert.Equal(t, pdu.Error{Code:pdu.ErrPermission}, err)
+}
+
+func TestLoadScript_reportsMistakes(t *testing.T) {
+	for script, message := range map[string]string{
+		`{"metrics": {"a": {"pmid": "1.2"}}}`:`a: invalid pmid "1.2"`,
+		`{"metrics": {"a": {"pmid": "1.2.3", "type": "int"}}}`:`a: unknown type "int"`,
+		`{"metrics": {"a": {"pmid": "1.2.3", "type": "string", "value": 5}}}`:`a: 5 is not a string`,
+		`{"metrics": {"a": {"pmid": "1.2.3", "error": "PM_ERR_NOPE"}}}`:`a: unknown error "PM_ERR_NOPE"`,
+		`{"indoms": {"1": {}}}`:`invalid indom "1"`,
+		`{"failures": {"everything": "PM_ERR_IPC"}}`:`unknown request "everything" in failures`,
+		`{"metric": {}}`:`json: unknown field "metric"`,
+	} {
+		assert.EqualError(t, New().LoadScript(strings.NewReader(script)), message, script)
+	}
+}
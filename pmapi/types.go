@@ -0,0 +1,183 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"errors"
+	"time"
+)
+
+/* The values of these constants are those of pmapi.h. They are spelled out rather than taken from
+   libpcp so that the package builds without cgo; libpcp_test.go checks them where libpcp is
+   available */
+const (
+	PmContextHost = PmContextType(1)
+	PmContextArchive = PmContextType(2)
+	PmContextLocal = PmContextType(3)
+	PmContextUndef = PmContextType(-1)
+	PmInDomNull = PmInDom(0xffffffff)
+	PmInNull = -1
+	/* What PmLookupName gives names it does not know, when it knows others asked for */
+	PmIDNull = PmID(0xffffffff)
+
+	PmSpaceByte = uint(0)
+	PmSpaceKByte = uint(1)
+	PmSpaceMByte = uint(2)
+	PmSpaceGByte = uint(3)
+	PmSpaceTByte = uint(4)
+	PmSpacePByte = uint(5)
+	PmSpaceEByte = uint(6)
+
+	PmTimeNSec = uint(0)
+	PmTimeUSec = uint(1)
+	PmTimeMSec = uint(2)
+	PmTimeSec = uint(3)
+	PmTimeMin = uint(4)
+	PmTimeHour = uint(5)
+
+	PmTypeNoSupport = -1
+	PmType32 = 0
+	PmTypeU32 = 1
+	PmType64 = 2
+	PmTypeU64 = 3
+	PmTypeFloat = 4
+	PmTypeDouble = 5
+	PmTypeString = 6
+	PmTypeAggregate= 7
+	PmTypeAggregateStatic = 8
+	PmTypeEvent = 9
+	PmTypeHighResEvent = 10
+	PmTypeUnknown = 255
+
+	PmSemCounter = 1
+	PmSemInstant = 3
+	PmSemDiscrete = 4
+
+	PmValInsitu = 0
+	PmValDptr = 1
+	PmValSptr = 2
+
+	PmModeLive = 0
+	PmModeInterp = 1
+	PmModeForw = 2
+	PmModeBack = 3
+
+	PmTextOneline = 1
+	PmTextHelp = 2
+
+	PmErrText = -12349
+	PmErrValue = -12351
+	PmErrTimeout = -12353
+	PmErrName = -12357
+	PmErrPmID = -12358
+	PmErrInDom = -12359
+	PmErrInst = -12360
+	PmErrIPC = -12366
+	PmErrEOF = -12368
+	PmErrEOL = -12370
+	PmErrNoLabels = -12409
+)
+
+type PMAPI interface {
+	PmLookupName(names ...string) ([]PmID, error)
+	PmFetch(pmids ...PmID) (*PmResult, error)
+	PmLookupDesc(pmid PmID) (PmDesc, error)
+	PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error)
+	PmGetInDom(indom PmInDom) (map[int]string, error)
+	PmLookupInDom(indom PmInDom, name string) (int, error)
+	PmNameInDom(indom PmInDom, instance int) (string, error)
+	PmAddProfile(indom PmInDom, instances ...int) error
+	PmDelProfile(indom PmInDom, instances ...int) error
+	PmLookupLabels(pmid PmID) ([]PmLabelSet, error)
+	PmLookupText(pmid PmID, level int) (string, error)
+	PmGetChildrenStatus(name string) (map[string]bool, error)
+}
+
+type PmDesc struct {
+	PmID PmID
+	Type int
+	InDom PmInDom
+	Sem int
+	Units PmUnits
+}
+
+type PmUnits struct {
+	DimSpace int
+	DimTime int
+	DimCount int
+	ScaleSpace uint
+	ScaleTime uint
+	ScaleCount int
+}
+
+type PmResult struct {
+	Timestamp time.Time
+	NumPmID	int
+	VSet []*PmValueSet
+}
+
+type PmValueSet struct {
+	PmID	PmID
+	NumVal	int
+	ValFmt	int
+	VList	[]*PmValue
+}
+
+type PmValue struct {
+	Inst int
+	/* Where the value is held until it is extracted: a copy of libpcp's pmValue, or the value as
+	   it came off the wire */
+	value extractable
+}
+
+/* extractable is a value PmExtractValue can decode */
+type extractable interface {
+	extract(value_format int, pm_type int) (PmAtomValue, error)
+}
+
+type PmAtomValue struct {
+	Int32 int32
+	UInt32 uint32
+	Int64 int64
+	UInt64 uint64
+	Float float32
+	Double float64
+	String string
+}
+
+type PmLogLabel struct {
+	Hostname string
+	/* When the archive's first record was written */
+	Start time.Time
+}
+
+type PmContextType int
+type PmID uint32
+type PmInDom uint32
+
+/* PmExtractValue decodes a value fetched by any context of this package, whichever way the
+   context fetched it */
+func PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error) {
+	if(pm_value.value == nil) {
+		return PmAtomValue{}, errors.New("PmValue was not fetched, it holds no value")
+	}
+	return pm_value.value.extract(value_format, pm_type)
+}
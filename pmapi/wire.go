@@ -0,0 +1,542 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* The port pmcd listens on when neither the host nor PMCD_PORT names one */
+const pmcdPort = "44321"
+
+/* How long pmcd has to accept a connection and to answer a request, libpcp's defaults for
+   PMCD_CONNECT_TIMEOUT and PMCD_REQUEST_TIMEOUT */
+var (
+	wireConnectTimeout = 5 * time.Second
+	wireRequestTimeout = 10 * time.Second
+)
+
+/* WireContext is a host context that speaks the PCP protocol to pmcd itself, so needs neither cgo
+   nor libpcp. It is what PmNewContext gives for hosts when the package is built without them */
+type WireContext struct {
+	host string
+	/* The features pmcd offered when it accepted the connection */
+	features int
+	/* Guards everything below. pmcd answers requests in turn, so a request holds the lock until
+	   its answer has been read */
+	lock sync.Mutex
+	/* Nil once the connection has failed, after which every request fails */
+	connection net.Conn
+	/* The instance profile, sent to pmcd before the next fetch whenever it has changed */
+	profile pdu.Profile
+	profile_sent bool
+}
+
+/* NewWireContext connects to pmcd on host, a name or address with an optional :port. Without a
+   port, the first port in PMCD_PORT or else 44321 is used, as libpcp does */
+func NewWireContext(host string) (*WireContext, error) {
+	connection, err := net.DialTimeout("tcp", pmcdAddress(host), wireConnectTimeout)
+	if(err != nil) {
+		return nil, err
+	}
+	c := &WireContext{host:host, connection:connection, profile:pdu.Profile{State:pdu.ProfileInclude}}
+	err = c.handshake()
+	if(err != nil) {
+		connection.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func pmcdAddress(host string) string {
+	if(host == "") {
+		host = "localhost"
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := pmcdPort
+	if(os.Getenv("PMCD_PORT") != "") {
+		port = strings.Split(os.Getenv("PMCD_PORT"), ",")[0]
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+/* handshake reads pmcd's challenge and answers it with our protocol version. No features are asked
+   for, as this client does neither TLS nor authentication */
+func (c *WireContext) handshake() error {
+	c.connection.SetDeadline(time.Now().Add(wireRequestTimeout))
+	challenge_pdu, err := pdu.Read(c.connection)
+	if(err != nil) {
+		return wireError(err)
+	}
+	challenge, err := pdu.DecodeChallenge(challenge_pdu)
+	if(err != nil) {
+		return wireError(err)
+	}
+	if(challenge.Version < pdu.Version) {
+		return errors.New(fmt.Sprintf("pmcd speaks PCP protocol version %v, version %v is needed", challenge.Version, pdu.Version))
+	}
+	if(challenge.Features & pdu.FeatureCredsRequired != 0) {
+		return wirePmError(pdu.ErrPermission)
+	}
+	c.features = challenge.Features
+	err = pdu.Write(c.connection, pdu.EncodeCreds(pdu.Creds{Version:pdu.Version}))
+	if(err != nil) {
+		return wireError(err)
+	}
+	return nil
+}
+
+/* Close disconnects from pmcd. The context cannot be used afterwards */
+func (c *WireContext) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if(c.connection == nil) {
+		return nil
+	}
+	err := c.connection.Close()
+	c.connection = nil
+	return err
+}
+
+/* send writes a request pmcd does not answer. The lock must be held */
+func (c *WireContext) send(request pdu.PDU) error {
+	if(c.connection == nil) {
+		return wirePmError(pdu.ErrNotConn)
+	}
+	c.connection.SetDeadline(time.Now().Add(wireRequestTimeout))
+	err := pdu.Write(c.connection, request)
+	if(err != nil) {
+		c.disconnect()
+		return wireError(err)
+	}
+	return nil
+}
+
+/* exchange writes a request and reads pmcd's answer. The lock must be held */
+func (c *WireContext) exchange(request pdu.PDU) (pdu.PDU, error) {
+	err := c.send(request)
+	if(err != nil) {
+		return pdu.PDU{}, err
+	}
+	answer, err := pdu.Read(c.connection)
+	if(err != nil) {
+		c.disconnect()
+		return pdu.PDU{}, wireError(err)
+	}
+	return answer, nil
+}
+
+func (c *WireContext) request(request pdu.PDU) (pdu.PDU, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.exchange(request)
+}
+
+/* disconnect drops a connection that failed part way through a request, as whatever pmcd sends
+   next can no longer be matched to a request */
+func (c *WireContext) disconnect() {
+	c.connection.Close()
+	c.connection = nil
+}
+
+/* wireError gives the PmError libpcp would for a failed request: the code of an error PDU, or a
+   transport or protocol failure */
+func wireError(err error) error {
+	if pdu_err, ok := err.(pdu.Error); ok {
+		return wirePmError(pdu_err.Code)
+	}
+	if net_err, ok := err.(net.Error); ok && net_err.Timeout() {
+		return wirePmError(pdu.ErrTimeout)
+	}
+	if(err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return wirePmError(pdu.ErrEOF)
+	}
+	return PmError{Code:pdu.ErrIPC, Message:fmt.Sprintf("%v: %v", pdu.Error{Code:pdu.ErrIPC}, err)}
+}
+
+/* wirePmError gives a code the message pmErrStr(3) would. Codes above the PM_ERR_* range are
+   negated errno values */
+func wirePmError(code int) PmError {
+	if(code < 0 && code > pdu.ErrGeneric) {
+		return PmError{Code:code, Message:syscall.Errno(-code).Error()}
+	}
+	return PmError{Code:code, Message:pdu.Error{Code:code}.Error()}
+}
+
+/* PmSetMode positions an archive context, so fails for a host other than to stay live */
+func (c *WireContext) PmSetMode(mode int, when time.Time, delta time.Duration) error {
+	if(mode != PmModeLive) {
+		return wirePmError(pdu.ErrMode)
+	}
+	return nil
+}
+
+func (c *WireContext) PmGetArchiveLabel() (PmLogLabel, error) {
+	return PmLogLabel{}, wirePmError(pdu.ErrNotArchive)
+}
+
+func (c *WireContext) PmGetArchiveEnd() (time.Time, error) {
+	return time.Time{}, wirePmError(pdu.ErrNotArchive)
+}
+
+/* PmGetContextHostname returns the value of pmcd.hostname, or the host connected to when pmcd
+   does not say, as pmGetContextHostName(3) does */
+func (c *WireContext) PmGetContextHostname() (string, error) {
+	hostname, err := c.pmcdHostname()
+	if(err != nil || hostname == "") {
+		host, _, split_err := net.SplitHostPort(c.host)
+		if(split_err != nil) {
+			host = c.host
+		}
+		return host, nil
+	}
+	return hostname, nil
+}
+
+func (c *WireContext) pmcdHostname() (string, error) {
+	pmids, err := c.PmLookupName("pmcd.hostname")
+	if(err != nil) {
+		return "", err
+	}
+	pm_result, err := c.PmFetch(pmids[0])
+	if(err != nil) {
+		return "", err
+	}
+	vset := pm_result.VSet[0]
+	if(vset.NumVal < 1) {
+		return "", wirePmError(pdu.ErrValue)
+	}
+	atom, err := PmExtractValue(vset.ValFmt, PmTypeString, vset.VList[0])
+	return atom.String, err
+}
+
+func (c *WireContext) PmLookupName(names ...string) ([]PmID, error) {
+	if(len(names) == 0) {
+		return []PmID{}, nil
+	}
+	answer, err := c.request(pdu.EncodeNameList(pdu.NameList{Names:names}))
+	if(err != nil) {
+		return nil, err
+	}
+	ids, err := pdu.DecodeIDList(answer)
+	if(err != nil) {
+		return nil, wireError(err)
+	}
+	if(ids.Status < 0) {
+		return nil, wirePmError(ids.Status)
+	}
+	if(len(ids.PmIDs) != len(names)) {
+		return nil, wireError(errors.New(fmt.Sprintf("%v PMIDs for %v names", len(ids.PmIDs), len(names))))
+	}
+	/* Like pmLookupName, names pmcd does not know are left as PM_ID_NULL as long as one is known */
+	pmids := make([]PmID, len(names))
+	for i, pmid := range ids.PmIDs {
+		pmids[i] = PmID(pmid)
+	}
+	return pmids, nil
+}
+
+func (c *WireContext) PmLookupDesc(pmid PmID) (PmDesc, error) {
+	answer, err := c.request(pdu.EncodeDescReq(uint32(pmid)))
+	if(err != nil) {
+		return PmDesc{}, err
+	}
+	desc, err := pdu.DecodeDesc(answer)
+	if(err != nil) {
+		return PmDesc{}, wireError(err)
+	}
+	return PmDesc{
+		PmID:PmID(desc.PmID),
+		Type:desc.Type,
+		InDom:PmInDom(desc.InDom),
+		Sem:desc.Sem,
+		Units:PmUnits{
+			DimSpace:desc.Units.DimSpace,
+			DimTime:desc.Units.DimTime,
+			DimCount:desc.Units.DimCount,
+			ScaleSpace:desc.Units.ScaleSpace,
+			ScaleTime:desc.Units.ScaleTime,
+			ScaleCount:desc.Units.ScaleCount,
+		}}, nil
+}
+
+/* instances asks pmcd for the instances of indom, all of them or those matching inst or name */
+func (c *WireContext) instances(indom PmInDom, inst int, name string) ([]pdu.Instance, error) {
+	answer, err := c.request(pdu.EncodeInstanceReq(pdu.InstanceReq{InDom:uint32(indom), Inst:inst, Name:name}))
+	if(err != nil) {
+		return nil, err
+	}
+	list, err := pdu.DecodeInstanceList(answer)
+	if(err != nil) {
+		return nil, wireError(err)
+	}
+	if(len(list.Instances) == 0 && (inst != PmInNull || name != "")) {
+		return nil, wirePmError(pdu.ErrInst)
+	}
+	return list.Instances, nil
+}
+
+func (c *WireContext) PmGetInDom(indom PmInDom) (map[int]string, error) {
+	instances, err := c.instances(indom, PmInNull, "")
+	if(err != nil) {
+		return nil, err
+	}
+	indom_map := make(map[int]string)
+	for _, instance := range instances {
+		indom_map[instance.Inst] = instance.Name
+	}
+	return indom_map, nil
+}
+
+func (c *WireContext) PmLookupInDom(indom PmInDom, name string) (int, error) {
+	instances, err := c.instances(indom, PmInNull, name)
+	if(err != nil) {
+		return 0, err
+	}
+	return instances[0].Inst, nil
+}
+
+func (c *WireContext) PmNameInDom(indom PmInDom, instance int) (string, error) {
+	instances, err := c.instances(indom, instance, "")
+	if(err != nil) {
+		return "", err
+	}
+	return instances[0].Name, nil
+}
+
+/* PmAddProfile adds instances to the context's fetch profile for indom. With no instances,
+   every instance of indom is included, and PmInDomNull includes every instance domain */
+func (c *WireContext) PmAddProfile(indom PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.profile_sent = false
+	return changeProfile(&c.profile, indom, pdu.ProfileInclude, instances)
+}
+
+/* PmDelProfile removes instances from the context's fetch profile for indom. With no instances,
+   every instance of indom is excluded, and PmInDomNull excludes every instance domain */
+func (c *WireContext) PmDelProfile(indom PmInDom, instances ...int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.profile_sent = false
+	return changeProfile(&c.profile, indom, pdu.ProfileExclude, instances)
+}
+
+/* changeProfile includes or excludes instances the way __pmProfileAdd and __pmProfileDel do: the
+   instances an instance domain lists are the exceptions to its state */
+func changeProfile(profile *pdu.Profile, indom PmInDom, state int, instances []int) error {
+	if(indom == PmInDomNull) {
+		if(len(instances) > 0) {
+			return wirePmError(pdu.ErrProfileSpec)
+		}
+		*profile = pdu.Profile{State:state}
+		return nil
+	}
+
+	index := -1
+	for i, indom_profile := range profile.InDoms {
+		if(indom_profile.InDom == uint32(indom)) {
+			index = i
+		}
+	}
+	if(index < 0) {
+		profile.InDoms = append(profile.InDoms, pdu.InDomProfile{InDom:uint32(indom), State:profile.State})
+		index = len(profile.InDoms) - 1
+	}
+	indom_profile := &profile.InDoms[index]
+	if(len(instances) == 0) {
+		indom_profile.State = state
+		indom_profile.Instances = nil
+		return nil
+	}
+	for _, instance := range instances {
+		listed := -1
+		for i, exception := range indom_profile.Instances {
+			if(exception == instance) {
+				listed = i
+			}
+		}
+		if(indom_profile.State == state && listed >= 0) {
+			indom_profile.Instances = append(indom_profile.Instances[:listed], indom_profile.Instances[listed + 1:]...)
+		}
+		if(indom_profile.State != state && listed < 0) {
+			indom_profile.Instances = append(indom_profile.Instances, instance)
+		}
+	}
+	return nil
+}
+
+func (c *WireContext) PmFetch(pmids ...PmID) (*PmResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if(!c.profile_sent) {
+		err := c.send(pdu.EncodeProfile(c.profile))
+		if(err != nil) {
+			return nil, err
+		}
+		c.profile_sent = true
+	}
+
+	fetch := pdu.Fetch{PmIDs:make([]uint32, len(pmids))}
+	for i, pmid := range pmids {
+		fetch.PmIDs[i] = uint32(pmid)
+	}
+	answer, err := c.exchange(pdu.EncodeFetch(fetch))
+	if(err != nil) {
+		return nil, err
+	}
+	result, err := pdu.DecodeResult(answer)
+	if(err != nil) {
+		return nil, wireError(err)
+	}
+
+	return newPmResult(result), nil
+}
+
+/* newPmResult gives the PmResult of a result pmcd sent or an archive holds */
+func newPmResult(result pdu.Result) *PmResult {
+	vset := make([]*PmValueSet, len(result.ValueSets))
+	for i, value_set := range result.ValueSets {
+		vlist := make([]*PmValue, len(value_set.Values))
+		for j, value := range value_set.Values {
+			vlist[j] = &PmValue{Inst:value.Inst, value:pduValue(value)}
+		}
+		vset[i] = &PmValueSet{PmID:PmID(value_set.PmID), NumVal:value_set.NumVal, ValFmt:value_set.ValFmt, VList:vlist}
+	}
+	return &PmResult{Timestamp:result.Timestamp, NumPmID:len(vset), VSet:vset}
+}
+
+/* pduValue is a value as pmcd sent it or an archive holds it */
+type pduValue pdu.Value
+
+func (v pduValue) extract(value_format int, pm_type int) (PmAtomValue, error) {
+	atom, err := pdu.Value(v).Atom(pm_type, value_format)
+	if(err != nil) {
+		return PmAtomValue{}, err
+	}
+	switch typed := atom.(type) {
+	case int32:
+		return PmAtomValue{Int32:typed}, nil
+	case uint32:
+		return PmAtomValue{UInt32:typed}, nil
+	case int64:
+		return PmAtomValue{Int64:typed}, nil
+	case uint64:
+		return PmAtomValue{UInt64:typed}, nil
+	case float32:
+		return PmAtomValue{Float:typed}, nil
+	case float64:
+		return PmAtomValue{Double:typed}, nil
+	case string:
+		return PmAtomValue{String:typed}, nil
+	}
+	return PmAtomValue{}, errors.New("Unknown type")
+}
+
+func (c *WireContext) PmExtractValue(value_format int, pm_type int, pm_value *PmValue) (PmAtomValue, error) {
+	return PmExtractValue(value_format, pm_type, pm_value)
+}
+
+/* PmLookupText returns the PmTextOneline or PmTextHelp text of a metric */
+func (c *WireContext) PmLookupText(pmid PmID, level int) (string, error) {
+	answer, err := c.request(pdu.EncodeTextReq(pdu.TextReq{Ident:uint32(pmid), Type:pdu.TextPmID | level}))
+	if(err != nil) {
+		return "", err
+	}
+	text, err := pdu.DecodeText(answer)
+	if(err != nil) {
+		return "", wireError(err)
+	}
+	return text, nil
+}
+
+/* PmLookupLabels asks for each level of a metric's label hierarchy in turn, as pmLookupLabels does:
+   the context, domain, instance domain, cluster and item, then the metric's instances */
+func (c *WireContext) PmLookupLabels(pmid PmID) ([]PmLabelSet, error) {
+	if(c.features & pdu.FeatureLabels == 0) {
+		return nil, wirePmError(PmErrNoLabels)
+	}
+	desc, err := c.PmLookupDesc(pmid)
+	if(err != nil) {
+		return nil, err
+	}
+
+	requests := []pdu.LabelReq{
+		{Ident:pdu.IDNull, Type:pdu.LabelContext},
+		{Ident:uint32(pmid) >> 22 & 0x1ff, Type:pdu.LabelDomain},
+	}
+	if(desc.InDom != PmInDomNull) {
+		requests = append(requests, pdu.LabelReq{Ident:uint32(desc.InDom), Type:pdu.LabelInDom})
+	}
+	requests = append(requests, pdu.LabelReq{Ident:uint32(pmid), Type:pdu.LabelCluster}, pdu.LabelReq{Ident:uint32(pmid), Type:pdu.LabelItem})
+	if(desc.InDom != PmInDomNull) {
+		requests = append(requests, pdu.LabelReq{Ident:uint32(desc.InDom), Type:pdu.LabelInstances})
+	}
+
+	label_sets := []PmLabelSet{}
+	for _, label_req := range requests {
+		answer, err := c.request(pdu.EncodeLabelReq(label_req))
+		if(err != nil) {
+			return nil, err
+		}
+		list, err := pdu.DecodeLabelList(answer)
+		if(err != nil) {
+			return nil, wireError(err)
+		}
+		for _, set := range list.Sets {
+			inst := set.Inst
+			if(label_req.Type != pdu.LabelInstances) {
+				inst = PmInNull
+			}
+			label_sets = append(label_sets, PmLabelSet{Inst:inst, Labels:set.Labels})
+		}
+	}
+	return label_sets, nil
+}
+
+/* PmGetChildrenStatus returns the names one level below name in the PMNS, each mapped to whether
+   it is a leaf (a metric). An empty name is the root of the PMNS and a leaf has no children */
+func (c *WireContext) PmGetChildrenStatus(name string) (map[string]bool, error) {
+	answer, err := c.request(pdu.EncodeChildReq(pdu.NameReq{Status:true, Name:name}))
+	if(err != nil) {
+		return nil, err
+	}
+	list, err := pdu.DecodeNameList(answer)
+	if(err != nil) {
+		return nil, wireError(err)
+	}
+	children := make(map[string]bool)
+	for i, child := range list.Names {
+		children[child] = len(list.Statuses) == 0 || list.Statuses[i] == pdu.LeafStatus
+	}
+	return children, nil
+}
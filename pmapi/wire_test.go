@@ -0,0 +1,232 @@
+//Copyright (c) 2016 Ryan Doyle
+//
+//Permission is hereby granted, free of charge, to any person obtaining a copy
+//of this software and associated documentation files (the "Software"), to deal
+//in the Software without restriction, including without limitation the rights
+//to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+//copies of the Software, and to permit persons to whom the Software is
+//furnished to do so, subject to the following conditions:
+//
+//The above copyright notice and this permission notice shall be included in all
+//copies or substantial portions of the Software.
+//
+//THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+//IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+//FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+//AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+//LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+//OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+//SOFTWARE.
+
+package pmapi
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pdu"
+	"github.com/ryandoyle/pcpeasygo/pmapi/pmcdtest"
+	"net"
+	"time"
+)
+
+/* wireContext connects to a started server, which is closed when the test ends */
+func wireContext(t *testing.T, server *pmcdtest.Server) *WireContext {
+	address, err := server.Start("localhost:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	c, err := NewWireContext(address)
+	assert.NoError(t, err)
+	return c
+}
+
+func TestWireContext_looksUpNamesLeavingUnknownOnesNull(t *testing.T) {
+	pmids, err := wireContext(t, pmcdtest.Sample()).PmLookupName("sample.double.million", "not.a.name", "sample.milliseconds")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []PmID{sampleDoubleMillionPmID, PmID(pdu.IDNull), sampleMillisecondsPmID}, pmids)
+}
+
+func TestWireContext_returnsAPmErrorWhenNoNameIsKnown(t *testing.T) {
+	_, err := wireContext(t, pmcdtest.Sample()).PmLookupName("not.a.name")
+
+	assert.Equal(t, PmError{Code:PmErrName, Message:"Unknown metric name"}, err)
+}
+
+func TestWireContext_looksUpDescriptors(t *testing.T) {
+	desc, err := wireContext(t, pmcdtest.Sample()).PmLookupDesc(sampleMillisecondsPmID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, PmDesc{PmID:sampleMillisecondsPmID, Type:PmTypeDouble, InDom:PmInDomNull, Sem:PmSemCounter,
+		Units:PmUnits{DimTime:1, ScaleTime:PmTimeMSec}}, desc)
+}
+
+func TestWireContext_fetchesValuesOfEveryFormat(t *testing.T) {
+	server := pmcdtest.Sample()
+	server.Timestamp = time.Unix(1500000000, 250000000)
+	c := wireContext(t, server)
+
+	pm_result, err := c.PmFetch(sampleColourPmID, sampleDoubleMillionPmID, sampleStringHulloPmID)
+	assert.NoError(t, err)
+	assert.Equal(t, server.Timestamp, pm_result.Timestamp)
+	assert.Equal(t, 3, pm_result.NumPmID)
+
+	colour := pm_result.VSet[0]
+	assert.Equal(t, PmValInsitu, colour.ValFmt)
+	assert.Equal(t, 3, colour.NumVal)
+	atom, err := c.PmExtractValue(colour.ValFmt, PmType32, colour.VList[2])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, colour.VList[2].Inst)
+	assert.Equal(t, PmAtomValue{Int32:303}, atom)
+
+	atom, _ = PmExtractValue(PmValDptr, PmTypeDouble, pm_result.VSet[1].VList[0])
+	assert.Equal(t, PmAtomValue{Double:1000000}, atom)
+	atom, _ = PmExtractValue(PmValDptr, PmTypeString, pm_result.VSet[2].VList[0])
+	assert.Equal(t, PmAtomValue{String:"hullo world!"}, atom)
+}
+
+func TestWireContext_fetchReportsErrorsPerMetric(t *testing.T) {
+	pm_result, err := wireContext(t, pmcdtest.Sample()).PmFetch(PmIDFromParts(29, 9, 9))
+
+	assert.NoError(t, err)
+	assert.Equal(t, PmErrPmID, pm_result.VSet[0].NumVal)
+	assert.Empty(t, pm_result.VSet[0].VList)
+}
+
+func TestWireContext_sendsTheProfileBeforeTheNextFetch(t *testing.T) {
+	c := wireContext(t, pmcdtest.Sample())
+	instances := func() []int {
+		pm_result, err := c.PmFetch(sampleColourPmID)
+		assert.NoError(t, err)
+		found := []int{}
+		for _, pm_value := range pm_result.VSet[0].VList {
+			found = append(found, pm_value.Inst)
+		}
+		return found
+	}
+
+	c.PmDelProfile(sampleColourInDom)
+	c.PmAddProfile(sampleColourInDom, 1, 2)
+	assert.Equal(t, []int{1, 2}, instances())
+
+	c.PmDelProfile(sampleColourInDom, 2)
+	assert.Equal(t, []int{1}, instances())
+
+	c.PmAddProfile(PmInDomNull)
+	assert.Equal(t, []int{0, 1, 2}, instances())
+}
+
+func TestWireContext_rejectsInstancesForTheNullInDom(t *testing.T) {
+	err := wireContext(t, pmcdtest.Sample()).PmAddProfile(PmInDomNull, 1)
+
+	assert.True(t, IsPmError(err, pdu.ErrProfileSpec))
+}
+
+func TestWireContext_looksUpInstances(t *testing.T) {
+	c := wireContext(t, pmcdtest.Sample())
+
+	indom, err := c.PmGetInDom(sampleColourInDom)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{0:"red", 1:"green", 2:"blue"}, indom)
+
+	instance, _ := c.PmLookupInDom(sampleColourInDom, "green")
+	assert.Equal(t, 1, instance)
+	name, _ := c.PmNameInDom(sampleColourInDom, 2)
+	assert.Equal(t, "blue", name)
+
+	_, err = c.PmLookupInDom(sampleColourInDom, "purple")
+	assert.True(t, IsPmError(err, PmErrInst))
+	_, err = c.PmGetInDom(PmInDom(123))
+	assert.True(t, IsPmError(err, PmErrInDom))
+}
+
+func TestWireContext_looksUpText(t *testing.T) {
+	c := wireContext(t, pmcdtest.Sample())
+
+	text, err := c.PmLookupText(sampleDoubleMillionPmID, PmTextHelp)
+	assert.NoError(t, err)
+	assert.Equal(t, "A 64-bit floating point value with the value 1000000.0.", text)
+
+	_, err = c.PmLookupText(sampleStringHulloPmID, PmTextHelp)
+	assert.True(t, IsPmError(err, PmErrText))
+}
+
+func TestWireContext_looksUpLabelsLevelByLevel(t *testing.T) {
+	label_sets, err := wireContext(t, pmcdtest.Sample()).PmLookupLabels(sampleColourPmID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []PmLabelSet{
+		{Inst:PmInNull, Labels:map[string]string{"hostname":"localhost"}},
+		{Inst:PmInNull, Labels:map[string]string{"agent":"sample", "role":"testing"}},
+		{Inst:0, Labels:map[string]string{"model":"RGB"}},
+		{Inst:1, Labels:map[string]string{"model":"RGB"}},
+		{Inst:2, Labels:map[string]string{"model":"RGB"}},
+	}, label_sets)
+}
+
+func TestWireContext_listsChildrenWithTheirLeafStatus(t *testing.T) {
+	c := wireContext(t, pmcdtest.Sample())
+
+	children, err := c.PmGetChildrenStatus("sample")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"colour":true, "milliseconds":true, "double":false, "string":false}, children)
+
+	children, err = c.PmGetChildrenStatus("sample.colour")
+	assert.NoError(t, err)
+	assert.Empty(t, children)
+}
+
+func TestWireContext_getsTheHostnameFromPmcd(t *testing.T) {
+	hostname, err := wireContext(t, pmcdtest.Sample()).PmGetContextHostname()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost", hostname)
+}
+
+func TestWireContext_passesOnErrorPDUs(t *testing.T) {
+	server := pmcdtest.Sample()
+	server.Fail(pdu.TypeDescReq, pdu.ErrPermission)
+
+	_, err := wireContext(t, server).PmLookupDesc(sampleDoubleMillionPmID)
+
+	assert.Equal(t, PmError{Code:pdu.ErrPermission, Message:"No permission to perform requested operation"}, err)
+}
+
+func TestWireContext_failsEveryRequestOnceDisconnected(t *testing.T) {
+	server := pmcdtest.Sample()
+	c := wireContext(t, server)
+	server.Close()
+
+	_, err := c.PmLookupDesc(sampleDoubleMillionPmID)
+	assert.Error(t, err)
+	_, err = c.PmLookupDesc(sampleDoubleMillionPmID)
+	assert.True(t, IsPmError(err, pdu.ErrNotConn))
+}
+
+func TestNewWireContext_refusesAnOlderProtocol(t *testing.T) {
+	listener, _ := net.Listen("tcp", "localhost:0")
+	defer listener.Close()
+	go func() {
+		connection, err := listener.Accept()
+		if(err == nil) {
+			pdu.Write(connection, pdu.EncodeChallenge(pdu.Challenge{Version:1}))
+			connection.Close()
+		}
+	}()
+
+	c, err := NewWireContext(listener.Addr().String())
+
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "pmcd speaks PCP protocol version 1, version 2 is needed")
+}
+
+func TestPmcdAddress(t *testing.T) {
+	assert.Equal(t, "localhost:44321", pmcdAddress(""))
+	assert.Equal(t, "web1:44321", pmcdAddress("web1"))
+	assert.Equal(t, "web1:4000", pmcdAddress("web1:4000"))
+	assert.Equal(t, "[::1]:44321", pmcdAddress("::1"))
+
+	t.Setenv("PMCD_PORT", "4001,4002")
+	assert.Equal(t, "web1:4001", pmcdAddress("web1"))
+}